@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package main
+
+import (
+	"fmt"
+	stdLog "log"
+	"os"
+
+	"github.com/spf13/viper"
+	"github.com/yakumioto/alkaid/internal/common/crypto/kek"
+)
+
+const kekUsage = `usage: alkaid kek <command>
+
+commands:
+  init   generate the kek configured by crypto.kek.source, an existing kek is never overwritten
+         file: write a new kek to crypto.kek.file.path
+         kms:  write a new kek for crypto.kek.kms.keyId to crypto.kek.kms.dir, run it before changing the key id
+         env:  print a new kek to be set in the environment variable crypto.kek.env.name
+`
+
+// runKEK 执行 alkaid kek init 命令，服务启动时不会自动生成 KEK
+func runKEK(args []string) {
+	if len(args) == 0 || args[0] != "init" {
+		fmt.Fprint(os.Stderr, kekUsage)
+		os.Exit(2)
+	}
+
+	var (
+		km  kek.KeyManager
+		err error
+	)
+
+	source := viper.GetString("crypto.kek.source")
+	switch source {
+	case kek.SourceFile:
+		km, err = kek.GenerateFile(viper.GetString("crypto.kek.file.path"))
+	case kek.SourceKMS:
+		km, err = kek.GenerateLocalKMSKey(viper.GetString("crypto.kek.kms.dir"), viper.GetString("crypto.kek.kms.keyId"))
+	case kek.SourceEnv:
+		encoded, err := kek.GenKey()
+		if err != nil {
+			stdLog.Fatalf("generate kek error: %v", err)
+		}
+		fmt.Printf("%v=%v\n", viper.GetString("crypto.kek.env.name"), encoded)
+		return
+	default:
+		stdLog.Fatalf("unsupported kek source: %q", source)
+	}
+	if err != nil {
+		stdLog.Fatalf("generate %v kek error: %v", source, err)
+	}
+
+	fmt.Printf("generated %v kek, key id is %v\n", source, km.KeyID())
+}
@@ -14,14 +14,19 @@ import (
 	"strings"
 
 	"github.com/spf13/viper"
+	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
+	"github.com/yakumioto/alkaid/internal/common/crypto/kek"
+	"github.com/yakumioto/alkaid/internal/common/crypto/utils"
 	"github.com/yakumioto/alkaid/internal/common/jwt"
 	"github.com/yakumioto/alkaid/internal/common/log"
 	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/memory"
+	"github.com/yakumioto/alkaid/internal/common/storage/mysql"
+	"github.com/yakumioto/alkaid/internal/common/storage/postgres"
 	"github.com/yakumioto/alkaid/internal/common/storage/sqlite3"
 	"github.com/yakumioto/alkaid/internal/restful"
 	"github.com/yakumioto/alkaid/internal/restful/controllers"
 	"github.com/yakumioto/alkaid/internal/restful/middlewares"
-	"github.com/yakumioto/alkaid/internal/services/systems"
 	"github.com/yakumioto/alkaid/internal/services/users"
 )
 
@@ -31,7 +36,29 @@ func main() {
 
 	log.Initialize(viper.GetString("logging.level"))
 
-	initStorage()
+	if len(os.Args) > 1 && os.Args[1] == "kek" {
+		runKEK(os.Args[2:])
+		return
+	}
+
+	db := initStorage()
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(db, os.Args[2:])
+		return
+	}
+
+	checkMigrations(db)
+
+	initCrypto()
+	initKEK()
+
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt" {
+		runReencrypt(os.Args[2:])
+		return
+	}
+
+	initKDF()
 
 	jwt.Initialize(viper.GetString("auth.jwt.secret"), viper.GetDuration("auth.jwt.expires"))
 
@@ -50,9 +77,55 @@ func main() {
 	service.RegisterControllers(
 		new(controllers.Health),
 		new(controllers.InitializeSystem),
+		new(controllers.PreLogin),
 		new(controllers.Login),
 		new(controllers.CreateUser),
+		new(controllers.GetUserList),
 		new(controllers.GetUserDetailByID),
+		new(controllers.UpdateUser),
+		new(controllers.DeactivateUser),
+		new(controllers.ReactivateUser),
+		new(controllers.ChangeUserPassword),
+		new(controllers.ChangeUserEmail),
+		new(controllers.RegenerateUserRecoveryCode),
+		new(controllers.RecoverUser),
+		new(controllers.GrantEmergencyAccess),
+		new(controllers.GetEmergencyAccessList),
+		new(controllers.RevokeEmergencyAccess),
+		new(controllers.InitiateEmergencyRecovery),
+		new(controllers.RejectEmergencyRecovery),
+		new(controllers.EmergencyRecoverUser),
+		new(controllers.CreateOrganization),
+		new(controllers.GetOrganizationList),
+		new(controllers.GetOrganizationDetailByID),
+		new(controllers.UpdateOrganization),
+		new(controllers.GetOrganizationUserList),
+		new(controllers.DeactivateOrganizationUser),
+		new(controllers.ReactivateOrganizationUser),
+		new(controllers.GetOrganizationMSPConfig),
+		new(controllers.CreateIdentity),
+		new(controllers.GetIdentityList),
+		new(controllers.GetIdentityDetailByID),
+		new(controllers.UpdateIdentity),
+		new(controllers.ExportIdentityMSP),
+		new(controllers.CreateChannel),
+		new(controllers.GetChannelList),
+		new(controllers.GetChannelDetailByID),
+		new(controllers.GetChannelGenesisBlock),
+		new(controllers.GetChannelConfig),
+		new(controllers.ProposeChannelUpdate),
+		new(controllers.GetChannelUpdateList),
+		new(controllers.GetChannelUpdateDetailByID),
+		new(controllers.GetChannelUpdateEnvelope),
+		new(controllers.SignChannelUpdate),
+		new(controllers.GetChannelUpdateSigningRequest),
+		new(controllers.SubmitChannelUpdate),
+		new(controllers.CreateTransaction),
+		new(controllers.GetTransactionDetailByID),
+		new(controllers.CreateTransactionEnvelope),
+		new(controllers.SignTransaction),
+		&controllers.DecodeProto{MaxBodySize: viper.GetInt64("restful.tools.maxBodySize")},
+		&controllers.EncodeProto{MaxBodySize: viper.GetInt64("restful.tools.maxBodySize")},
 	)
 
 	if err := service.Run(viper.GetString("restful.address")); err != nil {
@@ -78,25 +151,90 @@ func initConfig() {
 	}
 }
 
-func initStorage() {
+func initCrypto() {
+	if !viper.GetBool("crypto.pkcs11.enabled") {
+		return
+	}
+
+	err := factory.InitPKCS11(&factory.PKCS11Opts{
+		Library: viper.GetString("crypto.pkcs11.library"),
+		Label:   viper.GetString("crypto.pkcs11.label"),
+		Pin:     viper.GetString("crypto.pkcs11.pin"),
+	})
+	if err != nil {
+		log.Panicf("initialize pkcs11 key store error: %v", err)
+	}
+}
+
+func initKEK() {
+	var (
+		km  kek.KeyManager
+		err error
+	)
+
+	source := viper.GetString("crypto.kek.source")
+	switch source {
+	case kek.SourceFile:
+		km, err = kek.FromFile(viper.GetString("crypto.kek.file.path"))
+	case kek.SourceEnv:
+		km, err = kek.FromEnv(viper.GetString("crypto.kek.env.name"))
+	case kek.SourceKMS:
+		km, err = kek.NewLocalKMS(viper.GetString("crypto.kek.kms.dir"), viper.GetString("crypto.kek.kms.keyId"))
+	default:
+		log.Panicf("unsupported kek source: %q", source)
+	}
+	// 内存数据库的数据不会持久化，加载失败时使用临时 KEK，持久化数据库依然启动失败
+	if err != nil && viper.GetString("database.use") == memory.Driver {
+		log.Warnf("load %v kek error: %v, use an ephemeral kek for the memory database", source, err)
+		km, err = kek.NewEphemeral()
+	}
+	if err != nil {
+		log.Panicf("load %v kek error: %v, run `alkaid kek init` to generate a new kek", source, err)
+	}
+	log.Infof("kek key id is %v", km.KeyID())
+	kek.Initialize(km)
+}
+
+func initKDF() {
+	params := utils.KDFParams{
+		Type:       viper.GetString("auth.kdf.type"),
+		Iterations: viper.GetInt("auth.kdf.iterations"),
+		Memory:     viper.GetInt("auth.kdf.memory"),
+		Threads:    viper.GetInt("auth.kdf.threads"),
+	}
+	if params.Type == "" {
+		params = utils.DefaultKDFParams
+	}
+
+	if err := users.SetKDFPolicy(params); err != nil {
+		log.Panicf("invalid kdf policy: %v", err)
+	}
+	log.Infof("user password kdf policy is %v", params.Type)
+}
+
+func initStorage() storage.Storage {
 	var (
 		db  storage.Storage
 		err error
 	)
 
-	switch viper.GetString("database.use") {
+	driver := viper.GetString("database.use")
+	switch driver {
 	case sqlite3.Driver:
 		db, err = sqlite3.NewDB(viper.GetString("database.sqlite3.path"))
-		if err != nil {
-			log.Panicf("new sqlite3 database error: %v", err)
-		}
+	case mysql.Driver:
+		db, err = mysql.NewDB(viper.GetString("database.mysql.dsn"))
+	case postgres.Driver:
+		db, err = postgres.NewDB(viper.GetString("database.postgres.dsn"))
+	case memory.Driver:
+		db = memory.NewDB()
+	default:
+		log.Panicf("unsupported database driver: %q", driver)
 	}
-	storage.Initialize(db)
-	if err := storage.AutoMigrate(
-		new(systems.System),
-		new(users.User),
-		new(users.UserOrganizations),
-	); err != nil {
-		log.Panicf("storage auto migrate error: %v", err)
+	if err != nil {
+		log.Panicf("new %v database error: %v", driver, err)
 	}
+	storage.Initialize(db)
+
+	return db
 }
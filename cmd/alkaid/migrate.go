@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	stdLog "log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/yakumioto/alkaid/internal/common/log"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/memory"
+	"github.com/yakumioto/alkaid/internal/common/storage/migrate"
+	"github.com/yakumioto/alkaid/internal/migrations"
+)
+
+const migrateUsage = `usage: alkaid migrate <command> [flags]
+
+commands:
+  up       apply all pending migrations
+  down     roll back the most recently applied migrations
+  status   show the status of all migrations
+  unlock   release the migration lock left by a crashed process
+
+flags:
+  -dry-run   print the migrations to be applied or rolled back without executing them (up, down)
+  -steps     number of migrations to roll back, default 1 (down)
+`
+
+// runMigrate 执行 alkaid migrate up|down|status|unlock 命令
+func runMigrate(db storage.Storage, args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, migrateUsage) }
+	dryRun := flags.Bool("dry-run", false, "")
+	steps := flags.Int("steps", 1, "")
+	_ = flags.Parse(args[1:])
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		stdLog.Fatalf("new migrator error: %v", err)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		done, err := migrator.Up(ctx, *dryRun)
+		printMigrations("applied", done, *dryRun)
+		if err != nil {
+			stdLog.Fatalf("migrate up error: %v", err)
+		}
+	case "down":
+		if *steps <= 0 {
+			stdLog.Fatalf("steps must be a positive integer")
+		}
+		done, err := migrator.Down(ctx, *steps, *dryRun)
+		printMigrations("rolled back", done, *dryRun)
+		if err != nil {
+			stdLog.Fatalf("migrate down error: %v", err)
+		}
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			stdLog.Fatalf("migrate status error: %v", err)
+		}
+		printStatus(statuses)
+	case "unlock":
+		if err := migrator.Unlock(); err != nil {
+			stdLog.Fatalf("migrate unlock error: %v", err)
+		}
+		fmt.Println("migration lock released")
+	default:
+		fmt.Fprint(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
+
+func printMigrations(action string, list []*migrate.Migration, dryRun bool) {
+	if dryRun {
+		action = "would be " + action
+	}
+
+	if len(list) == 0 {
+		fmt.Println("no migrations " + action)
+		return
+	}
+
+	for _, m := range list {
+		fmt.Printf("%v: %v\n", action, m)
+	}
+}
+
+func printStatus(statuses []*migrate.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, status := range statuses {
+		state, appliedAt := "pending", "-"
+		if status.Applied != nil {
+			state = "applied"
+			appliedAt = time.Unix(status.Applied.AppliedAt, 0).Format(time.RFC3339)
+		}
+		switch {
+		case status.Migration == nil:
+			state = "unknown"
+		case status.ChecksumMismatch():
+			state = "checksum mismatch"
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", status.Version(), status.Name(), state, appliedAt)
+	}
+	_ = w.Flush()
+}
+
+// checkMigrations 启动服务前检查表结构是否为最新版本，database.migrate.auto 为 true 时自动执行未执行的迁移
+func checkMigrations(db storage.Storage) {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Panicf("new migrator error: %v", err)
+	}
+
+	// 内存数据库每次启动都是空的，总是自动迁移
+	if viper.GetBool("database.migrate.auto") || viper.GetString("database.use") == memory.Driver {
+		if _, err = migrator.Up(context.Background(), false); err != nil {
+			log.Panicf("migrate up error: %v", err)
+		}
+		return
+	}
+
+	pending, err := migrator.Pending()
+	if err != nil {
+		log.Panicf("check migrations error: %v", err)
+	}
+	if len(pending) != 0 {
+		log.Panicf("database has %d pending migrations, run `alkaid migrate up` first", len(pending))
+	}
+}
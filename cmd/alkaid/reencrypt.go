@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	stdLog "log"
+	"os"
+
+	"github.com/yakumioto/alkaid/internal/services/identities"
+	"github.com/yakumioto/alkaid/internal/services/organizations"
+	"github.com/yakumioto/alkaid/internal/services/users"
+)
+
+const reencryptUsage = `usage: alkaid reencrypt [flags]
+
+upgrade the protected fields that can be decrypted without a user password, it is safe to run repeatedly:
+  organizations  rewrap the data keys with the current kek
+  identities     list the node identities whose keys are not encrypted with the organization data key
+  users          list the users whose protected fields are waiting for a login to be upgraded
+
+flags:
+  -batch-size   number of records loaded per query, default 100
+  -dry-run      report the records to be upgraded without updating them
+`
+
+// runReencrypt 执行 alkaid reencrypt 命令，分批升级不需要用户密码即可解密的数据，并列出需要等待用户登录的记录
+func runReencrypt(args []string) {
+	flags := flag.NewFlagSet("reencrypt", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, reencryptUsage) }
+	batchSize := flags.Int("batch-size", 100, "")
+	dryRun := flags.Bool("dry-run", false, "")
+	_ = flags.Parse(args)
+	if *batchSize <= 0 {
+		stdLog.Fatalf("batch size must be a positive integer")
+	}
+
+	ctx := context.Background()
+	rewrapped, pendingOrganizations, err := organizations.ReencryptDataKeys(ctx, *batchSize, *dryRun)
+	if err != nil {
+		stdLog.Fatalf("reencrypt organizations error: %v", err)
+	}
+	pendingIdentities, err := identities.FindLegacyEncryptionIdentities(ctx, *batchSize)
+	if err != nil {
+		stdLog.Fatalf("query identities error: %v", err)
+	}
+	pendingUsers, err := users.FindLegacyEncryptionUsers(ctx, *batchSize)
+	if err != nil {
+		stdLog.Fatalf("query users error: %v", err)
+	}
+
+	action := "rewrapped"
+	if *dryRun {
+		action = "would be rewrapped"
+	}
+	printReencrypt("organization data keys "+action, rewrapped)
+	printReencrypt("organizations that cannot be upgraded", pendingOrganizations)
+	printReencrypt("node identities that cannot be upgraded", pendingIdentities)
+	printReencrypt("users waiting for a login", pendingUsers)
+}
+
+func printReencrypt(title string, ids []string) {
+	fmt.Printf("%v: %d\n", title, len(ids))
+	for _, id := range ids {
+		fmt.Printf("  %v\n", id)
+	}
+}
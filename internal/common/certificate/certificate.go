@@ -9,17 +9,30 @@
 package certificate
 
 import (
+	stdCrypto "crypto"
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
+	"math/big"
 	"net"
 
 	"github.com/pkg/errors"
-	"github.com/yakumioto/alkaid/internal/services/identities"
+	"github.com/tjfoc/gmsm/sm2"
+	gmx509 "github.com/tjfoc/gmsm/x509"
 
 	"github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/common/crypto"
 )
 
+const (
+	MSPTypeOrderer = "orderer"
+	MSPTypePeer    = "peer"
+	MSPTypeAdmin   = "admin"
+	MSPTypeClient  = "client"
+)
+
 type PkixName struct {
 	OrgName       string
 	Domain        string
@@ -32,7 +45,14 @@ type PkixName struct {
 	PostalCode    string
 }
 
-func NewCA(pkikName *PkixName, priv *ecdsa.PrivateKey) (*x509.Certificate, error) {
+// NewCA 使用私钥生成自签名的 CA 证书，返回 pem 格式的证书。私钥为 pem 格式的 ECDSA 或者 SM2 私钥，
+// 也可以是私钥不可导出的 ECDSA crypto.Signer，例如 PKCS#11 设备中的私钥
+func NewCA(pkikName *PkixName, privKey interface{}) ([]byte, error) {
+	priv, err := parseSigner(privKey)
+	if err != nil {
+		return nil, err
+	}
+
 	template := crypto.X509Template()
 
 	// this is a CA
@@ -56,34 +76,42 @@ func NewCA(pkikName *PkixName, priv *ecdsa.PrivateKey) (*x509.Certificate, error
 		pkikName.StreetAddress,
 		pkikName.PostalCode,
 	)
-	template.SubjectKeyId = crypto.ComputeSKI(priv)
 
-	x509Cert, err := crypto.GenCertificateECDSA(
-		&template,
-		&template,
-		&priv.PublicKey,
-		priv,
-	)
-	if err != nil {
-		return nil, err
+	switch priv := priv.(type) {
+	case *ecdsa.PrivateKey:
+		template.SubjectKeyId = crypto.ComputeSKI(priv)
+		return genCertificate(&template, nil, &priv.PublicKey, priv)
+	case *sm2.PrivateKey:
+		template.SubjectKeyId = computeSKI(priv.Curve, priv.X, priv.Y)
+		return genCertificate(&template, nil, &priv.PublicKey, priv)
+	case stdCrypto.Signer:
+		pub, ok := priv.Public().(*ecdsa.PublicKey)
+		if !ok {
+			return nil, errors.Errorf("unsupported signer public key type: %T", priv.Public())
+		}
+		template.SubjectKeyId = computeSKI(pub.Curve, pub.X, pub.Y)
+		return genCertificate(&template, nil, pub, priv)
 	}
 
-	return x509Cert, nil
+	return nil, errors.Errorf("unsupported private key type: %T", priv)
 }
 
+// SignCertificate 使用 CA 私钥签发签名证书，公钥以及 CA 证书为 pem 格式，CA 私钥与 NewCA 相同，
+// 公钥与 CA 私钥的算法需要一致，返回 pem 格式的证书
 func SignCertificate(
 	name *PkixName,
 	commonName,
 	orgUnits string,
 	alternateNames []string,
-	pub *ecdsa.PublicKey,
-	caPrivKey *ecdsa.PrivateKey,
-	caCertificate *x509.Certificate) (*x509.Certificate, error) {
+	pubKey []byte,
+	caPrivKey interface{},
+	caCertificate []byte) ([]byte, error) {
 	template := crypto.X509Template()
+	template.KeyUsage = x509.KeyUsageDigitalSignature
 	switch orgUnits {
-	case identities.MSPTypeOrderer, identities.MSPTypePeer:
+	case MSPTypeOrderer, MSPTypePeer:
 		template.KeyUsage = x509.KeyUsageDigitalSignature
-	case identities.MSPTypeAdmin, identities.MSPTypeClient:
+	case MSPTypeAdmin, MSPTypeClient:
 		template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
 		template.ExtKeyUsage = []x509.ExtKeyUsage{
 			x509.ExtKeyUsageServerAuth,
@@ -101,10 +129,168 @@ func SignCertificate(
 		name.StreetAddress,
 		name.PostalCode,
 	)
-	subject.OrganizationalUnit = append(subject.OrganizationalUnit, orgUnits)
+	// 未开启 NodeOUs 时 orgUnits 为空，证书中不附加身份类型
+	if orgUnits != "" {
+		subject.OrganizationalUnit = append(subject.OrganizationalUnit, orgUnits)
+	}
 
 	template.Subject = subject
+	setAlternateNames(&template, alternateNames)
+
+	return signCertificate(&template, pubKey, caPrivKey, caCertificate)
+}
+
+// SignTLSCertificate 签发通讯证书，同时用于服务端和客户端认证
+func SignTLSCertificate(
+	name *PkixName,
+	commonName string,
+	alternateNames []string,
+	pubKey []byte,
+	caPrivKey interface{},
+	caCertificate []byte) ([]byte, error) {
+	template := crypto.X509Template()
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{
+		x509.ExtKeyUsageServerAuth,
+		x509.ExtKeyUsageClientAuth,
+	}
+
+	template.Subject = crypto.SubjectTemplateAdditional(
+		"",
+		commonName,
+		name.Country,
+		name.Province,
+		name.Locality,
+		name.OrgUnit,
+		name.StreetAddress,
+		name.PostalCode,
+	)
+	setAlternateNames(&template, append([]string{commonName}, alternateNames...))
+
+	return signCertificate(&template, pubKey, caPrivKey, caCertificate)
+}
+
+func signCertificate(template *x509.Certificate, pubKey []byte, caPrivKey interface{}, caCertificate []byte) ([]byte, error) {
+	pub, err := ParsePublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := parseSigner(caPrivKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return genCertificate(template, caCertificate, pub, priv)
+}
+
+// genCertificate caCertificate 为空时生成自签名证书，ECDSA 使用标准库签发，SM2 使用 gmsm 签发，
+// crypto.Signer 需要放在最后匹配，*ecdsa.PrivateKey 以及 *sm2.PrivateKey 同样实现了 crypto.Signer
+func genCertificate(template *x509.Certificate, caCertificate []byte, pub, priv interface{}) ([]byte, error) {
+	switch priv := priv.(type) {
+	case *ecdsa.PrivateKey:
+		pub, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, errors.New("the public key and the ca private key must both be ECDSA")
+		}
+
+		parent := template
+		if caCertificate != nil {
+			var err error
+			if parent, err = SignCert(caCertificate); err != nil {
+				return nil, err
+			}
+		}
+
+		cert, err := crypto.GenCertificateECDSA(template, parent, pub, priv)
+		if err != nil {
+			return nil, err
+		}
+
+		return crypto.X509Export(cert), nil
+	case *sm2.PrivateKey:
+		pub, ok := pub.(*sm2.PublicKey)
+		if !ok {
+			return nil, errors.New("the public key and the ca private key must both be SM2")
+		}
+
+		sm2Template := newSM2Template(template)
+		parent := sm2Template
+		if caCertificate != nil {
+			var err error
+			if parent, err = gmx509.ReadCertificateFromPem(caCertificate); err != nil {
+				return nil, errors.WithMessage(err, "failed to parse sm2 ca certificate")
+			}
+		}
+
+		return gmx509.CreateCertificateToPem(sm2Template, parent, pub, priv)
+	case stdCrypto.Signer:
+		pub, ok := pub.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, errors.New("the public key and the ca private key must both be ECDSA")
+		}
 
+		parent := template
+		if caCertificate != nil {
+			var err error
+			if parent, err = SignCert(caCertificate); err != nil {
+				return nil, err
+			}
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, priv)
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+	}
+
+	return nil, errors.Errorf("unsupported private key type: %T", priv)
+}
+
+// parseSigner pem 格式的私钥使用 ParsePrivateKey 解析，crypto.Signer 直接使用
+func parseSigner(privKey interface{}) (interface{}, error) {
+	switch privKey := privKey.(type) {
+	case []byte:
+		return ParsePrivateKey(privKey)
+	case stdCrypto.Signer:
+		return privKey, nil
+	}
+
+	return nil, errors.Errorf("unsupported private key type: %T", privKey)
+}
+
+// newSM2Template 将 X509Template 生成的模版转换为 gmsm 的证书模版，签名算法为 SM2WithSM3
+func newSM2Template(template *x509.Certificate) *gmx509.Certificate {
+	sm2Template := &gmx509.Certificate{
+		SignatureAlgorithm:    gmx509.SM2WithSM3,
+		SerialNumber:          template.SerialNumber,
+		Subject:               template.Subject,
+		NotBefore:             template.NotBefore,
+		NotAfter:              template.NotAfter,
+		KeyUsage:              gmx509.KeyUsage(template.KeyUsage),
+		BasicConstraintsValid: template.BasicConstraintsValid,
+		IsCA:                  template.IsCA,
+		SubjectKeyId:          template.SubjectKeyId,
+		DNSNames:              template.DNSNames,
+		IPAddresses:           template.IPAddresses,
+	}
+	for _, usage := range template.ExtKeyUsage {
+		sm2Template.ExtKeyUsage = append(sm2Template.ExtKeyUsage, gmx509.ExtKeyUsage(usage))
+	}
+
+	return sm2Template
+}
+
+// computeSKI 与 crypto.ComputeSKI 一致，对未压缩格式的公钥计算 SHA-256
+func computeSKI(curve elliptic.Curve, x, y *big.Int) []byte {
+	raw := elliptic.Marshal(curve, x, y)
+
+	hash := sha256.Sum256(raw)
+	return hash[:]
+}
+
+func setAlternateNames(template *x509.Certificate, alternateNames []string) {
 	for _, san := range alternateNames {
 		// try to parse as an IP address first
 		ip := net.ParseIP(san)
@@ -114,12 +300,6 @@ func SignCertificate(
 			template.DNSNames = append(template.DNSNames, san)
 		}
 	}
-
-	return crypto.GenCertificateECDSA(
-		&template,
-		caCertificate,
-		pub,
-		caPrivKey)
 }
 
 // SignCert load a ecdsa cert from Certificate
@@ -136,6 +316,25 @@ func SignCert(certByte []byte) (*x509.Certificate, error) {
 	return cert, err
 }
 
+// PublicKey load a ecdsa public key from pem
+func PublicKey(pubKey []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(pubKey)
+	if block == nil {
+		return nil, errors.New("bytes are not PEM encoded")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.WithMessage(err, "pem bytes are not PKIX encoded")
+	}
+
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("pem bytes do not contain an EC public key")
+	}
+	return pub, nil
+}
+
 func Signer(privKey []byte) (*crypto.ECDSASigner, error) {
 	block, _ := pem.Decode(privKey)
 	if block == nil {
@@ -153,3 +352,49 @@ func Signer(privKey []byte) (*crypto.ECDSASigner, error) {
 	}
 	return &crypto.ECDSASigner{PrivateKey: priv}, nil
 }
+
+// ParsePrivateKey 解析 pem 格式的 PKCS8 私钥，返回 *ecdsa.PrivateKey 或者 *sm2.PrivateKey
+func ParsePrivateKey(privKey []byte) (interface{}, error) {
+	block, _ := pem.Decode(privKey)
+	if block == nil {
+		return nil, errors.New("bytes are not PEM encoded")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err == nil {
+		if priv, ok := key.(*ecdsa.PrivateKey); ok {
+			return priv, nil
+		}
+		return nil, errors.Errorf("unsupported private key type: %T", key)
+	}
+
+	// 标准库无法识别 SM2 曲线
+	priv, sm2Err := gmx509.ParsePKCS8UnecryptedPrivateKey(block.Bytes)
+	if sm2Err != nil {
+		return nil, errors.WithMessage(err, "pem bytes are not PKCS8 encoded")
+	}
+	return priv, nil
+}
+
+// ParsePublicKey 解析 pem 格式的 PKIX 公钥，返回 *ecdsa.PublicKey 或者 *sm2.PublicKey
+func ParsePublicKey(pubKey []byte) (interface{}, error) {
+	block, _ := pem.Decode(pubKey)
+	if block == nil {
+		return nil, errors.New("bytes are not PEM encoded")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err == nil {
+		if pub, ok := key.(*ecdsa.PublicKey); ok {
+			return pub, nil
+		}
+		return nil, errors.Errorf("unsupported public key type: %T", key)
+	}
+
+	// gmsm 将 SM2 公钥解析为使用 SM2 曲线的 ecdsa.PublicKey
+	key, sm2Err := gmx509.ParsePKIXPublicKey(block.Bytes)
+	if pub, ok := key.(*ecdsa.PublicKey); sm2Err == nil && ok && pub.Curve == sm2.P256Sm2() {
+		return &sm2.PublicKey{Curve: pub.Curve, X: pub.X, Y: pub.Y}, nil
+	}
+	return nil, errors.WithMessage(err, "pem bytes are not PKIX encoded")
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package certificate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	gmx509 "github.com/tjfoc/gmsm/x509"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
+)
+
+func genKeyPair(t *testing.T, algorithm crypto.Algorithm) ([]byte, []byte) {
+	privateKey, err := factory.CryptoKeyGen(algorithm)
+	assert.NoError(t, err)
+	privateKeyPem, err := privateKey.Bytes()
+	assert.NoError(t, err)
+	publicKey, err := privateKey.PublicKey()
+	assert.NoError(t, err)
+	publicKeyPem, err := publicKey.Bytes()
+	assert.NoError(t, err)
+
+	return privateKeyPem, publicKeyPem
+}
+
+func TestSignCertificate(t *testing.T) {
+	name := &PkixName{Domain: "org1.alkaid.com", CommonName: "ca.org1.alkaid.com", Country: "China"}
+
+	for _, algorithm := range []crypto.Algorithm{crypto.EcdsaP256, crypto.Sm2} {
+		caPrivateKey, _ := genKeyPair(t, algorithm)
+		_, publicKey := genKeyPair(t, algorithm)
+
+		caCert, err := NewCA(name, caPrivateKey)
+		assert.NoError(t, err, algorithm)
+		signCert, err := SignCertificate(name, "peer0.org1.alkaid.com", MSPTypePeer, nil, publicKey, caPrivateKey, caCert)
+		assert.NoError(t, err, algorithm)
+		tlsCert, err := SignTLSCertificate(name, "peer0.org1.alkaid.com", []string{"127.0.0.1"}, publicKey, caPrivateKey, caCert)
+		assert.NoError(t, err, algorithm)
+
+		// gmsm 可以同时解析 ECDSA 以及 SM2 证书
+		ca, err := gmx509.ReadCertificateFromPem(caCert)
+		assert.NoError(t, err, algorithm)
+		assert.True(t, ca.IsCA, algorithm)
+		for _, raw := range [][]byte{signCert, tlsCert} {
+			cert, err := gmx509.ReadCertificateFromPem(raw)
+			assert.NoError(t, err, algorithm)
+			assert.NoError(t, cert.CheckSignatureFrom(ca), algorithm)
+			assert.Equal(t, ca.SubjectKeyId, cert.AuthorityKeyId, algorithm)
+		}
+
+		cert, err := gmx509.ReadCertificateFromPem(signCert)
+		assert.NoError(t, err, algorithm)
+		assert.Equal(t, []string{MSPTypePeer}, cert.Subject.OrganizationalUnit, algorithm)
+		if algorithm == crypto.Sm2 {
+			assert.Equal(t, gmx509.SM2WithSM3, cert.SignatureAlgorithm)
+		}
+	}
+
+	// 公钥与 CA 私钥的算法需要一致
+	caPrivateKey, _ := genKeyPair(t, crypto.EcdsaP256)
+	caCert, err := NewCA(name, caPrivateKey)
+	assert.NoError(t, err)
+	_, publicKey := genKeyPair(t, crypto.Sm2)
+	_, err = SignCertificate(name, "peer0.org1.alkaid.com", MSPTypePeer, nil, publicKey, caPrivateKey, caCert)
+	assert.Error(t, err)
+
+	_, err = NewCA(name, []byte("invalid"))
+	assert.Error(t, err)
+}
+
+// HSM 中的 CA 私钥通过 crypto.Signer 签发证书
+func TestSignCertificateWithSigner(t *testing.T) {
+	name := &PkixName{Domain: "org1.alkaid.com", CommonName: "ca.org1.alkaid.com", Country: "China"}
+
+	caSigner, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, publicKey := genKeyPair(t, crypto.EcdsaP256)
+
+	caCert, err := NewCA(name, caSigner)
+	assert.NoError(t, err)
+	signCert, err := SignCertificate(name, "peer0.org1.alkaid.com", MSPTypePeer, nil, publicKey, caSigner, caCert)
+	assert.NoError(t, err)
+	tlsCert, err := SignTLSCertificate(name, "peer0.org1.alkaid.com", []string{"127.0.0.1"}, publicKey, caSigner, caCert)
+	assert.NoError(t, err)
+
+	block, _ := pem.Decode(caCert)
+	ca, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+	assert.True(t, ca.IsCA)
+	for _, raw := range [][]byte{signCert, tlsCert} {
+		block, _ := pem.Decode(raw)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		assert.NoError(t, err)
+		assert.NoError(t, cert.CheckSignatureFrom(ca))
+		assert.Equal(t, ca.SubjectKeyId, cert.AuthorityKeyId)
+	}
+
+	_, err = NewCA(name, "invalid")
+	assert.Error(t, err)
+}
@@ -0,0 +1,361 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package configtx
+
+import (
+	"math"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	ab "github.com/hyperledger/fabric-protos-go/orderer"
+	"github.com/hyperledger/fabric-protos-go/orderer/etcdraft"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/pkg/errors"
+	"github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/protoutil"
+)
+
+const (
+	OrdererGroupKey     = "Orderer"
+	ApplicationGroupKey = "Application"
+
+	HashingAlgorithmKey          = "HashingAlgorithm"
+	BlockDataHashingStructureKey = "BlockDataHashingStructure"
+	CapabilitiesKey              = "Capabilities"
+	ConsensusTypeKey             = "ConsensusType"
+	BatchSizeKey                 = "BatchSize"
+	BatchTimeoutKey              = "BatchTimeout"
+	ChannelRestrictionsKey       = "ChannelRestrictions"
+	MSPKey                       = "MSP"
+	EndpointsKey                 = "Endpoints"
+	AnchorPeersKey               = "AnchorPeers"
+
+	ConsensusTypeEtcdRaft = "etcdraft"
+	CapabilityV2_0        = "V2_0"
+
+	defaultHashingAlgorithm = "SHA256"
+)
+
+// Organization 通道中的成员组织，Endpoints 仅对排序组织生效，AnchorPeers 仅对应用组织生效
+type Organization struct {
+	MSPID       string
+	MSP         *msp.MSPConfig
+	Endpoints   []string
+	AnchorPeers []*pb.AnchorPeer
+	Policies    map[string]*Policy
+}
+
+// Orderer 通道的排序服务配置，目前仅支持 etcdraft 共识
+type Orderer struct {
+	Organizations []*Organization
+	Consenters    []*etcdraft.Consenter
+	Options       *etcdraft.Options
+	BatchTimeout  string
+	BatchSize     *ab.BatchSize
+	Policies      map[string]*Policy
+}
+
+// Application 通道的应用配置
+type Application struct {
+	Organizations []*Organization
+	Policies      map[string]*Policy
+}
+
+// Channel 生成应用通道创世区块所需的全部配置
+type Channel struct {
+	ChannelID   string
+	Orderer     *Orderer
+	Application *Application
+	Policies    map[string]*Policy
+}
+
+// NewChannelGroup 生成通道配置的根配置组，包含 Orderer 以及 Application 两个子配置组
+func NewChannelGroup(channel *Channel) (*cb.ConfigGroup, error) {
+	if channel.ChannelID == "" {
+		return nil, errors.New("channel id is required")
+	}
+	if channel.Orderer == nil {
+		return nil, errors.New("orderer is required")
+	}
+	if channel.Application == nil {
+		return nil, errors.New("application is required")
+	}
+
+	group := protoutil.NewConfigGroup()
+	group.ModPolicy = AdminsPolicyKey
+
+	var err error
+	group.Policies, err = newConfigPolicies(mergePolicies(defaultMetaPolicies(), channel.Policies))
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]proto.Message{
+		HashingAlgorithmKey:          &cb.HashingAlgorithm{Name: defaultHashingAlgorithm},
+		BlockDataHashingStructureKey: &cb.BlockDataHashingStructure{Width: math.MaxUint32},
+		CapabilitiesKey:              newCapabilities(CapabilityV2_0),
+	}
+	if err = setValues(group, values); err != nil {
+		return nil, err
+	}
+
+	if group.Groups[OrdererGroupKey], err = newOrdererGroup(channel.Orderer); err != nil {
+		return nil, errors.WithMessage(err, "failed to create orderer group")
+	}
+	if group.Groups[ApplicationGroupKey], err = newApplicationGroup(channel.Application); err != nil {
+		return nil, errors.WithMessage(err, "failed to create application group")
+	}
+
+	return group, nil
+}
+
+// NewGenesisBlock 根据通道配置生成应用通道的创世区块，
+// 创世区块中仅包含一笔未签名的 CONFIG 交易
+func NewGenesisBlock(channel *Channel) (*cb.Block, error) {
+	group, err := NewChannelGroup(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := protoutil.CreateSignedEnvelope(cb.HeaderType_CONFIG, channel.ChannelID, nil,
+		&cb.ConfigEnvelope{Config: &cb.Config{ChannelGroup: group}}, 0, 0)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create config envelope")
+	}
+
+	envBytes, err := proto.Marshal(env)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to marshal config envelope")
+	}
+
+	block := protoutil.NewBlock(0, nil)
+	block.Data.Data = [][]byte{envBytes}
+	block.Header.DataHash = protoutil.BlockDataHash(block.Data)
+
+	lastConfig, err := proto.Marshal(&cb.Metadata{
+		Value: protoutil.MarshalOrPanic(&cb.LastConfig{Index: 0}),
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to marshal last config metadata")
+	}
+	signatures, err := proto.Marshal(&cb.Metadata{
+		Value: protoutil.MarshalOrPanic(&cb.OrdererBlockMetadata{LastConfig: &cb.LastConfig{Index: 0}}),
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to marshal signatures metadata")
+	}
+
+	block.Metadata.Metadata[cb.BlockMetadataIndex_LAST_CONFIG] = lastConfig
+	block.Metadata.Metadata[cb.BlockMetadataIndex_SIGNATURES] = signatures
+
+	return block, nil
+}
+
+// ConfigFromBlock 从配置区块中解析出通道配置
+func ConfigFromBlock(block *cb.Block) (*cb.Config, error) {
+	env, err := protoutil.ExtractEnvelope(block, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	configEnv := new(cb.ConfigEnvelope)
+	if _, err = protoutil.UnmarshalEnvelopeOfType(env, cb.HeaderType_CONFIG, configEnv); err != nil {
+		return nil, err
+	}
+
+	return configEnv.Config, nil
+}
+
+func newOrdererGroup(orderer *Orderer) (*cb.ConfigGroup, error) {
+	if len(orderer.Organizations) == 0 {
+		return nil, errors.New("at least one orderer organization is required")
+	}
+	if len(orderer.Consenters) == 0 {
+		return nil, errors.New("at least one consenter is required")
+	}
+
+	options := orderer.Options
+	if options == nil {
+		options = defaultEtcdRaftOptions()
+	}
+
+	metadata, err := proto.Marshal(&etcdraft.ConfigMetadata{
+		Consenters: orderer.Consenters,
+		Options:    options,
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to marshal etcdraft metadata")
+	}
+
+	batchSize := orderer.BatchSize
+	if batchSize == nil {
+		batchSize = defaultBatchSize()
+	}
+	batchTimeout := orderer.BatchTimeout
+	if batchTimeout == "" {
+		batchTimeout = "2s"
+	}
+
+	policies := defaultMetaPolicies()
+	policies[BlockValidationPolicyKey] = implicitMetaPolicy("ANY " + WritersPolicyKey)
+
+	group, err := newGroup(mergePolicies(policies, orderer.Policies), map[string]proto.Message{
+		ConsensusTypeKey: &ab.ConsensusType{
+			Type:     ConsensusTypeEtcdRaft,
+			Metadata: metadata,
+			State:    ab.ConsensusType_STATE_NORMAL,
+		},
+		BatchSizeKey:           batchSize,
+		BatchTimeoutKey:        &ab.BatchTimeout{Timeout: batchTimeout},
+		ChannelRestrictionsKey: &ab.ChannelRestrictions{},
+		CapabilitiesKey:        newCapabilities(CapabilityV2_0),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, org := range orderer.Organizations {
+		if len(org.Endpoints) == 0 {
+			return nil, errors.Errorf("orderer organization %s has no endpoints", org.MSPID)
+		}
+
+		orgPolicies := map[string]*Policy{
+			ReadersPolicyKey: signaturePolicy(signedByAnyRole(org.MSPID, "member")),
+			WritersPolicyKey: signaturePolicy(signedByAnyRole(org.MSPID, "member")),
+			AdminsPolicyKey:  signaturePolicy(signedByAnyRole(org.MSPID, "admin")),
+		}
+
+		values := map[string]proto.Message{
+			EndpointsKey: &cb.OrdererAddresses{Addresses: org.Endpoints},
+		}
+
+		if group.Groups[org.MSPID], err = newOrganizationGroup(org, mergePolicies(orgPolicies, org.Policies), values); err != nil {
+			return nil, err
+		}
+	}
+
+	return group, nil
+}
+
+func newApplicationGroup(application *Application) (*cb.ConfigGroup, error) {
+	if len(application.Organizations) == 0 {
+		return nil, errors.New("at least one application organization is required")
+	}
+
+	policies := defaultMetaPolicies()
+	policies[EndorsementPolicyKey] = implicitMetaPolicy("MAJORITY " + EndorsementPolicyKey)
+	policies[LifecycleEndorsementPolicyKey] = implicitMetaPolicy("MAJORITY " + EndorsementPolicyKey)
+
+	group, err := newGroup(mergePolicies(policies, application.Policies), map[string]proto.Message{
+		CapabilitiesKey: newCapabilities(CapabilityV2_0),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, org := range application.Organizations {
+		orgPolicies := map[string]*Policy{
+			ReadersPolicyKey:     signaturePolicy(signedByAnyRole(org.MSPID, "admin", "peer", "client")),
+			WritersPolicyKey:     signaturePolicy(signedByAnyRole(org.MSPID, "admin", "client")),
+			AdminsPolicyKey:      signaturePolicy(signedByAnyRole(org.MSPID, "admin")),
+			EndorsementPolicyKey: signaturePolicy(signedByAnyRole(org.MSPID, "peer")),
+		}
+
+		values := make(map[string]proto.Message)
+		if len(org.AnchorPeers) != 0 {
+			values[AnchorPeersKey] = &pb.AnchorPeers{AnchorPeers: org.AnchorPeers}
+		}
+
+		if group.Groups[org.MSPID], err = newOrganizationGroup(org, mergePolicies(orgPolicies, org.Policies), values); err != nil {
+			return nil, err
+		}
+	}
+
+	return group, nil
+}
+
+func newOrganizationGroup(org *Organization, policies map[string]*Policy, values map[string]proto.Message) (*cb.ConfigGroup, error) {
+	if org.MSPID == "" || org.MSP == nil {
+		return nil, errors.New("organization msp id and msp config are required")
+	}
+
+	values[MSPKey] = org.MSP
+	group, err := newGroup(policies, values)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to create organization group %s", org.MSPID)
+	}
+
+	return group, nil
+}
+
+func newGroup(policies map[string]*Policy, values map[string]proto.Message) (*cb.ConfigGroup, error) {
+	group := protoutil.NewConfigGroup()
+	group.ModPolicy = AdminsPolicyKey
+
+	var err error
+	if group.Policies, err = newConfigPolicies(policies); err != nil {
+		return nil, err
+	}
+	if err = setValues(group, values); err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+func setValues(group *cb.ConfigGroup, values map[string]proto.Message) error {
+	for key, value := range values {
+		data, err := proto.Marshal(value)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to marshal config value %s", key)
+		}
+
+		group.Values[key] = &cb.ConfigValue{
+			Value:     data,
+			ModPolicy: AdminsPolicyKey,
+		}
+	}
+
+	return nil
+}
+
+func newCapabilities(capabilities ...string) *cb.Capabilities {
+	c := &cb.Capabilities{Capabilities: make(map[string]*cb.Capability)}
+	for _, capability := range capabilities {
+		c.Capabilities[capability] = &cb.Capability{}
+	}
+
+	return c
+}
+
+func defaultMetaPolicies() map[string]*Policy {
+	return map[string]*Policy{
+		ReadersPolicyKey: implicitMetaPolicy("ANY " + ReadersPolicyKey),
+		WritersPolicyKey: implicitMetaPolicy("ANY " + WritersPolicyKey),
+		AdminsPolicyKey:  implicitMetaPolicy("MAJORITY " + AdminsPolicyKey),
+	}
+}
+
+func defaultEtcdRaftOptions() *etcdraft.Options {
+	return &etcdraft.Options{
+		TickInterval:         "500ms",
+		ElectionTick:         10,
+		HeartbeatTick:        1,
+		MaxInflightBlocks:    5,
+		SnapshotIntervalSize: 16 * 1024 * 1024,
+	}
+}
+
+func defaultBatchSize() *ab.BatchSize {
+	return &ab.BatchSize{
+		MaxMessageCount:   500,
+		AbsoluteMaxBytes:  10 * 1024 * 1024,
+		PreferredMaxBytes: 2 * 1024 * 1024,
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package configtx
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric-protos-go/orderer/etcdraft"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestChannel(t *testing.T) *Channel {
+	org := func(mspID string) *Organization {
+		config, err := NewMSPConfig(&MSPOptions{
+			MSPID:     mspID,
+			RootCerts: [][]byte{[]byte("sign ca")},
+			TLSRoots:  [][]byte{[]byte("tls ca")},
+			NodeOUs:   true,
+		})
+		assert.NoError(t, err)
+
+		return &Organization{MSPID: mspID, MSP: config, Endpoints: []string{"orderer0." + mspID + ":7050"}}
+	}
+
+	return &Channel{
+		ChannelID: "mychannel",
+		Orderer: &Orderer{
+			Organizations: []*Organization{org("orderer")},
+			Consenters: []*etcdraft.Consenter{{
+				Host: "orderer0.orderer", Port: 7050,
+				ClientTlsCert: []byte("tls cert"), ServerTlsCert: []byte("tls cert"),
+			}},
+		},
+		Application: &Application{
+			Organizations: []*Organization{org("org1"), org("org2")},
+			Policies: map[string]*Policy{
+				EndorsementPolicyKey: {Type: SignaturePolicyType, Rule: "AND('org1.peer', 'org2.peer')"},
+			},
+		},
+	}
+}
+
+func TestNewGenesisBlock(t *testing.T) {
+	block, err := NewGenesisBlock(newTestChannel(t))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), block.Header.Number)
+
+	config, err := ConfigFromBlock(block)
+	assert.NoError(t, err)
+
+	application := config.ChannelGroup.Groups[ApplicationGroupKey]
+	assert.Len(t, application.Groups, 2)
+	assert.Contains(t, config.ChannelGroup.Groups[OrdererGroupKey].Groups, "orderer")
+	assert.Contains(t, config.ChannelGroup.Groups[OrdererGroupKey].Values, ConsensusTypeKey)
+
+	endorsement := application.Policies[EndorsementPolicyKey].Policy
+	assert.Equal(t, int32(cb.Policy_SIGNATURE), endorsement.Type)
+
+	data, err := MarshalJSON(block)
+	assert.NoError(t, err)
+
+	tree := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(data, &tree))
+	assert.Contains(t, string(data), `"type": "etcdraft"`)
+
+	_, err = NewGenesisBlock(&Channel{ChannelID: "mychannel"})
+	assert.Error(t, err)
+}
+
+func TestNewSignaturePolicy(t *testing.T) {
+	tests := []struct {
+		rule       string
+		identities int
+		wantErr    bool
+	}{
+		{rule: "OR('org1.admin')", identities: 1},
+		{rule: "AND('org1.peer', 'org2.peer', 'org1.peer')", identities: 2},
+		{rule: "OutOf(1, 'org1.member', OR('org2.client', 'org3.orderer'))", identities: 3},
+		{rule: "OR('org1.admin'", wantErr: true},
+		{rule: "OR('org1.unknown')", wantErr: true},
+		{rule: "XOR('org1.admin')", wantErr: true},
+		{rule: "OutOf(x, 'org1.admin')", wantErr: true},
+	}
+
+	for _, test := range tests {
+		policy, err := NewSignaturePolicy(test.rule)
+		if test.wantErr {
+			assert.Error(t, err, test.rule)
+			continue
+		}
+
+		assert.NoError(t, err, test.rule)
+		assert.Len(t, policy.Identities, test.identities, test.rule)
+	}
+
+	policy, err := NewSignaturePolicy("AND('org1.peer', 'org2.peer')")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), policy.Rule.GetNOutOf().N)
+
+	role := new(msp.MSPRole)
+	assert.NoError(t, proto.Unmarshal(policy.Identities[1].Principal, role))
+	assert.Equal(t, "org2", role.MspIdentifier)
+	assert.Equal(t, msp.MSPRole_PEER, role.Role)
+}
+
+func TestNewImplicitMetaPolicy(t *testing.T) {
+	policy, err := NewImplicitMetaPolicy("majority Admins")
+	assert.NoError(t, err)
+	assert.Equal(t, cb.ImplicitMetaPolicy_MAJORITY, policy.Rule)
+	assert.Equal(t, "Admins", policy.SubPolicy)
+
+	_, err = NewImplicitMetaPolicy("SOME Admins")
+	assert.Error(t, err)
+	_, err = NewImplicitMetaPolicy("ANY")
+	assert.Error(t, err)
+}
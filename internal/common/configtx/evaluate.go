@@ -0,0 +1,488 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package configtx
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/pem"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
+	"github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/protoutil"
+)
+
+const rootGroupKey = "Channel"
+
+// PolicyStatus 配置更新需要满足的修改策略
+type PolicyStatus struct {
+	Path      string `json:"path"`
+	Satisfied bool   `json:"satisfied"`
+}
+
+// SignatureStatus 配置更新中收集到的签名，Valid 为 false 时签名不参与策略计算
+type SignatureStatus struct {
+	MSPID   string   `json:"mspId"`
+	Subject string   `json:"subject,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+	Valid   bool     `json:"valid"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// UpdateStatus 配置更新的签名收集状态，所有修改策略均满足时 Satisfied 为 true
+type UpdateStatus struct {
+	Policies   []*PolicyStatus    `json:"policies"`
+	Signatures []*SignatureStatus `json:"signatures"`
+	Satisfied  bool               `json:"satisfied"`
+}
+
+// EvaluateUpdate 根据当前的通道配置计算配置更新需要满足的修改策略，
+// 并使用配置更新中的签名逐一校验，规则与 Fabric configtx 的校验逻辑保持一致：
+// 已存在的元素版本号变化时需要满足其 mod_policy，新增的元素由其父配置组的 mod_policy 控制。
+func EvaluateUpdate(config *cb.Config, env *cb.ConfigUpdateEnvelope) (*UpdateStatus, error) {
+	configUpdate := new(cb.ConfigUpdate)
+	if err := proto.Unmarshal(env.ConfigUpdate, configUpdate); err != nil {
+		return nil, errors.WithMessage(err, "failed to unmarshal config update")
+	}
+	if configUpdate.ReadSet == nil || configUpdate.WriteSet == nil {
+		return nil, errors.New("config update has no read set or write set")
+	}
+
+	if err := verifyReadSet([]string{rootGroupKey}, config.ChannelGroup, configUpdate.ReadSet); err != nil {
+		return nil, err
+	}
+
+	required := make(map[string]bool)
+	if err := requiredPolicies([]string{rootGroupKey}, config.ChannelGroup, configUpdate.WriteSet, required); err != nil {
+		return nil, err
+	}
+
+	signedData, err := protoutil.ConfigUpdateEnvelopeAsSignedData(env)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse config signatures")
+	}
+
+	msps, err := collectMSPs(config.ChannelGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &UpdateStatus{
+		Policies:   make([]*PolicyStatus, 0, len(required)),
+		Signatures: make([]*SignatureStatus, 0, len(signedData)),
+	}
+
+	signers := make([]*signer, 0, len(signedData))
+	seen := make(map[string]bool)
+	for _, sd := range signedData {
+		s, signatureStatus := validateSignedData(msps, sd)
+		status.Signatures = append(status.Signatures, signatureStatus)
+
+		// 同一个身份的多个签名只计算一次
+		if s != nil && !seen[string(sd.Identity)] {
+			seen[string(sd.Identity)] = true
+			signers = append(signers, s)
+		}
+	}
+
+	paths := make([]string, 0, len(required))
+	for path := range required {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	status.Satisfied = len(paths) != 0
+	for _, path := range paths {
+		satisfied := evaluatePolicyPath(config.ChannelGroup, path, signers)
+		status.Policies = append(status.Policies, &PolicyStatus{Path: path, Satisfied: satisfied})
+		status.Satisfied = status.Satisfied && satisfied
+	}
+
+	return status, nil
+}
+
+// verifyReadSet 读集中的版本号需要与当前配置一致，否则说明配置更新已经过期
+func verifyReadSet(path []string, original, read *cb.ConfigGroup) error {
+	if original == nil {
+		return errors.Errorf("config group %s does not exist", joinPath(path))
+	}
+	if read.Version != original.Version {
+		return errors.Errorf("config group %s version mismatch: read %d, current %d",
+			joinPath(path), read.Version, original.Version)
+	}
+
+	for key, value := range read.Values {
+		if current, ok := original.Values[key]; !ok || current.Version != value.Version {
+			return errors.Errorf("config value %s/%s is stale", joinPath(path), key)
+		}
+	}
+	for key, policy := range read.Policies {
+		if current, ok := original.Policies[key]; !ok || current.Version != policy.Version {
+			return errors.Errorf("config policy %s/%s is stale", joinPath(path), key)
+		}
+	}
+	for key, group := range read.Groups {
+		if err := verifyReadSet(subPath(path, key), original.Groups[key], group); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func requiredPolicies(path []string, original, write *cb.ConfigGroup, required map[string]bool) error {
+	// 新增的配置组由父配置组的修改策略控制
+	if original == nil {
+		return nil
+	}
+
+	add := func(element, modPolicy string) error {
+		if modPolicy == "" {
+			return errors.Errorf("config element %s has no mod_policy and cannot be modified", element)
+		}
+		if strings.HasPrefix(modPolicy, "/") {
+			required[modPolicy] = true
+		} else {
+			required[joinPath(path)+"/"+modPolicy] = true
+		}
+		return nil
+	}
+
+	if write.Version != original.Version {
+		if err := add(joinPath(path), original.ModPolicy); err != nil {
+			return err
+		}
+	}
+
+	for key, value := range write.Values {
+		if current, ok := original.Values[key]; ok && current.Version != value.Version {
+			if err := add(joinPath(path)+"/"+key, current.ModPolicy); err != nil {
+				return err
+			}
+		}
+	}
+	for key, policy := range write.Policies {
+		if current, ok := original.Policies[key]; ok && current.Version != policy.Version {
+			if err := add(joinPath(path)+"/"+key, current.ModPolicy); err != nil {
+				return err
+			}
+		}
+	}
+	for key, group := range write.Groups {
+		if err := requiredPolicies(subPath(path, key), original.Groups[key], group, required); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func joinPath(path []string) string {
+	return "/" + strings.Join(path, "/")
+}
+
+func subPath(path []string, key string) []string {
+	sub := make([]string, len(path), len(path)+1)
+	copy(sub, path)
+	return append(sub, key)
+}
+
+// mspInfo 校验签名身份所需的 MSP 信息
+type mspInfo struct {
+	roots         *x509.CertPool
+	intermediates *x509.CertPool
+	admins        [][]byte
+	nodeOUs       bool
+}
+
+// signer 通过校验的签名者以及其拥有的角色
+type signer struct {
+	mspID string
+	roles map[msp.MSPRole_MSPRoleType]bool
+}
+
+// HasMSP 通道配置中是否包含指定 MSP ID 的组织
+func HasMSP(config *cb.Config, mspID string) (bool, error) {
+	msps, err := collectMSPs(config.ChannelGroup)
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := msps[mspID]
+	return ok, nil
+}
+
+func collectMSPs(group *cb.ConfigGroup) (map[string]*mspInfo, error) {
+	msps := make(map[string]*mspInfo)
+
+	var walk func(group *cb.ConfigGroup) error
+	walk = func(group *cb.ConfigGroup) error {
+		if value, ok := group.Values[MSPKey]; ok {
+			mspConfig := new(msp.MSPConfig)
+			if err := proto.Unmarshal(value.Value, mspConfig); err != nil {
+				return errors.WithMessage(err, "failed to unmarshal msp config")
+			}
+
+			if mspConfig.Type == FabricMSPType {
+				fabricMSPConfig := new(msp.FabricMSPConfig)
+				if err := proto.Unmarshal(mspConfig.Config, fabricMSPConfig); err != nil {
+					return errors.WithMessage(err, "failed to unmarshal fabric msp config")
+				}
+
+				info := &mspInfo{
+					roots:         x509.NewCertPool(),
+					intermediates: x509.NewCertPool(),
+					nodeOUs:       fabricMSPConfig.FabricNodeOus.GetEnable(),
+				}
+				for _, cert := range fabricMSPConfig.RootCerts {
+					info.roots.AppendCertsFromPEM(cert)
+				}
+				for _, cert := range fabricMSPConfig.IntermediateCerts {
+					info.intermediates.AppendCertsFromPEM(cert)
+				}
+				for _, cert := range fabricMSPConfig.Admins {
+					if c, err := parseCertificate(cert); err == nil {
+						info.admins = append(info.admins, c.Raw)
+					}
+				}
+
+				msps[fabricMSPConfig.Name] = info
+			}
+		}
+
+		for _, sub := range group.Groups {
+			if err := walk(sub); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(group); err != nil {
+		return nil, err
+	}
+
+	return msps, nil
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("certificate is not pem encoded")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+var ouRoles = map[string]msp.MSPRole_MSPRoleType{
+	"admin":   msp.MSPRole_ADMIN,
+	"client":  msp.MSPRole_CLIENT,
+	"peer":    msp.MSPRole_PEER,
+	"orderer": msp.MSPRole_ORDERER,
+}
+
+func validateSignedData(msps map[string]*mspInfo, sd *protoutil.SignedData) (*signer, *SignatureStatus) {
+	status := new(SignatureStatus)
+	invalid := func(err error) (*signer, *SignatureStatus) {
+		status.Error = err.Error()
+		return nil, status
+	}
+
+	sid := new(msp.SerializedIdentity)
+	if err := proto.Unmarshal(sd.Identity, sid); err != nil {
+		return invalid(errors.WithMessage(err, "failed to unmarshal serialized identity"))
+	}
+	status.MSPID = sid.Mspid
+
+	info, ok := msps[sid.Mspid]
+	if !ok {
+		return invalid(errors.Errorf("msp %s is not a member of channel", sid.Mspid))
+	}
+
+	cert, err := parseCertificate(sid.IdBytes)
+	if err != nil {
+		return invalid(err)
+	}
+	status.Subject = cert.Subject.CommonName
+
+	if _, err = cert.Verify(x509.VerifyOptions{
+		Roots:         info.roots,
+		Intermediates: info.intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return invalid(errors.WithMessage(err, "certificate is not issued by msp"))
+	}
+
+	key, err := publicKey(cert)
+	if err != nil {
+		return invalid(err)
+	}
+	if !crypto.Verify(key, sd.Data, sd.Signature) {
+		return invalid(errors.New("signature verification failed"))
+	}
+
+	s := &signer{
+		mspID: sid.Mspid,
+		roles: map[msp.MSPRole_MSPRoleType]bool{msp.MSPRole_MEMBER: true},
+	}
+	if info.nodeOUs {
+		for _, ou := range cert.Subject.OrganizationalUnit {
+			if role, ok := ouRoles[ou]; ok {
+				s.roles[role] = true
+			}
+		}
+	}
+	for _, admin := range info.admins {
+		if bytes.Equal(admin, cert.Raw) {
+			s.roles[msp.MSPRole_ADMIN] = true
+		}
+	}
+
+	for role := range s.roles {
+		status.Roles = append(status.Roles, strings.ToLower(role.String()))
+	}
+	sort.Strings(status.Roles)
+	status.Valid = true
+
+	return s, status
+}
+
+// publicKey 根据证书公钥的曲线导入 SM2 或者 ECDSA 公钥，SM2 签名使用 SM3 计算摘要
+func publicKey(cert *x509.Certificate) (crypto.Key, error) {
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("certificate does not contain an ecdsa or sm2 public key")
+	}
+
+	algorithm := crypto.EcdsaP256
+	if pub.Curve == sm2.P256Sm2() {
+		algorithm = crypto.Sm2
+	}
+
+	return factory.CryptoKeyImport(cert.RawSubjectPublicKeyInfo, algorithm)
+}
+
+// evaluatePolicyPath 计算形如 /Channel/Application/Admins 的策略是否满足
+func evaluatePolicyPath(root *cb.ConfigGroup, path string, signers []*signer) bool {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) < 2 || parts[0] != rootGroupKey {
+		return false
+	}
+
+	group := root
+	for _, name := range parts[1 : len(parts)-1] {
+		if group = group.Groups[name]; group == nil {
+			return false
+		}
+	}
+
+	policy, ok := group.Policies[parts[len(parts)-1]]
+	if !ok || policy.Policy == nil {
+		return false
+	}
+
+	return evaluatePolicy(group, policy.Policy, signers)
+}
+
+func evaluatePolicy(group *cb.ConfigGroup, policy *cb.Policy, signers []*signer) bool {
+	switch cb.Policy_PolicyType(policy.Type) {
+	case cb.Policy_IMPLICIT_META:
+		implicitMetaPolicy := new(cb.ImplicitMetaPolicy)
+		if err := proto.Unmarshal(policy.Value, implicitMetaPolicy); err != nil {
+			return false
+		}
+
+		var threshold int
+		switch implicitMetaPolicy.Rule {
+		case cb.ImplicitMetaPolicy_ANY:
+			threshold = 1
+		case cb.ImplicitMetaPolicy_ALL:
+			threshold = len(group.Groups)
+		case cb.ImplicitMetaPolicy_MAJORITY:
+			threshold = len(group.Groups)/2 + 1
+		}
+
+		satisfied := 0
+		for _, sub := range group.Groups {
+			subPolicy, ok := sub.Policies[implicitMetaPolicy.SubPolicy]
+			if ok && subPolicy.Policy != nil && evaluatePolicy(sub, subPolicy.Policy, signers) {
+				satisfied++
+			}
+		}
+
+		return satisfied >= threshold
+	case cb.Policy_SIGNATURE:
+		envelope := new(cb.SignaturePolicyEnvelope)
+		if err := proto.Unmarshal(policy.Value, envelope); err != nil || envelope.Rule == nil {
+			return false
+		}
+
+		return evaluateSignaturePolicy(envelope.Rule, envelope.Identities, signers, make([]bool, len(signers)))
+	}
+
+	return false
+}
+
+// evaluateSignaturePolicy 与 cauthdsl 一致，每个签名只能满足一个身份要求
+func evaluateSignaturePolicy(rule *cb.SignaturePolicy, identities []*msp.MSPPrincipal, signers []*signer, used []bool) bool {
+	switch t := rule.Type.(type) {
+	case *cb.SignaturePolicy_SignedBy:
+		if t.SignedBy < 0 || int(t.SignedBy) >= len(identities) {
+			return false
+		}
+
+		principal := identities[t.SignedBy]
+		for i, s := range signers {
+			if !used[i] && s.satisfies(principal) {
+				used[i] = true
+				return true
+			}
+		}
+
+		return false
+	case *cb.SignaturePolicy_NOutOf_:
+		verified := int32(0)
+		_used := make([]bool, len(used))
+		copy(_used, used)
+
+		for _, sub := range t.NOutOf.Rules {
+			if evaluateSignaturePolicy(sub, identities, signers, _used) {
+				verified++
+			}
+		}
+
+		if verified >= t.NOutOf.N {
+			copy(used, _used)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *signer) satisfies(principal *msp.MSPPrincipal) bool {
+	if principal.PrincipalClassification != msp.MSPPrincipal_ROLE {
+		return false
+	}
+
+	role := new(msp.MSPRole)
+	if err := proto.Unmarshal(principal.Principal, role); err != nil {
+		return false
+	}
+
+	return role.MspIdentifier == s.mspID && s.roles[role.Role]
+}
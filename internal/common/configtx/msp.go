@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package configtx
+
+import (
+	"bytes"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/pkg/errors"
+	"github.com/yakumioto/alkaid/internal/common/certificate"
+	"github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/common/tools/protolator"
+)
+
+const (
+	// FabricMSPType 对应 msp.MSPConfig 中 Fabric 类型的 MSP
+	FabricMSPType = 0
+
+	signatureHashFamily            = "SHA2"
+	identityIdentifierHashFunction = "SHA256"
+
+	// 国密版本 Fabric 使用的摘要算法
+	HashFamilySM3   = "SM3"
+	HashFunctionSM3 = "SM3"
+)
+
+// MSPOptions 生成 Fabric MSP 配置所需的证书，均为 pem 格式
+type MSPOptions struct {
+	MSPID      string
+	RootCerts  [][]byte
+	TLSRoots   [][]byte
+	AdminCerts [][]byte
+	NodeOUs    bool
+
+	// 为空时使用 SHA2 以及 SHA256
+	SignatureHashFamily            string
+	IdentityIdentifierHashFunction string
+}
+
+// NewFabricMSPConfig 根据组织的根证书生成 FabricMSPConfig，
+// 开启 NodeOUs 时通过签名根证书识别 client，peer，admin 以及 orderer 身份。
+func NewFabricMSPConfig(opts *MSPOptions) (*msp.FabricMSPConfig, error) {
+	if opts.MSPID == "" {
+		return nil, errors.New("msp id is required")
+	}
+	if len(opts.RootCerts) == 0 {
+		return nil, errors.New("at least one root certificate is required")
+	}
+
+	config := &msp.FabricMSPConfig{
+		Name:         opts.MSPID,
+		RootCerts:    opts.RootCerts,
+		Admins:       opts.AdminCerts,
+		TlsRootCerts: opts.TLSRoots,
+		CryptoConfig: &msp.FabricCryptoConfig{
+			SignatureHashFamily:            signatureHashFamily,
+			IdentityIdentifierHashFunction: identityIdentifierHashFunction,
+		},
+	}
+	if opts.SignatureHashFamily != "" {
+		config.CryptoConfig.SignatureHashFamily = opts.SignatureHashFamily
+	}
+	if opts.IdentityIdentifierHashFunction != "" {
+		config.CryptoConfig.IdentityIdentifierHashFunction = opts.IdentityIdentifierHashFunction
+	}
+
+	if opts.NodeOUs {
+		rootCert := opts.RootCerts[0]
+		config.FabricNodeOus = &msp.FabricNodeOUs{
+			Enable:              true,
+			ClientOuIdentifier:  newOUIdentifier(rootCert, certificate.MSPTypeClient),
+			PeerOuIdentifier:    newOUIdentifier(rootCert, certificate.MSPTypePeer),
+			AdminOuIdentifier:   newOUIdentifier(rootCert, certificate.MSPTypeAdmin),
+			OrdererOuIdentifier: newOUIdentifier(rootCert, certificate.MSPTypeOrderer),
+		}
+	}
+
+	return config, nil
+}
+
+func newOUIdentifier(cert []byte, ou string) *msp.FabricOUIdentifier {
+	return &msp.FabricOUIdentifier{
+		Certificate:                  cert,
+		OrganizationalUnitIdentifier: ou,
+	}
+}
+
+// NewMSPConfig 生成可以直接写入通道配置的 msp.MSPConfig
+func NewMSPConfig(opts *MSPOptions) (*msp.MSPConfig, error) {
+	fabricMSPConfig, err := NewFabricMSPConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := proto.Marshal(fabricMSPConfig)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to marshal fabric msp config")
+	}
+
+	return &msp.MSPConfig{
+		Type:   FabricMSPType,
+		Config: config,
+	}, nil
+}
+
+// MarshalJSON 使用 protolator 将 proto 消息展开为可读的 JSON
+func MarshalJSON(msg proto.Message) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := protolator.DeepMarshalJSON(buf, msg); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON 将 protolator 展开的 JSON 还原为 proto 消息
+func UnmarshalJSON(data []byte, msg proto.Message) error {
+	return protolator.DeepUnmarshalJSON(bytes.NewReader(data), msg)
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package configtx
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMSPConfig(t *testing.T) {
+	config, err := NewMSPConfig(&MSPOptions{
+		MSPID:     "org1",
+		RootCerts: [][]byte{[]byte("sign ca")},
+		TLSRoots:  [][]byte{[]byte("tls ca")},
+		NodeOUs:   true,
+	})
+	assert.NoError(t, err)
+
+	fabricMSPConfig := new(msp.FabricMSPConfig)
+	assert.NoError(t, proto.Unmarshal(config.Config, fabricMSPConfig))
+	assert.Equal(t, "org1", fabricMSPConfig.Name)
+	assert.Equal(t, "admin", fabricMSPConfig.FabricNodeOus.AdminOuIdentifier.OrganizationalUnitIdentifier)
+	assert.Equal(t, []byte("sign ca"), fabricMSPConfig.FabricNodeOus.AdminOuIdentifier.Certificate)
+	assert.Equal(t, "SHA2", fabricMSPConfig.CryptoConfig.SignatureHashFamily)
+
+	data, err := MarshalJSON(config)
+	assert.NoError(t, err)
+
+	tree := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(data, &tree))
+	assert.Equal(t, "org1", tree["config"].(map[string]interface{})["name"])
+
+	_, err = NewMSPConfig(&MSPOptions{MSPID: "org1"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,345 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package configtx
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/pkg/errors"
+)
+
+const (
+	ReadersPolicyKey              = "Readers"
+	WritersPolicyKey              = "Writers"
+	AdminsPolicyKey               = "Admins"
+	EndorsementPolicyKey          = "Endorsement"
+	LifecycleEndorsementPolicyKey = "LifecycleEndorsement"
+	BlockValidationPolicyKey      = "BlockValidation"
+)
+
+const (
+	ImplicitMetaPolicyType = "ImplicitMeta"
+	SignaturePolicyType    = "Signature"
+)
+
+// Policy 通道配置中的策略，与 configtx.yaml 中的写法保持一致：
+// ImplicitMeta 策略的规则形如 "MAJORITY Admins"，
+// Signature 策略的规则形如 "OR('org1.admin', OutOf(2, 'org2.peer', 'org3.peer'))"
+type Policy struct {
+	Type string `json:"type"`
+	Rule string `json:"rule"`
+}
+
+func implicitMetaPolicy(rule string) *Policy {
+	return &Policy{Type: ImplicitMetaPolicyType, Rule: rule}
+}
+
+func signaturePolicy(rule string) *Policy {
+	return &Policy{Type: SignaturePolicyType, Rule: rule}
+}
+
+// mergePolicies 使用自定义的策略覆盖默认策略
+func mergePolicies(defaults, policies map[string]*Policy) map[string]*Policy {
+	merged := make(map[string]*Policy, len(defaults)+len(policies))
+	for name, policy := range defaults {
+		merged[name] = policy
+	}
+	for name, policy := range policies {
+		merged[name] = policy
+	}
+
+	return merged
+}
+
+// NewPolicy 将策略转换为通道配置中的 common.Policy
+func NewPolicy(policy *Policy) (*cb.Policy, error) {
+	if policy == nil {
+		return nil, errors.New("policy is required")
+	}
+
+	var (
+		typ   cb.Policy_PolicyType
+		value proto.Message
+		err   error
+	)
+
+	switch policy.Type {
+	case ImplicitMetaPolicyType:
+		typ = cb.Policy_IMPLICIT_META
+		value, err = NewImplicitMetaPolicy(policy.Rule)
+	case SignaturePolicyType:
+		typ = cb.Policy_SIGNATURE
+		value, err = NewSignaturePolicy(policy.Rule)
+	default:
+		return nil, errors.Errorf("unknown policy type: %s", policy.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := proto.Marshal(value)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to marshal policy")
+	}
+
+	return &cb.Policy{Type: int32(typ), Value: data}, nil
+}
+
+func newConfigPolicies(policies map[string]*Policy) (map[string]*cb.ConfigPolicy, error) {
+	configPolicies := make(map[string]*cb.ConfigPolicy, len(policies))
+	for name, policy := range policies {
+		p, err := NewPolicy(policy)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid policy %s", name)
+		}
+
+		configPolicies[name] = &cb.ConfigPolicy{
+			Policy:    p,
+			ModPolicy: AdminsPolicyKey,
+		}
+	}
+
+	return configPolicies, nil
+}
+
+// NewImplicitMetaPolicy 解析形如 "ANY Readers" 的规则
+func NewImplicitMetaPolicy(rule string) (*cb.ImplicitMetaPolicy, error) {
+	fields := strings.Fields(rule)
+	if len(fields) != 2 {
+		return nil, errors.Errorf("implicit meta policy rule must be of the form 'ANY|ALL|MAJORITY SubPolicy', got %q", rule)
+	}
+
+	value, ok := cb.ImplicitMetaPolicy_Rule_value[strings.ToUpper(fields[0])]
+	if !ok {
+		return nil, errors.Errorf("unknown implicit meta policy rule: %s", fields[0])
+	}
+
+	return &cb.ImplicitMetaPolicy{
+		Rule:      cb.ImplicitMetaPolicy_Rule(value),
+		SubPolicy: fields[1],
+	}, nil
+}
+
+var mspRoles = map[string]msp.MSPRole_MSPRoleType{
+	"member":  msp.MSPRole_MEMBER,
+	"admin":   msp.MSPRole_ADMIN,
+	"client":  msp.MSPRole_CLIENT,
+	"peer":    msp.MSPRole_PEER,
+	"orderer": msp.MSPRole_ORDERER,
+}
+
+// NewSignaturePolicy 解析签名策略规则，支持 AND，OR，OutOf 以及 'MSPID.role' 形式的身份
+func NewSignaturePolicy(rule string) (*cb.SignaturePolicyEnvelope, error) {
+	p := &signaturePolicyParser{tokens: tokenize(rule), indexes: make(map[string]int32)}
+
+	signaturePolicy, err := p.parse()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "invalid signature policy %q", rule)
+	}
+	if p.pos != len(p.tokens) {
+		return nil, errors.Errorf("invalid signature policy %q: unexpected %q", rule, p.tokens[p.pos])
+	}
+
+	return &cb.SignaturePolicyEnvelope{
+		Version:    0,
+		Rule:       signaturePolicy,
+		Identities: p.identities,
+	}, nil
+}
+
+func tokenize(rule string) []string {
+	tokens := make([]string, 0)
+
+	for i := 0; i < len(rule); {
+		switch c := rule[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(rule[i+1:], c)
+			if end < 0 {
+				tokens = append(tokens, rule[i:])
+				return tokens
+			}
+			tokens = append(tokens, rule[i:i+end+2])
+			i += end + 2
+		default:
+			end := strings.IndexAny(rule[i:], " \t\n(),'\"")
+			if end < 0 {
+				end = len(rule) - i
+			}
+			tokens = append(tokens, rule[i:i+end])
+			i += end
+		}
+	}
+
+	return tokens
+}
+
+type signaturePolicyParser struct {
+	tokens     []string
+	pos        int
+	identities []*msp.MSPPrincipal
+	indexes    map[string]int32
+}
+
+func (p *signaturePolicyParser) next() (string, error) {
+	if p.pos >= len(p.tokens) {
+		return "", errors.New("unexpected end of rule")
+	}
+
+	token := p.tokens[p.pos]
+	p.pos++
+	return token, nil
+}
+
+func (p *signaturePolicyParser) expect(want string) error {
+	token, err := p.next()
+	if err != nil {
+		return err
+	}
+	if token != want {
+		return errors.Errorf("expected %q, got %q", want, token)
+	}
+
+	return nil
+}
+
+func (p *signaturePolicyParser) parse() (*cb.SignaturePolicy, error) {
+	token, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(token, "'") || strings.HasPrefix(token, "\"") {
+		return p.signedBy(strings.Trim(token, "'\""))
+	}
+
+	var n int
+	switch strings.ToUpper(token) {
+	case "AND", "OR":
+	case "OUTOF":
+		if err = p.expect("("); err != nil {
+			return nil, err
+		}
+		token, err = p.next()
+		if err != nil {
+			return nil, err
+		}
+		if n, err = strconv.Atoi(token); err != nil || n < 0 {
+			return nil, errors.Errorf("invalid OutOf count %q", token)
+		}
+		if err = p.expect(","); err != nil {
+			return nil, err
+		}
+		rules, err := p.parseArgs()
+		if err != nil {
+			return nil, err
+		}
+		return nOutOf(int32(n), rules), nil
+	default:
+		return nil, errors.Errorf("unknown operator %q", token)
+	}
+
+	if err = p.expect("("); err != nil {
+		return nil, err
+	}
+	rules, err := p.parseArgs()
+	if err != nil {
+		return nil, err
+	}
+
+	n = 1
+	if strings.ToUpper(token) == "AND" {
+		n = len(rules)
+	}
+
+	return nOutOf(int32(n), rules), nil
+}
+
+// parseArgs 解析以逗号分隔的子规则直到右括号
+func (p *signaturePolicyParser) parseArgs() ([]*cb.SignaturePolicy, error) {
+	rules := make([]*cb.SignaturePolicy, 0)
+	for {
+		rule, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+
+		token, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch token {
+		case ",":
+			continue
+		case ")":
+			return rules, nil
+		default:
+			return nil, errors.Errorf("expected ',' or ')', got %q", token)
+		}
+	}
+}
+
+func (p *signaturePolicyParser) signedBy(principal string) (*cb.SignaturePolicy, error) {
+	index, ok := p.indexes[principal]
+	if !ok {
+		dot := strings.LastIndexByte(principal, '.')
+		if dot <= 0 {
+			return nil, errors.Errorf("principal %q must be of the form 'MSPID.role'", principal)
+		}
+
+		role, ok := mspRoles[strings.ToLower(principal[dot+1:])]
+		if !ok {
+			return nil, errors.Errorf("unknown msp role in principal %q", principal)
+		}
+
+		data, err := proto.Marshal(&msp.MSPRole{MspIdentifier: principal[:dot], Role: role})
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to marshal msp role")
+		}
+
+		index = int32(len(p.identities))
+		p.indexes[principal] = index
+		p.identities = append(p.identities, &msp.MSPPrincipal{
+			PrincipalClassification: msp.MSPPrincipal_ROLE,
+			Principal:               data,
+		})
+	}
+
+	return &cb.SignaturePolicy{
+		Type: &cb.SignaturePolicy_SignedBy{SignedBy: index},
+	}, nil
+}
+
+func nOutOf(n int32, rules []*cb.SignaturePolicy) *cb.SignaturePolicy {
+	return &cb.SignaturePolicy{
+		Type: &cb.SignaturePolicy_NOutOf_{
+			NOutOf: &cb.SignaturePolicy_NOutOf{N: n, Rules: rules},
+		},
+	}
+}
+
+// signedByAnyRole 生成任意一个角色签名即可满足的策略规则
+func signedByAnyRole(mspID string, roles ...string) string {
+	principals := make([]string, 0, len(roles))
+	for _, role := range roles {
+		principals = append(principals, fmt.Sprintf("'%s.%s'", mspID, role))
+	}
+
+	return fmt.Sprintf("OR(%s)", strings.Join(principals, ", "))
+}
@@ -0,0 +1,356 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package configtx
+
+import (
+	"bytes"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	"github.com/pkg/errors"
+	"github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/protoutil"
+)
+
+// ComputeUpdate 计算原始配置与新配置之间的读写集差异，与 configtxlator compute_update 的结果一致
+func ComputeUpdate(channelID string, original, updated *cb.Config) (*cb.ConfigUpdate, error) {
+	if original.ChannelGroup == nil {
+		return nil, errors.New("no channel group included for original config")
+	}
+	if updated.ChannelGroup == nil {
+		return nil, errors.New("no channel group included for updated config")
+	}
+
+	readSet, writeSet, groupUpdated := computeGroupUpdate(original.ChannelGroup, updated.ChannelGroup)
+	if !groupUpdated {
+		return nil, errors.New("no differences detected between original and updated config")
+	}
+
+	return &cb.ConfigUpdate{
+		ChannelId: channelID,
+		ReadSet:   readSet,
+		WriteSet:  writeSet,
+	}, nil
+}
+
+func computePoliciesMapUpdate(original, updated map[string]*cb.ConfigPolicy) (readSet, writeSet, sameSet map[string]*cb.ConfigPolicy, updatedMembers bool) {
+	readSet = make(map[string]*cb.ConfigPolicy)
+	writeSet = make(map[string]*cb.ConfigPolicy)
+	// sameSet 保存未修改的策略，当配置组的成员变化时需要放入读写集
+	sameSet = make(map[string]*cb.ConfigPolicy)
+
+	for policyName, originalPolicy := range original {
+		updatedPolicy, ok := updated[policyName]
+		if !ok {
+			updatedMembers = true
+			continue
+		}
+
+		if originalPolicy.ModPolicy == updatedPolicy.ModPolicy && proto.Equal(originalPolicy.Policy, updatedPolicy.Policy) {
+			sameSet[policyName] = &cb.ConfigPolicy{Version: originalPolicy.Version}
+			continue
+		}
+
+		writeSet[policyName] = &cb.ConfigPolicy{
+			Version:   originalPolicy.Version + 1,
+			ModPolicy: updatedPolicy.ModPolicy,
+			Policy:    updatedPolicy.Policy,
+		}
+	}
+
+	for policyName, updatedPolicy := range updated {
+		if _, ok := original[policyName]; ok {
+			continue
+		}
+
+		updatedMembers = true
+		writeSet[policyName] = &cb.ConfigPolicy{
+			Version:   0,
+			ModPolicy: updatedPolicy.ModPolicy,
+			Policy:    updatedPolicy.Policy,
+		}
+	}
+
+	return
+}
+
+func computeValuesMapUpdate(original, updated map[string]*cb.ConfigValue) (readSet, writeSet, sameSet map[string]*cb.ConfigValue, updatedMembers bool) {
+	readSet = make(map[string]*cb.ConfigValue)
+	writeSet = make(map[string]*cb.ConfigValue)
+	sameSet = make(map[string]*cb.ConfigValue)
+
+	for valueName, originalValue := range original {
+		updatedValue, ok := updated[valueName]
+		if !ok {
+			updatedMembers = true
+			continue
+		}
+
+		if originalValue.ModPolicy == updatedValue.ModPolicy && bytes.Equal(originalValue.Value, updatedValue.Value) {
+			sameSet[valueName] = &cb.ConfigValue{Version: originalValue.Version}
+			continue
+		}
+
+		writeSet[valueName] = &cb.ConfigValue{
+			Version:   originalValue.Version + 1,
+			ModPolicy: updatedValue.ModPolicy,
+			Value:     updatedValue.Value,
+		}
+	}
+
+	for valueName, updatedValue := range updated {
+		if _, ok := original[valueName]; ok {
+			continue
+		}
+
+		updatedMembers = true
+		writeSet[valueName] = &cb.ConfigValue{
+			Version:   0,
+			ModPolicy: updatedValue.ModPolicy,
+			Value:     updatedValue.Value,
+		}
+	}
+
+	return
+}
+
+func computeGroupsMapUpdate(original, updated map[string]*cb.ConfigGroup) (readSet, writeSet, sameSet map[string]*cb.ConfigGroup, updatedMembers bool) {
+	readSet = make(map[string]*cb.ConfigGroup)
+	writeSet = make(map[string]*cb.ConfigGroup)
+	sameSet = make(map[string]*cb.ConfigGroup)
+
+	for groupName, originalGroup := range original {
+		updatedGroup, ok := updated[groupName]
+		if !ok {
+			updatedMembers = true
+			continue
+		}
+
+		groupReadSet, groupWriteSet, groupUpdated := computeGroupUpdate(originalGroup, updatedGroup)
+		if !groupUpdated {
+			sameSet[groupName] = groupReadSet
+			continue
+		}
+
+		readSet[groupName] = groupReadSet
+		writeSet[groupName] = groupWriteSet
+	}
+
+	for groupName, updatedGroup := range updated {
+		if _, ok := original[groupName]; ok {
+			continue
+		}
+
+		updatedMembers = true
+		_, groupWriteSet, _ := computeGroupUpdate(protoutil.NewConfigGroup(), updatedGroup)
+		writeSet[groupName] = &cb.ConfigGroup{
+			Version:   0,
+			ModPolicy: updatedGroup.ModPolicy,
+			Policies:  groupWriteSet.Policies,
+			Values:    groupWriteSet.Values,
+			Groups:    groupWriteSet.Groups,
+		}
+	}
+
+	return
+}
+
+func computeGroupUpdate(original, updated *cb.ConfigGroup) (readSet, writeSet *cb.ConfigGroup, updatedGroup bool) {
+	readSetPolicies, writeSetPolicies, sameSetPolicies, policiesMembersUpdated := computePoliciesMapUpdate(original.Policies, updated.Policies)
+	readSetValues, writeSetValues, sameSetValues, valuesMembersUpdated := computeValuesMapUpdate(original.Values, updated.Values)
+	readSetGroups, writeSetGroups, sameSetGroups, groupsMembersUpdated := computeGroupsMapUpdate(original.Groups, updated.Groups)
+
+	// 配置组的成员以及修改策略没有变化时，配置组本身的版本号不需要增加
+	if !(policiesMembersUpdated || valuesMembersUpdated || groupsMembersUpdated || original.ModPolicy != updated.ModPolicy) {
+		if len(readSetPolicies) == 0 &&
+			len(writeSetPolicies) == 0 &&
+			len(readSetValues) == 0 &&
+			len(writeSetValues) == 0 &&
+			len(readSetGroups) == 0 &&
+			len(writeSetGroups) == 0 {
+			return &cb.ConfigGroup{
+				Version: original.Version,
+			}, &cb.ConfigGroup{
+				Version: original.Version,
+			}, false
+		}
+
+		return &cb.ConfigGroup{
+			Version:  original.Version,
+			Policies: readSetPolicies,
+			Values:   readSetValues,
+			Groups:   readSetGroups,
+		}, &cb.ConfigGroup{
+			Version:  original.Version,
+			Policies: writeSetPolicies,
+			Values:   writeSetValues,
+			Groups:   writeSetGroups,
+		}, true
+	}
+
+	for k, samePolicy := range sameSetPolicies {
+		readSetPolicies[k] = samePolicy
+		writeSetPolicies[k] = samePolicy
+	}
+
+	for k, sameValue := range sameSetValues {
+		readSetValues[k] = sameValue
+		writeSetValues[k] = sameValue
+	}
+
+	for k, sameGroup := range sameSetGroups {
+		readSetGroups[k] = sameGroup
+		writeSetGroups[k] = sameGroup
+	}
+
+	return &cb.ConfigGroup{
+		Version:  original.Version,
+		Policies: readSetPolicies,
+		Values:   readSetValues,
+		Groups:   readSetGroups,
+	}, &cb.ConfigGroup{
+		Version:   original.Version + 1,
+		Policies:  writeSetPolicies,
+		Values:    writeSetValues,
+		Groups:    writeSetGroups,
+		ModPolicy: updated.ModPolicy,
+	}, true
+}
+
+// ApplyUpdate 将配置更新的写集应用到当前配置，生成排序节点提交后的新配置，序号加 1。
+// 与排序节点一致，写集中版本号与当前配置不同的元素被替换为写集中的内容，版本号增加的配置组的成员以写集为准
+func ApplyUpdate(config *cb.Config, configUpdate *cb.ConfigUpdate) (*cb.Config, error) {
+	if config.ChannelGroup == nil {
+		return nil, errors.New("no channel group included for config")
+	}
+	if configUpdate.WriteSet == nil {
+		return nil, errors.New("no write set included for config update")
+	}
+
+	group, err := applyGroupUpdate("/"+rootGroupKey, config.ChannelGroup, configUpdate.WriteSet)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cb.Config{
+		Sequence:     config.Sequence + 1,
+		ChannelGroup: group,
+	}, nil
+}
+
+func applyGroupUpdate(path string, current, write *cb.ConfigGroup) (*cb.ConfigGroup, error) {
+	// 新增的配置组以写集为准
+	if current == nil {
+		return proto.Clone(write).(*cb.ConfigGroup), nil
+	}
+
+	membersUpdated := write.Version != current.Version
+	if membersUpdated && write.Version != current.Version+1 {
+		return nil, errors.Errorf("group %v version %v does not follow current version %v",
+			path, write.Version, current.Version)
+	}
+
+	group := &cb.ConfigGroup{
+		Version:   current.Version,
+		ModPolicy: current.ModPolicy,
+		Policies:  make(map[string]*cb.ConfigPolicy),
+		Values:    make(map[string]*cb.ConfigValue),
+		Groups:    make(map[string]*cb.ConfigGroup),
+	}
+	if membersUpdated {
+		group.Version = write.Version
+		group.ModPolicy = write.ModPolicy
+	} else {
+		// 配置组版本号不变时成员不变，写集中只包含被修改的成员
+		for name, policy := range current.Policies {
+			group.Policies[name] = policy
+		}
+		for name, value := range current.Values {
+			group.Values[name] = value
+		}
+		for name, child := range current.Groups {
+			group.Groups[name] = child
+		}
+	}
+
+	for name, policy := range write.Policies {
+		currentPolicy, ok := current.Policies[name]
+		switch {
+		case !ok && !membersUpdated:
+			return nil, errors.Errorf("policy %v/%v added without updating the group version", path, name)
+		case ok && policy.Version == currentPolicy.Version:
+			group.Policies[name] = currentPolicy
+		default:
+			group.Policies[name] = policy
+		}
+	}
+
+	for name, value := range write.Values {
+		currentValue, ok := current.Values[name]
+		switch {
+		case !ok && !membersUpdated:
+			return nil, errors.Errorf("value %v/%v added without updating the group version", path, name)
+		case ok && value.Version == currentValue.Version:
+			group.Values[name] = currentValue
+		default:
+			group.Values[name] = value
+		}
+	}
+
+	for name, child := range write.Groups {
+		currentChild, ok := current.Groups[name]
+		if !ok && !membersUpdated {
+			return nil, errors.Errorf("group %v/%v added without updating the group version", path, name)
+		}
+
+		var err error
+		if group.Groups[name], err = applyGroupUpdate(path+"/"+name, currentChild, child); err != nil {
+			return nil, err
+		}
+	}
+
+	return group, nil
+}
+
+// NewConfigSignature 使用 signer 对配置更新进行签名
+func NewConfigSignature(signer protoutil.Signer, configUpdate []byte) (*cb.ConfigSignature, error) {
+	signatureHeader, err := protoutil.NewSignatureHeader(signer)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create signature header")
+	}
+
+	header, err := proto.Marshal(signatureHeader)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to marshal signature header")
+	}
+
+	signature, err := signer.Sign(bytes.Join([][]byte{header, configUpdate}, nil))
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to sign config update")
+	}
+
+	return &cb.ConfigSignature{
+		SignatureHeader: header,
+		Signature:       signature,
+	}, nil
+}
+
+// NewConfigUpdateTx 生成可以直接提交给排序节点的 CONFIG_UPDATE 交易
+func NewConfigUpdateTx(channelID string, signer protoutil.Signer, configUpdateEnv *cb.ConfigUpdateEnvelope) (*cb.Envelope, error) {
+	env, err := protoutil.CreateSignedEnvelope(cb.HeaderType_CONFIG_UPDATE, channelID, signer, configUpdateEnv, 0, 0)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create config update envelope")
+	}
+
+	// 校验生成的交易可以被还原为原始的配置更新
+	if _, err = protoutil.EnvelopeToConfigUpdate(env); err != nil {
+		return nil, errors.WithMessage(err, "invalid config update envelope")
+	}
+
+	return env, nil
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package configtx
+
+import (
+	"crypto/elliptic"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/hyperledger/fabric-protos-go/orderer/etcdraft"
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/certificate"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
+	"github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/protoutil"
+)
+
+type testOrg struct {
+	org     *Organization
+	signers map[string]*crypto.Signer
+}
+
+func genKeyPair(t *testing.T, algorithm crypto.Algorithm) (crypto.Key, []byte, []byte) {
+	privateKey, err := factory.CryptoKeyGen(algorithm)
+	assert.NoError(t, err)
+	privateKeyPem, err := privateKey.Bytes()
+	assert.NoError(t, err)
+	publicKey, err := privateKey.PublicKey()
+	assert.NoError(t, err)
+	publicKeyPem, err := publicKey.Bytes()
+	assert.NoError(t, err)
+
+	return privateKey, privateKeyPem, publicKeyPem
+}
+
+func newTestOrg(t *testing.T, mspID string, algorithm crypto.Algorithm) *testOrg {
+	_, caKeyPem, _ := genKeyPair(t, algorithm)
+	name := &certificate.PkixName{Domain: mspID + ".example.com", CommonName: "ca." + mspID + ".example.com"}
+	caPem, err := certificate.NewCA(name, caKeyPem)
+	assert.NoError(t, err)
+
+	config, err := NewMSPConfig(&MSPOptions{MSPID: mspID, RootCerts: [][]byte{caPem}, TLSRoots: [][]byte{caPem}, NodeOUs: true})
+	assert.NoError(t, err)
+
+	signers := make(map[string]*crypto.Signer)
+	for _, ou := range []string{certificate.MSPTypeAdmin, certificate.MSPTypeClient} {
+		key, _, pub := genKeyPair(t, algorithm)
+		cert, err := certificate.SignCertificate(name, ou+"@"+mspID, ou, nil, pub, caKeyPem, caPem)
+		assert.NoError(t, err)
+		signers[ou], err = crypto.NewSigner(mspID, cert, key)
+		assert.NoError(t, err)
+	}
+
+	return &testOrg{
+		org:     &Organization{MSPID: mspID, MSP: config, Endpoints: []string{"orderer0." + mspID + ":7050"}},
+		signers: signers,
+	}
+}
+
+func newTestConfig(t *testing.T, orderer *testOrg, orgs ...*testOrg) *cb.Config {
+	channel := &Channel{
+		ChannelID: "mychannel",
+		Orderer: &Orderer{
+			Organizations: []*Organization{orderer.org},
+			Consenters:    []*etcdraft.Consenter{{Host: "orderer0", Port: 7050}},
+		},
+		Application: &Application{},
+	}
+	for _, org := range orgs {
+		channel.Application.Organizations = append(channel.Application.Organizations, org.org)
+	}
+
+	group, err := NewChannelGroup(channel)
+	assert.NoError(t, err)
+
+	return &cb.Config{ChannelGroup: group}
+}
+
+func TestComputeUpdateAndEvaluate(t *testing.T) {
+	orderer, org1, org2, org3 := newTestOrg(t, "orderer", crypto.EcdsaP256), newTestOrg(t, "org1", crypto.EcdsaP256),
+		newTestOrg(t, "org2", crypto.Sm2), newTestOrg(t, "org3", crypto.EcdsaP256)
+
+	original := newTestConfig(t, orderer, org1, org2)
+	updated := newTestConfig(t, orderer, org1, org2, org3)
+	// 重新生成的配置与原配置中除 org3 外的内容保持一致
+	updated.ChannelGroup.Groups[ApplicationGroupKey].Groups["org1"] = original.ChannelGroup.Groups[ApplicationGroupKey].Groups["org1"]
+	updated.ChannelGroup.Groups[ApplicationGroupKey].Groups["org2"] = original.ChannelGroup.Groups[ApplicationGroupKey].Groups["org2"]
+	updated.ChannelGroup.Groups[OrdererGroupKey] = original.ChannelGroup.Groups[OrdererGroupKey]
+
+	configUpdate, err := ComputeUpdate("mychannel", original, updated)
+	assert.NoError(t, err)
+
+	application := configUpdate.WriteSet.Groups[ApplicationGroupKey]
+	assert.Equal(t, uint64(1), application.Version)
+	assert.Contains(t, application.Groups, "org3")
+	assert.NotContains(t, configUpdate.WriteSet.Groups, OrdererGroupKey)
+
+	_, err = ComputeUpdate("mychannel", original, original)
+	assert.Error(t, err)
+
+	configUpdateBytes, err := proto.Marshal(configUpdate)
+	assert.NoError(t, err)
+	env := &cb.ConfigUpdateEnvelope{ConfigUpdate: configUpdateBytes}
+
+	sign := func(s *crypto.Signer) {
+		signature, err := NewConfigSignature(s, configUpdateBytes)
+		assert.NoError(t, err)
+		env.Signatures = append(env.Signatures, signature)
+	}
+
+	status, err := EvaluateUpdate(original, env)
+	assert.NoError(t, err)
+	assert.False(t, status.Satisfied)
+	assert.Equal(t, []*PolicyStatus{{Path: "/Channel/Application/Admins"}}, status.Policies)
+
+	// client 以及非通道成员的签名不满足 Admins 策略
+	sign(org1.signers[certificate.MSPTypeAdmin])
+	sign(org2.signers[certificate.MSPTypeClient])
+	sign(org3.signers[certificate.MSPTypeAdmin])
+	status, err = EvaluateUpdate(original, env)
+	assert.NoError(t, err)
+	assert.False(t, status.Satisfied)
+	assert.Len(t, status.Signatures, 3)
+	assert.True(t, status.Signatures[0].Valid)
+	assert.Equal(t, []string{"admin", "member"}, status.Signatures[0].Roles)
+	// org2 为 SM2 组织，签名使用 SM3 计算摘要
+	assert.True(t, status.Signatures[1].Valid)
+	assert.Equal(t, []string{"client", "member"}, status.Signatures[1].Roles)
+	assert.False(t, status.Signatures[2].Valid)
+
+	sign(org2.signers[certificate.MSPTypeAdmin])
+	status, err = EvaluateUpdate(original, env)
+	assert.NoError(t, err)
+	assert.True(t, status.Satisfied)
+
+	tx, err := NewConfigUpdateTx("mychannel", org1.signers[certificate.MSPTypeAdmin], env)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tx.Signature)
+
+	// 配置更新过期后不再有效
+	_, err = EvaluateUpdate(updated, env)
+	assert.NoError(t, err)
+	updated.ChannelGroup.Groups[ApplicationGroupKey].Version = 1
+	_, err = EvaluateUpdate(updated, env)
+	assert.Error(t, err)
+}
+
+func TestApplyUpdate(t *testing.T) {
+	orderer, org1, org2, org3 := newTestOrg(t, "orderer", crypto.EcdsaP256), newTestOrg(t, "org1", crypto.EcdsaP256),
+		newTestOrg(t, "org2", crypto.EcdsaP256), newTestOrg(t, "org3", crypto.EcdsaP256)
+
+	original := newTestConfig(t, orderer, org1, org2)
+	updated := proto.Clone(original).(*cb.Config)
+	updated.ChannelGroup.Groups[ApplicationGroupKey].Groups["org3"] =
+		newTestConfig(t, orderer, org3).ChannelGroup.Groups[ApplicationGroupKey].Groups["org3"]
+
+	configUpdate, err := ComputeUpdate("mychannel", original, updated)
+	assert.NoError(t, err)
+	applied, err := ApplyUpdate(original, configUpdate)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), applied.Sequence)
+	application := applied.ChannelGroup.Groups[ApplicationGroupKey]
+	assert.Equal(t, uint64(1), application.Version)
+	assert.Contains(t, application.Groups, "org3")
+	assert.True(t, proto.Equal(original.ChannelGroup.Groups[ApplicationGroupKey].Groups["org1"], application.Groups["org1"]))
+	assert.True(t, proto.Equal(original.ChannelGroup.Groups[OrdererGroupKey], applied.ChannelGroup.Groups[OrdererGroupKey]))
+
+	// 之后的配置更新基于应用后的版本号计算读集
+	removed := proto.Clone(applied).(*cb.Config)
+	delete(removed.ChannelGroup.Groups[ApplicationGroupKey].Groups, "org2")
+	configUpdate, err = ComputeUpdate("mychannel", applied, removed)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), configUpdate.ReadSet.Groups[ApplicationGroupKey].Version)
+	applied, err = ApplyUpdate(applied, configUpdate)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), applied.Sequence)
+	assert.Equal(t, uint64(2), applied.ChannelGroup.Groups[ApplicationGroupKey].Version)
+	assert.NotContains(t, applied.ChannelGroup.Groups[ApplicationGroupKey].Groups, "org2")
+
+	// 写集的版本号跳跃时无法应用
+	configUpdate.WriteSet.Groups[ApplicationGroupKey].Version = 4
+	_, err = ApplyUpdate(removed, configUpdate)
+	assert.Error(t, err)
+}
+
+func TestValidateSignedDataLowS(t *testing.T) {
+	org1 := newTestOrg(t, "org1", crypto.EcdsaP256)
+	msps, err := collectMSPs(newTestConfig(t, org1, org1).ChannelGroup)
+	assert.NoError(t, err)
+
+	admin := org1.signers[certificate.MSPTypeAdmin]
+	identity, err := admin.Serialize()
+	assert.NoError(t, err)
+	data := []byte("config update")
+	sig, err := admin.Sign(data)
+	assert.NoError(t, err)
+
+	s, status := validateSignedData(msps, &protoutil.SignedData{Data: data, Identity: identity, Signature: sig})
+	assert.NotNil(t, s)
+	assert.True(t, status.Valid)
+
+	// S 替换为 N-S 后签名在数学上仍然有效，但 high-S 格式的签名需要拒绝
+	var signature struct{ R, S *big.Int }
+	_, err = asn1.Unmarshal(sig, &signature)
+	assert.NoError(t, err)
+	signature.S.Sub(elliptic.P256().Params().N, signature.S)
+	highS, err := asn1.Marshal(signature)
+	assert.NoError(t, err)
+
+	s, status = validateSignedData(msps, &protoutil.SignedData{Data: data, Identity: identity, Signature: highS})
+	assert.Nil(t, s)
+	assert.False(t, status.Valid)
+}
+
+func TestEvaluateSignaturePolicy(t *testing.T) {
+	signers := []*signer{
+		{mspID: "org1", roles: map[msp.MSPRole_MSPRoleType]bool{msp.MSPRole_MEMBER: true, msp.MSPRole_ADMIN: true}},
+		{mspID: "org2", roles: map[msp.MSPRole_MSPRoleType]bool{msp.MSPRole_MEMBER: true, msp.MSPRole_PEER: true}},
+	}
+
+	tests := []struct {
+		rule      string
+		satisfied bool
+	}{
+		{rule: "OR('org1.admin')", satisfied: true},
+		{rule: "AND('org1.admin', 'org2.peer')", satisfied: true},
+		{rule: "AND('org1.admin', 'org1.member')", satisfied: false},
+		{rule: "OutOf(2, 'org1.member', 'org2.member', 'org3.member')", satisfied: true},
+		{rule: "OR('org2.admin', 'org1.client')", satisfied: false},
+	}
+
+	for _, test := range tests {
+		envelope, err := NewSignaturePolicy(test.rule)
+		assert.NoError(t, err)
+		assert.Equal(t, test.satisfied,
+			evaluateSignaturePolicy(envelope.Rule, envelope.Identities, signers, make([]bool, len(signers))), test.rule)
+	}
+}
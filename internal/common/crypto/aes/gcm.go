@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package aes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+// GCMKey AES-GCM 密钥，密文格式为 nonce || ciphertext || tag
+type GCMKey struct {
+	key []byte
+}
+
+// Bytes 返回原始密钥，用于封装后保存
+func (a *GCMKey) Bytes() ([]byte, error) {
+	return append([]byte(nil), a.key...), nil
+}
+
+func (a *GCMKey) SKI() []byte {
+	hash := sha256.New()
+	hash.Write(a.key)
+	return hash.Sum(nil)
+}
+
+func (a *GCMKey) Symmetric() bool {
+	return true
+}
+
+func (a *GCMKey) Private() bool {
+	return true
+}
+
+func (a *GCMKey) PublicKey() (crypto.Key, error) {
+	return nil, errors.New("cannot call this method on a symmetric key")
+}
+
+func (a *GCMKey) Sign(_ []byte) ([]byte, error) {
+	return nil, errors.New("cannot call this method on a symmetric key")
+}
+
+func (a *GCMKey) Verify(_, _ []byte) bool {
+	return false
+}
+
+func (a *GCMKey) Encrypt(text []byte) ([]byte, error) {
+	return a.EncryptWithAssociatedData(text, nil)
+}
+
+func (a *GCMKey) Decrypt(ciphertext []byte) ([]byte, error) {
+	return a.DecryptWithAssociatedData(ciphertext, nil)
+}
+
+func (a *GCMKey) EncryptWithAssociatedData(text, ad []byte) ([]byte, error) {
+	aead, err := a.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomIV(aead.NonceSize())
+	if err != nil {
+		return nil, fmt.Errorf("random nonce error: %v", err)
+	}
+
+	return aead.Seal(nonce, nonce, text, ad), nil
+}
+
+func (a *GCMKey) DecryptWithAssociatedData(ciphertext, ad []byte) ([]byte, error) {
+	aead, err := a.aead()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("invalid ciphertext length")
+	}
+
+	nonce := ciphertext[:aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[aead.NonceSize():], ad)
+}
+
+func (a *GCMKey) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(a.key)
+	if err != nil {
+		return nil, fmt.Errorf("new chipher error: %v", err)
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -37,8 +37,9 @@ type CBCKey struct {
 	key []byte
 }
 
+// Bytes 返回原始密钥，用于封装后保存
 func (a *CBCKey) Bytes() ([]byte, error) {
-	return nil, errors.New("not supported")
+	return append([]byte(nil), a.key...), nil
 }
 
 func (a *CBCKey) SKI() []byte {
@@ -93,6 +94,10 @@ func (a *CBCKey) Encrypt(text []byte) ([]byte, error) {
 }
 
 func (a *CBCKey) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aes.BlockSize*2 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid ciphertext length")
+	}
+
 	iv := ciphertext[0:16]
 	src := ciphertext[16:]
 
@@ -105,7 +110,7 @@ func (a *CBCKey) Decrypt(ciphertext []byte) ([]byte, error) {
 	paddedText := make([]byte, len(src))
 	mode.CryptBlocks(paddedText, src)
 
-	return pkcs7UnPadding(paddedText), nil
+	return pkcs7UnPadding(paddedText)
 }
 
 func pkcs7Padding(src []byte) []byte {
@@ -120,7 +125,11 @@ func pkcs7Padding(src []byte) []byte {
 	return append(src, paddingText...)
 }
 
-func pkcs7UnPadding(src []byte) []byte {
+func pkcs7UnPadding(src []byte) ([]byte, error) {
 	unPadding := int(src[len(src)-1])
-	return src[:(len(src) - unPadding)]
+	if unPadding == 0 || unPadding > aes.BlockSize || unPadding > len(src) {
+		return nil, errors.New("invalid padding")
+	}
+
+	return src[:(len(src) - unPadding)], nil
 }
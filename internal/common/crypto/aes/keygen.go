@@ -0,0 +1,36 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package aes
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+func KeyGen(opts crypto.KeyGenOpts) (crypto.Key, error) {
+	return new(keyGenerator).KeyGen(opts)
+}
+
+type keyGenerator struct{}
+
+func (kg *keyGenerator) KeyGen(opts crypto.KeyGenOpts) (crypto.Key, error) {
+	keyLen := keyLength(opts.Algorithm())
+	if keyLen == 0 {
+		return nil, fmt.Errorf("unsupported aes algorithm: %v", opts.Algorithm())
+	}
+
+	key := make([]byte, keyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating AES key error: [%s]", err)
+	}
+
+	return new(keyImporter).KeyImport(key, opts)
+}
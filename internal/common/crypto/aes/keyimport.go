@@ -34,17 +34,7 @@ func (kg *keyImporter) KeyImport(raw interface{}, opts crypto.KeyImportOpts) (cr
 		return nil, fmt.Errorf("only supports string or []byte type of key")
 	}
 
-	keyLen := 0
-
-	switch opts.Algorithm() {
-	case crypto.AesCbc128:
-		keyLen = 128 / 8
-	case crypto.AesCbc192:
-		keyLen = 192 / 8
-	case crypto.AesCbc256:
-		keyLen = 256 / 8
-	}
-
+	keyLen := keyLength(opts.Algorithm())
 	if len(key) != keyLen {
 		key = pbkdf2.Key(key, key, 1000, keyLen, sha256.New)
 	}
@@ -54,7 +44,25 @@ func (kg *keyImporter) KeyImport(raw interface{}, opts crypto.KeyImportOpts) (cr
 		return &CBCKey{
 			key: key,
 		}, nil
+	case crypto.AesGcm256:
+		return &GCMKey{
+			key: key,
+		}, nil
 	}
 
 	return nil, fmt.Errorf("unsupported aes algorithm: %v", opts.Algorithm())
 }
+
+// keyLength 算法对应的密钥长度，单位为 byte，不支持的算法返回 0
+func keyLength(algorithm crypto.Algorithm) int {
+	switch algorithm {
+	case crypto.AesCbc128:
+		return 128 / 8
+	case crypto.AesCbc192:
+		return 192 / 8
+	case crypto.AesCbc256, crypto.AesGcm256:
+		return 256 / 8
+	}
+
+	return 0
+}
@@ -28,7 +28,7 @@ func TestKeyImport(t *testing.T) {
 		{
 			struct {
 			}{},
-			errors.New("only supports []byte type of key"),
+			errors.New("only supports string or []byte type of key"),
 		},
 	}
 
@@ -0,0 +1,26 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package aes
+
+import (
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+func init() {
+	for _, algorithm := range []crypto.Algorithm{crypto.AesCbc128, crypto.AesCbc192, crypto.AesCbc256, crypto.AesGcm256} {
+		crypto.Register(&crypto.AlgorithmInfo{
+			Algorithm:    algorithm,
+			KeySize:      keyLength(algorithm) * 8,
+			Symmetric:    true,
+			Usages:       crypto.UsageEncrypt | crypto.UsageWrap,
+			KeyGenerator: new(keyGenerator),
+			KeyImporter:  new(keyImporter),
+		})
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package chacha20poly1305
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Key XChaCha20-Poly1305 密钥，使用 192 位的随机 nonce，密文格式为 nonce || ciphertext || tag
+type Key struct {
+	key []byte
+}
+
+// Bytes 返回原始密钥，用于封装后保存
+func (c *Key) Bytes() ([]byte, error) {
+	return append([]byte(nil), c.key...), nil
+}
+
+func (c *Key) SKI() []byte {
+	hash := sha256.New()
+	hash.Write(c.key)
+	return hash.Sum(nil)
+}
+
+func (c *Key) Symmetric() bool {
+	return true
+}
+
+func (c *Key) Private() bool {
+	return true
+}
+
+func (c *Key) PublicKey() (crypto.Key, error) {
+	return nil, errors.New("cannot call this method on a symmetric key")
+}
+
+func (c *Key) Sign(_ []byte) ([]byte, error) {
+	return nil, errors.New("cannot call this method on a symmetric key")
+}
+
+func (c *Key) Verify(_, _ []byte) bool {
+	return false
+}
+
+func (c *Key) Encrypt(text []byte) ([]byte, error) {
+	return c.EncryptWithAssociatedData(text, nil)
+}
+
+func (c *Key) Decrypt(ciphertext []byte) ([]byte, error) {
+	return c.DecryptWithAssociatedData(ciphertext, nil)
+}
+
+func (c *Key) EncryptWithAssociatedData(text, ad []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(c.key)
+	if err != nil {
+		return nil, fmt.Errorf("new chipher error: %v", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("random nonce error: %v", err)
+	}
+
+	return aead.Seal(nonce, nonce, text, ad), nil
+}
+
+func (c *Key) DecryptWithAssociatedData(ciphertext, ad []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(c.key)
+	if err != nil {
+		return nil, fmt.Errorf("new chipher error: %v", err)
+	}
+
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("invalid ciphertext length")
+	}
+
+	nonce := ciphertext[:aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[aead.NonceSize():], ad)
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package chacha20poly1305
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+func TestEncryptWithAssociatedData(t *testing.T) {
+	key, err := NewKey("test password", &crypto.XChaCha20Poly1305KeyImportOpts{})
+	assert.NoError(t, err)
+	aeadKey := key.(crypto.AEADKey)
+
+	tcs := []struct {
+		name    string
+		ad      []byte
+		openAd  []byte
+		success bool
+	}{
+		{"without associated data", nil, nil, true},
+		{"same associated data", []byte("alice/protectedSymmetricKey"), []byte("alice/protectedSymmetricKey"), true},
+		{"different associated data", []byte("alice/protectedSymmetricKey"), []byte("bob/protectedSymmetricKey"), false},
+	}
+
+	for _, tc := range tcs {
+		ciphertext, err := aeadKey.EncryptWithAssociatedData([]byte("hello world"), tc.ad)
+		assert.NoError(t, err, tc.name)
+
+		text, err := aeadKey.DecryptWithAssociatedData(ciphertext, tc.openAd)
+		assert.Equal(t, tc.success, err == nil, tc.name)
+		if tc.success {
+			assert.Equal(t, "hello world", string(text), tc.name)
+		}
+	}
+
+	_, err = key.Decrypt([]byte("short"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,38 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package chacha20poly1305
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+type KeyGenerator struct{}
+
+func KeyGen(opts crypto.KeyGenOpts) (crypto.Key, error) {
+	return new(KeyGenerator).KeyGen(opts)
+}
+
+func (k *KeyGenerator) KeyGen(opts crypto.KeyGenOpts) (crypto.Key, error) {
+	if opts.Algorithm() != crypto.XChaCha20Poly1305 {
+		return nil, fmt.Errorf("unsupported chacha20poly1305 algorithm: %v", opts.Algorithm())
+	}
+
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating XChaCha20-Poly1305 key error: [%s]", err)
+	}
+
+	return &Key{
+		key: key,
+	}, nil
+}
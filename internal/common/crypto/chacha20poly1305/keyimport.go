@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package chacha20poly1305
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+type KeyImporter struct{}
+
+func NewKey(raw interface{}, opts crypto.KeyImportOpts) (crypto.Key, error) {
+	return new(KeyImporter).KeyImport(raw, opts)
+}
+
+// KeyImport 密钥长度不是 256 位时与 AES 密钥一样使用 PBKDF2 扩展
+func (k *KeyImporter) KeyImport(raw interface{}, opts crypto.KeyImportOpts) (crypto.Key, error) {
+	var key []byte
+
+	switch raw := raw.(type) {
+	case []byte:
+		key = raw
+	case string:
+		key = []byte(raw)
+	default:
+		return nil, fmt.Errorf("only supports string or []byte type of key")
+	}
+
+	if opts.Algorithm() != crypto.XChaCha20Poly1305 {
+		return nil, fmt.Errorf("unsupported chacha20poly1305 algorithm: %v", opts.Algorithm())
+	}
+
+	if len(key) != chacha20poly1305.KeySize {
+		key = pbkdf2.Key(key, key, 1000, chacha20poly1305.KeySize, sha256.New)
+	}
+
+	return &Key{
+		key: key,
+	}, nil
+}
@@ -0,0 +1,25 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package chacha20poly1305
+
+import (
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func init() {
+	crypto.Register(&crypto.AlgorithmInfo{
+		Algorithm:    crypto.XChaCha20Poly1305,
+		KeySize:      chacha20poly1305.KeySize * 8,
+		Symmetric:    true,
+		Usages:       crypto.UsageEncrypt | crypto.UsageWrap,
+		KeyGenerator: new(KeyGenerator),
+		KeyImporter:  new(KeyImporter),
+	})
+}
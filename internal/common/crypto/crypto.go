@@ -26,6 +26,31 @@ type Key interface {
 	Decrypt(src []byte) ([]byte, error)
 }
 
+// LowSVerifier 由 ECDSA 公钥实现，Verify 使用 VerifyLowS 代替 Key.Verify，
+// 与 Fabric 一样拒绝 high-S 格式的签名
+type LowSVerifier interface {
+	VerifyLowS(hash, sig []byte) bool
+}
+
+// AEADKey 由 AEAD 对称密钥以及 RSA 密钥（附加数据作为 OAEP 的 label）实现，附加数据（例如记录 ID 以及字段名）
+// 参与认证但不加密，解密时需要提供相同的附加数据。Encrypt 以及 Decrypt 等同于附加数据为空
+type AEADKey interface {
+	EncryptWithAssociatedData(src, ad []byte) ([]byte, error)
+	DecryptWithAssociatedData(src, ad []byte) ([]byte, error)
+}
+
+// Digester 由不使用 SHA-256 计算消息摘要的非对称密钥实现，例如 SM2 密钥使用 SM3，
+// Signer 签名前以及 Verify 验签前使用 Digest 代替 SHA-256
+type Digester interface {
+	Digest(msg []byte) []byte
+}
+
+// StdSigner 由私钥不可导出的非对称私钥实现，例如 PKCS#11 设备中的私钥，
+// 返回的标准库 crypto.Signer 可以直接用于签发证书
+type StdSigner interface {
+	Signer() crypto.Signer
+}
+
 type KeyGenerator interface {
 	KeyGen(opts KeyGenOpts) (Key, error)
 }
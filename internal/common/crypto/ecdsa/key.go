@@ -9,16 +9,18 @@
 package ecdsa
 
 import (
-	cryptoStd "crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/pem"
+	"math/big"
 
 	"github.com/pkg/errors"
 	"github.com/yakumioto/alkaid/internal/common/crypto"
+	fabricCrypto "github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/common/crypto"
 )
 
 type ecdsaPrivateKey struct {
@@ -51,8 +53,10 @@ func (e *ecdsaPrivateKey) PublicKey() (crypto.Key, error) {
 	return &ecdsaPublicKey{publicKey: &e.privateKey.PublicKey}, nil
 }
 
+// Sign 签名结果为 low-S 格式，Fabric 会拒绝 high-S 格式的签名
 func (e *ecdsaPrivateKey) Sign(digest []byte) ([]byte, error) {
-	return e.privateKey.Sign(rand.Reader, digest, cryptoStd.SHA256)
+	signer := &fabricCrypto.ECDSASigner{PrivateKey: e.privateKey}
+	return signer.Sign(rand.Reader, digest, nil)
 }
 
 func (e *ecdsaPrivateKey) Verify(_, _ []byte) bool {
@@ -110,6 +114,22 @@ func (e *ecdsaPublicKey) Verify(hash, sig []byte) bool {
 	return ecdsa.VerifyASN1(e.publicKey, hash, sig)
 }
 
+// VerifyLowS 在 Verify 的基础上拒绝 high-S 格式的签名，与 Fabric 的校验规则一致
+func (e *ecdsaPublicKey) VerifyLowS(hash, sig []byte) bool {
+	signature := new(fabricCrypto.ECDSASignature)
+	rest, err := asn1.Unmarshal(sig, signature)
+	if err != nil || len(rest) != 0 || signature.S == nil {
+		return false
+	}
+
+	halfOrder := new(big.Int).Rsh(e.publicKey.Params().N, 1)
+	if signature.S.Cmp(halfOrder) == 1 {
+		return false
+	}
+
+	return ecdsa.VerifyASN1(e.publicKey, hash, sig)
+}
+
 func (e *ecdsaPublicKey) Encrypt(_ []byte) ([]byte, error) {
 	return nil, errors.New("not supported")
 }
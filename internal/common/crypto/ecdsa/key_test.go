@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package ecdsa
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	fabricCrypto "github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/common/crypto"
+)
+
+func TestECDSASignLowS(t *testing.T) {
+	for _, opts := range []crypto.KeyGenOpts{&crypto.ECDSAP256KeyGenOpts{}, &crypto.ECDSAP384KeyGenOpts{}} {
+		privateKey, err := KeyGen(opts)
+		assert.NoError(t, err)
+		publicKey, err := privateKey.PublicKey()
+		assert.NoError(t, err)
+		verifier := publicKey.(crypto.LowSVerifier)
+		n := publicKey.(*ecdsaPublicKey).publicKey.Params().N
+
+		// 随机签名约一半为 high-S，多次签名保证覆盖到需要转换的情况
+		for i := 0; i < 32; i++ {
+			digest := sha256.Sum256([]byte{byte(i)})
+			sig, err := privateKey.Sign(digest[:])
+			assert.NoError(t, err)
+			assert.True(t, publicKey.Verify(digest[:], sig), opts.Algorithm())
+			assert.True(t, verifier.VerifyLowS(digest[:], sig), opts.Algorithm())
+
+			// (r, N-s) 同样是合法的签名，但是 Fabric 会拒绝
+			signature := new(fabricCrypto.ECDSASignature)
+			_, err = asn1.Unmarshal(sig, signature)
+			assert.NoError(t, err)
+			signature.S.Sub(n, signature.S)
+			highS, err := asn1.Marshal(*signature)
+			assert.NoError(t, err)
+			assert.True(t, publicKey.Verify(digest[:], highS), opts.Algorithm())
+			assert.False(t, verifier.VerifyLowS(digest[:], highS), opts.Algorithm())
+		}
+
+		digest := sha256.Sum256([]byte("message"))
+		assert.False(t, verifier.VerifyLowS(digest[:], []byte("invalid")), opts.Algorithm())
+		invalid, err := asn1.Marshal(fabricCrypto.ECDSASignature{R: big.NewInt(1), S: big.NewInt(1)})
+		assert.NoError(t, err)
+		assert.False(t, verifier.VerifyLowS(digest[:], invalid), opts.Algorithm())
+	}
+}
+
+func TestKeyImport(t *testing.T) {
+	privateKey, err := KeyGen(&crypto.ECDSAP256KeyGenOpts{})
+	assert.NoError(t, err)
+	publicKey, err := privateKey.PublicKey()
+	assert.NoError(t, err)
+
+	for _, key := range []crypto.Key{privateKey, publicKey} {
+		raw, err := key.Bytes()
+		assert.NoError(t, err)
+
+		imported, err := KeyImport(raw)
+		assert.NoError(t, err)
+		assert.Equal(t, key.Private(), imported.Private())
+		assert.Equal(t, key.SKI(), imported.SKI())
+	}
+
+	_, err = KeyImport([]byte("invalid"))
+	assert.Error(t, err)
+}
@@ -11,6 +11,7 @@ package ecdsa
 import (
 	"crypto/ecdsa"
 	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
 
@@ -35,14 +36,19 @@ func (k *KeyImporter) KeyImport(raw interface{}, _ crypto.KeyImportOpts) (crypto
 		return nil, fmt.Errorf("only supports string or []byte type of key")
 	}
 
+	// Bytes 导出的 pem 格式同样可以直接导入
+	if block, _ := pem.Decode(der); block != nil {
+		der = block.Bytes
+	}
+
 	key, err := x509.ParsePKCS8PrivateKey(der)
-	if err == nil {
-		return &ecdsaPrivateKey{privateKey: key.(*ecdsa.PrivateKey)}, nil
+	if privateKey, ok := key.(*ecdsa.PrivateKey); err == nil && ok {
+		return &ecdsaPrivateKey{privateKey: privateKey}, nil
 	}
 
 	key, err = x509.ParsePKIXPublicKey(der)
-	if err == nil {
-		return &ecdsaPublicKey{publicKey: key.(*ecdsa.PublicKey)}, nil
+	if publicKey, ok := key.(*ecdsa.PublicKey); err == nil && ok {
+		return &ecdsaPublicKey{publicKey: publicKey}, nil
 	}
 
 	return nil, errors.New("is not ecdsa key")
@@ -0,0 +1,30 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package ecdsa
+
+import (
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+func init() {
+	crypto.Register(&crypto.AlgorithmInfo{
+		Algorithm:    crypto.EcdsaP256,
+		KeySize:      256,
+		Usages:       crypto.UsageSign,
+		KeyGenerator: new(keyGenerator),
+		KeyImporter:  new(KeyImporter),
+	})
+	crypto.Register(&crypto.AlgorithmInfo{
+		Algorithm:    crypto.EcdsaP384,
+		KeySize:      384,
+		Usages:       crypto.UsageSign,
+		KeyGenerator: new(keyGenerator),
+		KeyImporter:  new(KeyImporter),
+	})
+}
@@ -10,47 +10,55 @@ package factory
 
 import (
 	"fmt"
+
 	"github.com/yakumioto/alkaid/internal/common/crypto"
-	"github.com/yakumioto/alkaid/internal/common/crypto/aes"
-	"github.com/yakumioto/alkaid/internal/common/crypto/ecdsa"
-	"github.com/yakumioto/alkaid/internal/common/crypto/hmac"
-	"github.com/yakumioto/alkaid/internal/common/crypto/rsa"
+
+	// 算法包在 init 中注册到 crypto 的注册表
+	_ "github.com/yakumioto/alkaid/internal/common/crypto/aes"
+	_ "github.com/yakumioto/alkaid/internal/common/crypto/chacha20poly1305"
+	_ "github.com/yakumioto/alkaid/internal/common/crypto/ecdsa"
+	_ "github.com/yakumioto/alkaid/internal/common/crypto/hmac"
+	_ "github.com/yakumioto/alkaid/internal/common/crypto/rsa"
+	_ "github.com/yakumioto/alkaid/internal/common/crypto/sm2"
+	_ "github.com/yakumioto/alkaid/internal/common/crypto/sm4"
 )
 
+// CryptoKeyGen 使用注册表中算法对应的密钥生成器生成密钥，对称密钥为随机生成
 func CryptoKeyGen(algorithm crypto.Algorithm) (crypto.Key, error) {
-	switch algorithm {
-	case crypto.EcdsaP256:
-		return ecdsa.KeyGen(&crypto.ECDSAP256KeyGenOpts{})
-	case crypto.EcdsaP384:
-		return ecdsa.KeyGen(&crypto.ECDSAP384KeyGenOpts{})
-	case crypto.Rsa1024:
-		return rsa.KeyGen(&crypto.RSA1024KeyImportOpts{})
-	case crypto.Rsa2048:
-		return rsa.KeyGen(&crypto.RSA2048KeyImportOpts{})
-	case crypto.Rsa4096:
-		return rsa.KeyGen(&crypto.RSA4096KeyImportOpts{})
+	info, ok := crypto.Lookup(algorithm)
+	if !ok || info.KeyGenerator == nil {
+		return nil, fmt.Errorf("not found key generator: %v", algorithm)
 	}
 
-	return nil, fmt.Errorf("not found key generator: %v", algorithm)
+	return info.KeyGenerator.KeyGen(algorithm)
 }
 
+// CryptoKeyImport 使用注册表中算法对应的密钥导入器导入密钥
 func CryptoKeyImport(raw interface{}, algorithm crypto.Algorithm) (crypto.Key, error) {
-	switch algorithm {
-	case crypto.AesCbc128:
-		return aes.NewKey(raw, &crypto.AES128KeyImportOpts{})
-	case crypto.AesCbc192:
-		return aes.NewKey(raw, &crypto.AES192KeyImportOpts{})
-	case crypto.AesCbc256:
-		return aes.NewKey(raw, &crypto.AES256KeyImportOpts{})
-	case crypto.HmacSha256:
-		return hmac.NewKey(raw, &crypto.HMACSha256ImportOpts{})
-	case crypto.HmacSha512:
-		return hmac.NewKey(raw, &crypto.HMACSha512ImportOpts{})
-	case crypto.EcdsaP256, crypto.EcdsaP384:
-		return ecdsa.KeyImport(raw)
-	case crypto.Rsa1024, crypto.Rsa2048, crypto.Rsa4096:
-		return rsa.KeyImport(raw)
+	info, ok := crypto.Lookup(algorithm)
+	if !ok {
+		return nil, fmt.Errorf("not found key importer: %v", algorithm)
+	}
+
+	return info.KeyImporter.KeyImport(raw, algorithm)
+}
+
+// CryptoKeyGenByUsage 按照策略生成指定用途的密钥，例如 CryptoKeyGenByUsage(crypto.DefaultPolicy, crypto.UsageSign)
+func CryptoKeyGenByUsage(policy crypto.Policy, usage crypto.KeyUsage) (crypto.Key, error) {
+	info, err := policy.Algorithm(usage)
+	if err != nil {
+		return nil, err
+	}
+
+	return CryptoKeyGen(info.Algorithm)
+}
+
+// CryptoKeyImportByUsage 按照策略导入指定用途的密钥
+func CryptoKeyImportByUsage(raw interface{}, policy crypto.Policy, usage crypto.KeyUsage) (crypto.Key, error) {
+	info, err := policy.Algorithm(usage)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, fmt.Errorf("not found key importer: %v", algorithm)
+	return CryptoKeyImport(raw, info.Algorithm)
 }
@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package factory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+func TestCryptoKeyGen(t *testing.T) {
+	algorithms := map[crypto.Algorithm]int{
+		crypto.EcdsaP256:         256,
+		crypto.EcdsaP384:         384,
+		crypto.Rsa1024:           1024,
+		crypto.Rsa2048:           2048,
+		crypto.Rsa4096:           4096,
+		crypto.AesCbc128:         128,
+		crypto.AesCbc192:         192,
+		crypto.AesCbc256:         256,
+		crypto.AesGcm256:         256,
+		crypto.XChaCha20Poly1305: 256,
+		crypto.HmacSha256:        256,
+		crypto.HmacSha512:        512,
+		crypto.Sm2:               256,
+		crypto.Sm3:               256,
+		crypto.Sm4Cbc:            128,
+	}
+	assert.Len(t, crypto.Algorithms(), len(algorithms))
+
+	for _, info := range crypto.Algorithms() {
+		name := string(info.Algorithm)
+		assert.Equal(t, algorithms[info.Algorithm], info.KeySize, name)
+
+		if info.Algorithm == crypto.Rsa4096 {
+			continue
+		}
+
+		key, err := CryptoKeyGen(info.Algorithm)
+		assert.NoError(t, err, name)
+		assert.Equal(t, info.Symmetric, key.Symmetric(), name)
+
+		// 生成的密钥导出后可以重新导入
+		raw, err := key.Bytes()
+		assert.NoError(t, err, name)
+		if info.Symmetric {
+			assert.Len(t, raw, info.KeySize/8, name)
+		}
+		imported, err := CryptoKeyImport(raw, info.Algorithm)
+		assert.NoError(t, err, name)
+		assert.Equal(t, key.SKI(), imported.SKI(), name)
+	}
+
+	_, err := CryptoKeyGen("UNKNOWN")
+	assert.Error(t, err)
+	_, err = CryptoKeyImport([]byte("key"), "UNKNOWN")
+	assert.Error(t, err)
+}
+
+func TestCryptoKeyGenByUsage(t *testing.T) {
+	tcs := []struct {
+		name      string
+		policy    crypto.Policy
+		usage     crypto.KeyUsage
+		algorithm crypto.Algorithm
+		success   bool
+	}{
+		{"default sign", crypto.DefaultPolicy, crypto.UsageSign, crypto.EcdsaP256, true},
+		{"default encrypt", crypto.DefaultPolicy, crypto.UsageEncrypt, crypto.AesGcm256, true},
+		{"default wrap", crypto.DefaultPolicy, crypto.UsageWrap, crypto.Rsa2048, true},
+		{"default mac", crypto.DefaultPolicy, crypto.UsageMAC, crypto.HmacSha256, true},
+		{"sm2 sign", crypto.SM2Policy, crypto.UsageSign, crypto.Sm2, true},
+		{"sm2 mac", crypto.SM2Policy, crypto.UsageMAC, crypto.Sm3, true},
+		{"unsupported usage", crypto.Policy{crypto.UsageSign: crypto.HmacSha256}, crypto.UsageSign, "", false},
+		{"missing usage", crypto.Policy{}, crypto.UsageWrap, "", false},
+	}
+
+	for _, tc := range tcs {
+		info, err := tc.policy.Algorithm(tc.usage)
+		assert.Equal(t, tc.success, err == nil, tc.name)
+		if !tc.success {
+			continue
+		}
+		assert.Equal(t, tc.algorithm, info.Algorithm, tc.name)
+
+		key, err := CryptoKeyGenByUsage(tc.policy, tc.usage)
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, info.Symmetric, key.Symmetric(), tc.name)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !pkcs11
+
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package factory
+
+import (
+	"errors"
+)
+
+// InitPKCS11 未使用 -tags pkcs11 编译时不支持 PKCS#11
+func InitPKCS11(_ *PKCS11Opts) error {
+	return errors.New("pkcs11 support is not compiled in, rebuild with -tags pkcs11")
+}
@@ -0,0 +1,30 @@
+//go:build pkcs11
+
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package factory
+
+import (
+	"github.com/yakumioto/alkaid/internal/common/crypto/pkcs11"
+)
+
+// InitPKCS11 打开 PKCS#11 设备并注册为 PKCS11 提供者
+func InitPKCS11(opts *PKCS11Opts) error {
+	provider, err := pkcs11.New(&pkcs11.Opts{
+		Library: opts.Library,
+		Label:   opts.Label,
+		Pin:     opts.Pin,
+	})
+	if err != nil {
+		return err
+	}
+
+	RegisterProvider(ProviderPKCS11, provider)
+	return nil
+}
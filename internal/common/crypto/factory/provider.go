@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package factory
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+const (
+	// ProviderSW 在进程内生成密钥，私钥的 Bytes 为 pem 格式
+	ProviderSW = "SW"
+	// ProviderPKCS11 在 PKCS#11 设备中生成密钥，私钥的 Bytes 为 PKCS#11 URI 格式的密钥句柄，签名在设备中完成
+	ProviderPKCS11 = "PKCS11"
+)
+
+// pkcs11URIScheme PKCS#11 密钥句柄的前缀，见 RFC 7512
+const pkcs11URIScheme = "pkcs11:"
+
+// Provider 密钥提供者，决定私钥生成以及保存的位置
+type Provider interface {
+	crypto.KeyGenerator
+	crypto.KeyImporter
+}
+
+// PKCS11Opts PKCS#11 提供者的配置，需要使用 -tags pkcs11 编译
+type PKCS11Opts struct {
+	Library string
+	Label   string
+	Pin     string
+}
+
+var providers = struct {
+	sync.RWMutex
+	m map[string]Provider
+}{
+	m: map[string]Provider{
+		ProviderSW: new(swProvider),
+	},
+}
+
+// RegisterProvider 注册密钥提供者，同名的提供者会被替换
+func RegisterProvider(name string, provider Provider) {
+	providers.Lock()
+	defer providers.Unlock()
+
+	providers.m[name] = provider
+}
+
+// GetProvider 获取密钥提供者，name 为空时返回 SW
+func GetProvider(name string) (Provider, error) {
+	if name == "" {
+		name = ProviderSW
+	}
+
+	providers.RLock()
+	defer providers.RUnlock()
+
+	provider, ok := providers.m[name]
+	if !ok {
+		return nil, fmt.Errorf("key provider is not enabled: %v", name)
+	}
+
+	return provider, nil
+}
+
+// IsKeyHandle 判断私钥是否为硬件设备中密钥的句柄
+func IsKeyHandle(raw []byte) bool {
+	return bytes.HasPrefix(raw, []byte(pkcs11URIScheme))
+}
+
+// CryptoPrivateKeyImport 导入私钥，raw 为密钥句柄时从 PKCS#11 提供者导入，否则按照 algorithm 导入
+func CryptoPrivateKeyImport(raw []byte, algorithm crypto.Algorithm) (crypto.Key, error) {
+	if !IsKeyHandle(raw) {
+		return CryptoKeyImport(raw, algorithm)
+	}
+
+	provider, err := GetProvider(ProviderPKCS11)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.KeyImport(raw, algorithm)
+}
+
+// CryptoKeyGenByUsageWithProvider 与 CryptoKeyGenByUsage 相同，但是使用指定的提供者生成密钥
+func CryptoKeyGenByUsageWithProvider(name string, policy crypto.Policy, usage crypto.KeyUsage) (crypto.Key, error) {
+	provider, err := GetProvider(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := policy.Algorithm(usage)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.KeyGen(info.Algorithm)
+}
+
+// swProvider 使用注册表中的软件实现
+type swProvider struct{}
+
+func (p *swProvider) KeyGen(opts crypto.KeyGenOpts) (crypto.Key, error) {
+	return CryptoKeyGen(opts.Algorithm())
+}
+
+func (p *swProvider) KeyImport(raw interface{}, opts crypto.KeyImportOpts) (crypto.Key, error) {
+	return CryptoKeyImport(raw, opts.Algorithm())
+}
@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"hash"
 
+	"github.com/tjfoc/gmsm/sm3"
 	"github.com/yakumioto/alkaid/internal/common/crypto"
 )
 
@@ -25,8 +26,9 @@ type Key struct {
 	algorithm crypto.Algorithm
 }
 
+// Bytes 返回原始密钥，用于封装后保存
 func (h *Key) Bytes() ([]byte, error) {
-	return nil, errors.New("not supported")
+	return append([]byte(nil), h.key...), nil
 }
 
 func (h *Key) SKI() []byte {
@@ -36,7 +38,7 @@ func (h *Key) SKI() []byte {
 }
 
 func (h *Key) Symmetric() bool {
-	return false
+	return true
 }
 
 func (h *Key) Private() bool {
@@ -54,6 +56,8 @@ func (h *Key) Sign(digest []byte) ([]byte, error) {
 		hc = hmac.New(sha256.New, h.key)
 	case crypto.HmacSha512:
 		hc = hmac.New(sha512.New, h.key)
+	case crypto.Sm3:
+		hc = hmac.New(sm3.New, h.key)
 	default:
 		return nil, fmt.Errorf("not support %v algorithm", h.algorithm)
 	}
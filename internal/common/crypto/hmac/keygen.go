@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package hmac
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+type KeyGenerator struct{}
+
+func KeyGen(opts crypto.KeyGenOpts) (crypto.Key, error) {
+	return new(KeyGenerator).KeyGen(opts)
+}
+
+// KeyGen 密钥长度与摘要算法的输出长度一致
+func (k *KeyGenerator) KeyGen(opts crypto.KeyGenOpts) (crypto.Key, error) {
+	keyLen := keyLength(opts.Algorithm())
+	if keyLen == 0 {
+		return nil, fmt.Errorf("unsupported hmac algorithm: %v", opts.Algorithm())
+	}
+
+	key := make([]byte, keyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating HMAC key error: [%s]", err)
+	}
+
+	return &Key{
+		key:       key,
+		algorithm: opts.Algorithm(),
+	}, nil
+}
+
+// keyLength 算法对应的密钥长度，单位为 byte，不支持的算法返回 0
+func keyLength(algorithm crypto.Algorithm) int {
+	switch algorithm {
+	case crypto.HmacSha256, crypto.Sm3:
+		return 256 / 8
+	case crypto.HmacSha512:
+		return 512 / 8
+	}
+
+	return 0
+}
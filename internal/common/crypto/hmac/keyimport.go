@@ -33,7 +33,7 @@ func (k *KeyImporter) KeyImport(raw interface{}, opts crypto.KeyImportOpts) (cry
 	}
 
 	switch opts.Algorithm() {
-	case crypto.HmacSha256, crypto.HmacSha512:
+	case crypto.HmacSha256, crypto.HmacSha512, crypto.Sm3:
 		return &Key{
 			key:       key,
 			algorithm: opts.Algorithm(),
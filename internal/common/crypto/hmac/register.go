@@ -0,0 +1,26 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package hmac
+
+import (
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+func init() {
+	for _, algorithm := range []crypto.Algorithm{crypto.HmacSha256, crypto.HmacSha512, crypto.Sm3} {
+		crypto.Register(&crypto.AlgorithmInfo{
+			Algorithm:    algorithm,
+			KeySize:      keyLength(algorithm) * 8,
+			Symmetric:    true,
+			Usages:       crypto.UsageMAC,
+			KeyGenerator: new(KeyGenerator),
+			KeyImporter:  new(KeyImporter),
+		})
+	}
+}
@@ -0,0 +1,345 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+// Package kek 服务端的密钥加密密钥（Key Encryption Key），用来封装组织的数据密钥。
+// KEK 可以从文件、环境变量或者 KMS 加载，封装结果的格式为 <keyID>:<Envelope>，解封时按照 keyID 选择 KEK
+package kek
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/yakumioto/alkaid/internal/common/crypto/utils"
+)
+
+// 可选的 KEK 来源
+const (
+	SourceFile = "file"
+	SourceEnv  = "env"
+	SourceKMS  = "kms"
+)
+
+// KeySize KEK 原始密钥的长度
+const KeySize = 32
+
+var ErrNotInitialized = errors.New("kek is not initialized")
+
+// KeyManager 封装以及解封数据密钥，KEK 本身不会离开 KeyManager，接入云厂商 KMS 时实现该接口即可
+type KeyManager interface {
+	// KeyID 当前用于封装的 KEK 标识
+	KeyID() string
+	// WrapKey 封装数据密钥，ad 为绑定的附加数据
+	WrapKey(key, ad []byte) (string, error)
+	// UnwrapKey 解封 WrapKey 的结果，需要提供相同的附加数据
+	UnwrapKey(wrapped string, ad []byte) ([]byte, error)
+}
+
+var (
+	once    sync.Once
+	manager KeyManager
+)
+
+// Initialize 设置全局的 KeyManager，仅第一次调用生效
+func Initialize(km KeyManager) {
+	once.Do(func() {
+		manager = km
+	})
+}
+
+// WrapKey 使用全局的 KeyManager 封装数据密钥
+func WrapKey(key, ad []byte) (string, error) {
+	if manager == nil {
+		return "", ErrNotInitialized
+	}
+
+	return manager.WrapKey(key, ad)
+}
+
+// UnwrapKey 使用全局的 KeyManager 解封数据密钥
+func UnwrapKey(wrapped string, ad []byte) ([]byte, error) {
+	if manager == nil {
+		return nil, ErrNotInitialized
+	}
+
+	return manager.UnwrapKey(wrapped, ad)
+}
+
+// RewrapKey 使用全局的 KeyManager 重新封装数据密钥，见 Rewrap
+func RewrapKey(wrapped string, ad []byte) (string, bool, error) {
+	if manager == nil {
+		return "", false, ErrNotInitialized
+	}
+
+	return Rewrap(manager, wrapped, ad)
+}
+
+// Rewrap 使用 km 当前的 KEK 重新封装数据密钥，更换 KEK 之后用来批量升级已有的数据密钥。
+// 已经由当前 KEK 封装时直接返回原结果，第二个返回值为 false
+func Rewrap(km KeyManager, wrapped string, ad []byte) (string, bool, error) {
+	keyID, _, err := splitWrapped(wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	if keyID == km.KeyID() {
+		return wrapped, false, nil
+	}
+
+	key, err := km.UnwrapKey(wrapped, ad)
+	if err != nil {
+		return "", false, err
+	}
+	rewrapped, err := km.WrapKey(key, ad)
+	if err != nil {
+		return "", false, err
+	}
+
+	return rewrapped, true, nil
+}
+
+// GenKey 生成 base64 编码的随机 KEK
+func GenKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// NewEphemeral 生成只保存在内存中的随机 KEK，进程退出后封装的数据密钥无法再解封，只用于内存数据库
+func NewEphemeral() (KeyManager, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	return NewLocal(key)
+}
+
+// local 软件 KEK，原始密钥经过 HKDF 扩展后使用 AES-256-GCM 封装
+type local struct {
+	id  string
+	key *utils.StretchedKey
+}
+
+// NewLocal 使用原始密钥创建软件 KEK，keyID 由密钥计算得到，不会泄露密钥本身
+func NewLocal(masterKey []byte) (KeyManager, error) {
+	if len(masterKey) != KeySize {
+		return nil, fmt.Errorf("kek must be %d bytes, got %d", KeySize, len(masterKey))
+	}
+
+	key, err := utils.GetStretchedKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := sha256.Sum256(key.Mac)
+
+	return &local{id: hex.EncodeToString(fingerprint[:8]), key: key}, nil
+}
+
+// FromFile 从文件加载 base64 编码的 KEK，文件不存在时返回错误，不会自动生成，
+// 避免路径配置错误时使用新的 KEK 导致已有的数据密钥无法解封
+func FromFile(path string) (KeyManager, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("kek file not found: %v", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return fromBase64(strings.TrimSpace(string(data)))
+}
+
+// GenerateFile 生成新的 KEK 并写入文件，文件已经存在时返回错误，不会覆盖已有的 KEK
+func GenerateFile(path string) (KeyManager, error) {
+	encoded, err := GenKey()
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("kek file already exists: %v", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = f.WriteString(encoded); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+
+	return fromBase64(encoded)
+}
+
+// FromEnv 从环境变量加载 base64 编码的 KEK
+func FromEnv(name string) (KeyManager, error) {
+	encoded, ok := os.LookupEnv(name)
+	if !ok || encoded == "" {
+		return nil, fmt.Errorf("environment variable %v is not set", name)
+	}
+
+	return fromBase64(strings.TrimSpace(encoded))
+}
+
+func fromBase64(encoded string) (KeyManager, error) {
+	masterKey, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("base64 decode kek error: %v", err)
+	}
+
+	return NewLocal(masterKey)
+}
+
+func (l *local) KeyID() string {
+	return l.id
+}
+
+func (l *local) WrapKey(key, ad []byte) (string, error) {
+	wrapped, err := utils.EncryptWithStretchedKey(l.key, key, ad)
+	if err != nil {
+		return "", err
+	}
+
+	return l.id + ":" + wrapped, nil
+}
+
+func (l *local) UnwrapKey(wrapped string, ad []byte) ([]byte, error) {
+	keyID, envelope, err := splitWrapped(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if keyID != l.id {
+		return nil, fmt.Errorf("data key is wrapped by another kek: %v", keyID)
+	}
+
+	return utils.DecryptWithStretchedKey(l.key, envelope, ad)
+}
+
+// localKMS KMS 的本地替代实现，每个 keyID 的 KEK 保存在目录下的 <keyID>.key 文件中，
+// 封装使用当前的 keyID，解封时按照 keyID 加载对应的 KEK，更换 keyID 后旧的数据密钥仍然可以解封
+type localKMS struct {
+	dir   string
+	keyID string
+
+	lock sync.Mutex
+	keys map[string]KeyManager
+}
+
+// NewLocalKMS 创建 KMS 的本地替代实现，keyID 对应的 KEK 需要预先通过 GenerateLocalKMSKey 生成
+func NewLocalKMS(dir, keyID string) (KeyManager, error) {
+	if err := checkKMSKeyID(keyID); err != nil {
+		return nil, err
+	}
+
+	kms := &localKMS{dir: dir, keyID: keyID, keys: make(map[string]KeyManager)}
+	if _, err := kms.key(keyID); err != nil {
+		return nil, err
+	}
+
+	return kms, nil
+}
+
+// GenerateLocalKMSKey 在 dir 中生成 keyID 对应的 KEK，已经存在时返回错误，更换 keyID 前使用
+func GenerateLocalKMSKey(dir, keyID string) (KeyManager, error) {
+	if err := checkKMSKeyID(keyID); err != nil {
+		return nil, err
+	}
+
+	return GenerateFile(filepath.Join(dir, keyID+".key"))
+}
+
+func checkKMSKeyID(keyID string) error {
+	if keyID == "" || strings.ContainsAny(keyID, `:/\`) {
+		return fmt.Errorf("invalid kms key id: %q", keyID)
+	}
+
+	return nil
+}
+
+func (k *localKMS) key(keyID string) (KeyManager, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if key, ok := k.keys[keyID]; ok {
+		return key, nil
+	}
+
+	path := filepath.Join(k.dir, keyID+".key")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("kms key not found: %v", keyID)
+	}
+	key, err := FromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	k.keys[keyID] = key
+
+	return key, nil
+}
+
+func (k *localKMS) KeyID() string {
+	return k.keyID
+}
+
+func (k *localKMS) WrapKey(key, ad []byte) (string, error) {
+	kek, err := k.key(k.keyID)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := kek.WrapKey(key, ad)
+	if err != nil {
+		return "", err
+	}
+	_, envelope, err := splitWrapped(wrapped)
+	if err != nil {
+		return "", err
+	}
+
+	return k.keyID + ":" + envelope, nil
+}
+
+func (k *localKMS) UnwrapKey(wrapped string, ad []byte) ([]byte, error) {
+	keyID, envelope, err := splitWrapped(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if keyID == "" || strings.ContainsAny(keyID, `/\`) {
+		return nil, fmt.Errorf("invalid kms key id: %q", keyID)
+	}
+	kek, err := k.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return kek.UnwrapKey(kek.KeyID()+":"+envelope, ad)
+}
+
+func splitWrapped(wrapped string) (string, string, error) {
+	parts := strings.SplitN(wrapped, ":", 2)
+	if len(parts) != 2 {
+		return "", "", errors.New("irregular wrapped key format")
+	}
+
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package kek
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocal(t *testing.T) {
+	km, err := NewLocal([]byte(strings.Repeat("k", KeySize)))
+	assert.NoError(t, err)
+	other, err := NewLocal([]byte(strings.Repeat("o", KeySize)))
+	assert.NoError(t, err)
+
+	wrapped, err := km.WrapKey([]byte("data key"), []byte("org1/protectedDataKey"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(wrapped, km.KeyID()+":"))
+
+	key, err := km.UnwrapKey(wrapped, []byte("org1/protectedDataKey"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data key", string(key))
+
+	// 附加数据不同或者 KEK 不同时无法解封
+	_, err = km.UnwrapKey(wrapped, []byte("org2/protectedDataKey"))
+	assert.Error(t, err)
+	_, err = other.UnwrapKey(wrapped, []byte("org1/protectedDataKey"))
+	assert.Error(t, err)
+	_, err = km.UnwrapKey("invalid", nil)
+	assert.Error(t, err)
+
+	_, err = NewLocal([]byte("short"))
+	assert.Error(t, err)
+}
+
+func TestFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kek", "alkaid.kek")
+
+	// 文件不存在时返回错误，不会自动生成
+	_, err := FromFile(path)
+	assert.Error(t, err)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	km, err := GenerateFile(path)
+	assert.NoError(t, err)
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	reloaded, err := FromFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, km.KeyID(), reloaded.KeyID())
+
+	// 已经存在的 KEK 不会被覆盖
+	_, err = GenerateFile(path)
+	assert.Error(t, err)
+	reloaded, err = FromFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, km.KeyID(), reloaded.KeyID())
+
+	assert.NoError(t, os.WriteFile(path, []byte("invalid"), 0600))
+	_, err = FromFile(path)
+	assert.Error(t, err)
+}
+
+func TestFromEnv(t *testing.T) {
+	encoded, err := GenKey()
+	assert.NoError(t, err)
+
+	t.Setenv("ALKAID_TEST_KEK", encoded)
+	km, err := FromEnv("ALKAID_TEST_KEK")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, km.KeyID())
+
+	_, err = FromEnv("ALKAID_TEST_KEK_NOT_SET")
+	assert.Error(t, err)
+}
+
+func TestNewEphemeral(t *testing.T) {
+	km, err := NewEphemeral()
+	assert.NoError(t, err)
+	other, err := NewEphemeral()
+	assert.NoError(t, err)
+	assert.NotEqual(t, km.KeyID(), other.KeyID())
+
+	wrapped, err := km.WrapKey([]byte("data key"), nil)
+	assert.NoError(t, err)
+	key, err := km.UnwrapKey(wrapped, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "data key", string(key))
+}
+
+func TestLocalKMS(t *testing.T) {
+	dir := t.TempDir()
+
+	// keyID 对应的 KEK 需要预先生成
+	_, err := NewLocalKMS(dir, "alkaid-v1")
+	assert.Error(t, err)
+	_, err = GenerateLocalKMSKey(dir, "alkaid-v1")
+	assert.NoError(t, err)
+	_, err = GenerateLocalKMSKey(dir, "alkaid-v1")
+	assert.Error(t, err)
+
+	v1, err := NewLocalKMS(dir, "alkaid-v1")
+	assert.NoError(t, err)
+	wrapped, err := v1.WrapKey([]byte("data key"), nil)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(wrapped, "alkaid-v1:"))
+
+	// 更换 keyID 后旧的数据密钥仍然可以解封
+	_, err = GenerateLocalKMSKey(dir, "alkaid-v2")
+	assert.NoError(t, err)
+	v2, err := NewLocalKMS(dir, "alkaid-v2")
+	assert.NoError(t, err)
+	key, err := v2.UnwrapKey(wrapped, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "data key", string(key))
+
+	// 使用新的 keyID 重新封装，已经使用当前 keyID 封装时不修改
+	rewrapped, ok, err := Rewrap(v2, wrapped, nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(rewrapped, "alkaid-v2:"))
+	key, err = v2.UnwrapKey(rewrapped, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "data key", string(key))
+	unchanged, ok, err := Rewrap(v2, rewrapped, nil)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, rewrapped, unchanged)
+
+	_, err = v2.UnwrapKey("alkaid-v3:"+strings.SplitN(wrapped, ":", 2)[1], nil)
+	assert.Error(t, err)
+	_, err = v2.UnwrapKey("../alkaid-v1:"+strings.SplitN(wrapped, ":", 2)[1], nil)
+	assert.Error(t, err)
+	_, err = NewLocalKMS(dir, "invalid:id")
+	assert.Error(t, err)
+	_, err = GenerateLocalKMSKey(dir, "../invalid")
+	assert.Error(t, err)
+}
@@ -20,8 +20,17 @@ const (
 	AesCbc192 Algorithm = "AES_CBC_192"
 	AesCbc256 Algorithm = "AES_CBC_256"
 
+	// AEAD 算法，附加数据参与认证
+	AesGcm256         Algorithm = "AES_GCM_256"
+	XChaCha20Poly1305 Algorithm = "XCHACHA20_POLY1305"
+
 	HmacSha256 Algorithm = "HMAC_SHA256"
 	HmacSha512 Algorithm = "HMAC_SHA512"
+
+	// 国密算法，Sm3 对应 HMAC-SM3
+	Sm2    Algorithm = "SM2"
+	Sm3    Algorithm = "SM3"
+	Sm4Cbc Algorithm = "SM4_CBC"
 )
 
 type Algorithm string
@@ -38,6 +47,12 @@ func (opts *ECDSAP384KeyGenOpts) Algorithm() Algorithm {
 	return EcdsaP384
 }
 
+type SM2KeyGenOpts struct{}
+
+func (opts *SM2KeyGenOpts) Algorithm() Algorithm {
+	return Sm2
+}
+
 type AES128KeyImportOpts struct{}
 
 func (opts *AES128KeyImportOpts) Algorithm() Algorithm {
@@ -56,6 +71,18 @@ func (opts *AES256KeyImportOpts) Algorithm() Algorithm {
 	return AesCbc256
 }
 
+type AESGCM256KeyImportOpts struct{}
+
+func (opts *AESGCM256KeyImportOpts) Algorithm() Algorithm {
+	return AesGcm256
+}
+
+type XChaCha20Poly1305KeyImportOpts struct{}
+
+func (opts *XChaCha20Poly1305KeyImportOpts) Algorithm() Algorithm {
+	return XChaCha20Poly1305
+}
+
 type HMACSha256ImportOpts struct{}
 
 func (opts *HMACSha256ImportOpts) Algorithm() Algorithm {
@@ -68,6 +95,18 @@ func (opts *HMACSha512ImportOpts) Algorithm() Algorithm {
 	return HmacSha512
 }
 
+type HMACSm3ImportOpts struct{}
+
+func (opts *HMACSm3ImportOpts) Algorithm() Algorithm {
+	return Sm3
+}
+
+type SM4KeyImportOpts struct{}
+
+func (opts *SM4KeyImportOpts) Algorithm() Algorithm {
+	return Sm4Cbc
+}
+
 type RSA1024KeyImportOpts struct{}
 
 func (opts *RSA1024KeyImportOpts) Algorithm() Algorithm {
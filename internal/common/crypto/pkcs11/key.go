@@ -0,0 +1,112 @@
+//go:build pkcs11
+
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package pkcs11
+
+import (
+	stdCrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	ecdsaKey "github.com/yakumioto/alkaid/internal/common/crypto/ecdsa"
+	fabricCrypto "github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/common/crypto"
+)
+
+// privateKey 设备中的 ECDSA 私钥，实现 crypto.StdSigner，可以直接用于签发证书
+type privateKey struct {
+	provider  *Provider
+	handle    pkcs11.ObjectHandle
+	ski       []byte
+	publicKey *ecdsa.PublicKey
+}
+
+// Bytes 私钥不可导出，返回 PKCS#11 URI 格式的密钥句柄
+func (k *privateKey) Bytes() ([]byte, error) {
+	return []byte(k.provider.uri(k.ski)), nil
+}
+
+func (k *privateKey) SKI() []byte {
+	return k.ski
+}
+
+func (k *privateKey) Symmetric() bool {
+	return false
+}
+
+func (k *privateKey) Private() bool {
+	return true
+}
+
+// PublicKey 公钥使用软件实现
+func (k *privateKey) PublicKey() (crypto.Key, error) {
+	der, err := x509.MarshalPKIXPublicKey(k.publicKey)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to marshal public key")
+	}
+
+	return ecdsaKey.KeyImport(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// Sign 在设备中签名，设备返回 r || s，转换为 ASN.1 编码的 low-S 格式
+func (k *privateKey) Sign(digest []byte) ([]byte, error) {
+	sig, err := k.provider.sign(k.handle, digest)
+	if err != nil {
+		return nil, err
+	}
+	if len(sig) == 0 || len(sig)%2 != 0 {
+		return nil, errors.New("invalid signature length")
+	}
+
+	r := new(big.Int).SetBytes(sig[:len(sig)/2])
+	s := new(big.Int).SetBytes(sig[len(sig)/2:])
+
+	halfOrder := new(big.Int).Rsh(k.publicKey.Params().N, 1)
+	if s.Cmp(halfOrder) == 1 {
+		s.Sub(k.publicKey.Params().N, s)
+	}
+
+	return asn1.Marshal(fabricCrypto.ECDSASignature{R: r, S: s})
+}
+
+func (k *privateKey) Verify(_, _ []byte) bool {
+	return false
+}
+
+func (k *privateKey) Encrypt(_ []byte) ([]byte, error) {
+	return nil, errors.New("not supported")
+}
+
+func (k *privateKey) Decrypt(_ []byte) ([]byte, error) {
+	return nil, errors.New("not supported")
+}
+
+// Signer 返回在设备中签名的标准库 crypto.Signer
+func (k *privateKey) Signer() stdCrypto.Signer {
+	return &signer{key: k}
+}
+
+type signer struct {
+	key *privateKey
+}
+
+func (s *signer) Public() stdCrypto.PublicKey {
+	return s.key.publicKey
+}
+
+func (s *signer) Sign(_ io.Reader, digest []byte, _ stdCrypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(digest)
+}
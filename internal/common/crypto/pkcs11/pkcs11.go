@@ -0,0 +1,360 @@
+//go:build pkcs11
+
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+// Package pkcs11 使用 PKCS#11 设备（HSM）生成并保存 ECDSA 私钥，私钥不可导出，签名在设备中完成。
+// 需要使用 -tags pkcs11 编译，测试可以使用 SoftHSMv2。
+package pkcs11
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+// URIScheme 密钥句柄使用 RFC 7512 的 PKCS#11 URI 格式
+const URIScheme = "pkcs11:"
+
+var (
+	oidNamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidNamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+)
+
+type Opts struct {
+	Library string // PKCS#11 动态库路径，例如 /usr/lib/softhsm/libsofthsm2.so
+	Label   string // Token 标签
+	Pin     string // 用户 PIN
+}
+
+// Provider 持有一个已登录的会话，PKCS#11 会话不能并发使用，所有操作串行执行
+type Provider struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	label   string
+	lock    sync.Mutex
+}
+
+// New 加载 PKCS#11 动态库，打开标签为 opts.Label 的 Token 并登录
+func New(opts *Opts) (*Provider, error) {
+	ctx := pkcs11.New(opts.Library)
+	if ctx == nil {
+		return nil, errors.Errorf("failed to load pkcs11 library: %v", opts.Library)
+	}
+
+	if err := ctx.Initialize(); err != nil && !isError(err, pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		return nil, errors.WithMessage(err, "failed to initialize pkcs11 library")
+	}
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get slot list")
+	}
+
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil || info.Label != opts.Label {
+			continue
+		}
+
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to open session")
+		}
+		if err = ctx.Login(session, pkcs11.CKU_USER, opts.Pin); err != nil && !isError(err, pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+			_ = ctx.CloseSession(session)
+			return nil, errors.WithMessage(err, "failed to login")
+		}
+
+		return &Provider{
+			ctx:     ctx,
+			session: session,
+			label:   opts.Label,
+		}, nil
+	}
+
+	return nil, errors.Errorf("token not found: %v", opts.Label)
+}
+
+// Close 关闭会话，设备中的密钥不受影响
+func (p *Provider) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	_ = p.ctx.Logout(p.session)
+	return p.ctx.CloseSession(p.session)
+}
+
+// KeyGen 在设备中生成 ECDSA 密钥对，私钥不可导出，CKA_ID 为公钥的 SKI
+func (p *Provider) KeyGen(opts crypto.KeyGenOpts) (crypto.Key, error) {
+	var (
+		curve elliptic.Curve
+		oid   asn1.ObjectIdentifier
+	)
+	switch opts.Algorithm() {
+	case crypto.EcdsaP256:
+		curve, oid = elliptic.P256(), oidNamedCurveP256
+	case crypto.EcdsaP384:
+		curve, oid = elliptic.P384(), oidNamedCurveP384
+	default:
+		return nil, fmt.Errorf("unsupported pkcs11 algorithm: %v", opts.Algorithm())
+	}
+
+	params, err := asn1.Marshal(oid)
+	if err != nil {
+		return nil, err
+	}
+
+	// 生成时使用随机的临时 ID，生成后替换为 SKI
+	tmpID := make([]byte, 16)
+	if _, err = rand.Read(tmpID); err != nil {
+		return nil, err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	publicTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, tmpID),
+	}
+	privateTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, tmpID),
+	}
+
+	publicHandle, privateHandle, err := p.ctx.GenerateKeyPair(p.session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_EC_KEY_PAIR_GEN, nil)},
+		publicTemplate, privateTemplate)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to generate key pair")
+	}
+
+	publicKey, err := p.publicKey(publicHandle, curve)
+	if err != nil {
+		return nil, err
+	}
+
+	ski := skiOf(publicKey)
+	idTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_ID, ski),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, hex.EncodeToString(ski)),
+	}
+	for _, handle := range []pkcs11.ObjectHandle{publicHandle, privateHandle} {
+		if err = p.ctx.SetAttributeValue(p.session, handle, idTemplate); err != nil {
+			return nil, errors.WithMessage(err, "failed to set key id")
+		}
+	}
+
+	return &privateKey{
+		provider:  p,
+		handle:    privateHandle,
+		ski:       ski,
+		publicKey: publicKey,
+	}, nil
+}
+
+// KeyImport raw 为 Bytes 返回的 PKCS#11 URI，只能导入当前 Token 中的私钥
+func (p *Provider) KeyImport(raw interface{}, _ crypto.KeyImportOpts) (crypto.Key, error) {
+	var uri string
+	switch raw := raw.(type) {
+	case []byte:
+		uri = string(raw)
+	case string:
+		uri = raw
+	default:
+		return nil, fmt.Errorf("only supports string or []byte type of key")
+	}
+
+	token, ski, err := parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" && token != p.label {
+		return nil, errors.Errorf("key belongs to another token: %v", token)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	privateHandle, err := p.findObject(pkcs11.CKO_PRIVATE_KEY, ski)
+	if err != nil {
+		return nil, err
+	}
+	publicHandle, err := p.findObject(pkcs11.CKO_PUBLIC_KEY, ski)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := p.ctx.GetAttributeValue(p.session, publicHandle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, nil),
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get ec params")
+	}
+	oid := new(asn1.ObjectIdentifier)
+	if _, err = asn1.Unmarshal(attrs[0].Value, oid); err != nil {
+		return nil, errors.WithMessage(err, "failed to parse ec params")
+	}
+
+	var curve elliptic.Curve
+	switch {
+	case oid.Equal(oidNamedCurveP256):
+		curve = elliptic.P256()
+	case oid.Equal(oidNamedCurveP384):
+		curve = elliptic.P384()
+	default:
+		return nil, errors.Errorf("unsupported curve: %v", oid)
+	}
+
+	publicKey, err := p.publicKey(publicHandle, curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return &privateKey{
+		provider:  p,
+		handle:    privateHandle,
+		ski:       ski,
+		publicKey: publicKey,
+	}, nil
+}
+
+func (p *Provider) findObject(class uint, id []byte) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	}
+	if err := p.ctx.FindObjectsInit(p.session, template); err != nil {
+		return 0, errors.WithMessage(err, "failed to find objects")
+	}
+	defer func() { _ = p.ctx.FindObjectsFinal(p.session) }()
+
+	handles, _, err := p.ctx.FindObjects(p.session, 1)
+	if err != nil {
+		return 0, errors.WithMessage(err, "failed to find objects")
+	}
+	if len(handles) == 0 {
+		return 0, errors.Errorf("key not found: %x", id)
+	}
+
+	return handles[0], nil
+}
+
+// publicKey 读取公钥的 CKA_EC_POINT，部分设备返回 DER 编码的 OCTET STRING，部分设备直接返回未压缩的点
+func (p *Provider) publicKey(handle pkcs11.ObjectHandle, curve elliptic.Curve) (*ecdsa.PublicKey, error) {
+	attrs, err := p.ctx.GetAttributeValue(p.session, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get ec point")
+	}
+
+	point := attrs[0].Value
+	var octets []byte
+	if rest, err := asn1.Unmarshal(point, &octets); err == nil && len(rest) == 0 {
+		point = octets
+	}
+
+	x, y := elliptic.Unmarshal(curve, point)
+	if x == nil {
+		return nil, errors.New("failed to unmarshal ec point")
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func (p *Provider) sign(handle pkcs11.ObjectHandle, digest []byte) ([]byte, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if err := p.ctx.SignInit(p.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, handle); err != nil {
+		return nil, errors.WithMessage(err, "failed to initialize sign")
+	}
+
+	return p.ctx.Sign(p.session, digest)
+}
+
+func (p *Provider) uri(ski []byte) string {
+	return URIScheme + "token=" + url.PathEscape(p.label) + ";id=" + percentEncode(ski) + ";type=private"
+}
+
+// parseURI 解析 PKCS#11 URI 中的 token 以及 id 属性
+func parseURI(uri string) (string, []byte, error) {
+	if !strings.HasPrefix(uri, URIScheme) {
+		return "", nil, errors.New("is not a pkcs11 uri")
+	}
+
+	var (
+		token string
+		id    []byte
+	)
+	for _, attr := range strings.Split(strings.TrimPrefix(uri, URIScheme), ";") {
+		kv := strings.SplitN(attr, "=", 2)
+		if len(kv) != 2 {
+			return "", nil, errors.Errorf("invalid pkcs11 uri attribute: %v", attr)
+		}
+
+		value, err := url.PathUnescape(kv[1])
+		if err != nil {
+			return "", nil, errors.WithMessage(err, "invalid pkcs11 uri")
+		}
+		switch kv[0] {
+		case "token":
+			token = value
+		case "id":
+			id = []byte(value)
+		}
+	}
+	if len(id) == 0 {
+		return "", nil, errors.New("pkcs11 uri without id")
+	}
+
+	return token, id, nil
+}
+
+func percentEncode(data []byte) string {
+	var sb strings.Builder
+	for _, b := range data {
+		sb.WriteString(fmt.Sprintf("%%%02X", b))
+	}
+
+	return sb.String()
+}
+
+func isError(err error, code uint) bool {
+	e, ok := err.(pkcs11.Error)
+	return ok && uint(e) == code
+}
+
+// skiOf 与软件实现一致，对未压缩格式的公钥计算 SHA-256
+func skiOf(publicKey *ecdsa.PublicKey) []byte {
+	hash := sha256.Sum256(elliptic.Marshal(publicKey.Curve, publicKey.X, publicKey.Y))
+	return hash[:]
+}
@@ -0,0 +1,97 @@
+//go:build pkcs11
+
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package pkcs11
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	fabricCrypto "github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/common/crypto"
+)
+
+// newProvider 连接 PKCS11_LIB 指定的设备，一般为 SoftHSMv2，未设置时跳过测试
+func newProvider(t *testing.T) *Provider {
+	library := os.Getenv("PKCS11_LIB")
+	if library == "" {
+		t.Skip("PKCS11_LIB is not set")
+	}
+
+	provider, err := New(&Opts{
+		Library: library,
+		Label:   os.Getenv("PKCS11_LABEL"),
+		Pin:     os.Getenv("PKCS11_PIN"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = provider.Close() })
+
+	return provider
+}
+
+func TestProvider(t *testing.T) {
+	provider := newProvider(t)
+
+	for _, algorithm := range []crypto.Algorithm{crypto.EcdsaP256, crypto.EcdsaP384} {
+		key, err := provider.KeyGen(algorithm)
+		assert.NoError(t, err, algorithm)
+
+		// 数据库中只保存密钥句柄
+		handle, err := key.Bytes()
+		assert.NoError(t, err, algorithm)
+		assert.True(t, strings.HasPrefix(string(handle), URIScheme), algorithm)
+
+		imported, err := provider.KeyImport(string(handle), algorithm)
+		assert.NoError(t, err, algorithm)
+		assert.Equal(t, key.SKI(), imported.SKI(), algorithm)
+
+		publicKey, err := imported.PublicKey()
+		assert.NoError(t, err, algorithm)
+		publicKeyPem, err := publicKey.Bytes()
+		assert.NoError(t, err, algorithm)
+		block, _ := pem.Decode(publicKeyPem)
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		assert.NoError(t, err, algorithm)
+
+		// 设备中的签名结果可以使用软件公钥验证，并且为 low-S 格式
+		digest := sha256.Sum256([]byte("alkaid"))
+		signature, err := imported.Sign(digest[:])
+		assert.NoError(t, err, algorithm)
+		assert.True(t, publicKey.Verify(digest[:], signature), algorithm)
+		ecdsaPub := pub.(*ecdsa.PublicKey)
+		assert.True(t, ecdsa.VerifyASN1(ecdsaPub, digest[:], signature), algorithm)
+		sig := new(fabricCrypto.ECDSASignature)
+		_, err = asn1.Unmarshal(signature, sig)
+		assert.NoError(t, err, algorithm)
+		halfOrder := new(big.Int).Rsh(ecdsaPub.Curve.Params().N, 1)
+		assert.True(t, sig.S.Cmp(halfOrder) <= 0, algorithm)
+
+		signer := imported.(crypto.StdSigner).Signer()
+		signature, err = signer.Sign(rand.Reader, digest[:], nil)
+		assert.NoError(t, err, algorithm)
+		assert.True(t, publicKey.Verify(digest[:], signature), algorithm)
+	}
+
+	_, err := provider.KeyGen(crypto.Sm2)
+	assert.Error(t, err)
+	_, err = provider.KeyImport("pkcs11:token=alkaid;id=%00;type=private", crypto.EcdsaP256)
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package crypto
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KeyUsage 密钥用途，可以按位组合
+type KeyUsage int
+
+const (
+	// UsageSign 签名以及验签
+	UsageSign KeyUsage = 1 << iota
+	// UsageEncrypt 加解密业务数据
+	UsageEncrypt
+	// UsageWrap 封装其他密钥
+	UsageWrap
+	// UsageMAC 计算以及校验消息认证码
+	UsageMAC
+)
+
+var usageNames = []struct {
+	usage KeyUsage
+	name  string
+}{
+	{UsageSign, "sign"},
+	{UsageEncrypt, "encrypt"},
+	{UsageWrap, "wrap"},
+	{UsageMAC, "mac"},
+}
+
+func (u KeyUsage) String() string {
+	names := make([]string, 0, len(usageNames))
+	for _, usage := range usageNames {
+		if u&usage.usage != 0 {
+			names = append(names, usage.name)
+		}
+	}
+
+	return strings.Join(names, "|")
+}
+
+// Algorithm 实现 KeyGenOpts 以及 KeyImportOpts，注册表中的算法不需要额外的参数
+func (a Algorithm) Algorithm() Algorithm {
+	return a
+}
+
+// AlgorithmInfo 算法的元数据以及对应的密钥生成器、导入器，由各个算法包在 init 中注册
+type AlgorithmInfo struct {
+	Algorithm Algorithm
+	// KeySize 密钥长度，单位为 bit
+	KeySize      int
+	Symmetric    bool
+	Usages       KeyUsage
+	KeyGenerator KeyGenerator
+	KeyImporter  KeyImporter
+}
+
+// Supports 判断算法是否支持全部的 usage
+func (i *AlgorithmInfo) Supports(usage KeyUsage) bool {
+	return i.Usages&usage == usage
+}
+
+var registry = struct {
+	sync.RWMutex
+	algorithms map[Algorithm]*AlgorithmInfo
+}{
+	algorithms: make(map[Algorithm]*AlgorithmInfo),
+}
+
+// Register 注册算法，重复注册或者缺少密钥导入器时 panic
+func Register(info *AlgorithmInfo) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if info == nil || info.KeyImporter == nil {
+		panic("crypto: Register key importer is nil")
+	}
+	if _, ok := registry.algorithms[info.Algorithm]; ok {
+		panic("crypto: Register called twice for algorithm " + string(info.Algorithm))
+	}
+
+	registry.algorithms[info.Algorithm] = info
+}
+
+// Lookup 查询已注册的算法
+func Lookup(algorithm Algorithm) (*AlgorithmInfo, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	info, ok := registry.algorithms[algorithm]
+	return info, ok
+}
+
+// Algorithms 返回所有已注册的算法，按照算法名称排序
+func Algorithms() []*AlgorithmInfo {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	infos := make([]*AlgorithmInfo, 0, len(registry.algorithms))
+	for _, info := range registry.algorithms {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Algorithm < infos[j].Algorithm
+	})
+
+	return infos
+}
+
+// Policy 密钥策略，服务按照用途申请密钥，由策略决定具体的算法
+type Policy map[KeyUsage]Algorithm
+
+var (
+	// DefaultPolicy 默认策略
+	DefaultPolicy = Policy{
+		UsageSign:    EcdsaP256,
+		UsageEncrypt: AesGcm256,
+		UsageWrap:    Rsa2048,
+		UsageMAC:     HmacSha256,
+	}
+
+	// SM2Policy 国密策略
+	SM2Policy = Policy{
+		UsageSign:    Sm2,
+		UsageEncrypt: Sm4Cbc,
+		UsageWrap:    Sm2,
+		UsageMAC:     Sm3,
+	}
+)
+
+// Algorithm 返回用途对应的算法，算法未注册或者不支持该用途时返回错误
+func (p Policy) Algorithm(usage KeyUsage) (*AlgorithmInfo, error) {
+	algorithm, ok := p[usage]
+	if !ok {
+		return nil, fmt.Errorf("no algorithm for usage: %v", usage)
+	}
+
+	info, ok := Lookup(algorithm)
+	if !ok {
+		return nil, fmt.Errorf("algorithm not registered: %v", algorithm)
+	}
+	if !info.Supports(usage) {
+		return nil, fmt.Errorf("algorithm %v does not support usage: %v", algorithm, usage)
+	}
+
+	return info, nil
+}
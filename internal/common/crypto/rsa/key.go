@@ -60,7 +60,16 @@ func (r *PrivateKey) Encrypt(_ []byte) ([]byte, error) {
 }
 
 func (r *PrivateKey) Decrypt(src []byte) ([]byte, error) {
-	return rsa.DecryptOAEP(sha256.New(), rand.Reader, r.privateKey, src, nil)
+	return r.DecryptWithAssociatedData(src, nil)
+}
+
+func (r *PrivateKey) EncryptWithAssociatedData(_, _ []byte) ([]byte, error) {
+	return nil, errors.New("not supported")
+}
+
+// DecryptWithAssociatedData 附加数据作为 OAEP 的 label，与加密时的 label 不一致时解密失败
+func (r *PrivateKey) DecryptWithAssociatedData(src, ad []byte) ([]byte, error) {
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, r.privateKey, src, ad)
 }
 
 type PublicKey struct {
@@ -110,9 +119,18 @@ func (r *PublicKey) Verify(hash, sig []byte) bool {
 }
 
 func (r *PublicKey) Encrypt(src []byte) ([]byte, error) {
-	return rsa.EncryptOAEP(sha256.New(), rand.Reader, r.publicKey, src, nil)
+	return r.EncryptWithAssociatedData(src, nil)
+}
+
+// EncryptWithAssociatedData 附加数据作为 OAEP 的 label，解密时需要提供相同的 label
+func (r *PublicKey) EncryptWithAssociatedData(src, ad []byte) ([]byte, error) {
+	return rsa.EncryptOAEP(sha256.New(), rand.Reader, r.publicKey, src, ad)
 }
 
 func (r *PublicKey) Decrypt(_ []byte) ([]byte, error) {
 	return nil, errors.New("not supported")
 }
+
+func (r *PublicKey) DecryptWithAssociatedData(_, _ []byte) ([]byte, error) {
+	return nil, errors.New("not supported")
+}
@@ -10,6 +10,7 @@ package rsa
 
 import (
 	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
 
@@ -34,6 +35,11 @@ func (k *KeyImporter) KeyImport(raw interface{}, opts crypto.KeyImportOpts) (cry
 		return nil, fmt.Errorf("only supports string or []byte type of key")
 	}
 
+	// Bytes 导出的是 pem 格式，同时支持 pem 以及 der 格式
+	if block, _ := pem.Decode(der); block != nil {
+		der = block.Bytes
+	}
+
 	privKey, err := x509.ParsePKCS1PrivateKey(der)
 	if err == nil {
 		return &PrivateKey{privateKey: privKey}, nil
@@ -44,5 +50,5 @@ func (k *KeyImporter) KeyImport(raw interface{}, opts crypto.KeyImportOpts) (cry
 		return &PublicKey{publicKey: pubKey}, nil
 	}
 
-	return nil, errors.New("is not ecdsa key")
+	return nil, errors.New("is not rsa key")
 }
@@ -0,0 +1,25 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package rsa
+
+import (
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+func init() {
+	for algorithm, bits := range map[crypto.Algorithm]int{crypto.Rsa1024: 1024, crypto.Rsa2048: 2048, crypto.Rsa4096: 4096} {
+		crypto.Register(&crypto.AlgorithmInfo{
+			Algorithm:    algorithm,
+			KeySize:      bits,
+			Usages:       crypto.UsageSign | crypto.UsageWrap,
+			KeyGenerator: new(keyGenerator),
+			KeyImporter:  new(KeyImporter),
+		})
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package crypto
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/msp"
+)
+
+// Signer 使用签名私钥以及对应的证书实现 Fabric 的 identity.SignerSerializer，可以直接用于 protoutil。
+// 签名前对消息计算 SHA-256 摘要（私钥实现 Digester 时使用其摘要算法），ECDSA 私钥的签名结果为 low-S 格式
+type Signer struct {
+	mspID string
+	cert  []byte
+	key   Key
+}
+
+// NewSigner cert 为 pem 格式的签名证书，key 必须为非对称私钥
+func NewSigner(mspID string, cert []byte, key Key) (*Signer, error) {
+	if key == nil || key.Symmetric() || !key.Private() {
+		return nil, errors.New("signer requires an asymmetric private key")
+	}
+
+	return &Signer{
+		mspID: mspID,
+		cert:  cert,
+		key:   key,
+	}, nil
+}
+
+func (s *Signer) Sign(msg []byte) ([]byte, error) {
+	if digester, ok := s.key.(Digester); ok {
+		return s.key.Sign(digester.Digest(msg))
+	}
+
+	digest := sha256.Sum256(msg)
+	return s.key.Sign(digest[:])
+}
+
+func (s *Signer) Serialize() ([]byte, error) {
+	return proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   s.mspID,
+		IdBytes: s.cert,
+	})
+}
+
+// Verify 使用非对称公钥校验 Signer 生成的签名，摘要算法与 Signer 一致，
+// 公钥实现 LowSVerifier 时与 Fabric 一样拒绝 high-S 格式的签名
+func Verify(key Key, msg, sig []byte) bool {
+	var digest []byte
+	if digester, ok := key.(Digester); ok {
+		digest = digester.Digest(msg)
+	} else {
+		sum := sha256.Sum256(msg)
+		digest = sum[:]
+	}
+
+	if verifier, ok := key.(LowSVerifier); ok {
+		return verifier.VerifyLowS(digest, sig)
+	}
+
+	return key.Verify(digest, sig)
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package sm2
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/sm3"
+	"github.com/tjfoc/gmsm/x509"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+type sm2PrivateKey struct {
+	privateKey *sm2.PrivateKey
+}
+
+func (s *sm2PrivateKey) Bytes() ([]byte, error) {
+	pkcs8Encoded, err := x509.MarshalSm2UnecryptedPrivateKey(s.privateKey)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to marshal private key")
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8Encoded}), nil
+}
+
+func (s *sm2PrivateKey) SKI() []byte {
+	pubKey, _ := s.PublicKey()
+	return pubKey.SKI()
+}
+
+func (s *sm2PrivateKey) Symmetric() bool {
+	return false
+}
+
+func (s *sm2PrivateKey) Private() bool {
+	return true
+}
+
+func (s *sm2PrivateKey) PublicKey() (crypto.Key, error) {
+	return &sm2PublicKey{publicKey: &s.privateKey.PublicKey}, nil
+}
+
+// Sign SM2 签名内部会使用 SM3 以及默认的用户 ID 再次计算摘要，与 Fabric 国密版本的签名方式一致
+func (s *sm2PrivateKey) Sign(digest []byte) ([]byte, error) {
+	return s.privateKey.Sign(rand.Reader, digest, nil)
+}
+
+// Digest 使用 SM3 计算消息摘要，Signer 签名前调用
+func (s *sm2PrivateKey) Digest(msg []byte) []byte {
+	return sm3.Sm3Sum(msg)
+}
+
+func (s *sm2PrivateKey) Verify(_, _ []byte) bool {
+	return false
+}
+
+func (s *sm2PrivateKey) Encrypt(_ []byte) ([]byte, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s *sm2PrivateKey) Decrypt(src []byte) ([]byte, error) {
+	return sm2.DecryptAsn1(s.privateKey, src)
+}
+
+type sm2PublicKey struct {
+	publicKey *sm2.PublicKey
+}
+
+func (s *sm2PublicKey) Bytes() ([]byte, error) {
+	pkixEncoded, err := x509.MarshalSm2PublicKey(s.publicKey)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to marshal public key")
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkixEncoded}), nil
+}
+
+func (s *sm2PublicKey) SKI() []byte {
+	// 与 ECDSA 公钥一致，使用 SHA-256 计算
+	raw := elliptic.Marshal(s.publicKey.Curve, s.publicKey.X, s.publicKey.Y)
+
+	hash := sha256.New()
+	hash.Write(raw)
+	return hash.Sum(nil)
+}
+
+func (s *sm2PublicKey) Symmetric() bool {
+	return false
+}
+
+func (s *sm2PublicKey) Private() bool {
+	return false
+}
+
+func (s *sm2PublicKey) PublicKey() (crypto.Key, error) {
+	return s, nil
+}
+
+func (s *sm2PublicKey) Sign(_ []byte) ([]byte, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s *sm2PublicKey) Digest(msg []byte) []byte {
+	return sm3.Sm3Sum(msg)
+}
+
+func (s *sm2PublicKey) Verify(hash, sig []byte) bool {
+	return s.publicKey.Verify(hash, sig)
+}
+
+func (s *sm2PublicKey) Encrypt(src []byte) ([]byte, error) {
+	return sm2.EncryptAsn1(s.publicKey, src, rand.Reader)
+}
+
+func (s *sm2PublicKey) Decrypt(_ []byte) ([]byte, error) {
+	return nil, errors.New("not supported")
+}
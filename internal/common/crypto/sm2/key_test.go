@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package sm2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"github.com/yakumioto/alkaid/internal/common/crypto/ecdsa"
+)
+
+func TestSM2SignAndEncrypt(t *testing.T) {
+	privateKey, err := KeyGen(&crypto.SM2KeyGenOpts{})
+	assert.NoError(t, err)
+	publicKey, err := privateKey.PublicKey()
+	assert.NoError(t, err)
+
+	digest := privateKey.(crypto.Digester).Digest([]byte("message"))
+	sig, err := privateKey.Sign(digest)
+	assert.NoError(t, err)
+	assert.True(t, publicKey.Verify(digest, sig))
+	assert.False(t, publicKey.Verify(digest[1:], sig))
+
+	ciphertext, err := publicKey.Encrypt([]byte("hello world"))
+	assert.NoError(t, err)
+	text, err := privateKey.Decrypt(ciphertext)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(text))
+}
+
+func TestKeyImport(t *testing.T) {
+	privateKey, err := KeyGen(&crypto.SM2KeyGenOpts{})
+	assert.NoError(t, err)
+	publicKey, err := privateKey.PublicKey()
+	assert.NoError(t, err)
+
+	for _, key := range []crypto.Key{privateKey, publicKey} {
+		raw, err := key.Bytes()
+		assert.NoError(t, err)
+
+		imported, err := KeyImport(raw)
+		assert.NoError(t, err)
+		assert.Equal(t, key.Private(), imported.Private())
+		assert.Equal(t, key.SKI(), imported.SKI())
+	}
+
+	// ECDSA 密钥不能作为 SM2 密钥导入
+	ecdsaPrivateKey, err := ecdsa.KeyGen(&crypto.ECDSAP256KeyGenOpts{})
+	assert.NoError(t, err)
+	ecdsaPublicKey, err := ecdsaPrivateKey.PublicKey()
+	assert.NoError(t, err)
+	for _, key := range []crypto.Key{ecdsaPrivateKey, ecdsaPublicKey} {
+		raw, err := key.Bytes()
+		assert.NoError(t, err)
+		_, err = KeyImport(raw)
+		assert.Error(t, err)
+	}
+
+	_, err = KeyImport([]byte("invalid"))
+	assert.Error(t, err)
+}
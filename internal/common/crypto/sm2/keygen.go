@@ -0,0 +1,36 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package sm2
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+func KeyGen(opts crypto.KeyGenOpts) (crypto.Key, error) {
+	return new(keyGenerator).KeyGen(opts)
+}
+
+type keyGenerator struct{}
+
+func (kg *keyGenerator) KeyGen(opts crypto.KeyGenOpts) (crypto.Key, error) {
+	if opts.Algorithm() != crypto.Sm2 {
+		return nil, fmt.Errorf("unsupported SM2 algorithm: %v", opts.Algorithm())
+	}
+
+	privateKey, err := sm2.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating SM2 key error: [%s]", err)
+	}
+
+	return &sm2PrivateKey{privateKey: privateKey}, nil
+}
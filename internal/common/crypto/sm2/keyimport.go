@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package sm2
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/x509"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+var oidNamedCurveSM2 = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 301}
+
+type KeyImporter struct{}
+
+func KeyImport(raw interface{}) (crypto.Key, error) {
+	return new(KeyImporter).KeyImport(raw, nil)
+}
+
+func (k *KeyImporter) KeyImport(raw interface{}, _ crypto.KeyImportOpts) (crypto.Key, error) {
+	var der []byte
+
+	switch raw := raw.(type) {
+	case []byte:
+		der = raw
+	case string:
+		der = []byte(raw)
+	default:
+		return nil, fmt.Errorf("only supports string or []byte type of key")
+	}
+
+	if block, _ := pem.Decode(der); block != nil {
+		der = block.Bytes
+	}
+
+	if isSM2PrivateKey(der) {
+		privateKey, err := x509.ParsePKCS8UnecryptedPrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		return &sm2PrivateKey{privateKey: privateKey}, nil
+	}
+
+	// gmsm 将 SM2 公钥解析为使用 SM2 曲线的 ecdsa.PublicKey
+	key, err := x509.ParsePKIXPublicKey(der)
+	if publicKey, ok := key.(*ecdsa.PublicKey); err == nil && ok && publicKey.Curve == sm2.P256Sm2() {
+		return &sm2PublicKey{publicKey: &sm2.PublicKey{Curve: publicKey.Curve, X: publicKey.X, Y: publicKey.Y}}, nil
+	}
+
+	return nil, errors.New("is not sm2 key")
+}
+
+// isSM2PrivateKey gmsm 解析 PKCS8 私钥时不校验曲线，ECDSA 私钥同样会被解析为 SM2 私钥
+func isSM2PrivateKey(der []byte) bool {
+	var privateKey struct {
+		Version    int
+		Algo       pkix.AlgorithmIdentifier
+		PrivateKey []byte
+	}
+	if _, err := asn1.Unmarshal(der, &privateKey); err != nil {
+		return false
+	}
+
+	var curve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(privateKey.Algo.Parameters.FullBytes, &curve); err != nil {
+		return false
+	}
+
+	return curve.Equal(oidNamedCurveSM2)
+}
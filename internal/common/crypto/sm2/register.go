@@ -0,0 +1,23 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package sm2
+
+import (
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+func init() {
+	crypto.Register(&crypto.AlgorithmInfo{
+		Algorithm:    crypto.Sm2,
+		KeySize:      256,
+		Usages:       crypto.UsageSign | crypto.UsageWrap,
+		KeyGenerator: new(keyGenerator),
+		KeyImporter:  new(KeyImporter),
+	})
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package sm4
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/tjfoc/gmsm/sm3"
+	"github.com/tjfoc/gmsm/sm4"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+type randomIVFunc func(len int) ([]byte, error)
+
+var (
+	randomIV randomIVFunc = func(len int) ([]byte, error) {
+		iv := make([]byte, len)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, err
+		}
+
+		return iv, nil
+	}
+)
+
+type CBCKey struct {
+	key []byte
+}
+
+// Bytes 返回原始密钥，用于封装后保存
+func (s *CBCKey) Bytes() ([]byte, error) {
+	return append([]byte(nil), s.key...), nil
+}
+
+func (s *CBCKey) SKI() []byte {
+	return sm3.Sm3Sum(s.key)
+}
+
+func (s *CBCKey) Symmetric() bool {
+	return true
+}
+
+func (s *CBCKey) Private() bool {
+	return true
+}
+
+func (s *CBCKey) PublicKey() (crypto.Key, error) {
+	return nil, errors.New("cannot call this method on a symmetric key")
+}
+
+func (s *CBCKey) Sign(_ []byte) ([]byte, error) {
+	return nil, errors.New("cannot call this method on a symmetric key")
+}
+
+func (s *CBCKey) Verify(_, _ []byte) bool {
+	return false
+}
+
+func (s *CBCKey) Encrypt(text []byte) ([]byte, error) {
+	paddedText := pkcs7Padding(text)
+
+	iv, err := randomIV(sm4.BlockSize)
+	if err != nil {
+		return nil, fmt.Errorf("random iv error: %v", err)
+	}
+
+	block, err := sm4.NewCipher(s.key)
+	if err != nil {
+		return nil, fmt.Errorf("new chipher error: %v", err)
+	}
+
+	mode := cipher.NewCBCEncrypter(block, iv)
+	dst := make([]byte, len(paddedText))
+	mode.CryptBlocks(dst, paddedText)
+
+	ciphertext := bytes.NewBuffer(nil)
+	ciphertext.Write(iv)
+	ciphertext.Write(dst)
+
+	return ciphertext.Bytes(), nil
+}
+
+func (s *CBCKey) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < sm4.BlockSize*2 || len(ciphertext)%sm4.BlockSize != 0 {
+		return nil, errors.New("invalid ciphertext length")
+	}
+
+	iv := ciphertext[:sm4.BlockSize]
+	src := ciphertext[sm4.BlockSize:]
+
+	block, err := sm4.NewCipher(s.key)
+	if err != nil {
+		return nil, fmt.Errorf("new chipher error: %v", err)
+	}
+
+	mode := cipher.NewCBCDecrypter(block, iv)
+	paddedText := make([]byte, len(src))
+	mode.CryptBlocks(paddedText, src)
+
+	return pkcs7UnPadding(paddedText)
+}
+
+func pkcs7Padding(src []byte) []byte {
+	padding := sm4.BlockSize - len(src)%sm4.BlockSize
+	return append(src, bytes.Repeat([]byte{byte(padding)}, padding)...)
+}
+
+func pkcs7UnPadding(src []byte) ([]byte, error) {
+	unPadding := int(src[len(src)-1])
+	if unPadding == 0 || unPadding > sm4.BlockSize || unPadding > len(src) {
+		return nil, errors.New("invalid padding")
+	}
+
+	return src[:(len(src) - unPadding)], nil
+}
@@ -0,0 +1,38 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package sm4
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/tjfoc/gmsm/sm4"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+func KeyGen(opts crypto.KeyGenOpts) (crypto.Key, error) {
+	return new(keyGenerator).KeyGen(opts)
+}
+
+type keyGenerator struct{}
+
+func (kg *keyGenerator) KeyGen(opts crypto.KeyGenOpts) (crypto.Key, error) {
+	if opts.Algorithm() != crypto.Sm4Cbc {
+		return nil, fmt.Errorf("unsupported sm4 algorithm: %v", opts.Algorithm())
+	}
+
+	key := make([]byte, sm4.BlockSize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generating SM4 key error: [%s]", err)
+	}
+
+	return &CBCKey{
+		key: key,
+	}, nil
+}
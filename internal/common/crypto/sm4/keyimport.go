@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package sm4
+
+import (
+	"fmt"
+
+	"github.com/tjfoc/gmsm/sm3"
+	"github.com/tjfoc/gmsm/sm4"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func NewKey(raw interface{}, opts crypto.KeyImportOpts) (crypto.Key, error) {
+	return new(keyImporter).KeyImport(raw, opts)
+}
+
+type keyImporter struct{}
+
+// KeyImport SM4 密钥固定为 128 位，长度不一致时使用 PBKDF2-SM3 扩展
+func (kg *keyImporter) KeyImport(raw interface{}, opts crypto.KeyImportOpts) (crypto.Key, error) {
+	var key []byte
+
+	switch raw := raw.(type) {
+	case []byte:
+		key = raw
+	case string:
+		key = []byte(raw)
+	default:
+		return nil, fmt.Errorf("only supports string or []byte type of key")
+	}
+
+	if opts.Algorithm() != crypto.Sm4Cbc {
+		return nil, fmt.Errorf("unsupported sm4 algorithm: %v", opts.Algorithm())
+	}
+
+	if len(key) != sm4.BlockSize {
+		key = pbkdf2.Key(key, key, 1000, sm4.BlockSize, sm3.New)
+	}
+
+	return &CBCKey{
+		key: key,
+	}, nil
+}
@@ -0,0 +1,25 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package sm4
+
+import (
+	"github.com/tjfoc/gmsm/sm4"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+)
+
+func init() {
+	crypto.Register(&crypto.AlgorithmInfo{
+		Algorithm:    crypto.Sm4Cbc,
+		KeySize:      sm4.BlockSize * 8,
+		Symmetric:    true,
+		Usages:       crypto.UsageEncrypt | crypto.UsageWrap,
+		KeyGenerator: new(keyGenerator),
+		KeyImporter:  new(keyImporter),
+	})
+}
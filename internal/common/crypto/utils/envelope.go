@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HasMac 使用独立 HMAC 认证的类型，Envelope 中包含 MAC 部分
+func (t EncType) HasMac() bool {
+	switch t {
+	case AesCbc256HmacSha256B64, Rsa2048OaepSha256HmacShaB64, Sm4Cbc128HmacSm3B64:
+		return true
+	}
+
+	return false
+}
+
+// IsAEAD 密文自带认证并且支持附加数据的类型
+func (t EncType) IsAEAD() bool {
+	return t == AesGcm256B64 || t == XChaCha20Poly1305B64
+}
+
+func (t EncType) valid() bool {
+	return AesCbc256B64 <= t && t <= XChaCha20Poly1305B64
+}
+
+// Envelope 加密数据的格式为 "<typ>.<base64 ciphertext>"，带有 MAC 的类型为 "<typ>.<base64 ciphertext>.<base64 mac>"，
+// MAC 为对 base64 编码后的密文计算的 HMAC
+type Envelope struct {
+	Type       EncType
+	Ciphertext []byte
+	Mac        []byte
+}
+
+// ParseEnvelope 严格解析加密数据：类型必须是已知的 EncType，分段数量与类型一致，
+// base64 必须是标准编码，密文以及 MAC 不能为空
+func ParseEnvelope(text string) (*Envelope, error) {
+	parts := strings.Split(text, ".")
+	if len(parts) < 2 {
+		return nil, errors.New("irregular encrypted data format")
+	}
+
+	typ, err := strconv.Atoi(parts[0])
+	if err != nil || strconv.Itoa(typ) != parts[0] {
+		return nil, errors.New("irregular encrypted data format")
+	}
+	envelope := &Envelope{Type: EncType(typ)}
+	if !envelope.Type.valid() {
+		return nil, fmt.Errorf("unsupported encryption type: %v", typ)
+	}
+
+	expected := 2
+	if envelope.Type.HasMac() {
+		expected = 3
+	}
+	if len(parts) != expected {
+		return nil, fmt.Errorf("encryption type %v requires %d parts, got %d", typ, expected, len(parts))
+	}
+
+	envelope.Ciphertext, err = decodeBase64(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("base64 decode ciphertext error: %v", err)
+	}
+	if envelope.Type.HasMac() {
+		envelope.Mac, err = decodeBase64(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("base64 decode sig error: %v", err)
+		}
+	}
+
+	return envelope, nil
+}
+
+// EncodedCiphertext base64 编码后的密文，即 MAC 的计算对象
+func (e *Envelope) EncodedCiphertext() string {
+	return base64.StdEncoding.EncodeToString(e.Ciphertext)
+}
+
+func (e *Envelope) String() string {
+	parts := []string{strconv.Itoa(int(e.Type)), e.EncodedCiphertext()}
+	if e.Type.HasMac() {
+		parts = append(parts, base64.StdEncoding.EncodeToString(e.Mac))
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	data, err := base64.StdEncoding.Strict().DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty data")
+	}
+
+	return data, nil
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEnvelope(t *testing.T) {
+	tcs := []struct {
+		name    string
+		text    string
+		typ     EncType
+		success bool
+	}{
+		{"aes-cbc", "0.aGVsbG8=", AesCbc256B64, true},
+		{"aes-cbc with hmac", "1.aGVsbG8=.c2ln", AesCbc256HmacSha256B64, true},
+		{"rsa", "2.aGVsbG8=", Rsa2048OaepSha256B64, true},
+		{"aes-gcm", "5.aGVsbG8=", AesGcm256B64, true},
+		{"xchacha20-poly1305", "6.aGVsbG8=", XChaCha20Poly1305B64, true},
+		{"missing hmac", "1.aGVsbG8=", 0, false},
+		{"unexpected hmac", "5.aGVsbG8=.c2ln", 0, false},
+		{"unknown type", "9.aGVsbG8=", 0, false},
+		{"negative type", "-1.aGVsbG8=", 0, false},
+		{"non canonical type", "01.aGVsbG8=.c2ln", 0, false},
+		{"empty ciphertext", "5.", 0, false},
+		{"empty hmac", "1.aGVsbG8=.", 0, false},
+		{"url base64", "5.-_8=", 0, false},
+		{"non strict base64", "5.aGVsbG9=", 0, false},
+		{"no separator", "aGVsbG8=", 0, false},
+	}
+
+	for _, tc := range tcs {
+		envelope, err := ParseEnvelope(tc.text)
+		assert.Equal(t, tc.success, err == nil, tc.name)
+		if tc.success {
+			assert.Equal(t, tc.typ, envelope.Type, tc.name)
+			assert.Equal(t, tc.text, envelope.String(), tc.name)
+		}
+	}
+}
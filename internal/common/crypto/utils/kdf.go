@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package utils
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// 可选的密码 KDF 算法
+const (
+	KDFPBKDF2Sha256 = "pbkdf2-sha256"
+	KDFArgon2id     = "argon2id"
+)
+
+// KDF 参数的下限，低于下限的参数视为无效
+const (
+	MinPBKDF2Iterations = 100000
+	MinArgon2Iterations = 2
+	MinArgon2Memory     = 16 // MiB
+	MinArgon2Threads    = 1
+)
+
+// DefaultKDFParams 未配置时使用的 KDF 参数，与引入可配置 KDF 之前的 GetMasterKey 一致
+var DefaultKDFParams = KDFParams{
+	Type:       KDFPBKDF2Sha256,
+	Iterations: MinPBKDF2Iterations,
+}
+
+// KDFParams 从密码生成主密钥的 KDF 参数。PBKDF2 只使用 Iterations；
+// Argon2id 的 Iterations 为时间成本，Memory 的单位为 MiB，Threads 为并行度
+type KDFParams struct {
+	Type       string `json:"kdf"`
+	Iterations int    `json:"kdfIterations"`
+	Memory     int    `json:"kdfMemory,omitempty"`
+	Threads    int    `json:"kdfThreads,omitempty"`
+}
+
+// Validate 校验 KDF 类型以及参数是否满足下限
+func (p KDFParams) Validate() error {
+	switch p.Type {
+	case KDFPBKDF2Sha256:
+		if p.Iterations < MinPBKDF2Iterations {
+			return fmt.Errorf("pbkdf2 iterations must be at least %d", MinPBKDF2Iterations)
+		}
+	case KDFArgon2id:
+		if p.Iterations < MinArgon2Iterations {
+			return fmt.Errorf("argon2id iterations must be at least %d", MinArgon2Iterations)
+		}
+		if p.Memory < MinArgon2Memory {
+			return fmt.Errorf("argon2id memory must be at least %d MiB", MinArgon2Memory)
+		}
+		if p.Threads < MinArgon2Threads || p.Threads > 255 {
+			return fmt.Errorf("argon2id threads must be between %d and 255", MinArgon2Threads)
+		}
+	default:
+		return fmt.Errorf("unsupported kdf: %q", p.Type)
+	}
+
+	return nil
+}
+
+// Satisfies 判断参数是否满足 policy 的要求，KDF 类型不同或者任意一个参数低于 policy 时需要升级
+func (p KDFParams) Satisfies(policy KDFParams) bool {
+	if p.Type != policy.Type || p.Iterations < policy.Iterations {
+		return false
+	}
+	if p.Type == KDFArgon2id {
+		return p.Memory >= policy.Memory && p.Threads >= policy.Threads
+	}
+
+	return true
+}
+
+// MasterKey 使用 KDF 从密码生成 32 字节的主密钥，Argon2id 使用盐的 SHA-256 摘要作为盐
+func (p KDFParams) MasterKey(password, salt string) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch p.Type {
+	case KDFArgon2id:
+		digest := sha256.Sum256([]byte(salt))
+		return argon2.IDKey([]byte(password), digest[:], uint32(p.Iterations),
+			uint32(p.Memory)*1024, uint8(p.Threads), 32), nil
+	default:
+		return pbkdf2.Key([]byte(password), []byte(salt), p.Iterations, 32, sha256.New), nil
+	}
+}
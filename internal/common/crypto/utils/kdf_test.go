@@ -0,0 +1,57 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKDFParams(t *testing.T) {
+	argon2id := KDFParams{Type: KDFArgon2id, Iterations: 2, Memory: 16, Threads: 1}
+
+	tcs := []struct {
+		name   string
+		params KDFParams
+		valid  bool
+	}{
+		{"default", DefaultKDFParams, true},
+		{"argon2id", argon2id, true},
+		{"pbkdf2 iterations", KDFParams{Type: KDFPBKDF2Sha256, Iterations: 1}, false},
+		{"argon2id memory", KDFParams{Type: KDFArgon2id, Iterations: 2, Memory: 1, Threads: 1}, false},
+		{"argon2id threads", KDFParams{Type: KDFArgon2id, Iterations: 2, Memory: 16, Threads: 256}, false},
+		{"unsupported", KDFParams{Type: "scrypt", Iterations: 1}, false},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.valid, tc.params.Validate() == nil, tc.name)
+		_, err := tc.params.MasterKey("password", "alice@alkaid.com")
+		assert.Equal(t, tc.valid, err == nil, tc.name)
+	}
+
+	// 默认参数与 GetMasterKey 的结果一致
+	masterKey, err := DefaultKDFParams.MasterKey("password", "alice@alkaid.com")
+	assert.NoError(t, err)
+	assert.Equal(t, GetMasterKey("password", "alice@alkaid.com"), masterKey)
+
+	key1, err := argon2id.MasterKey("password", "alice@alkaid.com")
+	assert.NoError(t, err)
+	key2, err := argon2id.MasterKey("password", "bob@alkaid.com")
+	assert.NoError(t, err)
+	assert.Len(t, key1, 32)
+	assert.NotEqual(t, key1, key2)
+	assert.NotEqual(t, masterKey, key1)
+
+	assert.True(t, argon2id.Satisfies(argon2id))
+	assert.False(t, DefaultKDFParams.Satisfies(argon2id))
+	assert.False(t, argon2id.Satisfies(KDFParams{Type: KDFArgon2id, Iterations: 2, Memory: 64, Threads: 1}))
+	assert.False(t, DefaultKDFParams.Satisfies(KDFParams{Type: KDFPBKDF2Sha256, Iterations: 600000}))
+	assert.True(t, KDFParams{Type: KDFPBKDF2Sha256, Iterations: 600000}.Satisfies(DefaultKDFParams))
+}
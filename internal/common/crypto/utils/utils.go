@@ -9,8 +9,6 @@
 package utils
 
 import (
-	"bytes"
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
@@ -21,8 +19,11 @@ import (
 	"github.com/lithammer/shortuuid"
 	"github.com/yakumioto/alkaid/internal/common/crypto"
 	"github.com/yakumioto/alkaid/internal/common/crypto/aes"
+	"github.com/yakumioto/alkaid/internal/common/crypto/chacha20poly1305"
+	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
 	"github.com/yakumioto/alkaid/internal/common/crypto/hmac"
 	"github.com/yakumioto/alkaid/internal/common/crypto/rsa"
+	"github.com/yakumioto/alkaid/internal/common/crypto/sm4"
 	"golang.org/x/crypto/hkdf"
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -30,10 +31,13 @@ import (
 type EncType int
 
 const (
-	AesCbc256B64 = iota
+	AesCbc256B64 EncType = iota
 	AesCbc256HmacSha256B64
 	Rsa2048OaepSha256B64
 	Rsa2048OaepSha256HmacShaB64
+	Sm4Cbc128HmacSm3B64
+	AesGcm256B64
+	XChaCha20Poly1305B64
 )
 
 type StretchedKey struct {
@@ -62,14 +66,22 @@ func GetStretchedKey(masterKey []byte) (*StretchedKey, error) {
 	}, nil
 }
 
+// GenSymmetricKey 按照默认策略生成加密密钥以及 MAC 密钥，返回两者的原始密钥
 func GenSymmetricKey() (*StretchedKey, error) {
-	encData := make([]byte, 32)
-	macData := make([]byte, 32)
-	_, err := rand.Read(encData)
+	encKey, err := factory.CryptoKeyGenByUsage(crypto.DefaultPolicy, crypto.UsageEncrypt)
+	if err != nil {
+		return nil, err
+	}
+	macKey, err := factory.CryptoKeyGenByUsage(crypto.DefaultPolicy, crypto.UsageMAC)
+	if err != nil {
+		return nil, err
+	}
+
+	encData, err := encKey.Bytes()
 	if err != nil {
 		return nil, err
 	}
-	_, err = rand.Read(macData)
+	macData, err := macKey.Bytes()
 	if err != nil {
 		return nil, err
 	}
@@ -112,10 +124,72 @@ func GenResourceID(namespace string) string {
 	return fmt.Sprintf("%s-%s", namespace, shortuuid.New())
 }
 
+// EncryptWithStretchedKey 使用扩展密钥的 Enc 进行 AES-256-GCM 加密，ad 为绑定的附加数据
+func EncryptWithStretchedKey(key *StretchedKey, text, ad []byte) (string, error) {
+	gcmKey, err := factory.CryptoKeyImport(key.Enc, crypto.AesGcm256)
+	if err != nil {
+		return "", err
+	}
+
+	return EncryptWithAssociatedData(AesGcm256B64, text, ad, gcmKey)
+}
+
+// DecryptWithStretchedKey 使用扩展密钥的 Enc 进行 AES-256-GCM 解密，只接受 AES-256-GCM 格式，
+// 旧格式的密文没有绑定附加数据，需要通过 DecryptLegacyWithStretchedKey 在升级时解密
+func DecryptWithStretchedKey(key *StretchedKey, ciphertext string, ad []byte) ([]byte, error) {
+	envelope, err := ParseEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if envelope.Type != AesGcm256B64 {
+		return nil, fmt.Errorf("unexpected encryption type: %v", envelope.Type)
+	}
+
+	gcmKey, err := factory.CryptoKeyImport(key.Enc, crypto.AesGcm256)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecryptWithAssociatedData(ciphertext, ad, gcmKey)
+}
+
+// DecryptLegacyWithStretchedKey 解密 AES-256-CBC + HMAC-SHA256 格式的旧数据，旧格式不校验附加数据，
+// 只能用于将旧数据升级为 AES-256-GCM 格式，不包含 MAC 的 AES-256-CBC 格式始终被拒绝
+func DecryptLegacyWithStretchedKey(key *StretchedKey, ciphertext string) ([]byte, error) {
+	envelope, err := ParseEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if envelope.Type != AesCbc256HmacSha256B64 {
+		return nil, fmt.Errorf("unexpected legacy encryption type: %v", envelope.Type)
+	}
+
+	aesKey, err := factory.CryptoKeyImport(key.Enc, crypto.AesCbc256)
+	if err != nil {
+		return nil, err
+	}
+	hmacKey, err := factory.CryptoKeyImport(key.Mac, crypto.HmacSha256)
+	if err != nil {
+		return nil, err
+	}
+
+	return Decrypt(ciphertext, aesKey, hmacKey)
+}
+
+// Encrypt 等同于附加数据为空的 EncryptWithAssociatedData
 func Encrypt(typ EncType, text []byte, keys ...interface{}) (string, error) {
+	return EncryptWithAssociatedData(typ, text, nil, keys...)
+}
+
+// EncryptWithAssociatedData 按照 typ 加密数据，返回 Envelope 格式的字符串。
+// 附加数据用来将密文与所属的记录绑定，解密时需要提供相同的附加数据，密钥不支持附加数据时返回错误
+func EncryptWithAssociatedData(typ EncType, text, ad []byte, keys ...interface{}) (string, error) {
 	var (
 		ak crypto.Key
+		gk crypto.Key
+		ck crypto.Key
 		rk crypto.Key
+		sk crypto.Key
 		hk crypto.Key
 	)
 
@@ -123,137 +197,146 @@ func Encrypt(typ EncType, text []byte, keys ...interface{}) (string, error) {
 		switch key := key.(type) {
 		case *aes.CBCKey:
 			ak = key
+		case *aes.GCMKey:
+			gk = key
+		case *chacha20poly1305.Key:
+			ck = key
 		case *hmac.Key:
 			hk = key
 		case *rsa.PublicKey:
 			rk = key
+		case *sm4.CBCKey:
+			sk = key
 		}
 	}
 
 	var (
-		ciphertextBytes []byte
-		err             error
+		ek  crypto.Key
+		err error
 	)
 	switch typ {
+	case AesCbc256B64:
+		ek, err = requireKeys("aes key", ak)
 	case AesCbc256HmacSha256B64:
-		if ak == nil || hk == nil {
-			return "", errors.New("not found aes key or hmac key")
-		}
-		ciphertextBytes, err = ak.Encrypt(text)
-		if err != nil {
-			return "", err
-		}
-
+		ek, err = requireKeys("aes key or hmac key", ak, hk)
+	case Rsa2048OaepSha256B64:
+		ek, err = requireKeys("rsa key", rk)
 	case Rsa2048OaepSha256HmacShaB64:
-		if rk == nil || hk == nil {
-			return "", errors.New("not found rsa key or hmac key")
-		}
-		ciphertextBytes, err = rk.Encrypt(text)
-		if err != nil {
-			return "", err
-		}
+		ek, err = requireKeys("rsa key or hmac key", rk, hk)
+	case Sm4Cbc128HmacSm3B64:
+		ek, err = requireKeys("sm4 key or hmac key", sk, hk)
+	case AesGcm256B64:
+		ek, err = requireKeys("aes-gcm key", gk)
+	case XChaCha20Poly1305B64:
+		ek, err = requireKeys("xchacha20-poly1305 key", ck)
+	default:
+		return "", fmt.Errorf("unsupported encryption type: %v", typ)
+	}
+	if err != nil {
+		return "", err
 	}
 
-	ciphertext := base64.StdEncoding.EncodeToString(ciphertextBytes)
-	sigBytes, err := hk.Sign([]byte(ciphertext))
+	envelope := &Envelope{Type: typ}
+	if aeadKey, ok := ek.(crypto.AEADKey); ok {
+		envelope.Ciphertext, err = aeadKey.EncryptWithAssociatedData(text, ad)
+	} else if len(ad) != 0 {
+		return "", fmt.Errorf("encryption type %v does not support associated data", typ)
+	} else {
+		envelope.Ciphertext, err = ek.Encrypt(text)
+	}
 	if err != nil {
 		return "", err
 	}
-	sig := base64.StdEncoding.EncodeToString(sigBytes)
 
-	data := bytes.NewBuffer(nil)
-	data.WriteString(strconv.Itoa(int(typ)))
-	data.WriteString(".")
-	data.WriteString(ciphertext)
-	data.WriteString(".")
-	data.WriteString(sig)
+	if typ.HasMac() {
+		envelope.Mac, err = hk.Sign([]byte(envelope.EncodedCiphertext()))
+		if err != nil {
+			return "", err
+		}
+	}
 
-	return data.String(), nil
+	return envelope.String(), nil
 }
 
+// Decrypt 等同于附加数据为空的 DecryptWithAssociatedData
 func Decrypt(text string, keys ...crypto.Key) ([]byte, error) {
+	return DecryptWithAssociatedData(text, nil, keys...)
+}
+
+// DecryptWithAssociatedData 使用 ParseEnvelope 严格解析加密数据后解密，带有 MAC 的类型先校验 MAC
+func DecryptWithAssociatedData(text string, ad []byte, keys ...crypto.Key) ([]byte, error) {
 	var (
-		ak      crypto.Key
-		rk      crypto.Key
-		hk      crypto.Key
-		sig     bool
-		sigText string
+		ak crypto.Key
+		gk crypto.Key
+		ck crypto.Key
+		rk crypto.Key
+		sk crypto.Key
+		hk crypto.Key
 	)
 
 	for _, key := range keys {
 		switch key := key.(type) {
 		case *aes.CBCKey:
 			ak = key
+		case *aes.GCMKey:
+			gk = key
+		case *chacha20poly1305.Key:
+			ck = key
 		case *hmac.Key:
 			hk = key
 		case *rsa.PrivateKey:
 			rk = key
+		case *sm4.CBCKey:
+			sk = key
 		}
 	}
 
-	texts := strings.Split(text, ".")
-	if len(texts) < 2 && len(texts) > 3 {
-		return nil, errors.New("irregular encrypted data format")
-	}
-	if len(texts) == 3 {
-		sig = true
-	}
-	typ, err := strconv.Atoi(texts[0])
+	envelope, err := ParseEnvelope(text)
 	if err != nil {
-		return nil, errors.New("irregular encrypted data format")
-	}
-	ciphertext := texts[1]
-	if sig {
-		sigText = texts[2]
+		return nil, err
 	}
 
-	switch EncType(typ) {
+	var dk crypto.Key
+	switch envelope.Type {
+	case AesCbc256B64:
+		dk, err = requireKeys("aes key", ak)
 	case AesCbc256HmacSha256B64:
-		if hk == nil || ak == nil {
-			return nil, errors.New("not found aes key or hmac key")
-		}
-
-		sigBytes, err := base64.StdEncoding.DecodeString(sigText)
-		if err != nil {
-			return nil, fmt.Errorf("base64 decode sig error: %v", err)
-		}
-		if !hk.Verify([]byte(ciphertext), sigBytes) {
-			return nil, fmt.Errorf("hmac verify error: %v", err)
-		}
+		dk, err = requireKeys("aes key or hmac key", ak, hk)
+	case Rsa2048OaepSha256B64:
+		dk, err = requireKeys("rsa key", rk)
+	case Rsa2048OaepSha256HmacShaB64:
+		dk, err = requireKeys("rsa key or hmac key", rk, hk)
+	case Sm4Cbc128HmacSm3B64:
+		dk, err = requireKeys("sm4 key or hmac key", sk, hk)
+	case AesGcm256B64:
+		dk, err = requireKeys("aes-gcm key", gk)
+	case XChaCha20Poly1305B64:
+		dk, err = requireKeys("xchacha20-poly1305 key", ck)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		ciphertextBytes, err := base64.StdEncoding.DecodeString(ciphertext)
-		if err != nil {
-			return nil, fmt.Errorf("base64 decode ciphertext error: %v", err)
-		}
-		dataBytes, err := ak.Decrypt(ciphertextBytes)
-		if err != nil {
-			return nil, err
-		}
+	if envelope.Type.HasMac() && !hk.Verify([]byte(envelope.EncodedCiphertext()), envelope.Mac) {
+		return nil, errors.New("hmac verify error")
+	}
 
-		return dataBytes, nil
-	case Rsa2048OaepSha256HmacShaB64:
-		if hk == nil || rk == nil {
-			return nil, errors.New("not found aes key or hmac key")
-		}
-		sigBytes, err := base64.StdEncoding.DecodeString(sigText)
-		if err != nil {
-			return nil, fmt.Errorf("base64 decode sig error: %v", err)
-		}
-		if !hk.Verify([]byte(ciphertext), sigBytes) {
-			return nil, fmt.Errorf("hmac verify error: %v", err)
-		}
+	if aeadKey, ok := dk.(crypto.AEADKey); ok {
+		return aeadKey.DecryptWithAssociatedData(envelope.Ciphertext, ad)
+	}
+	if len(ad) != 0 {
+		return nil, fmt.Errorf("encryption type %v does not support associated data", envelope.Type)
+	}
+	return dk.Decrypt(envelope.Ciphertext)
+}
 
-		ciphertextBytes, err := base64.StdEncoding.DecodeString(ciphertext)
-		if err != nil {
-			return nil, fmt.Errorf("base64 decode ciphertext error: %v", err)
-		}
-		dataBytes, err := rk.Decrypt(ciphertextBytes)
-		if err != nil {
-			return nil, err
+// requireKeys 返回第一个密钥作为加解密使用的密钥，任意一个密钥不存在时返回错误
+func requireKeys(name string, keys ...crypto.Key) (crypto.Key, error) {
+	for _, key := range keys {
+		if key == nil {
+			return nil, fmt.Errorf("not found %s", name)
 		}
-
-		return dataBytes, nil
 	}
 
-	return nil, nil
+	return keys[0], nil
 }
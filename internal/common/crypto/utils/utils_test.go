@@ -9,12 +9,16 @@
 package utils
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/yakumioto/alkaid/internal/common/crypto/rsa"
+	"github.com/yakumioto/alkaid/internal/common/crypto/sm4"
 
 	"github.com/yakumioto/alkaid/internal/common/crypto"
 	"github.com/yakumioto/alkaid/internal/common/crypto/aes"
+	"github.com/yakumioto/alkaid/internal/common/crypto/chacha20poly1305"
 	"github.com/yakumioto/alkaid/internal/common/crypto/hmac"
 )
 
@@ -40,3 +44,102 @@ func TestEncrypt(t *testing.T) {
 	}
 	t.Log(string(data))
 }
+
+func TestEncryptSm4(t *testing.T) {
+	sKey, _ := sm4.NewKey("test password", &crypto.SM4KeyImportOpts{})
+	hKey, _ := hmac.NewKey("test password", &crypto.HMACSm3ImportOpts{})
+	otherKey, _ := hmac.NewKey("other password", &crypto.HMACSm3ImportOpts{})
+
+	ciphertext, err := Encrypt(Sm4Cbc128HmacSm3B64, []byte("hello word"), sKey, hKey)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(ciphertext, "4."))
+
+	data, err := Decrypt(ciphertext, sKey, hKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello word", string(data))
+
+	_, err = Decrypt(ciphertext, sKey, otherKey)
+	assert.Error(t, err)
+
+	_, err = Encrypt(Sm4Cbc128HmacSm3B64, []byte("hello word"), hKey)
+	assert.Error(t, err)
+}
+
+func TestEncryptWithAssociatedData(t *testing.T) {
+	gKey, _ := aes.NewKey("test password", &crypto.AESGCM256KeyImportOpts{})
+	cKey, _ := chacha20poly1305.NewKey("test password", &crypto.XChaCha20Poly1305KeyImportOpts{})
+	aKey, _ := aes.NewKey("test password", &crypto.AES256KeyImportOpts{})
+	rPrivKey, _ := rsa.KeyGen(&crypto.RSA2048KeyImportOpts{})
+	rPubKey, _ := rPrivKey.PublicKey()
+
+	tcs := []struct {
+		name   string
+		typ    EncType
+		encKey crypto.Key
+		decKey crypto.Key
+	}{
+		{"aes-gcm", AesGcm256B64, gKey, gKey},
+		{"xchacha20-poly1305", XChaCha20Poly1305B64, cKey, cKey},
+		{"rsa-oaep", Rsa2048OaepSha256B64, rPubKey, rPrivKey},
+	}
+
+	for _, tc := range tcs {
+		ciphertext, err := EncryptWithAssociatedData(tc.typ, []byte("hello word"), []byte("alice/field"), tc.encKey)
+		assert.NoError(t, err, tc.name)
+
+		data, err := DecryptWithAssociatedData(ciphertext, []byte("alice/field"), tc.decKey)
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, "hello word", string(data), tc.name)
+
+		// 附加数据不同时无法解密
+		_, err = DecryptWithAssociatedData(ciphertext, []byte("bob/field"), tc.decKey)
+		assert.Error(t, err, tc.name)
+	}
+
+	// 不支持附加数据的类型返回错误，而不是忽略附加数据
+	_, err := EncryptWithAssociatedData(AesCbc256B64, []byte("hello word"), []byte("alice/field"), aKey)
+	assert.Error(t, err)
+	ciphertext, err := Encrypt(AesCbc256B64, []byte("hello word"), aKey)
+	assert.NoError(t, err)
+	_, err = DecryptWithAssociatedData(ciphertext, []byte("alice/field"), aKey)
+	assert.Error(t, err)
+
+	// 缺少密钥或者 MAC 时返回错误而不是 panic
+	_, err = Decrypt("1.aGVsbG8=.c2ln", aKey)
+	assert.Error(t, err)
+	_, err = Decrypt("5.aGVsbG8=", aKey)
+	assert.Error(t, err)
+	_, err = Encrypt(EncType(100), []byte("hello word"), aKey)
+	assert.Error(t, err)
+}
+
+func TestDecryptWithStretchedKey(t *testing.T) {
+	key, err := GenSymmetricKey()
+	assert.NoError(t, err)
+	aesKey, _ := aes.NewKey(string(key.Enc), &crypto.AES256KeyImportOpts{})
+	hKey, _ := hmac.NewKey(string(key.Mac), &crypto.HMACSha256ImportOpts{})
+
+	ciphertext, err := EncryptWithStretchedKey(key, []byte("hello word"), []byte("user1/field"))
+	assert.NoError(t, err)
+	data, err := DecryptWithStretchedKey(key, ciphertext, []byte("user1/field"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello word", string(data))
+
+	// 旧格式只能通过 DecryptLegacyWithStretchedKey 解密，没有 MAC 的格式始终被拒绝
+	legacy, err := Encrypt(AesCbc256HmacSha256B64, []byte("hello word"), aesKey, hKey)
+	assert.NoError(t, err)
+	unauthenticated, err := Encrypt(AesCbc256B64, []byte("hello word"), aesKey)
+	assert.NoError(t, err)
+
+	_, err = DecryptWithStretchedKey(key, legacy, []byte("user1/field"))
+	assert.Error(t, err)
+	_, err = DecryptWithStretchedKey(key, unauthenticated, nil)
+	assert.Error(t, err)
+	data, err = DecryptLegacyWithStretchedKey(key, legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello word", string(data))
+	_, err = DecryptLegacyWithStretchedKey(key, unauthenticated)
+	assert.Error(t, err)
+	_, err = DecryptLegacyWithStretchedKey(key, ciphertext)
+	assert.Error(t, err)
+}
@@ -0,0 +1,627 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package memory
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// expr 查询条件的语法树，支持 AND、OR、NOT、括号，以及 =、<>、!=、>、>=、<、<=、LIKE [ESCAPE]、IN、IS NULL
+type expr interface {
+	eval(r row) bool
+}
+
+type andExpr struct{ left, right expr }
+
+func (e *andExpr) eval(r row) bool { return e.left.eval(r) && e.right.eval(r) }
+
+type orExpr struct{ left, right expr }
+
+func (e *orExpr) eval(r row) bool { return e.left.eval(r) || e.right.eval(r) }
+
+type notExpr struct{ expr expr }
+
+func (e *notExpr) eval(r row) bool { return !e.expr.eval(r) }
+
+type trueExpr struct{}
+
+func (trueExpr) eval(row) bool { return true }
+
+// operand 比较运算的操作数，column 不为空时取行中对应列的值
+type operand struct {
+	column string
+	value  interface{}
+}
+
+func (o *operand) get(r row) interface{} {
+	if o.column != "" {
+		return r[o.column]
+	}
+	return o.value
+}
+
+type compareExpr struct {
+	op          string
+	left, right *operand
+	escape      rune // LIKE 的转义字符，为 0 时没有转义字符
+}
+
+func (e *compareExpr) eval(r row) bool {
+	left, right := e.left.get(r), e.right.get(r)
+	if left == nil || right == nil {
+		return false
+	}
+
+	if e.op == "LIKE" {
+		l, lok := left.(string)
+		pattern, rok := right.(string)
+		return lok && rok && likePattern(pattern, e.escape).MatchString(l)
+	}
+
+	c, ok := compare(left, right)
+	if !ok {
+		return false
+	}
+
+	switch e.op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+
+	return false
+}
+
+type inExpr struct {
+	left   *operand
+	values []*operand
+}
+
+func (e *inExpr) eval(r row) bool {
+	left := e.left.get(r)
+	if left == nil {
+		return false
+	}
+
+	for _, value := range e.values {
+		if c, ok := compare(left, value.get(r)); ok && c == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+type isNullExpr struct {
+	left *operand
+}
+
+func (e *isNullExpr) eval(r row) bool {
+	return e.left.get(r) == nil
+}
+
+var likeCache sync.Map
+
+// likePattern 将 LIKE 的模式转换为正则表达式，与 sqlite 一致不区分大小写，
+// escape 之后的字符按照字面值匹配
+func likePattern(pattern string, escape rune) *regexp.Regexp {
+	key := string(escape) + "\x00" + pattern
+	if re, ok := likeCache.Load(key); ok {
+		return re.(*regexp.Regexp)
+	}
+
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case escape != 0 && r == escape:
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+
+	re := regexp.MustCompile(b.String())
+	likeCache.Store(key, re)
+	return re
+}
+
+// normalize 将值转换为 int64、float64、string、time.Time 或者 nil，便于比较
+func normalize(v interface{}) interface{} {
+	if valuer, ok := v.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil {
+			return nil
+		}
+		v = value
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		return v
+	case []byte:
+		return string(v)
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			return int64(1)
+		}
+		return int64(0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	}
+
+	return rv.Interface()
+}
+
+// compare 比较两个值，类型不同时尝试将字符串转换为数字，与 sqlite 的类型亲和性一致
+func compare(a, b interface{}) (int, bool) {
+	a, b = normalize(a), normalize(b)
+	if a == nil || b == nil {
+		return 0, false
+	}
+
+	if s, ok := a.(string); ok {
+		if _, isString := b.(string); !isString {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				a = f
+			}
+		}
+	}
+	if s, ok := b.(string); ok {
+		if _, isString := a.(string); !isString {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				b = f
+			}
+		}
+	}
+
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return compareOrdered(a < b, a > b), true
+		case float64:
+			return compareOrdered(float64(a) < b, float64(a) > b), true
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return compareOrdered(a < float64(b), a > float64(b)), true
+		case float64:
+			return compareOrdered(a < b, a > b), true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			return compareOrdered(a.Before(b), a.After(b)), true
+		}
+	}
+
+	return 0, false
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+type token struct {
+	kind  string // ident, keyword, string, number, op, ?, (, ), ,
+	value string
+}
+
+var keywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "LIKE": true, "IN": true,
+	"IS": true, "NULL": true, "TRUE": true, "FALSE": true, "ESCAPE": true,
+}
+
+func tokenize(query string) ([]*token, error) {
+	tokens := make([]*token, 0)
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '?' || r == '(' || r == ')' || r == ',':
+			tokens = append(tokens, &token{kind: string(r)})
+			i++
+		case r == '\'':
+			var b strings.Builder
+			i++
+			for ; i < len(runes); i++ {
+				if runes[i] == '\'' {
+					if i+1 < len(runes) && runes[i+1] == '\'' {
+						b.WriteRune('\'')
+						i++
+						continue
+					}
+					break
+				}
+				b.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string in %q", query)
+			}
+			tokens = append(tokens, &token{kind: "string", value: b.String()})
+			i++
+		case strings.ContainsRune("=<>!", r):
+			j := i + 1
+			if j < len(runes) && (runes[j] == '=' || r == '<' && runes[j] == '>') {
+				j++
+			}
+			op := string(runes[i:j])
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected ! in %q", query)
+			}
+			if op == "!=" {
+				op = "<>"
+			}
+			tokens = append(tokens, &token{kind: "op", value: op})
+			i = j
+		case unicode.IsDigit(r) || r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, &token{kind: "number", value: string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_' || r == '`' || r == '"':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) ||
+				strings.ContainsRune("_.`\"", runes[j])) {
+				j++
+			}
+			word := string(runes[i:j])
+			if upper := strings.ToUpper(word); keywords[upper] {
+				tokens = append(tokens, &token{kind: "keyword", value: upper})
+			} else {
+				tokens = append(tokens, &token{kind: "ident", value: columnName(word)})
+			}
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q in %q", r, query)
+		}
+	}
+
+	return tokens, nil
+}
+
+// columnName 去掉标识符的引号以及表名前缀
+func columnName(ident string) string {
+	ident = strings.NewReplacer("`", "", `"`, "").Replace(ident)
+	if i := strings.LastIndex(ident, "."); i >= 0 {
+		ident = ident[i+1:]
+	}
+	return ident
+}
+
+type parser struct {
+	query  string
+	tokens []*token
+	pos    int
+	args   []interface{}
+	argPos int
+}
+
+// parseExpr 解析 SQL 查询条件，? 按照顺序替换为 args
+func parseExpr(query string, args []interface{}) (expr, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{query: query, tokens: tokens, args: args}
+	if len(tokens) == 0 {
+		return trueExpr{}, nil
+	}
+
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, p.errorf("unexpected token")
+	}
+	if p.argPos != len(p.args) {
+		return nil, fmt.Errorf("%d arguments given but %d placeholders in %q", len(p.args), p.argPos, query)
+	}
+
+	return e, nil
+}
+
+func (p *parser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("%v at token %d in %q", fmt.Sprintf(format, a...), p.pos, p.query)
+}
+
+func (p *parser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return &token{}
+}
+
+func (p *parser) next() *token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) keyword(value string) bool {
+	if t := p.peek(); t.kind == "keyword" && t.value == value {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orExpr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andExpr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.keyword("NOT") {
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{e}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	if p.peek().kind == "(" {
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != ")" {
+			return nil, p.errorf("missing )")
+		}
+		return e, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	not := p.keyword("NOT")
+	var e expr
+	switch t := p.next(); {
+	case t.kind == "op" && !not:
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		e = &compareExpr{op: t.value, left: left, right: right}
+	case t.kind == "keyword" && t.value == "LIKE":
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		like := &compareExpr{op: "LIKE", left: left, right: right}
+		if p.keyword("ESCAPE") {
+			if like.escape, err = p.parseEscape(); err != nil {
+				return nil, err
+			}
+		}
+		e = like
+	case t.kind == "keyword" && t.value == "IN":
+		values, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		e = &inExpr{left: left, values: values}
+	case t.kind == "keyword" && t.value == "IS" && !not:
+		isNot := p.keyword("NOT")
+		if !p.keyword("NULL") {
+			return nil, p.errorf("expected NULL")
+		}
+		e = &isNullExpr{left: left}
+		if isNot {
+			e = &notExpr{e}
+		}
+	default:
+		return nil, p.errorf("expected operator")
+	}
+
+	if not {
+		e = &notExpr{e}
+	}
+	return e, nil
+}
+
+// parseEscape 解析 ESCAPE 之后的单个字符，可以是字符串或者参数
+func (p *parser) parseEscape() (rune, error) {
+	o, err := p.parseOperand()
+	if err != nil {
+		return 0, err
+	}
+
+	s, ok := o.value.(string)
+	if runes := []rune(s); o.column == "" && ok && len(runes) == 1 {
+		return runes[0], nil
+	}
+
+	return 0, p.errorf("ESCAPE expects a single character")
+}
+
+// parseList 解析 IN 的参数，支持 (?, ?) 以及参数为切片的 (?) 或 ?
+func (p *parser) parseList() ([]*operand, error) {
+	values := make([]*operand, 0)
+	parenthesized := p.peek().kind == "("
+	if parenthesized {
+		p.next()
+	}
+
+	for {
+		value, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, expand(value)...)
+
+		if !parenthesized || p.peek().kind != "," {
+			break
+		}
+		p.next()
+	}
+
+	if parenthesized && p.next().kind != ")" {
+		return nil, p.errorf("missing )")
+	}
+
+	return values, nil
+}
+
+// expand 将切片类型的参数展开为多个操作数
+func expand(o *operand) []*operand {
+	if o.column != "" || o.value == nil {
+		return []*operand{o}
+	}
+	if _, ok := o.value.([]byte); ok {
+		return []*operand{o}
+	}
+
+	rv := reflect.ValueOf(o.value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []*operand{o}
+	}
+
+	values := make([]*operand, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		values = append(values, &operand{value: rv.Index(i).Interface()})
+	}
+	return values
+}
+
+func (p *parser) parseOperand() (*operand, error) {
+	t := p.next()
+	switch t.kind {
+	case "ident":
+		return &operand{column: t.value}, nil
+	case "?":
+		if p.argPos >= len(p.args) {
+			return nil, p.errorf("not enough arguments")
+		}
+		arg := p.args[p.argPos]
+		p.argPos++
+		return &operand{value: arg}, nil
+	case "string":
+		return &operand{value: t.value}, nil
+	case "number":
+		if i, err := strconv.ParseInt(t.value, 10, 64); err == nil {
+			return &operand{value: i}, nil
+		}
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", t.value)
+		}
+		return &operand{value: f}, nil
+	case "keyword":
+		switch t.value {
+		case "TRUE":
+			return &operand{value: true}, nil
+		case "FALSE":
+			return &operand{value: false}, nil
+		case "NULL":
+			return &operand{}, nil
+		}
+	}
+
+	return nil, p.errorf("unexpected %v %q", t.kind, t.value)
+}
@@ -0,0 +1,1040 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+// Package memory 纯内存的 storage.Storage 实现，用于单元测试以及演示环境，进程退出后数据丢失。
+//
+// 表结构通过 gorm 的 schema 解析，与 orm 包保持相同的表名、列名、主键、唯一索引以及自动时间戳规则；
+// 查询条件支持 orm 包中常用的 SQL 子集，见 parseExpr。
+// 每次写入都在表的副本上执行后再替换，事务提交时在父级上按顺序重放事务中的写入，
+// 因此嵌套事务的行为与 savepoint 一致。
+package memory
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"gorm.io/gorm/schema"
+)
+
+const Driver = "memory"
+
+var (
+	ErrTxDone         = errors.New("memory: transaction has already been committed or rolled back")
+	ErrNotTransaction = errors.New("memory: not in a transaction")
+	ErrMissingWhere   = errors.New("memory: WHERE conditions required")
+)
+
+type row map[string]interface{}
+
+type index struct {
+	unique  bool
+	columns []string
+}
+
+type table struct {
+	columns     []string
+	primaryKeys []string
+	indexes     map[string]*index
+	rows        []row
+}
+
+func (t *table) clone() *table {
+	c := &table{
+		columns:     append([]string(nil), t.columns...),
+		primaryKeys: append([]string(nil), t.primaryKeys...),
+		indexes:     make(map[string]*index, len(t.indexes)),
+		rows:        make([]row, 0, len(t.rows)),
+	}
+	for name, idx := range t.indexes {
+		c.indexes[name] = &index{unique: idx.unique, columns: append([]string(nil), idx.columns...)}
+	}
+	for _, r := range t.rows {
+		c.rows = append(c.rows, r.clone())
+	}
+	return c
+}
+
+func (t *table) hasColumn(column string) bool {
+	for _, c := range t.columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUnique 校验主键以及唯一索引，NULL 不参与唯一性校验
+func (t *table) checkUnique(name string) error {
+	constraints := make([][]string, 0, len(t.indexes)+1)
+	if len(t.primaryKeys) != 0 {
+		constraints = append(constraints, t.primaryKeys)
+	}
+	for _, idx := range t.indexes {
+		if idx.unique {
+			constraints = append(constraints, idx.columns)
+		}
+	}
+
+	for _, columns := range constraints {
+		seen := make(map[string]bool, len(t.rows))
+		for _, r := range t.rows {
+			key, ok := r.key(columns)
+			if !ok {
+				continue
+			}
+			if seen[key] {
+				return fmt.Errorf("memory: UNIQUE constraint failed: %v.%v", name, strings.Join(columns, ", "))
+			}
+			seen[key] = true
+		}
+	}
+
+	return nil
+}
+
+func (r row) clone() row {
+	c := make(row, len(r))
+	for k, v := range r {
+		c[k] = copyValue(v)
+	}
+	return c
+}
+
+func (r row) key(columns []string) (string, bool) {
+	parts := make([]string, 0, len(columns))
+	for _, column := range columns {
+		v := normalize(r[column])
+		if v == nil {
+			return "", false
+		}
+		parts = append(parts, fmt.Sprintf("%T:%v", v, v))
+	}
+	return strings.Join(parts, "\x00"), true
+}
+
+// state 某一时刻的全部数据，发布后不再修改
+type state struct {
+	tables map[string]*table
+	owned  map[string]bool
+}
+
+func (st *state) clone() *state {
+	c := &state{tables: make(map[string]*table, len(st.tables)), owned: make(map[string]bool)}
+	for name, t := range st.tables {
+		c.tables[name] = t
+	}
+	return c
+}
+
+// table 返回可以修改的表，同一次写入中只复制一次
+func (st *state) table(name string) (*table, error) {
+	t, ok := st.tables[name]
+	if !ok {
+		return nil, fmt.Errorf("memory: no such table: %v", name)
+	}
+	if !st.owned[name] {
+		t = t.clone()
+		st.tables[name] = t
+		st.owned[name] = true
+	}
+	return t, nil
+}
+
+type op func(st *state) error
+
+type DB struct {
+	mu     sync.RWMutex
+	state  *state
+	parent *DB
+	ops    []op
+	done   bool
+	cache  *sync.Map
+}
+
+func NewDB() *DB {
+	return &DB{
+		state: &state{tables: make(map[string]*table)},
+		cache: new(sync.Map),
+	}
+}
+
+func (s *DB) snapshot() (*state, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.done {
+		return nil, ErrTxDone
+	}
+	return s.state, nil
+}
+
+// write 在当前数据的副本上执行 o，成功后替换当前数据，失败时数据不变
+func (s *DB) write(o op) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		return ErrTxDone
+	}
+
+	next := s.state.clone()
+	if err := o(next); err != nil {
+		return err
+	}
+	s.state = next
+
+	if s.parent != nil {
+		s.ops = append(s.ops, o)
+	}
+	return nil
+}
+
+func (s *DB) parse(value interface{}) (*schema.Schema, error) {
+	if value == nil {
+		return nil, fmt.Errorf("memory: unsupported nil model")
+	}
+	return schema.Parse(value, s.cache, schema.NamingStrategy{})
+}
+
+func (s *DB) AutoMigrate(dst ...interface{}) error {
+	return s.Migrator().AutoMigrate(dst...)
+}
+
+func (s *DB) Create(value interface{}) error {
+	sch, err := s.parse(value)
+	if err != nil {
+		return err
+	}
+
+	values := reflect.Indirect(reflect.ValueOf(value))
+	elems := []reflect.Value{values}
+	if values.Kind() == reflect.Slice || values.Kind() == reflect.Array {
+		elems = make([]reflect.Value, 0, values.Len())
+		for i := 0; i < values.Len(); i++ {
+			elems = append(elems, reflect.Indirect(values.Index(i)))
+		}
+	}
+
+	now := time.Now()
+	rows := make([]row, 0, len(elems))
+	for _, elem := range elems {
+		r := make(row)
+		for _, field := range sch.Fields {
+			if field.DBName == "" || !field.Creatable {
+				continue
+			}
+
+			v, zero := field.ValueOf(elem)
+			if zero && (field.AutoCreateTime != 0 || field.AutoUpdateTime != 0) {
+				if err = field.Set(elem, timestamp(field, now)); err != nil {
+					return err
+				}
+				v, _ = field.ValueOf(elem)
+			} else if zero && field.DefaultValueInterface != nil {
+				v = field.DefaultValueInterface
+			}
+			r[field.DBName] = storeValue(v)
+		}
+		rows = append(rows, r)
+	}
+
+	var autoIncrement *schema.Field
+	if pk := sch.PrioritizedPrimaryField; pk != nil && pk.AutoIncrement {
+		autoIncrement = pk
+	}
+
+	return s.write(func(st *state) error {
+		t, err := st.table(sch.Table)
+		if err != nil {
+			return err
+		}
+
+		for i, r := range rows {
+			r = r.clone()
+			if autoIncrement != nil {
+				if v, _ := normalize(r[autoIncrement.DBName]).(int64); v == 0 {
+					id := nextID(t, autoIncrement.DBName)
+					r[autoIncrement.DBName] = id
+					if err = autoIncrement.Set(elems[i], id); err != nil {
+						return err
+					}
+				}
+			}
+			t.rows = append(t.rows, r)
+		}
+
+		return t.checkUnique(sch.Table)
+	})
+}
+
+func nextID(t *table, column string) int64 {
+	var max int64
+	for _, r := range t.rows {
+		if v, ok := normalize(r[column]).(int64); ok && v > max {
+			max = v
+		}
+	}
+	return max + 1
+}
+
+// Update 与 gorm 的 Updates 一致：values 为结构体时只更新非零值字段且不更新主键，主键非零时同时作为条件
+func (s *DB) Update(values interface{}, options *storage.UpdateOptions) error {
+	if options == nil {
+		return storage.ErrNeedUpdateOptions
+	}
+
+	sch, err := s.parse(values)
+	if err != nil {
+		return err
+	}
+
+	where, err := compileCondition(s, sch, options.Query, options.Args)
+	if err != nil {
+		return err
+	}
+
+	column, value, precondition := options.GetPrecondition()
+	if precondition {
+		where = &andExpr{where, &compareExpr{op: "=", left: &operand{column: columnName(column)}, right: &operand{value: value}}}
+	}
+
+	restore := func() {}
+	if _, next, ok := options.GetVersion(); ok {
+		if restore, err = setVersion(sch, values, column, next); err != nil {
+			return err
+		}
+	}
+
+	set := make(row)
+	elem := reflect.Indirect(reflect.ValueOf(values))
+	if m, ok := values.(map[string]interface{}); ok {
+		for name, v := range m {
+			column := name
+			if field := sch.LookUpField(name); field != nil {
+				column = field.DBName
+			}
+			set[column] = storeValue(v)
+		}
+	} else {
+		// 与 orm 一致，指定 Select 时只更新指定的列以及版本列，零值同样会被更新
+		selects := make(map[string]bool)
+		for _, name := range options.GetSelect() {
+			if field := sch.LookUpField(name); field != nil {
+				selects[field.DBName] = true
+			}
+		}
+		if _, _, ok := options.GetVersion(); ok && len(selects) != 0 {
+			selects[columnName(column)] = true
+		}
+
+		now := time.Now()
+		for _, field := range sch.Fields {
+			if field.DBName == "" || !field.Updatable {
+				continue
+			}
+
+			v, zero := field.ValueOf(elem)
+			if field.PrimaryKey {
+				if !zero {
+					where = &andExpr{where, &compareExpr{op: "=", left: &operand{column: field.DBName}, right: &operand{value: v}}}
+				}
+				continue
+			}
+			if field.AutoUpdateTime != 0 {
+				if err = field.Set(elem, timestamp(field, now)); err != nil {
+					return err
+				}
+				v, zero = field.ValueOf(elem)
+			}
+			switch {
+			case len(selects) == 0 && !zero, selects[field.DBName], field.AutoUpdateTime != 0:
+				set[field.DBName] = storeValue(v)
+			}
+		}
+	}
+
+	if len(set) == 0 && !precondition {
+		return nil
+	}
+
+	err = s.write(func(st *state) error {
+		t, err := st.table(sch.Table)
+		if err != nil {
+			return err
+		}
+
+		for column := range set {
+			if !t.hasColumn(column) {
+				return fmt.Errorf("memory: no such column: %v", column)
+			}
+		}
+
+		affected := 0
+		for _, r := range t.rows {
+			if !where.eval(r) {
+				continue
+			}
+			affected++
+			for column, v := range set {
+				r[column] = copyValue(v)
+			}
+		}
+
+		// 事务提交时重新执行，其间记录被修改同样返回冲突
+		if precondition && affected == 0 {
+			return storage.ErrConflict
+		}
+
+		return t.checkUnique(sch.Table)
+	})
+	if err != nil {
+		restore()
+	}
+
+	return err
+}
+
+// setVersion 与 orm 包一致，将新的版本写入 values，返回还原原有版本的函数
+func setVersion(sch *schema.Schema, values interface{}, column string, next int64) (func(), error) {
+	field := sch.LookUpField(column)
+	if field == nil {
+		return nil, fmt.Errorf("memory: no such column: %v", column)
+	}
+
+	elem := reflect.ValueOf(values)
+	old, _ := field.ValueOf(elem)
+	if err := field.Set(elem, next); err != nil {
+		return nil, err
+	}
+
+	return func() { _ = field.Set(elem, old) }, nil
+}
+
+// FindByID 与 orm 包一致，conditions 作为主键的取值列表，按照主键排序返回第一条记录
+func (s *DB) FindByID(dest interface{}, conditions ...interface{}) error {
+	return s.findByID(dest, conditions, false)
+}
+
+func (s *DB) FindUnscopedByID(dest interface{}, conditions ...interface{}) error {
+	return s.findByID(dest, conditions, true)
+}
+
+// findByID dest 支持软删除且 unscoped 为 false 时排除已删除的记录
+func (s *DB) findByID(dest interface{}, conditions []interface{}, unscoped bool) error {
+	sch, err := s.parse(dest)
+	if err != nil {
+		return err
+	}
+
+	where, err := primaryKeyCondition(sch, conditions)
+	if err != nil {
+		return err
+	}
+	if deleted, _, ok := storage.SoftDeleteColumns(reflect.New(sch.ModelType).Interface()); ok && !unscoped {
+		where = &andExpr{where, &compareExpr{op: "=", left: &operand{column: deleted}, right: &operand{value: false}}}
+	}
+
+	st, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+	t, ok := st.tables[sch.Table]
+	if !ok {
+		return fmt.Errorf("memory: no such table: %v", sch.Table)
+	}
+
+	rows := filter(t.rows, where)
+	if len(rows) == 0 {
+		return storage.ErrNotFound
+	}
+
+	orders := make([]order, 0, len(t.primaryKeys))
+	for _, pk := range t.primaryKeys {
+		orders = append(orders, order{column: pk})
+	}
+	sortRows(rows, orders)
+
+	return scan(sch, dest, rows[:1])
+}
+
+func (s *DB) FindByQuery(dest interface{}, options *storage.QueryOptions) error {
+	if options == nil {
+		options = storage.NewQueryOptions()
+	}
+
+	sch, err := s.parse(dest)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.query(sch, options)
+	if err != nil {
+		return err
+	}
+
+	orders, err := parseOrder(options.GetOrder())
+	if err != nil {
+		return err
+	}
+	sortRows(rows, orders)
+
+	if offset := options.GetOffset(); offset > 0 {
+		if offset > len(rows) {
+			offset = len(rows)
+		}
+		rows = rows[offset:]
+	}
+	if limit := options.GetLimit(); limit >= 0 && limit < len(rows) {
+		rows = rows[:limit]
+	}
+
+	if err = scan(sch, dest, rows); err != nil {
+		return err
+	}
+
+	// 与 orm 一致，查询列表时没有记录返回空列表，只有查询单条记录时才返回 ErrNotFound
+	if len(rows) == 0 && reflect.Indirect(reflect.ValueOf(dest)).Kind() != reflect.Slice {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *DB) Count(model interface{}, options *storage.QueryOptions) (int64, error) {
+	if options == nil {
+		options = storage.NewQueryOptions()
+	}
+
+	sch, err := s.parse(model)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := s.query(sch, options)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(rows)), nil
+}
+
+// query 返回满足 options 中查询条件的记录，与 gorm 一致：各个 Where 之间为 AND，Or 开始新的 OR 分组，Not 为 AND NOT，
+// 模型支持软删除时再排除已删除的记录
+func (s *DB) query(sch *schema.Schema, options *storage.QueryOptions) ([]row, error) {
+	groups := make([][]expr, 0)
+	group := make([]expr, 0)
+
+	for _, where := range options.GetWheres() {
+		e, err := compileCondition(s, sch, where.Query, where.Args)
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, e)
+	}
+
+	for _, or := range options.GetOrs() {
+		e, err := compileCondition(s, sch, or.Query, or.Args)
+		if err != nil {
+			return nil, err
+		}
+		if len(group) != 0 {
+			groups = append(groups, group)
+		}
+		group = []expr{e}
+	}
+
+	if not := options.GetNot(); not != nil {
+		e, err := compileCondition(s, sch, not.Query, not.Args)
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, &notExpr{e})
+	}
+	groups = append(groups, group)
+
+	var where expr
+	for _, group := range groups {
+		var e expr = trueExpr{}
+		for i, item := range group {
+			if i == 0 {
+				e = item
+				continue
+			}
+			e = &andExpr{e, item}
+		}
+		if where == nil {
+			where = e
+			continue
+		}
+		where = &orExpr{where, e}
+	}
+
+	if deleted, _, ok := storage.SoftDeleteColumns(reflect.New(sch.ModelType).Interface()); ok && !options.IsUnscoped() {
+		where = &andExpr{where, &compareExpr{op: "=", left: &operand{column: deleted}, right: &operand{value: false}}}
+	}
+
+	st, err := s.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := st.tables[sch.Table]
+	if !ok {
+		return nil, fmt.Errorf("memory: no such table: %v", sch.Table)
+	}
+
+	return filter(t.rows, where), nil
+}
+
+// Delete 与 orm 包一致，conditions 作为主键的取值列表，conditions 为空时使用 value 中的主键
+func (s *DB) Delete(value interface{}, conditions ...interface{}) error {
+	if deleted, deletedAt, ok := storage.SoftDeleteColumns(value); ok {
+		return s.softDelete(value, conditions, row{deleted: true, deletedAt: time.Now().Unix()})
+	}
+
+	sch, where, err := s.primaryKeyWhere(value, conditions)
+	if err != nil {
+		return err
+	}
+
+	return s.write(func(st *state) error {
+		t, err := st.table(sch.Table)
+		if err != nil {
+			return err
+		}
+
+		rows := make([]row, 0, len(t.rows))
+		for _, r := range t.rows {
+			if !where.eval(r) {
+				rows = append(rows, r)
+			}
+		}
+		t.rows = rows
+
+		return nil
+	})
+}
+
+func (s *DB) Restore(value interface{}, conditions ...interface{}) error {
+	deleted, deletedAt, ok := storage.SoftDeleteColumns(value)
+	if !ok {
+		return storage.ErrNotSoftDeletable
+	}
+
+	return s.softDelete(value, conditions, row{deleted: false, deletedAt: int64(0)})
+}
+
+// softDelete 更新匹配记录的软删除列
+func (s *DB) softDelete(value interface{}, conditions []interface{}, set row) error {
+	sch, where, err := s.primaryKeyWhere(value, conditions)
+	if err != nil {
+		return err
+	}
+
+	return s.write(func(st *state) error {
+		t, err := st.table(sch.Table)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range t.rows {
+			if !where.eval(r) {
+				continue
+			}
+			for column, v := range set {
+				r[column] = v
+			}
+		}
+
+		return nil
+	})
+}
+
+// primaryKeyWhere 返回 Delete 的条件，conditions 为空时使用 value 中非零值的主键，都为空时返回错误
+func (s *DB) primaryKeyWhere(value interface{}, conditions []interface{}) (*schema.Schema, expr, error) {
+	sch, err := s.parse(value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(conditions) != 0 {
+		where, err := primaryKeyCondition(sch, conditions)
+		return sch, where, err
+	}
+
+	var where expr
+	elem := reflect.Indirect(reflect.ValueOf(value))
+	if elem.Kind() == reflect.Struct {
+		for _, field := range sch.PrimaryFields {
+			if v, zero := field.ValueOf(elem); !zero {
+				where = equal(where, field.DBName, v)
+			}
+		}
+	}
+	if where == nil {
+		return nil, nil, ErrMissingWhere
+	}
+
+	return sch, where, nil
+}
+
+func (s *DB) Migrator() storage.Migrator {
+	return &migrator{db: s}
+}
+
+// Begin 开启事务，事务中的写入只对事务可见，Commit 时一次性写入父级
+func (s *DB) Begin() storage.Storage {
+	st, err := s.snapshot()
+	tx := &DB{state: st, parent: s, cache: s.cache}
+	if err != nil {
+		tx.done = true
+	}
+	return tx
+}
+
+func (s *DB) Commit() error {
+	if s.parent == nil {
+		return ErrNotTransaction
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		return ErrTxDone
+	}
+	s.done = true
+
+	ops := s.ops
+	return s.parent.write(func(st *state) error {
+		for _, o := range ops {
+			if err := o(st); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *DB) Rollback() error {
+	if s.parent == nil {
+		return ErrNotTransaction
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		return ErrTxDone
+	}
+	s.done = true
+	s.ops = nil
+
+	return nil
+}
+
+func (s *DB) Transaction(ctx context.Context, fn func(tx storage.Storage) error) (err error) {
+	tx := s.Begin()
+
+	panicked := true
+	defer func() {
+		if panicked || err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	err = fn(tx)
+	panicked = false
+	if err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// compileCondition 将 Where、Or、Not 的参数转换为表达式，支持 SQL 字符串、结构体以及 map
+func compileCondition(s *DB, sch *schema.Schema, query interface{}, args []interface{}) (expr, error) {
+	switch q := query.(type) {
+	case nil:
+		return trueExpr{}, nil
+	case string:
+		e, err := parseExpr(q, args)
+		if err != nil {
+			return nil, fmt.Errorf("memory: %w", err)
+		}
+		return e, nil
+	case map[string]interface{}:
+		var e expr
+		for name, v := range q {
+			column := columnName(name)
+			if field := sch.LookUpField(column); field != nil {
+				column = field.DBName
+			}
+			if v == nil {
+				e = and(e, &isNullExpr{left: &operand{column: column}})
+				continue
+			}
+			e = and(e, &inExpr{left: &operand{column: column}, values: expand(&operand{value: v})})
+		}
+		if e == nil {
+			return trueExpr{}, nil
+		}
+		return e, nil
+	}
+
+	elem := reflect.Indirect(reflect.ValueOf(query))
+	if elem.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("memory: unsupported condition type %T", query)
+	}
+
+	condSchema, err := s.parse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var e expr
+	for _, field := range condSchema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		if v, zero := field.ValueOf(elem); !zero {
+			e = equal(e, field.DBName, v)
+		}
+	}
+	if e == nil {
+		return trueExpr{}, nil
+	}
+	return e, nil
+}
+
+func primaryKeyCondition(sch *schema.Schema, conditions []interface{}) (expr, error) {
+	if len(conditions) == 0 {
+		return trueExpr{}, nil
+	}
+	if sch.PrioritizedPrimaryField == nil {
+		return nil, fmt.Errorf("memory: model %v has no primary key", sch.Name)
+	}
+
+	values := make([]*operand, 0, len(conditions))
+	for _, condition := range conditions {
+		values = append(values, expand(&operand{value: condition})...)
+	}
+
+	return &inExpr{left: &operand{column: sch.PrioritizedPrimaryField.DBName}, values: values}, nil
+}
+
+func and(left, right expr) expr {
+	if left == nil {
+		return right
+	}
+	return &andExpr{left, right}
+}
+
+func equal(left expr, column string, v interface{}) expr {
+	return and(left, &compareExpr{op: "=", left: &operand{column: column}, right: &operand{value: v}})
+}
+
+func filter(rows []row, where expr) []row {
+	result := make([]row, 0)
+	for _, r := range rows {
+		if where.eval(r) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+type order struct {
+	column string
+	desc   bool
+}
+
+// parseOrder 解析 "created_at DESC, resource_id ASC" 形式的排序
+func parseOrder(value interface{}) ([]order, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	str, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("memory: unsupported order type %T", value)
+	}
+
+	orders := make([]order, 0)
+	for _, item := range strings.Split(str, ",") {
+		parts := strings.Fields(item)
+		if len(parts) == 0 {
+			continue
+		}
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("memory: invalid order %q", item)
+		}
+
+		o := order{column: columnName(parts[0])}
+		if len(parts) == 2 {
+			switch strings.ToUpper(parts[1]) {
+			case "ASC":
+			case "DESC":
+				o.desc = true
+			default:
+				return nil, fmt.Errorf("memory: invalid order %q", item)
+			}
+		}
+		orders = append(orders, o)
+	}
+
+	return orders, nil
+}
+
+// sortRows 稳定排序，与 sqlite 一致 NULL 小于任何值
+func sortRows(rows []row, orders []order) {
+	if len(orders) == 0 {
+		return
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, o := range orders {
+			a, b := normalize(rows[i][o.column]), normalize(rows[j][o.column])
+			var c int
+			switch {
+			case a == nil && b == nil:
+				c = 0
+			case a == nil:
+				c = -1
+			case b == nil:
+				c = 1
+			default:
+				c, _ = compare(a, b)
+			}
+			if c == 0 {
+				continue
+			}
+			if o.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// scan 将记录写入 dest，dest 为切片时写入全部记录，否则写入第一条记录
+func scan(sch *schema.Schema, dest interface{}, rows []row) error {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("memory: dest must be a non-nil pointer, got %T", dest)
+	}
+	rv = rv.Elem()
+
+	if rv.Kind() != reflect.Slice {
+		if len(rows) == 0 {
+			return nil
+		}
+		return scanRow(sch, rv, rows[0])
+	}
+
+	elemType := rv.Type().Elem()
+	isPtr := elemType.Kind() == reflect.Ptr
+	if isPtr {
+		elemType = elemType.Elem()
+	}
+
+	slice := reflect.MakeSlice(rv.Type(), 0, len(rows))
+	for _, r := range rows {
+		elem := reflect.New(elemType)
+		if err := scanRow(sch, elem.Elem(), r); err != nil {
+			return err
+		}
+		if isPtr {
+			slice = reflect.Append(slice, elem)
+		} else {
+			slice = reflect.Append(slice, elem.Elem())
+		}
+	}
+	rv.Set(slice)
+
+	return nil
+}
+
+func scanRow(sch *schema.Schema, elem reflect.Value, r row) error {
+	for _, field := range sch.Fields {
+		if field.DBName == "" || !field.Readable {
+			continue
+		}
+		v, ok := r[field.DBName]
+		if !ok {
+			continue
+		}
+		if err := field.Set(elem, copyValue(v)); err != nil {
+			return fmt.Errorf("memory: scan column %v error: %w", field.DBName, err)
+		}
+	}
+	return nil
+}
+
+// storeValue 将字段的值转换为存储的值，实现 driver.Valuer 的类型按照其 Value 存储
+func storeValue(v interface{}) interface{} {
+	if valuer, ok := v.(driver.Valuer); ok {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil
+		}
+		value, err := valuer.Value()
+		if err != nil {
+			return nil
+		}
+		v = value
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		v = rv.Elem().Interface()
+	}
+
+	return copyValue(v)
+}
+
+func copyValue(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok && b != nil {
+		return append([]byte(nil), b...)
+	}
+	return v
+}
+
+func timestamp(field *schema.Field, now time.Time) interface{} {
+	if field.FieldType == reflect.TypeOf(time.Time{}) {
+		return now
+	}
+
+	t := field.AutoCreateTime
+	if t == 0 {
+		t = field.AutoUpdateTime
+	}
+
+	switch t {
+	case schema.UnixNanosecond:
+		return now.UnixNano()
+	case schema.UnixMillisecond:
+		return now.UnixNano() / int64(time.Millisecond)
+	}
+	return now.Unix()
+}
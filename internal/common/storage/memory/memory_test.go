@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package memory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, NewDB())
+}
+
+type testRecord struct {
+	ID        int64  `gorm:"primaryKey"`
+	Email     string `gorm:"uniqueIndex"`
+	Role      int
+	Active    bool
+	Data      []byte
+	CreatedAt int64 `gorm:"autoCreateTime"`
+	UpdatedAt int64 `gorm:"autoUpdateTime"`
+}
+
+func TestDB_Query(t *testing.T) {
+	db := NewDB()
+	assert.NoError(t, db.AutoMigrate(new(testRecord)))
+
+	records := []*testRecord{
+		{Email: "alice@example.com", Role: 1, Active: true, Data: []byte("a")},
+		{Email: "bob@example.com", Role: 2},
+		{Email: "Carol@example.com", Role: 2, Active: true},
+	}
+	for _, record := range records {
+		assert.NoError(t, db.Create(record))
+		assert.NotZero(t, record.CreatedAt)
+	}
+	assert.Equal(t, int64(3), records[2].ID)
+	assert.Error(t, db.Create(&testRecord{Email: "bob@example.com"}))
+
+	tcs := []struct {
+		name     string
+		options  *storage.QueryOptions
+		expected []int64
+		err      bool
+	}{
+		{"like", storage.NewQueryOptions().Where("email LIKE ?", "%carol%"), []int64{3}, false},
+		{"like escape", storage.NewQueryOptions().Where("email LIKE ? ESCAPE '!'", "%!_%").Or("email LIKE ? ESCAPE '!'", "b!o%"),
+			[]int64{2}, false},
+		{"in", storage.NewQueryOptions().Where("id IN ?", []int64{1, 3}), []int64{1, 3}, false},
+		{"in list", storage.NewQueryOptions().Where("id IN (?, ?)", 1, 2), []int64{1, 2}, false},
+		{"bool", storage.NewQueryOptions().Where("active = ?", true), []int64{1, 3}, false},
+		{"map", storage.NewQueryOptions().Where(map[string]interface{}{"role": 2}), []int64{2, 3}, false},
+		{"parentheses", storage.NewQueryOptions().Where("(role = ? OR active = ?) AND NOT id = ?", 2, true, 3), []int64{1, 2}, false},
+		{"or group", storage.NewQueryOptions().Where("role = ?", 2).Where("active = ?", true).Or("id = ?", 1), []int64{1, 3}, false},
+		{"quoted column", storage.NewQueryOptions().Where("`test_records`.`role` >= 2"), []int64{2, 3}, false},
+		{"order", storage.NewQueryOptions().Order("role desc, id asc"), []int64{2, 3, 1}, false},
+		{"limit", storage.NewQueryOptions().Order("id desc").Limit(2), []int64{3, 2}, false},
+		{"invalid", storage.NewQueryOptions().Where("role = = ?", 2), nil, true},
+		{"missing argument", storage.NewQueryOptions().Where("role = ?"), nil, true},
+	}
+
+	for _, tc := range tcs {
+		found := make([]*testRecord, 0)
+		err := db.FindByQuery(&found, tc.options)
+		if tc.err {
+			assert.Error(t, err, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+
+		ids := make([]int64, 0, len(found))
+		for _, record := range found {
+			ids = append(ids, record.ID)
+		}
+		assert.Equal(t, tc.expected, ids, tc.name)
+	}
+
+	// 读取的数据与存储的数据互不影响
+	record := new(testRecord)
+	assert.NoError(t, db.FindByID(record, 1))
+	record.Data[0] = 'x'
+	assert.NoError(t, db.FindByID(record, 1))
+	assert.Equal(t, []byte("a"), record.Data)
+
+	// 违反唯一索引的更新不生效
+	assert.Error(t, db.Update(&testRecord{Email: "alice@example.com"}, storage.NewUpdateOptions("id = ?", 2)))
+	assert.NoError(t, db.FindByID(record, 2))
+	assert.Equal(t, "bob@example.com", record.Email)
+}
+
+func TestDB_Migrator(t *testing.T) {
+	db := NewDB()
+	m := db.Migrator()
+
+	assert.False(t, m.HasTable(new(testRecord)))
+	assert.NoError(t, m.AutoMigrate(new(testRecord)))
+	assert.True(t, m.HasTable("test_records"))
+	assert.True(t, m.HasIndex(new(testRecord), "Email"))
+	assert.NoError(t, db.Create(&testRecord{Email: "alice@example.com"}))
+
+	assert.NoError(t, m.RenameColumn(new(testRecord), "role", "level"))
+	assert.True(t, m.HasColumn(new(testRecord), "level"))
+	assert.NoError(t, m.DropColumn(new(testRecord), "level"))
+	assert.False(t, m.HasColumn(new(testRecord), "level"))
+	assert.NoError(t, m.AddColumn(new(testRecord), "Role"))
+	assert.True(t, m.HasColumn(new(testRecord), "Role"))
+
+	assert.NoError(t, m.DropIndex(new(testRecord), "Email"))
+	assert.NoError(t, db.Create(&testRecord{Email: "alice@example.com"}))
+	assert.Error(t, m.CreateIndex(new(testRecord), "Email"))
+
+	assert.NoError(t, m.DropTable(new(testRecord)))
+	assert.False(t, m.HasTable(new(testRecord)))
+	assert.Error(t, db.Create(&testRecord{}))
+}
+
+func TestDB_Transaction(t *testing.T) {
+	db := NewDB()
+	assert.NoError(t, db.AutoMigrate(new(testRecord)))
+
+	assert.Equal(t, ErrNotTransaction, db.Commit())
+
+	tx := db.Begin()
+	assert.NoError(t, tx.Create(&testRecord{Email: "alice@example.com"}))
+	count, err := db.Count(new(testRecord), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count, "uncommitted writes are invisible")
+
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, ErrTxDone, tx.Commit())
+	assert.Equal(t, ErrTxDone, tx.Create(&testRecord{Email: "bob@example.com"}))
+
+	count, err = db.Count(new(testRecord), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// 提交时违反唯一索引则整个事务不生效
+	tx = db.Begin()
+	assert.NoError(t, tx.Create(&testRecord{Email: "bob@example.com"}))
+	assert.NoError(t, tx.Create(&testRecord{Email: "carol@example.com"}))
+	assert.NoError(t, db.Create(&testRecord{Email: "carol@example.com"}))
+	assert.Error(t, tx.Commit())
+
+	count, err = db.Count(new(testRecord), storage.NewQueryOptions().Where("email = ?", "bob@example.com"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
@@ -0,0 +1,367 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package memory
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm/schema"
+)
+
+// migrator 内存中的表结构变更，与数据写入一样在事务中执行
+type migrator struct {
+	db *DB
+}
+
+func (m *migrator) AutoMigrate(dst ...interface{}) error {
+	schemas := make([]*schema.Schema, 0, len(dst))
+	for _, value := range dst {
+		sch, err := m.db.parse(value)
+		if err != nil {
+			return err
+		}
+		schemas = append(schemas, sch)
+	}
+
+	return m.db.write(func(st *state) error {
+		for _, sch := range schemas {
+			if _, ok := st.tables[sch.Table]; !ok {
+				st.tables[sch.Table] = &table{
+					primaryKeys: append([]string(nil), sch.PrimaryFieldDBNames...),
+					indexes:     make(map[string]*index),
+				}
+				st.owned[sch.Table] = true
+			}
+
+			t, err := st.table(sch.Table)
+			if err != nil {
+				return err
+			}
+
+			for _, field := range sch.Fields {
+				if field.DBName != "" && !t.hasColumn(field.DBName) {
+					t.columns = append(t.columns, field.DBName)
+				}
+			}
+
+			for name, idx := range schemaIndexes(sch) {
+				if _, ok := t.indexes[name]; !ok {
+					t.indexes[name] = idx
+				}
+			}
+
+			if err = t.checkUnique(sch.Table); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (m *migrator) HasTable(dst interface{}) bool {
+	name, err := m.tableName(dst)
+	if err != nil {
+		return false
+	}
+
+	st, err := m.db.snapshot()
+	if err != nil {
+		return false
+	}
+
+	_, ok := st.tables[name]
+	return ok
+}
+
+func (m *migrator) DropTable(dst ...interface{}) error {
+	names := make([]string, 0, len(dst))
+	for _, value := range dst {
+		name, err := m.tableName(value)
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+	}
+
+	return m.db.write(func(st *state) error {
+		for _, name := range names {
+			delete(st.tables, name)
+		}
+		return nil
+	})
+}
+
+func (m *migrator) RenameTable(oldName, newName interface{}) error {
+	from, err := m.tableName(oldName)
+	if err != nil {
+		return err
+	}
+	to, err := m.tableName(newName)
+	if err != nil {
+		return err
+	}
+
+	return m.db.write(func(st *state) error {
+		t, ok := st.tables[from]
+		if !ok {
+			return fmt.Errorf("memory: no such table: %v", from)
+		}
+		if _, ok = st.tables[to]; ok {
+			return fmt.Errorf("memory: table %v already exists", to)
+		}
+		delete(st.tables, from)
+		st.tables[to] = t
+		st.owned[to] = st.owned[from]
+		return nil
+	})
+}
+
+func (m *migrator) HasColumn(dst interface{}, field string) bool {
+	sch, column, err := m.column(dst, field)
+	if err != nil {
+		return false
+	}
+
+	st, err := m.db.snapshot()
+	if err != nil {
+		return false
+	}
+
+	t, ok := st.tables[sch.Table]
+	return ok && t.hasColumn(column)
+}
+
+func (m *migrator) AddColumn(dst interface{}, field string) error {
+	sch, err := m.db.parse(dst)
+	if err != nil {
+		return err
+	}
+	f := sch.LookUpField(field)
+	if f == nil {
+		return fmt.Errorf("memory: failed to look up field with name: %v", field)
+	}
+
+	return m.db.write(func(st *state) error {
+		t, err := st.table(sch.Table)
+		if err != nil {
+			return err
+		}
+		if t.hasColumn(f.DBName) {
+			return fmt.Errorf("memory: duplicate column name: %v", f.DBName)
+		}
+		t.columns = append(t.columns, f.DBName)
+		return nil
+	})
+}
+
+func (m *migrator) DropColumn(dst interface{}, field string) error {
+	sch, column, err := m.column(dst, field)
+	if err != nil {
+		return err
+	}
+
+	return m.db.write(func(st *state) error {
+		t, err := st.table(sch.Table)
+		if err != nil {
+			return err
+		}
+		if !t.hasColumn(column) {
+			return fmt.Errorf("memory: no such column: %v", column)
+		}
+
+		t.columns = remove(t.columns, column)
+		for name, idx := range t.indexes {
+			for _, c := range idx.columns {
+				if c == column {
+					delete(t.indexes, name)
+					break
+				}
+			}
+		}
+		for _, r := range t.rows {
+			delete(r, column)
+		}
+		return nil
+	})
+}
+
+func (m *migrator) RenameColumn(dst interface{}, oldName, field string) error {
+	sch, from, err := m.column(dst, oldName)
+	if err != nil {
+		return err
+	}
+	_, to, err := m.column(dst, field)
+	if err != nil {
+		return err
+	}
+
+	return m.db.write(func(st *state) error {
+		t, err := st.table(sch.Table)
+		if err != nil {
+			return err
+		}
+		if !t.hasColumn(from) {
+			return fmt.Errorf("memory: no such column: %v", from)
+		}
+		if t.hasColumn(to) {
+			return fmt.Errorf("memory: duplicate column name: %v", to)
+		}
+
+		rename := func(columns []string) {
+			for i, c := range columns {
+				if c == from {
+					columns[i] = to
+				}
+			}
+		}
+		rename(t.columns)
+		rename(t.primaryKeys)
+		for _, idx := range t.indexes {
+			rename(idx.columns)
+		}
+		for _, r := range t.rows {
+			if v, ok := r[from]; ok {
+				r[to] = v
+				delete(r, from)
+			}
+		}
+		return nil
+	})
+}
+
+func (m *migrator) HasIndex(dst interface{}, name string) bool {
+	sch, err := m.db.parse(dst)
+	if err != nil {
+		return false
+	}
+	if idx := sch.LookIndex(name); idx != nil {
+		name = idx.Name
+	}
+
+	st, err := m.db.snapshot()
+	if err != nil {
+		return false
+	}
+
+	t, ok := st.tables[sch.Table]
+	if !ok {
+		return false
+	}
+	_, ok = t.indexes[name]
+	return ok
+}
+
+func (m *migrator) CreateIndex(dst interface{}, name string) error {
+	sch, err := m.db.parse(dst)
+	if err != nil {
+		return err
+	}
+	idx := sch.LookIndex(name)
+	if idx == nil {
+		return fmt.Errorf("memory: failed to create index with name %v", name)
+	}
+	created := newIndex(idx)
+
+	return m.db.write(func(st *state) error {
+		t, err := st.table(sch.Table)
+		if err != nil {
+			return err
+		}
+		if _, ok := t.indexes[idx.Name]; ok {
+			return fmt.Errorf("memory: index %v already exists", idx.Name)
+		}
+		t.indexes[idx.Name] = created
+		return t.checkUnique(sch.Table)
+	})
+}
+
+func (m *migrator) DropIndex(dst interface{}, name string) error {
+	sch, err := m.db.parse(dst)
+	if err != nil {
+		return err
+	}
+	if idx := sch.LookIndex(name); idx != nil {
+		name = idx.Name
+	}
+
+	return m.db.write(func(st *state) error {
+		t, err := st.table(sch.Table)
+		if err != nil {
+			return err
+		}
+		if _, ok := t.indexes[name]; !ok {
+			return fmt.Errorf("memory: no such index: %v", name)
+		}
+		delete(t.indexes, name)
+		return nil
+	})
+}
+
+// tableName dst 为字符串时直接作为表名，否则为数据模型
+func (m *migrator) tableName(dst interface{}) (string, error) {
+	if name, ok := dst.(string); ok {
+		return name, nil
+	}
+
+	sch, err := m.db.parse(dst)
+	if err != nil {
+		return "", err
+	}
+	return sch.Table, nil
+}
+
+// column field 可以是模型中的字段名或者列名，模型中不存在时作为列名使用
+func (m *migrator) column(dst interface{}, field string) (*schema.Schema, string, error) {
+	sch, err := m.db.parse(dst)
+	if err != nil {
+		return nil, "", err
+	}
+	if f := sch.LookUpField(field); f != nil {
+		return sch, f.DBName, nil
+	}
+	return sch, field, nil
+}
+
+// schemaIndexes 返回模型中定义的索引，unique 标签与 gorm 一致视为唯一索引
+func schemaIndexes(sch *schema.Schema) map[string]*index {
+	indexes := make(map[string]*index)
+	for name, idx := range sch.ParseIndexes() {
+		idx := idx
+		indexes[name] = newIndex(&idx)
+	}
+
+	for _, field := range sch.Fields {
+		if field.Unique && field.DBName != "" {
+			indexes[fmt.Sprintf("idx_%v_%v", sch.Table, field.DBName)] = &index{unique: true, columns: []string{field.DBName}}
+		}
+	}
+
+	return indexes
+}
+
+func newIndex(idx *schema.Index) *index {
+	columns := make([]string, 0, len(idx.Fields))
+	for _, option := range idx.Fields {
+		if option.Field != nil {
+			columns = append(columns, option.DBName)
+		}
+	}
+	return &index{unique: strings.EqualFold(idx.Class, "UNIQUE"), columns: columns}
+}
+
+func remove(columns []string, column string) []string {
+	result := make([]string, 0, len(columns))
+	for _, c := range columns {
+		if c != column {
+			result = append(result, c)
+		}
+	}
+	return result
+}
@@ -0,0 +1,358 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+// Package migrate 版本化的表结构迁移，已执行的迁移记录在 schema_migrations 表中。
+//
+// 迁移按照 Version 的字典序执行，每个迁移在独立的事务中执行并写入迁移记录，
+// 执行期间通过 schema_migrations_lock 表加锁，避免多个实例同时迁移。
+// 迁移中建表时使用迁移自己的快照结构而不是当前的数据模型，数据模型后续的修改不会改变已发布迁移的表结构，
+// 快照结构的定义通过 Describe 写入 Content 参与校验和计算。
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yakumioto/alkaid/internal/common/log"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+)
+
+var (
+	logger = log.GetPackageLogger("storage.migrate")
+
+	ErrLocked           = errors.New("migration is locked")
+	ErrChecksumMismatch = errors.New("migration checksum mismatch")
+	ErrUnknownMigration = errors.New("unknown applied migration")
+	ErrIrreversible     = errors.New("migration is irreversible")
+)
+
+// Migration 一个版本的迁移，Version 建议使用 yyyyMMddHHmmss 格式，Down 为空时表示不可回滚。
+// Content 描述迁移执行的内容，例如 DDL 或者 Describe 返回的快照结构定义，修改 Up 以及 Down 时需要同步修改
+type Migration struct {
+	Version string
+	Name    string
+	Content string
+	Up      func(tx storage.Storage) error
+	Down    func(tx storage.Storage) error
+}
+
+// Checksum 迁移的校验和，已执行迁移的版本、名称或者内容被修改时校验失败
+func (m *Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Version + "\x00" + m.Name + "\x00" + m.Content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Describe 返回快照结构的定义，包括表名以及每个字段的名称、类型和 gorm 标签，用作迁移的 Content
+func Describe(models ...interface{}) string {
+	var b strings.Builder
+	for _, model := range models {
+		t := reflect.Indirect(reflect.ValueOf(model)).Type()
+		b.WriteString(t.Name())
+		if tabler, ok := model.(interface{ TableName() string }); ok {
+			b.WriteString(" " + tabler.TableName())
+		}
+		b.WriteString(" {\n")
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			fmt.Fprintf(&b, "\t%v %v `%v`\n", field.Name, field.Type, field.Tag.Get("gorm"))
+		}
+		b.WriteString("}\n")
+	}
+
+	return b.String()
+}
+
+func (m *Migration) String() string {
+	return m.Version + "_" + m.Name
+}
+
+// SchemaMigration 已执行的迁移记录
+type SchemaMigration struct {
+	Version   string `gorm:"primaryKey"`
+	Name      string
+	Checksum  string
+	AppliedAt int64
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// SchemaMigrationLock 迁移锁，表中只会存在一行记录
+type SchemaMigrationLock struct {
+	ID       int `gorm:"primaryKey;autoIncrement:false"`
+	LockedBy string
+	LockedAt int64
+}
+
+func (SchemaMigrationLock) TableName() string {
+	return "schema_migrations_lock"
+}
+
+// Status 迁移状态，Applied 为 nil 时表示未执行，Migration 为 nil 时表示数据库中存在未知的迁移
+type Status struct {
+	Migration *Migration
+	Applied   *SchemaMigration
+}
+
+func (s *Status) Version() string {
+	if s.Migration != nil {
+		return s.Migration.Version
+	}
+	return s.Applied.Version
+}
+
+func (s *Status) Name() string {
+	if s.Migration != nil {
+		return s.Migration.Name
+	}
+	return s.Applied.Name
+}
+
+// ChecksumMismatch 已执行迁移的校验和与当前代码中的迁移不一致
+func (s *Status) ChecksumMismatch() bool {
+	return s.Migration != nil && s.Applied != nil && s.Applied.Checksum != s.Migration.Checksum()
+}
+
+type Migrator struct {
+	db         storage.Storage
+	migrations []*Migration
+}
+
+// New 创建迁移工具，migrations 的版本不能重复
+func New(db storage.Storage, migrations ...*Migration) (*Migrator, error) {
+	sorted := make([]*Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	for i, m := range sorted {
+		if m.Version == "" || m.Content == "" || m.Up == nil {
+			return nil, fmt.Errorf("migration %v must have version, content and up function", m)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("duplicate migration version %v", m.Version)
+		}
+	}
+
+	return &Migrator{db: db, migrations: sorted}, nil
+}
+
+// Status 返回所有迁移的状态，按照版本排序
+func (m *Migrator) Status() ([]*Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := &Status{Migration: migration}
+		if record, ok := applied[migration.Version]; ok {
+			status.Applied = record
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	for _, record := range applied {
+		statuses = append(statuses, &Status{Applied: record})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version() < statuses[j].Version()
+	})
+
+	return statuses, nil
+}
+
+// Pending 返回未执行的迁移，已执行迁移的校验和不一致时返回 ErrChecksumMismatch
+func (m *Migrator) Pending() ([]*Migration, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]*Migration, 0)
+	for _, status := range statuses {
+		if status.ChecksumMismatch() {
+			return nil, fmt.Errorf("%w: %v", ErrChecksumMismatch, status.Migration)
+		}
+		if status.Applied == nil {
+			pending = append(pending, status.Migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Up 执行所有未执行的迁移，dryRun 为 true 时只返回将要执行的迁移
+func (m *Migrator) Up(ctx context.Context, dryRun bool) ([]*Migration, error) {
+	if dryRun {
+		return m.Pending()
+	}
+
+	var done []*Migration
+	err := m.withLock(func() error {
+		pending, err := m.Pending()
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range pending {
+			migration := migration
+			logger.Infof("[%v] applying migration", migration)
+			err = m.db.Transaction(ctx, func(tx storage.Storage) error {
+				if err := migration.Up(tx); err != nil {
+					return err
+				}
+
+				return tx.Create(&SchemaMigration{
+					Version:   migration.Version,
+					Name:      migration.Name,
+					Checksum:  migration.Checksum(),
+					AppliedAt: time.Now().Unix(),
+				})
+			})
+			if err != nil {
+				return fmt.Errorf("apply migration %v error: %w", migration, err)
+			}
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down 按照版本倒序回滚最近执行的 steps 个迁移，dryRun 为 true 时只返回将要回滚的迁移
+func (m *Migrator) Down(ctx context.Context, steps int, dryRun bool) ([]*Migration, error) {
+	rollback := func() ([]*Migration, error) {
+		statuses, err := m.Status()
+		if err != nil {
+			return nil, err
+		}
+
+		migrations := make([]*Migration, 0, steps)
+		for i := len(statuses) - 1; i >= 0 && len(migrations) < steps; i-- {
+			status := statuses[i]
+			if status.Applied == nil {
+				continue
+			}
+			if status.Migration == nil {
+				return nil, fmt.Errorf("%w: %v_%v", ErrUnknownMigration, status.Applied.Version, status.Applied.Name)
+			}
+			if status.ChecksumMismatch() {
+				return nil, fmt.Errorf("%w: %v", ErrChecksumMismatch, status.Migration)
+			}
+			if status.Migration.Down == nil {
+				return nil, fmt.Errorf("%w: %v", ErrIrreversible, status.Migration)
+			}
+			migrations = append(migrations, status.Migration)
+		}
+
+		return migrations, nil
+	}
+
+	if dryRun {
+		return rollback()
+	}
+
+	var done []*Migration
+	err := m.withLock(func() error {
+		migrations, err := rollback()
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			migration := migration
+			logger.Infof("[%v] rolling back migration", migration)
+			err = m.db.Transaction(ctx, func(tx storage.Storage) error {
+				if err := migration.Down(tx); err != nil {
+					return err
+				}
+
+				return tx.Delete(new(SchemaMigration), migration.Version)
+			})
+			if err != nil {
+				return fmt.Errorf("roll back migration %v error: %w", migration, err)
+			}
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Unlock 强制释放迁移锁，用于迁移进程异常退出后没有释放锁的情况
+func (m *Migrator) Unlock() error {
+	if !m.db.Migrator().HasTable(new(SchemaMigrationLock)) {
+		return nil
+	}
+
+	return m.db.Delete(new(SchemaMigrationLock), 1)
+}
+
+func (m *Migrator) applied() (map[string]*SchemaMigration, error) {
+	applied := make(map[string]*SchemaMigration)
+	if !m.db.Migrator().HasTable(new(SchemaMigration)) {
+		return applied, nil
+	}
+
+	records := make([]*SchemaMigration, 0)
+	if err := m.db.FindByQuery(&records, nil); err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) withLock(fn func() error) error {
+	if err := m.db.Migrator().AutoMigrate(new(SchemaMigration), new(SchemaMigrationLock)); err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	lock := &SchemaMigrationLock{
+		ID:       1,
+		LockedBy: fmt.Sprintf("%v:%v", hostname, os.Getpid()),
+		LockedAt: time.Now().Unix(),
+	}
+	if err := m.db.Create(lock); err != nil {
+		holder := new(SchemaMigrationLock)
+		if findErr := m.db.FindByID(holder, 1); findErr == nil {
+			return fmt.Errorf("%w by %v at %v", ErrLocked, holder.LockedBy, time.Unix(holder.LockedAt, 0).Format(time.RFC3339))
+		}
+		return err
+	}
+
+	defer func() {
+		if err := m.db.Delete(new(SchemaMigrationLock), 1); err != nil {
+			logger.Errorf("release migration lock error: %v", err)
+		}
+	}()
+
+	return fn()
+}
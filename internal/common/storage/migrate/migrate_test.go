@@ -0,0 +1,240 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package migrate
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/sqlite3"
+)
+
+type document struct {
+	ID    string `gorm:"primaryKey"`
+	Title string
+}
+
+func (document) TableName() string {
+	return "documents"
+}
+
+type renamedDocument struct {
+	ID   string `gorm:"primaryKey"`
+	Name string
+}
+
+func (renamedDocument) TableName() string {
+	return "documents"
+}
+
+var testMigrations = []*Migration{
+	{
+		Version: "0002",
+		Name:    "rename_title",
+		Content: "ALTER TABLE documents RENAME COLUMN title TO name",
+		Up: func(tx storage.Storage) error {
+			return tx.Migrator().RenameColumn(new(document), "title", "name")
+		},
+		Down: func(tx storage.Storage) error {
+			return tx.Migrator().RenameColumn(new(renamedDocument), "name", "title")
+		},
+	},
+	{
+		Version: "0001",
+		Name:    "create_documents",
+		Content: Describe(new(document)),
+		Up: func(tx storage.Storage) error {
+			if err := tx.Migrator().AutoMigrate(new(document)); err != nil {
+				return err
+			}
+			return tx.Create(&document{ID: "a", Title: "hello"})
+		},
+		Down: func(tx storage.Storage) error {
+			return tx.Migrator().DropTable(new(document))
+		},
+	},
+}
+
+func newTestMigrator(t *testing.T, migrations ...*Migration) (*Migrator, storage.Storage) {
+	db, err := sqlite3.NewDB(filepath.Join(t.TempDir(), "alkaid.db"))
+	assert.NoError(t, err)
+
+	m, err := New(db, migrations...)
+	assert.NoError(t, err)
+
+	return m, db
+}
+
+func versions(migrations []*Migration) []string {
+	list := make([]string, 0)
+	for _, m := range migrations {
+		list = append(list, m.Version)
+	}
+	return list
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(nil, testMigrations[0], testMigrations[0])
+	assert.Error(t, err)
+
+	_, err = New(nil, &Migration{Version: "0001"})
+	assert.Error(t, err)
+
+	_, err = New(nil, &Migration{Version: "0001", Up: func(tx storage.Storage) error { return nil }})
+	assert.Error(t, err)
+}
+
+func TestMigrator_Up(t *testing.T) {
+	m, db := newTestMigrator(t, testMigrations...)
+	ctx := context.Background()
+
+	// dry run 不会修改数据库
+	done, err := m.Up(ctx, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0001", "0002"}, versions(done))
+	assert.False(t, db.Migrator().HasTable(new(SchemaMigration)))
+
+	done, err = m.Up(ctx, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0001", "0002"}, versions(done))
+
+	doc := new(renamedDocument)
+	assert.NoError(t, db.FindByID(doc, "a"))
+	assert.Equal(t, "hello", doc.Name)
+
+	done, err = m.Up(ctx, false)
+	assert.NoError(t, err)
+	assert.Empty(t, done)
+
+	statuses, err := m.Status()
+	assert.NoError(t, err)
+	assert.Len(t, statuses, 2)
+	for _, status := range statuses {
+		assert.NotNil(t, status.Applied)
+		assert.False(t, status.ChecksumMismatch())
+	}
+}
+
+func TestMigrator_UpFailed(t *testing.T) {
+	errFailed := errors.New("failed")
+	m, db := newTestMigrator(t, testMigrations[1], &Migration{
+		Version: "0002",
+		Name:    "failed",
+		Content: "INSERT INTO documents (id) VALUES ('b')",
+		Up: func(tx storage.Storage) error {
+			if err := tx.Create(&document{ID: "b"}); err != nil {
+				return err
+			}
+			return errFailed
+		},
+	})
+
+	done, err := m.Up(context.Background(), false)
+	assert.True(t, errors.Is(err, errFailed))
+	assert.Equal(t, []string{"0001"}, versions(done))
+
+	// 失败的迁移整体回滚，并且释放锁
+	assert.Equal(t, storage.ErrNotFound, db.FindByID(new(document), "b"))
+	assert.Equal(t, storage.ErrNotFound, db.FindByID(new(SchemaMigrationLock), 1))
+	pending, err := m.Pending()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0002"}, versions(pending))
+}
+
+func TestMigrator_Down(t *testing.T) {
+	m, db := newTestMigrator(t, testMigrations...)
+	ctx := context.Background()
+
+	_, err := m.Up(ctx, false)
+	assert.NoError(t, err)
+
+	done, err := m.Down(ctx, 2, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0002", "0001"}, versions(done))
+	assert.True(t, db.Migrator().HasColumn(new(renamedDocument), "name"))
+
+	done, err = m.Down(ctx, 1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0002"}, versions(done))
+	assert.True(t, db.Migrator().HasColumn(new(document), "title"))
+
+	done, err = m.Down(ctx, 5, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0001"}, versions(done))
+	assert.False(t, db.Migrator().HasTable(new(document)))
+
+	pending, err := m.Pending()
+	assert.NoError(t, err)
+	assert.Len(t, pending, 2)
+
+	// 不可回滚的迁移
+	m, _ = newTestMigrator(t, &Migration{Version: "0001", Content: "-", Up: func(tx storage.Storage) error { return nil }})
+	_, err = m.Up(ctx, false)
+	assert.NoError(t, err)
+	_, err = m.Down(ctx, 1, false)
+	assert.True(t, errors.Is(err, ErrIrreversible))
+}
+
+func TestMigrator_Checksum(t *testing.T) {
+	m, db := newTestMigrator(t, testMigrations...)
+	ctx := context.Background()
+
+	_, err := m.Up(ctx, false)
+	assert.NoError(t, err)
+
+	// 已执行的迁移被修改
+	renamed := *testMigrations[1]
+	renamed.Name = "create_documents_v2"
+	m, err = New(db, &renamed, testMigrations[0])
+	assert.NoError(t, err)
+	_, err = m.Up(ctx, false)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	// 版本以及名称不变，但是快照结构被修改
+	changed := *testMigrations[1]
+	changed.Content = Describe(new(renamedDocument))
+	m, err = New(db, &changed, testMigrations[0])
+	assert.NoError(t, err)
+	_, err = m.Up(ctx, false)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	// 数据库中存在代码中没有的迁移
+	m, err = New(db, testMigrations[1])
+	assert.NoError(t, err)
+	statuses, err := m.Status()
+	assert.NoError(t, err)
+	assert.Nil(t, statuses[1].Migration)
+	_, err = m.Down(ctx, 1, false)
+	assert.True(t, errors.Is(err, ErrUnknownMigration))
+}
+
+func TestDescribe(t *testing.T) {
+	assert.Equal(t, "document documents {\n\tID string `primaryKey`\n\tTitle string ``\n}\n", Describe(new(document)))
+	assert.NotEqual(t, Describe(new(document)), Describe(new(renamedDocument)))
+}
+
+func TestMigrator_Lock(t *testing.T) {
+	m, db := newTestMigrator(t, testMigrations...)
+	ctx := context.Background()
+
+	assert.NoError(t, db.Migrator().AutoMigrate(new(SchemaMigrationLock)))
+	assert.NoError(t, db.Create(&SchemaMigrationLock{ID: 1, LockedBy: "other"}))
+
+	_, err := m.Up(ctx, false)
+	assert.True(t, errors.Is(err, ErrLocked))
+	assert.False(t, db.Migrator().HasTable(new(document)))
+
+	assert.NoError(t, m.Unlock())
+	_, err = m.Up(ctx, false)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,21 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package mysql
+
+import (
+	"github.com/yakumioto/alkaid/internal/common/storage/orm"
+	"gorm.io/driver/mysql"
+)
+
+const Driver = "mysql"
+
+// NewDB dsn 示例：user:pass@tcp(127.0.0.1:3306)/dbname?charset=utf8mb4&parseTime=True&loc=Local
+func NewDB(dsn string) (*orm.DB, error) {
+	return orm.Open(mysql.Open(dsn))
+}
@@ -0,0 +1,32 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package mysql
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/orm"
+	"github.com/yakumioto/alkaid/internal/common/storage/storagetest"
+	"gorm.io/driver/mysql"
+)
+
+func TestMockConformance(t *testing.T) {
+	storagetest.RunMock(t, func(t *testing.T) (storage.Storage, sqlmock.Sqlmock) {
+		stdDB, mock, err := sqlmock.New()
+		assert.NoError(t, err)
+
+		db, err := orm.Open(mysql.New(mysql.Config{Conn: stdDB, SkipInitializeWithVersion: true}))
+		assert.NoError(t, err)
+
+		return db, mock
+	})
+}
@@ -0,0 +1,283 @@
+/*
+ * Copyright (c) 2021. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+// Package orm 基于 gorm 实现 storage.Storage，各数据库驱动只需要提供对应的 gorm.Dialector，
+// 保证不同数据库的行为一致。
+package orm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DB struct {
+	db *gorm.DB
+}
+
+func Open(dialector gorm.Dialector) (*DB, error) {
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	return New(db), nil
+}
+
+func New(db *gorm.DB) *DB {
+	return &DB{
+		db: db,
+	}
+}
+
+func (s *DB) AutoMigrate(dst ...interface{}) error {
+	return s.db.AutoMigrate(dst...)
+}
+
+func (s *DB) Create(value interface{}) error {
+	if tx := s.db.Create(value); tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (s *DB) Update(values interface{}, options *storage.UpdateOptions) error {
+	if options == nil {
+		return storage.ErrNeedUpdateOptions
+	}
+
+	column, value, ok := options.GetPrecondition()
+	if !ok {
+		if tx := s.selects(values, options).Where(options.Query, options.Args...).Updates(values); tx.Error != nil {
+			return tx.Error
+		}
+
+		return nil
+	}
+
+	restore := func() {}
+	if _, next, ok := options.GetVersion(); ok {
+		var err error
+		if restore, err = s.setVersion(values, column, next); err != nil {
+			return err
+		}
+	}
+
+	tx := s.selects(values, options).
+		Where(options.Query, options.Args...).
+		Where(clause.Eq{Column: clause.Column{Name: column}, Value: value}).
+		Updates(values)
+
+	err := tx.Error
+	if err == nil && tx.RowsAffected == 0 {
+		err = storage.ErrConflict
+	}
+	if err != nil {
+		// 更新失败时还原 values 中的版本，调用方可以重新读取后再次更新
+		restore()
+	}
+
+	return err
+}
+
+// selects 指定 Select 时只更新指定的列以及版本列，零值同样会被更新
+func (s *DB) selects(values interface{}, options *storage.UpdateOptions) *gorm.DB {
+	tx := s.db.Model(values)
+	columns := options.GetSelect()
+	if len(columns) == 0 {
+		return tx
+	}
+
+	if column, _, ok := options.GetVersion(); ok {
+		columns = append(append(make([]string, 0, len(columns)+1), columns...), column)
+	}
+	return tx.Select(columns)
+}
+
+// setVersion 将新的版本写入 values，与其他字段在同一条语句中更新，返回还原原有版本的函数
+func (s *DB) setVersion(values interface{}, column string, next int64) (func(), error) {
+	stmt := &gorm.Statement{DB: s.db}
+	if err := stmt.Parse(values); err != nil {
+		return nil, err
+	}
+
+	field := stmt.Schema.LookUpField(column)
+	if field == nil {
+		return nil, fmt.Errorf("version column %v not found in %v", column, stmt.Schema.Name)
+	}
+
+	elem := reflect.ValueOf(values)
+	old, _ := field.ValueOf(elem)
+	if err := field.Set(elem, next); err != nil {
+		return nil, err
+	}
+
+	return func() { _ = field.Set(elem, old) }, nil
+}
+
+func (s *DB) FindByID(dest interface{}, conditions ...interface{}) error {
+	return s.findByID(dest, conditions, false)
+}
+
+func (s *DB) FindUnscopedByID(dest interface{}, conditions ...interface{}) error {
+	return s.findByID(dest, conditions, true)
+}
+
+// findByID conditions 作为主键的取值列表，dest 支持软删除且 unscoped 为 false 时排除已删除的记录
+func (s *DB) findByID(dest interface{}, conditions []interface{}, unscoped bool) error {
+	tx := s.db
+	if deleted, _, ok := storage.SoftDeleteColumns(dest); ok && !unscoped {
+		tx = tx.Where(clause.Eq{Column: clause.Column{Name: deleted}, Value: false})
+	}
+
+	if tx := tx.First(dest, conditions); tx.Error != nil {
+		if tx.Error == gorm.ErrRecordNotFound {
+			return storage.ErrNotFound
+		}
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (s *DB) FindByQuery(dest interface{}, options *storage.QueryOptions) error {
+	if options == nil {
+		options = storage.NewQueryOptions()
+	}
+
+	tx := s.conditions(dest, options).
+		Order(options.GetOrder()).
+		Limit(options.GetLimit()).
+		Offset(options.GetOffset()).
+		Find(dest)
+
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	// 查询列表时没有记录返回空列表，只有查询单条记录时才返回 ErrNotFound
+	if tx.RowsAffected == 0 && reflect.Indirect(reflect.ValueOf(dest)).Kind() != reflect.Slice {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *DB) Count(model interface{}, options *storage.QueryOptions) (int64, error) {
+	if options == nil {
+		options = storage.NewQueryOptions()
+	}
+
+	var count int64
+	if tx := s.conditions(model, options).Model(model).Count(&count); tx.Error != nil {
+		return 0, tx.Error
+	}
+
+	return count, nil
+}
+
+// conditions 将 options 中的查询条件应用到新的会话中，
+// model 支持软删除时将查询条件作为一组，再与排除已删除记录的条件组合
+func (s *DB) conditions(model interface{}, options *storage.QueryOptions) *gorm.DB {
+	deleted, _, soft := storage.SoftDeleteColumns(model)
+	soft = soft && !options.IsUnscoped()
+
+	tx := s.db.Session(&gorm.Session{})
+	if soft {
+		tx = s.db.Session(&gorm.Session{NewDB: true})
+	}
+
+	for _, where := range options.GetWheres() {
+		tx = tx.Where(where.Query, where.Args...)
+	}
+
+	for _, or := range options.GetOrs() {
+		tx = tx.Or(or.Query, or.Args...)
+	}
+
+	if not := options.GetNot(); not != nil {
+		tx = tx.Not(not.Query, not.Args...)
+	}
+
+	if soft {
+		return s.db.Session(&gorm.Session{}).Where(tx).Where(clause.Eq{Column: clause.Column{Name: deleted}, Value: false})
+	}
+
+	return tx
+}
+
+func (s *DB) Delete(value interface{}, conditions ...interface{}) error {
+	if deleted, deletedAt, ok := storage.SoftDeleteColumns(value); ok {
+		return s.softDelete(value, conditions, map[string]interface{}{deleted: true, deletedAt: time.Now().Unix()})
+	}
+
+	if tx := s.db.Delete(value, conditions); tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (s *DB) Restore(value interface{}, conditions ...interface{}) error {
+	deleted, deletedAt, ok := storage.SoftDeleteColumns(value)
+	if !ok {
+		return storage.ErrNotSoftDeletable
+	}
+
+	return s.softDelete(value, conditions, map[string]interface{}{deleted: false, deletedAt: 0})
+}
+
+// softDelete 更新软删除列，conditions 与 Delete 一致作为主键的取值列表，为空时使用 value 中的主键
+func (s *DB) softDelete(value interface{}, conditions []interface{}, columns map[string]interface{}) error {
+	tx := s.db.Model(value)
+	if len(conditions) != 0 {
+		tx = tx.Where(clause.IN{Column: clause.PrimaryColumn, Values: conditions})
+	}
+
+	if tx = tx.Updates(columns); tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (s *DB) Migrator() storage.Migrator {
+	return s.db.Migrator()
+}
+
+func (s *DB) Begin() storage.Storage {
+	return New(s.db.Begin())
+}
+
+func (s *DB) Commit() error {
+	if tx := s.db.Commit(); tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (s *DB) Rollback() error {
+	if tx := s.db.Rollback(); tx.Error != nil {
+		return tx.Error
+	}
+
+	return nil
+}
+
+func (s *DB) Transaction(ctx context.Context, fn func(tx storage.Storage) error) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(New(tx))
+	})
+}
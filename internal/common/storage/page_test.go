@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package storage_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/memory"
+)
+
+type pageItem struct {
+	ResourceID string `gorm:"primaryKey"`
+	Name       string
+	Rank       int64
+}
+
+var pageSchema = &storage.Schema{
+	Fields: map[string]storage.Field{
+		"name": {Column: "name"},
+		"rank": {Column: "rank", Type: storage.FieldInt},
+	},
+	Key:         "resource_id",
+	DefaultSort: "-rank",
+}
+
+func findPage(t *testing.T, query string) (*storage.Page, []string) {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("GET", "/items?"+query, nil)
+	options, err := storage.NewQueryOptionsWithCtx(ctx, pageSchema)
+	assert.NoError(t, err)
+
+	list := make([]*pageItem, 0)
+	page, err := storage.FindPage(&list, options)
+	assert.NoError(t, err)
+
+	ids := make([]string, 0, len(list))
+	for _, item := range list {
+		ids = append(ids, item.ResourceID)
+	}
+	return page, ids
+}
+
+func TestFindPage(t *testing.T) {
+	storage.Initialize(memory.NewDB())
+	assert.NoError(t, storage.AutoMigrate(new(pageItem)))
+	for _, item := range []*pageItem{
+		{"a", "50%_off", 1}, {"b", "50% off", 1}, {"c", "500_off", 2},
+		{"d", "d", 2}, {"e", "e", 2}, {"f", "f", 3}, {"g", "g", 3},
+	} {
+		assert.NoError(t, storage.Create(item))
+	}
+
+	page, ids := findPage(t, "limit=3")
+	assert.Equal(t, []string{"f", "g", "c"}, ids)
+	assert.Equal(t, int64(7), page.Total)
+	assert.NotEmpty(t, page.NextCursor)
+
+	// 翻页期间新增排序在前面的记录，下一页不会出现重复的记录
+	assert.NoError(t, storage.Create(&pageItem{"h", "h", 4}))
+
+	page, ids = findPage(t, "limit=3&cursor="+page.NextCursor)
+	assert.Equal(t, []string{"d", "e", "a"}, ids)
+	assert.Equal(t, int64(8), page.Total)
+
+	page, ids = findPage(t, "limit=3&cursor="+page.NextCursor)
+	assert.Equal(t, []string{"b"}, ids)
+	assert.Empty(t, page.NextCursor)
+
+	// 记录数恰好为 limit 的整数倍时不会返回空的下一页
+	page, ids = findPage(t, "sort=name&limit=4&name~o")
+	assert.Equal(t, []string{"b", "a", "c"}, ids)
+	assert.Empty(t, page.NextCursor)
+	page, _ = findPage(t, "limit=8")
+	assert.Empty(t, page.NextCursor)
+
+	// % 以及 _ 按照字面值匹配
+	_, ids = findPage(t, "filter=name~0%25_")
+	assert.Equal(t, []string{"a"}, ids)
+	_, ids = findPage(t, "filter=name~_")
+	assert.Equal(t, []string{"c", "a"}, ids)
+}
@@ -0,0 +1,21 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package postgres
+
+import (
+	"github.com/yakumioto/alkaid/internal/common/storage/orm"
+	"gorm.io/driver/postgres"
+)
+
+const Driver = "postgres"
+
+// NewDB dsn 示例：host=127.0.0.1 user=user password=pass dbname=dbname port=5432 sslmode=disable TimeZone=Asia/Shanghai
+func NewDB(dsn string) (*orm.DB, error) {
+	return orm.Open(postgres.Open(dsn))
+}
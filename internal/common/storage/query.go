@@ -0,0 +1,418 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// FieldType 查询字段的类型，查询参数会按照类型转换后再作为 SQL 参数
+type FieldType int
+
+const (
+	FieldString FieldType = iota
+	FieldInt
+	FieldBool
+)
+
+// Field 允许查询的字段，Column 为数据库中的列名
+type Field struct {
+	Column string
+	Type   FieldType
+}
+
+// Schema 列表接口允许过滤以及排序的字段白名单，key 为查询参数中使用的字段名
+type Schema struct {
+	Fields map[string]Field
+	// Key 唯一的字符串列，总是作为最后一个排序字段，保证翻页结果稳定
+	Key string
+	// DefaultSort 未指定 sort 时使用的排序，格式与 sort 参数相同
+	DefaultSort string
+}
+
+// 按照长度排序，保证 >= 不会被解析为 >
+var operators = []string{"!=", ">=", "<=", "=", ">", "<", "~"}
+
+var sqlOperators = map[string]string{
+	"=":  "=",
+	"!=": "<>",
+	">":  ">",
+	">=": ">=",
+	"<":  "<",
+	"<=": "<=",
+	"~":  "LIKE",
+}
+
+// likeEscape ~ 运算符使用的转义字符，不使用反斜杠是因为 MySQL 的字符串字面值中反斜杠同样是转义字符
+const likeEscape = "!"
+
+var likeEscaper = strings.NewReplacer(likeEscape, likeEscape+likeEscape, "%", likeEscape+"%", "_", likeEscape+"_")
+
+// NewQueryOptionsWithCtx 通过 gin context 获取 options
+// 示例：example.com/users?filter=name~mioto&createdAt>=1649902088&sort=-createdAt&limit=20&cursor=...
+//
+// 过滤条件可以写在 filter 参数中（多个条件使用逗号分隔），也可以直接作为查询参数，
+// 支持 =、!=、>、>=、<、<=、~（包含，% 以及 _ 按照字面值匹配）运算符，各条件之间为 AND 关系。
+// filter 与 sort 中出现白名单之外的字段时返回 ErrInvalidQuery，直接作为查询参数时则忽略。
+// cursor 为上一页返回的 NextCursor，只能与生成它的 sort 一起使用。
+// 查询参数不能包含软删除的记录，需要时由调用方校验权限后使用 QueryOptions.Unscoped。
+func NewQueryOptionsWithCtx(ctx *gin.Context, schema *Schema) (*QueryOptions, error) {
+	var (
+		queries = make([]string, 0)
+		args    = make([]interface{}, 0)
+		sort    = schema.DefaultSort
+		limit   = DefaultLimit
+		after   = ""
+	)
+
+	addFilter := func(expr string, strict bool) error {
+		query, arg, err := schema.parseFilter(expr)
+		if err != nil {
+			if strict || !errors.Is(err, errUnknownField) {
+				return err
+			}
+			return nil
+		}
+		queries = append(queries, query)
+		args = append(args, arg)
+		return nil
+	}
+
+	for _, segment := range strings.Split(ctx.Request.URL.RawQuery, "&") {
+		if segment == "" {
+			continue
+		}
+		segment, err := url.QueryUnescape(segment)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+
+		key, value := segment, ""
+		if i := strings.Index(segment, "="); i >= 0 {
+			key, value = segment[:i], segment[i+1:]
+		}
+
+		switch key {
+		case "filter":
+			for _, expr := range strings.Split(value, ",") {
+				if expr == "" {
+					continue
+				}
+				if err := addFilter(expr, true); err != nil {
+					return nil, err
+				}
+			}
+		case "sort":
+			sort = value
+		case "limit":
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				return nil, fmt.Errorf("%w: limit must be a positive integer", ErrInvalidQuery)
+			}
+			if limit > MaxLimit {
+				limit = MaxLimit
+			}
+		case "cursor":
+			after = value
+		default:
+			if err := addFilter(segment, false); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	columns, err := schema.parseSort(sort)
+	if err != nil {
+		return nil, err
+	}
+
+	options := NewQueryOptions().Order(orderBy(columns)).Limit(limit)
+	options.keyset = &keyset{columns: columns}
+	if after != "" {
+		if options.keyset.after, err = options.keyset.decode(after); err != nil {
+			return nil, err
+		}
+	}
+	if len(queries) != 0 {
+		options.Where(strings.Join(queries, " AND "), args...)
+	}
+
+	return options, nil
+}
+
+var errUnknownField = fmt.Errorf("%w: unknown field", ErrInvalidQuery)
+
+// parseFilter 将 field<op>value 形式的过滤条件转换为 SQL 条件，列名只会来自白名单，值作为参数传递
+func (s *Schema) parseFilter(expr string) (string, interface{}, error) {
+	i := strings.IndexFunc(expr, func(r rune) bool {
+		return !(r == '_' || r == '.' || '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z')
+	})
+	name, rest := expr, ""
+	if i >= 0 {
+		name, rest = expr[:i], expr[i:]
+	}
+
+	field, ok := s.Fields[name]
+	if !ok {
+		return "", nil, fmt.Errorf("%w %q", errUnknownField, name)
+	}
+
+	op := ""
+	for _, operator := range operators {
+		if strings.HasPrefix(rest, operator) {
+			op = operator
+			break
+		}
+	}
+	if op == "" {
+		return "", nil, fmt.Errorf("%w: invalid filter %q", ErrInvalidQuery, expr)
+	}
+
+	value := rest[len(op):]
+	var arg interface{}
+	switch field.Type {
+	case FieldInt:
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: field %q must be an integer", ErrInvalidQuery, name)
+		}
+		arg = v
+	case FieldBool:
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: field %q must be a boolean", ErrInvalidQuery, name)
+		}
+		arg = v
+	default:
+		arg = value
+	}
+
+	if op == "~" {
+		if field.Type != FieldString {
+			return "", nil, fmt.Errorf("%w: field %q does not support ~", ErrInvalidQuery, name)
+		}
+		return fmt.Sprintf("%s LIKE ? ESCAPE '%s'", field.Column, likeEscape), "%" + likeEscaper.Replace(value) + "%", nil
+	}
+
+	return fmt.Sprintf("%s %s ?", field.Column, sqlOperators[op]), arg, nil
+}
+
+// sortColumn 排序列，typ 用于解析游标中的值
+type sortColumn struct {
+	column string
+	desc   bool
+	typ    FieldType
+}
+
+// parseSort 解析 -createdAt,name 形式的排序，- 表示倒序
+func (s *Schema) parseSort(sort string) ([]*sortColumn, error) {
+	columns := make([]*sortColumn, 0)
+	hasKey := false
+
+	for _, name := range strings.Split(sort, ",") {
+		if name == "" {
+			continue
+		}
+
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+
+		field, ok := s.Fields[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown sort field %q", ErrInvalidQuery, name)
+		}
+		if field.Column == s.Key {
+			hasKey = true
+		}
+		columns = append(columns, &sortColumn{column: field.Column, desc: desc, typ: field.Type})
+	}
+
+	if !hasKey && s.Key != "" {
+		columns = append(columns, &sortColumn{column: s.Key, typ: FieldString})
+	}
+
+	return columns, nil
+}
+
+// orderBy 将排序列转换为 SQL 排序
+func orderBy(columns []*sortColumn) string {
+	orders := make([]string, 0, len(columns))
+	for _, c := range columns {
+		direction := "ASC"
+		if c.desc {
+			direction = "DESC"
+		}
+		orders = append(orders, c.column+" "+direction)
+	}
+
+	return strings.Join(orders, ", ")
+}
+
+// keyset 游标分页，游标中保存上一页最后一条记录的排序列的值，
+// 下一页从排序在其之后的记录开始，翻页期间插入或者删除记录不会导致重复或者遗漏
+type keyset struct {
+	columns []*sortColumn
+	after   *condition
+}
+
+// cursor 游标的内容，Order 为生成游标时的排序，与当前排序不同时游标无效
+type cursor struct {
+	Order  string   `json:"o"`
+	Values []string `json:"v"`
+}
+
+// schemaCache 生成游标时解析数据模型的缓存
+var schemaCache = new(sync.Map)
+
+// encode 使用 item 中排序列的值生成游标
+func (k *keyset) encode(item reflect.Value) (string, error) {
+	item = reflect.Indirect(item)
+	sch, err := schema.Parse(item.Addr().Interface(), schemaCache, schema.NamingStrategy{})
+	if err != nil {
+		return "", err
+	}
+
+	c := &cursor{Order: orderBy(k.columns), Values: make([]string, 0, len(k.columns))}
+	for _, column := range k.columns {
+		field := sch.LookUpField(column.column)
+		if field == nil {
+			return "", fmt.Errorf("unknown sort column %q", column.column)
+		}
+		c.Values = append(c.Values, fmt.Sprint(item.FieldByIndex(field.StructField.Index).Interface()))
+	}
+
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// decode 解析游标，返回排序在游标之后的记录的查询条件，例如 -createdAt 对应
+// (created_at < ?) OR (created_at = ? AND resource_id > ?)
+func (k *keyset) decode(value string) (*condition, error) {
+	errInvalidCursor := fmt.Errorf("%w: invalid cursor", ErrInvalidQuery)
+
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	c := new(cursor)
+	if err = json.Unmarshal(raw, c); err != nil || c.Order != orderBy(k.columns) || len(c.Values) != len(k.columns) {
+		return nil, errInvalidCursor
+	}
+
+	values := make([]interface{}, 0, len(c.Values))
+	for i, column := range k.columns {
+		switch column.typ {
+		case FieldInt:
+			v, err := strconv.ParseInt(c.Values[i], 10, 64)
+			if err != nil {
+				return nil, errInvalidCursor
+			}
+			values = append(values, v)
+		case FieldBool:
+			v, err := strconv.ParseBool(c.Values[i])
+			if err != nil {
+				return nil, errInvalidCursor
+			}
+			values = append(values, v)
+		default:
+			values = append(values, c.Values[i])
+		}
+	}
+
+	ors := make([]string, 0, len(k.columns))
+	args := make([]interface{}, 0)
+	for i, column := range k.columns {
+		ands := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, k.columns[j].column+" = ?")
+			args = append(args, values[j])
+		}
+
+		op := ">"
+		if column.desc {
+			op = "<"
+		}
+		ands = append(ands, column.column+" "+op+" ?")
+		args = append(args, values[i])
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+
+	return &condition{Query: "(" + strings.Join(ors, " OR ") + ")", Args: args}, nil
+}
+
+// Page 分页查询的结果，NextCursor 为空时表示没有下一页
+type Page struct {
+	Items      interface{} `json:"items"`
+	Total      int64       `json:"total"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+// FindPage 按照 options 分页查询，dest 必须是切片的指针。
+// options 来自 NewQueryOptionsWithCtx 时使用游标分页，Total 为不包含游标条件的记录总数
+func FindPage(dest interface{}, options *QueryOptions) (*Page, error) {
+	total, err := Count(dest, options)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Items: dest, Total: total}
+	if options == nil || options.keyset == nil {
+		if err = FindByQuery(dest, options); err != nil {
+			return nil, err
+		}
+		return page, nil
+	}
+
+	// 多查询一条记录判断是否存在下一页
+	query := *options
+	query.wheres = append([]*condition(nil), options.wheres...)
+	if options.keyset.after != nil {
+		query.wheres = append(query.wheres, options.keyset.after)
+	}
+	if options.limit > 0 {
+		query.limit = options.limit + 1
+	}
+
+	if err = FindByQuery(dest, &query); err != nil {
+		return nil, err
+	}
+
+	list := reflect.Indirect(reflect.ValueOf(dest))
+	if options.limit > 0 && list.Len() > options.limit {
+		list.Set(list.Slice(0, options.limit))
+		if page.NextCursor, err = options.keyset.encode(list.Index(options.limit - 1)); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
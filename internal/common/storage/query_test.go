@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package storage
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSchema = &Schema{
+	Fields: map[string]Field{
+		"name":      {Column: "name"},
+		"root":      {Column: "root", Type: FieldBool},
+		"createdAt": {Column: "created_at", Type: FieldInt},
+	},
+	Key:         "resource_id",
+	DefaultSort: "-createdAt",
+}
+
+type testItem struct {
+	ResourceID string
+	Name       string
+	CreatedAt  int64
+}
+
+func newTestContext(query string) *gin.Context {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest("GET", "/users?"+query, nil)
+	return ctx
+}
+
+func TestNewQueryOptionsWithCtx(t *testing.T) {
+	tcs := []struct {
+		query string
+		where *condition
+		order string
+		limit int
+		err   error
+	}{
+		{"", nil, "created_at DESC, resource_id ASC", DefaultLimit, nil},
+		{"filter=name~mioto&createdAt>=1649902088&sort=-createdAt&limit=10",
+			&condition{"name LIKE ? ESCAPE '!' AND created_at >= ?", []interface{}{"%mioto%", int64(1649902088)}},
+			"created_at DESC, resource_id ASC", 10, nil},
+		{"filter=name~50%25_off!",
+			&condition{"name LIKE ? ESCAPE '!'", []interface{}{"%50!%!_off!!%"}},
+			"created_at DESC, resource_id ASC", DefaultLimit, nil},
+		{"filter=name!%3Dmioto,root=true&sort=name,createdAt",
+			&condition{"name <> ? AND root = ?", []interface{}{"mioto", true}},
+			"name ASC, created_at ASC, resource_id ASC", DefaultLimit, nil},
+		// unscoped 不属于查询语言，与其他未知参数一样忽略
+		{"encoding=protobuf&name=mioto&limit=1000&unscoped=true",
+			&condition{"name = ?", []interface{}{"mioto"}},
+			"created_at DESC, resource_id ASC", MaxLimit, nil},
+		{"filter=password=1", nil, "", 0, ErrInvalidQuery},
+		{"filter=name", nil, "", 0, ErrInvalidQuery},
+		{"createdAt>=yesterday", nil, "", 0, ErrInvalidQuery},
+		{"filter=createdAt~1", nil, "", 0, ErrInvalidQuery},
+		{"sort=password", nil, "", 0, ErrInvalidQuery},
+		{"limit=0", nil, "", 0, ErrInvalidQuery},
+		{"cursor=invalid", nil, "", 0, ErrInvalidQuery},
+	}
+
+	for _, tc := range tcs {
+		options, err := NewQueryOptionsWithCtx(newTestContext(tc.query), testSchema)
+		if tc.err != nil {
+			assert.True(t, errors.Is(err, tc.err), tc.query)
+			continue
+		}
+		assert.NoError(t, err, tc.query)
+
+		if tc.where == nil {
+			assert.Empty(t, options.GetWheres(), tc.query)
+		} else {
+			assert.Equal(t, []*condition{tc.where}, options.GetWheres(), tc.query)
+		}
+		assert.Equal(t, tc.order, options.GetOrder(), tc.query)
+		assert.Equal(t, tc.limit, options.GetLimit(), tc.query)
+		assert.Nil(t, options.keyset.after, tc.query)
+		assert.False(t, options.IsUnscoped(), tc.query)
+	}
+}
+
+func TestKeysetCursor(t *testing.T) {
+	options, err := NewQueryOptionsWithCtx(newTestContext("sort=-createdAt,name"), testSchema)
+	assert.NoError(t, err)
+	cursor, err := options.keyset.encode(reflect.ValueOf(&testItem{ResourceID: "r1", Name: "mioto", CreatedAt: 1649902088}))
+	assert.NoError(t, err)
+
+	options, err = NewQueryOptionsWithCtx(newTestContext("sort=-createdAt,name&cursor="+cursor), testSchema)
+	assert.NoError(t, err)
+	assert.Equal(t, &condition{
+		"((created_at < ?) OR (created_at = ? AND name > ?) OR (created_at = ? AND name = ? AND resource_id > ?))",
+		[]interface{}{int64(1649902088), int64(1649902088), "mioto", int64(1649902088), "mioto", "r1"},
+	}, options.keyset.after)
+	assert.Empty(t, options.GetWheres())
+
+	// 游标只能与生成它的排序一起使用
+	_, err = NewQueryOptionsWithCtx(newTestContext("sort=createdAt,name&cursor="+cursor), testSchema)
+	assert.True(t, errors.Is(err, ErrInvalidQuery))
+}
@@ -9,15 +9,19 @@
 package sqlite3
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/orm"
+	"github.com/yakumioto/alkaid/internal/common/storage/storagetest"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
-func testMockSQL() (*sqlite3, sqlmock.Sqlmock) {
+func testMockSQL() (*orm.DB, sqlmock.Sqlmock) {
 	stdDB, mock, err := sqlmock.New()
 	if err != nil {
 		panic(err)
@@ -28,9 +32,7 @@ func testMockSQL() (*sqlite3, sqlmock.Sqlmock) {
 		panic(err)
 	}
 
-	return &sqlite3{
-		db: db,
-	}, mock
+	return orm.New(db), mock
 }
 
 func TestNewDB(t *testing.T) {
@@ -61,3 +63,16 @@ func TestSqlite3_Create(t *testing.T) {
 		assert.NoErrorf(t, err, "create data error: %v", err)
 	}
 }
+
+func TestConformance(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "alkaid.db"))
+	assert.NoError(t, err)
+
+	storagetest.Run(t, db)
+}
+
+func TestMockConformance(t *testing.T) {
+	storagetest.RunMock(t, func(t *testing.T) (storage.Storage, sqlmock.Sqlmock) {
+		return testMockSQL()
+	})
+}
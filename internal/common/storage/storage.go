@@ -9,10 +9,10 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"reflect"
 	"sync"
-
-	"github.com/gin-gonic/gin"
 )
 
 var (
@@ -21,6 +21,9 @@ var (
 
 	ErrNotinitializedGlobalStorage = errors.New("the global storage instance is not initialized")
 	ErrNeedUpdateOptions           = errors.New("must need update options")
+	ErrNotSoftDeletable            = errors.New("model does not support soft delete")
+	// ErrConflict 更新时指定的版本或者前置条件不满足，记录已经被其他请求修改
+	ErrConflict = errors.New("conflict")
 
 	ErrNotFound = errors.New("not found")
 )
@@ -37,11 +40,86 @@ type Storage interface {
 	AutoMigrate(dst ...interface{}) error
 	Create(value interface{}) error
 	Update(values interface{}, options *UpdateOptions) error
+	// FindByID 按照主键查询记录，dest 实现 SoftDeleter 时排除已删除的记录
 	FindByID(dest interface{}, conditions ...interface{}) error
+	// FindUnscopedByID 与 FindByID 一致，但包含已删除的记录
+	FindUnscopedByID(dest interface{}, conditions ...interface{}) error
+	// FindByQuery 查询满足 options 的记录，dest 为切片时没有记录返回空切片，
+	// dest 为单条记录时没有记录返回 ErrNotFound
 	FindByQuery(dest interface{}, options *QueryOptions) error
+	// Count 返回满足 options 中查询条件的记录总数，忽略排序以及分页
+	Count(model interface{}, options *QueryOptions) (int64, error)
+	// Delete 删除记录，value 实现 SoftDeleter 时只标记为已删除
 	Delete(value interface{}, conditions ...interface{}) error
+	// Restore 恢复软删除的记录，value 未实现 SoftDeleter 时返回 ErrNotSoftDeletable
+	Restore(value interface{}, conditions ...interface{}) error
+	// Migrator 返回变更表结构的工具，供版本化迁移使用
+	Migrator() Migrator
 	Begin() Storage
 	Commit() error
+	Rollback() error
+	// Transaction 在事务中执行 fn，fn 返回错误或者 panic 时自动回滚，
+	// 在事务中再次调用 Transaction 时使用 savepoint 实现嵌套事务
+	Transaction(ctx context.Context, fn func(tx Storage) error) error
+}
+
+// Migrator 表结构变更操作，dst 为数据模型，field 为模型中的字段名或者列名
+type Migrator interface {
+	AutoMigrate(dst ...interface{}) error
+	HasTable(dst interface{}) bool
+	DropTable(dst ...interface{}) error
+	RenameTable(oldName, newName interface{}) error
+	HasColumn(dst interface{}, field string) bool
+	AddColumn(dst interface{}, field string) error
+	DropColumn(dst interface{}, field string) error
+	RenameColumn(dst interface{}, oldName, field string) error
+	HasIndex(dst interface{}, name string) bool
+	CreateIndex(dst interface{}, name string) error
+	DropIndex(dst interface{}, name string) error
+}
+
+// SoftDeleter 支持软删除的数据模型，FindByID、FindByQuery 以及 Count 默认排除已删除的记录，
+// 需要包含已删除的记录时使用 FindUnscopedByID 或者 QueryOptions.Unscoped
+type SoftDeleter interface {
+	// SoftDeleteColumns 返回标记记录已删除的布尔列以及删除时间的列
+	SoftDeleteColumns() (deleted, deletedAt string)
+}
+
+// SoftDeleteColumns 返回 model 的软删除列，model 可以是结构体、结构体指针或者切片的指针
+func SoftDeleteColumns(model interface{}) (deleted, deletedAt string, ok bool) {
+	typ := reflect.TypeOf(model)
+	for typ != nil && (typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array) {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return "", "", false
+	}
+
+	soft, ok := reflect.New(typ).Interface().(SoftDeleter)
+	if !ok {
+		return "", "", false
+	}
+
+	deleted, deletedAt = soft.SoftDeleteColumns()
+	return deleted, deletedAt, true
+}
+
+type txKey struct{}
+
+// NewContext 返回携带事务的 context，服务之间通过 context 传递同一个事务
+func NewContext(ctx context.Context, tx Storage) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// FromContext 返回 context 中携带的事务，不存在时返回全局 storage
+func FromContext(ctx context.Context) Storage {
+	if ctx != nil {
+		if tx, ok := ctx.Value(txKey{}).(Storage); ok {
+			return tx
+		}
+	}
+
+	return global
 }
 
 func AutoMigrate(dst ...interface{}) error {
@@ -73,7 +151,15 @@ func FindByID(dest interface{}, conditions ...interface{}) error {
 		return err
 	}
 
-	return global.FindByID(dest, conditions)
+	return global.FindByID(dest, conditions...)
+}
+
+func FindUnscopedByID(dest interface{}, conditions ...interface{}) error {
+	if err := checkGlobal(); err != nil {
+		return err
+	}
+
+	return global.FindUnscopedByID(dest, conditions...)
 }
 
 func FindByQuery(dest interface{}, options *QueryOptions) error {
@@ -84,12 +170,28 @@ func FindByQuery(dest interface{}, options *QueryOptions) error {
 	return global.FindByQuery(dest, options)
 }
 
+func Count(model interface{}, options *QueryOptions) (int64, error) {
+	if err := checkGlobal(); err != nil {
+		return 0, err
+	}
+
+	return global.Count(model, options)
+}
+
 func Delete(value interface{}, conditions ...interface{}) error {
 	if err := checkGlobal(); err != nil {
 		return err
 	}
 
-	return global.Delete(value, conditions)
+	return global.Delete(value, conditions...)
+}
+
+func Restore(value interface{}, conditions ...interface{}) error {
+	if err := checkGlobal(); err != nil {
+		return err
+	}
+
+	return global.Restore(value, conditions...)
 }
 
 func Begin() Storage {
@@ -108,6 +210,21 @@ func Commit() error {
 	return global.Commit()
 }
 
+// Transaction 在 context 携带的事务或者全局 storage 上开启事务，
+// 需要其他服务加入同一个事务时，通过 NewContext(ctx, tx) 传递
+func Transaction(ctx context.Context, fn func(tx Storage) error) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	s := FromContext(ctx)
+	if s == nil {
+		return ErrNotinitializedGlobalStorage
+	}
+
+	return s.Transaction(ctx, fn)
+}
+
 func checkGlobal() error {
 	if global == nil {
 		return ErrNotinitializedGlobalStorage
@@ -123,6 +240,15 @@ type condition struct {
 
 type UpdateOptions struct {
 	*condition
+	precondition *precondition
+	selects      []string
+}
+
+// precondition 乐观锁的前置条件，increment 为 true 时更新成功后 column 的值加 1
+type precondition struct {
+	column    string
+	value     interface{}
+	increment bool
 }
 
 func NewUpdateOptions(query interface{}, args ...interface{}) *UpdateOptions {
@@ -131,24 +257,60 @@ func NewUpdateOptions(query interface{}, args ...interface{}) *UpdateOptions {
 	}
 }
 
-type QueryOptions struct {
-	where  *condition
-	not    *condition
-	ors    []*condition
-	order  interface{}
-	limit  int
-	offset int
+// Precondition 只有 column 的值等于 value 时才更新，例如更新前读取到的 updated_at，
+// 没有记录满足条件时 Update 返回 ErrConflict
+func (u *UpdateOptions) Precondition(column string, value interface{}) *UpdateOptions {
+	u.precondition = &precondition{column: column, value: value}
+	return u
+}
+
+// Version 使用版本列实现乐观锁，只有版本等于 version 时才更新，并同时将版本设置为 version+1，
+// values 为结构体时更新成功后其中的版本字段同样为新的版本，没有记录满足条件时 Update 返回 ErrConflict
+func (u *UpdateOptions) Version(column string, version int64) *UpdateOptions {
+	u.precondition = &precondition{column: column, value: version, increment: true}
+	return u
 }
 
-// NewQueryOptionsWithCtx 通过 gin context 获取 options
-// 示例：example.com/users?type=name&q=mioto&createdAt=1649902088&page=20&limit=20
-func NewQueryOptionsWithCtx(ctx *gin.Context) *QueryOptions {
-	// todo: 目前只支持一个字段的模糊查询以及翻页查询
-	// typ := ctx.Query("type")
-	// q := ctx.Query("query")
-	// ctx.BindQuery()
+// Select 只更新 values 中指定的列，指定的列为零值时同样会被更新。
+// 未指定时与 gorm 一致只更新 values 中的非零值字段，版本列以及自动更新时间的列始终会被更新
+func (u *UpdateOptions) Select(columns ...string) *UpdateOptions {
+	u.selects = columns
+	return u
+}
 
-	return NewQueryOptions()
+// GetSelect 返回 Select 指定的列
+func (u *UpdateOptions) GetSelect() []string {
+	return u.selects
+}
+
+// GetPrecondition 返回前置条件，ok 为 false 时没有设置前置条件
+func (u *UpdateOptions) GetPrecondition() (column string, value interface{}, ok bool) {
+	if u.precondition == nil {
+		return "", nil, false
+	}
+
+	return u.precondition.column, u.precondition.value, true
+}
+
+// GetVersion 返回 Version 设置的版本列以及更新后的版本
+func (u *UpdateOptions) GetVersion() (column string, next int64, ok bool) {
+	if u.precondition == nil || !u.precondition.increment {
+		return "", 0, false
+	}
+
+	return u.precondition.column, u.precondition.value.(int64) + 1, true
+}
+
+type QueryOptions struct {
+	wheres   []*condition
+	not      *condition
+	ors      []*condition
+	order    interface{}
+	limit    int
+	offset   int
+	unscoped bool
+	// keyset NewQueryOptionsWithCtx 解析出的游标分页，只在 FindPage 中使用
+	keyset *keyset
 }
 
 func NewQueryOptions() *QueryOptions {
@@ -158,12 +320,13 @@ func NewQueryOptions() *QueryOptions {
 	}
 }
 
-func (q *QueryOptions) GetWhere() *condition {
-	return q.where
+func (q *QueryOptions) GetWheres() []*condition {
+	return q.wheres
 }
 
+// Where 添加查询条件，多次调用时各条件之间为 AND 关系
 func (q *QueryOptions) Where(query interface{}, args ...interface{}) *QueryOptions {
-	q.where = &condition{Query: query, Args: args}
+	q.wheres = append(q.wheres, &condition{Query: query, Args: args})
 	return q
 }
 
@@ -208,6 +371,17 @@ func (q *QueryOptions) Limit(limit int) *QueryOptions {
 	return q
 }
 
+// IsUnscoped 是否包含软删除的记录
+func (q *QueryOptions) IsUnscoped() bool {
+	return q.unscoped
+}
+
+// Unscoped 查询结果包含软删除的记录
+func (q *QueryOptions) Unscoped() *QueryOptions {
+	q.unscoped = true
+	return q
+}
+
 func (q *QueryOptions) GetOffset() int {
 	return q.offset
 }
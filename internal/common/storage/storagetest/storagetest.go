@@ -0,0 +1,491 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+// Package storagetest 为 storage.Storage 的各个实现提供一致性测试，保证不同数据库的行为一致。
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+)
+
+// Document 一致性测试使用的数据模型，对应 documents 表
+type Document struct {
+	ID   string `gorm:"primaryKey"`
+	Name string
+	Age  int
+}
+
+// SoftDocument 软删除一致性测试使用的数据模型，对应 soft_documents 表
+type SoftDocument struct {
+	ID        string `gorm:"primaryKey"`
+	Name      string
+	Deleted   bool
+	DeletedAt int64
+}
+
+func (SoftDocument) SoftDeleteColumns() (string, string) {
+	return "deleted", "deleted_at"
+}
+
+// VersionedDocument 乐观锁一致性测试使用的数据模型，对应 versioned_documents 表
+type VersionedDocument struct {
+	ID      string `gorm:"primaryKey"`
+	Name    string
+	Version int64
+}
+
+// Run 对真实的数据库运行一致性测试，s 需要连接到一个空数据库
+func Run(t *testing.T, s storage.Storage) {
+	assert.NoError(t, s.AutoMigrate(new(Document)))
+	assert.True(t, s.Migrator().HasTable(new(Document)))
+	assert.True(t, s.Migrator().HasColumn(new(Document), "Age"))
+
+	t.Run("Create", func(t *testing.T) {
+		for _, doc := range []*Document{{"a", "alice", 10}, {"b", "bob", 20}, {"c", "carol", 30}} {
+			assert.NoError(t, s.Create(doc))
+		}
+		assert.Error(t, s.Create(&Document{ID: "a", Name: "duplicate"}))
+	})
+
+	t.Run("FindByID", func(t *testing.T) {
+		doc := new(Document)
+		assert.NoError(t, s.FindByID(doc, "b"))
+		assert.Equal(t, &Document{"b", "bob", 20}, doc)
+
+		assert.Equal(t, storage.ErrNotFound, s.FindByID(new(Document), "z"))
+	})
+
+	t.Run("FindByQuery", func(t *testing.T) {
+		tcs := []struct {
+			name     string
+			options  *storage.QueryOptions
+			expected []string
+			err      error
+		}{
+			{"nil options", nil, []string{"a", "b", "c"}, nil},
+			{"where struct", storage.NewQueryOptions().Where(&Document{Name: "bob"}), []string{"b"}, nil},
+			{"where", storage.NewQueryOptions().Where("age > ?", 15).Order("age desc"), []string{"c", "b"}, nil},
+			{"or", storage.NewQueryOptions().Where("name = ?", "alice").Or("name = ?", "carol").Order("id"), []string{"a", "c"}, nil},
+			{"not", storage.NewQueryOptions().Not("name = ?", "alice").Order("id"), []string{"b", "c"}, nil},
+			{"limit offset", storage.NewQueryOptions().Order("age").Limit(1).Offset(1), []string{"b"}, nil},
+			{"multiple where", storage.NewQueryOptions().Where("age > ?", 15).Where(&Document{Name: "carol"}), []string{"c"}, nil},
+			{"like escape", storage.NewQueryOptions().Where("name LIKE ? ESCAPE '!'", "%!_%").Or("name LIKE ? ESCAPE '!'", "a!l%"),
+				[]string{"a"}, nil},
+			{"keyset", storage.NewQueryOptions().Where("((age > ?) OR (age = ? AND id > ?))", 20, 20, "a").Order("age, id"),
+				[]string{"b", "c"}, nil},
+			{"empty", storage.NewQueryOptions().Where("age > ?", 100), []string{}, nil},
+		}
+
+		for _, tc := range tcs {
+			docs := make([]*Document, 0)
+			err := s.FindByQuery(&docs, tc.options)
+			assert.Equal(t, tc.err, err, tc.name)
+
+			ids := make([]string, 0, len(docs))
+			for _, doc := range docs {
+				ids = append(ids, doc.ID)
+			}
+			if tc.options == nil || tc.options.GetOrder() == nil {
+				assert.ElementsMatch(t, tc.expected, ids, tc.name)
+				continue
+			}
+			if tc.expected == nil {
+				tc.expected = []string{}
+			}
+			assert.Equal(t, tc.expected, ids, tc.name)
+		}
+
+		// 查询单条记录时没有记录返回 ErrNotFound
+		assert.Equal(t, storage.ErrNotFound, s.FindByQuery(new(Document), storage.NewQueryOptions().Where("age > ?", 100)))
+	})
+
+	t.Run("Count", func(t *testing.T) {
+		count, err := s.Count(new(Document), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), count)
+
+		// 忽略排序以及分页
+		docs := make([]*Document, 0)
+		count, err = s.Count(&docs, storage.NewQueryOptions().Where("age > ?", 15).Order("age").Limit(1).Offset(1))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+
+		count, err = s.Count(new(Document), storage.NewQueryOptions().Where("age > ?", 100))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		assert.Equal(t, storage.ErrNeedUpdateOptions, s.Update(&Document{ID: "a", Age: 11}, nil))
+
+		// 只更新非零值字段
+		assert.NoError(t, s.Update(&Document{ID: "a", Age: 11}, storage.NewUpdateOptions("id = ?", "a")))
+		doc := new(Document)
+		assert.NoError(t, s.FindByID(doc, "a"))
+		assert.Equal(t, &Document{"a", "alice", 11}, doc)
+
+		// Select 指定的列为零值时同样会被更新，未指定的列不变
+		assert.NoError(t, s.Update(&Document{Name: "ann"}, storage.NewUpdateOptions("id = ?", "a").Select("age")))
+		assert.NoError(t, s.FindByID(doc, "a"))
+		assert.Equal(t, &Document{"a", "alice", 0}, doc)
+		assert.NoError(t, s.Update(&Document{Age: 11}, storage.NewUpdateOptions("id = ?", "a").Select("Age")))
+		assert.NoError(t, s.FindByID(doc, "a"))
+		assert.Equal(t, &Document{"a", "alice", 11}, doc)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.NoError(t, s.Delete(new(Document), "c"))
+		assert.Equal(t, storage.ErrNotFound, s.FindByID(new(Document), "c"))
+	})
+
+	t.Run("Begin", func(t *testing.T) {
+		tx := s.Begin()
+		assert.NoError(t, tx.Create(&Document{ID: "d", Name: "dave", Age: 40}))
+		assert.NoError(t, tx.Commit())
+
+		doc := new(Document)
+		assert.NoError(t, s.FindByID(doc, "d"))
+		assert.Equal(t, "dave", doc.Name)
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		tx := s.Begin()
+		assert.NoError(t, tx.Create(&Document{ID: "e", Name: "eve"}))
+		assert.NoError(t, tx.Rollback())
+		assert.Equal(t, storage.ErrNotFound, s.FindByID(new(Document), "e"))
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		errAbort := errors.New("abort")
+		ctx := context.Background()
+
+		// 返回错误时回滚
+		assert.Equal(t, errAbort, s.Transaction(ctx, func(tx storage.Storage) error {
+			assert.NoError(t, tx.Create(&Document{ID: "f", Name: "frank"}))
+			return errAbort
+		}))
+		assert.Equal(t, storage.ErrNotFound, s.FindByID(new(Document), "f"))
+
+		// panic 时回滚并继续向上抛出
+		assert.Panics(t, func() {
+			_ = s.Transaction(ctx, func(tx storage.Storage) error {
+				assert.NoError(t, tx.Create(&Document{ID: "f", Name: "frank"}))
+				panic(errAbort)
+			})
+		})
+		assert.Equal(t, storage.ErrNotFound, s.FindByID(new(Document), "f"))
+
+		// 嵌套事务回滚到 savepoint，不影响外层事务
+		assert.NoError(t, s.Transaction(ctx, func(tx storage.Storage) error {
+			if err := tx.Create(&Document{ID: "f", Name: "frank"}); err != nil {
+				return err
+			}
+			assert.Equal(t, errAbort, tx.Transaction(ctx, func(tx storage.Storage) error {
+				assert.NoError(t, tx.Create(&Document{ID: "g", Name: "grace"}))
+				return errAbort
+			}))
+			return nil
+		}))
+		assert.NoError(t, s.FindByID(new(Document), "f"))
+		assert.Equal(t, storage.ErrNotFound, s.FindByID(new(Document), "g"))
+
+		// 通过 context 传递的事务与外层事务一同回滚
+		assert.Equal(t, errAbort, s.Transaction(ctx, func(tx storage.Storage) error {
+			ctx := storage.NewContext(ctx, tx)
+			assert.NoError(t, storage.FromContext(ctx).Create(&Document{ID: "h", Name: "heidi"}))
+			return errAbort
+		}))
+		assert.Equal(t, storage.ErrNotFound, s.FindByID(new(Document), "h"))
+	})
+
+	t.Run("SoftDelete", func(t *testing.T) {
+		assert.NoError(t, s.AutoMigrate(new(SoftDocument)))
+		for _, doc := range []*SoftDocument{{ID: "x", Name: "xavier"}, {ID: "y", Name: "yvonne"}, {ID: "z", Name: "zoe"}} {
+			assert.NoError(t, s.Create(doc))
+		}
+
+		assert.NoError(t, s.Delete(new(SoftDocument), "x"))
+		assert.NoError(t, s.Delete(&SoftDocument{ID: "y"}))
+
+		// 默认排除已删除的记录，OR 条件同样受限
+		docs := make([]*SoftDocument, 0)
+		assert.NoError(t, s.FindByQuery(&docs, storage.NewQueryOptions().Or("name = ?", "xavier").Or("name = ?", "zoe")))
+		assert.Len(t, docs, 1)
+		assert.Equal(t, "z", docs[0].ID)
+
+		count, err := s.Count(new(SoftDocument), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		assert.NoError(t, s.FindByQuery(&docs, storage.NewQueryOptions().Where("deleted = ?", true).Order("id").Unscoped()))
+		assert.Len(t, docs, 2)
+		assert.Equal(t, "x", docs[0].ID)
+		assert.True(t, docs[0].Deleted)
+		assert.NotZero(t, docs[0].DeletedAt)
+
+		// FindByID 同样排除已删除的记录，FindUnscopedByID 包含已删除的记录
+		assert.Equal(t, storage.ErrNotFound, s.FindByID(new(SoftDocument), "y"))
+		deleted := new(SoftDocument)
+		assert.NoError(t, s.FindUnscopedByID(deleted, "y"))
+		assert.Equal(t, "yvonne", deleted.Name)
+		assert.True(t, deleted.Deleted)
+
+		assert.NoError(t, s.Restore(new(SoftDocument), "x"))
+		count, err = s.Count(new(SoftDocument), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+
+		doc := new(SoftDocument)
+		assert.NoError(t, s.FindByID(doc, "x"))
+		assert.Equal(t, &SoftDocument{ID: "x", Name: "xavier"}, doc)
+
+		assert.Equal(t, storage.ErrNotSoftDeletable, s.Restore(new(Document), "a"))
+	})
+
+	t.Run("Version", func(t *testing.T) {
+		assert.NoError(t, s.AutoMigrate(new(VersionedDocument)))
+		assert.NoError(t, s.Create(&VersionedDocument{ID: "v", Name: "victor", Version: 1}))
+
+		// 更新成功后 values 中为新的版本
+		doc := &VersionedDocument{ID: "v", Name: "vince"}
+		assert.NoError(t, s.Update(doc, storage.NewUpdateOptions("id = ?", "v").Version("version", 1)))
+		assert.Equal(t, int64(2), doc.Version)
+
+		// 版本已经变化，更新失败并还原 values 中的版本
+		stale := &VersionedDocument{ID: "v", Name: "vera"}
+		assert.Equal(t, storage.ErrConflict, s.Update(stale, storage.NewUpdateOptions("id = ?", "v").Version("version", 1)))
+		assert.Equal(t, int64(0), stale.Version)
+
+		stored := new(VersionedDocument)
+		assert.NoError(t, s.FindByID(stored, "v"))
+		assert.Equal(t, &VersionedDocument{"v", "vince", 2}, stored)
+
+		assert.NoError(t, s.Update(&VersionedDocument{ID: "v", Name: "vivian"},
+			storage.NewUpdateOptions("id = ?", "v").Precondition("name", "vince")))
+		assert.Equal(t, storage.ErrConflict, s.Update(&VersionedDocument{ID: "v", Name: "vera"},
+			storage.NewUpdateOptions("id = ?", "v").Precondition("name", "vince")))
+
+		// 事务中的冲突不影响事务之外的数据
+		assert.Equal(t, storage.ErrConflict, s.Transaction(context.Background(), func(tx storage.Storage) error {
+			return tx.Update(&VersionedDocument{ID: "v", Name: "vera"}, storage.NewUpdateOptions("id = ?", "v").Version("version", 1))
+		}))
+		assert.NoError(t, s.FindByID(stored, "v"))
+		assert.Equal(t, &VersionedDocument{"v", "vivian", 2}, stored)
+
+		// Select 时版本列同样会被更新
+		doc = &VersionedDocument{}
+		assert.NoError(t, s.Update(doc, storage.NewUpdateOptions("id = ?", "v").Version("version", 2).Select("name")))
+		assert.Equal(t, int64(3), doc.Version)
+		assert.NoError(t, s.FindByID(stored, "v"))
+		assert.Equal(t, &VersionedDocument{"v", "", 3}, stored)
+	})
+}
+
+// RunMock 使用 go-sqlmock 运行一致性测试，校验各个驱动生成的 SQL 以及错误处理是否一致。
+// newMock 每次需要返回一个新的 storage 以及对应的 mock，SQL 中的标识符引号因驱动而异，因此使用 . 匹配
+func RunMock(t *testing.T, newMock func(t *testing.T) (storage.Storage, sqlmock.Sqlmock)) {
+	errDB := errors.New("database error")
+
+	t.Run("Create", func(t *testing.T) {
+		s, mock := newMock(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO .documents.").
+			WithArgs("a", "alice", 10).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		assert.NoError(t, s.Create(&Document{"a", "alice", 10}))
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO .documents.").WillReturnError(errDB)
+		mock.ExpectRollback()
+		assert.Equal(t, errDB, s.Create(&Document{"a", "alice", 10}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("FindByID", func(t *testing.T) {
+		s, mock := newMock(t)
+		mock.ExpectQuery("SELECT \\* FROM .documents. WHERE .documents.\\..id. (=|IN)").
+			WithArgs("b").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age"}).AddRow("b", "bob", 20))
+		doc := new(Document)
+		assert.NoError(t, s.FindByID(doc, "b"))
+		assert.Equal(t, &Document{"b", "bob", 20}, doc)
+
+		mock.ExpectQuery("SELECT \\* FROM .documents.").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age"}))
+		assert.Equal(t, storage.ErrNotFound, s.FindByID(new(Document), "z"))
+
+		mock.ExpectQuery("SELECT \\* FROM .documents.").WillReturnError(errDB)
+		assert.Equal(t, errDB, s.FindByID(new(Document), "z"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("FindByQuery", func(t *testing.T) {
+		s, mock := newMock(t)
+		mock.ExpectQuery("SELECT \\* FROM .documents. WHERE age > .+ OR name = .+ AND NOT name = .+ ORDER BY age desc LIMIT 1").
+			WithArgs(15, "alice", "carol").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age"}).AddRow("c", "carol", 30))
+		docs := make([]*Document, 0)
+		assert.NoError(t, s.FindByQuery(&docs, storage.NewQueryOptions().
+			Where("age > ?", 15).Or("name = ?", "alice").Not("name = ?", "carol").Order("age desc").Limit(1)))
+		assert.Equal(t, []*Document{{"c", "carol", 30}}, docs)
+
+		mock.ExpectQuery("SELECT \\* FROM .documents.").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age"}))
+		assert.NoError(t, s.FindByQuery(&docs, nil))
+		assert.Empty(t, docs)
+
+		mock.ExpectQuery("SELECT \\* FROM .documents.").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "age"}))
+		assert.Equal(t, storage.ErrNotFound, s.FindByQuery(new(Document), nil))
+
+		mock.ExpectQuery("SELECT \\* FROM .documents.").WillReturnError(errDB)
+		assert.Equal(t, errDB, s.FindByQuery(&docs, nil))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Count", func(t *testing.T) {
+		s, mock := newMock(t)
+		mock.ExpectQuery("SELECT count\\(\\*\\) FROM .documents. WHERE age > .+ AND .documents.\\..name. = .+").
+			WithArgs(15, "carol").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		count, err := s.Count(new(Document), storage.NewQueryOptions().
+			Where("age > ?", 15).Where(&Document{Name: "carol"}).Order("age").Limit(1))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		mock.ExpectQuery("SELECT count\\(\\*\\) FROM .documents.").WillReturnError(errDB)
+		_, err = s.Count(new(Document), nil)
+		assert.Equal(t, errDB, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		s, mock := newMock(t)
+		assert.Equal(t, storage.ErrNeedUpdateOptions, s.Update(&Document{ID: "a", Age: 11}, nil))
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE .documents. SET .age.=.+ WHERE id = .+ AND .id. = .+").
+			WithArgs(11, "a", "a").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		assert.NoError(t, s.Update(&Document{ID: "a", Age: 11}, storage.NewUpdateOptions("id = ?", "a")))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s, mock := newMock(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM .documents. WHERE .documents.\\..id. (=|IN)").
+			WithArgs("c").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		assert.NoError(t, s.Delete(new(Document), "c"))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("SoftDelete", func(t *testing.T) {
+		s, mock := newMock(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE .soft_documents. SET .deleted.=.+,.deleted_at.=.+ WHERE .soft_documents.\\..id. (=|IN)").
+			WithArgs(true, sqlmock.AnyArg(), "x").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		assert.NoError(t, s.Delete(new(SoftDocument), "x"))
+
+		mock.ExpectQuery("SELECT \\* FROM .soft_documents. WHERE \\(name = .+ OR name = .+\\) AND .deleted. = .+").
+			WithArgs("xavier", "zoe", false).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow("z", "zoe"))
+		docs := make([]*SoftDocument, 0)
+		assert.NoError(t, s.FindByQuery(&docs, storage.NewQueryOptions().Or("name = ?", "xavier").Or("name = ?", "zoe")))
+
+		mock.ExpectQuery("SELECT \\* FROM .soft_documents. WHERE .deleted. = .+ AND .soft_documents.\\..id. (=|IN)").
+			WithArgs(false, "x").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+		assert.Equal(t, storage.ErrNotFound, s.FindByID(new(SoftDocument), "x"))
+
+		mock.ExpectQuery("SELECT \\* FROM .soft_documents. WHERE .soft_documents.\\..id. (=|IN)").
+			WithArgs("x").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "deleted"}).AddRow("x", "xavier", true))
+		doc := new(SoftDocument)
+		assert.NoError(t, s.FindUnscopedByID(doc, "x"))
+		assert.True(t, doc.Deleted)
+
+		mock.ExpectQuery("SELECT count\\(\\*\\) FROM .soft_documents.$").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+		_, err := s.Count(new(SoftDocument), storage.NewQueryOptions().Unscoped())
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Version", func(t *testing.T) {
+		s, mock := newMock(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE .versioned_documents. SET .name.=.+,.version.=.+ WHERE id = .+ AND .version. = .+").
+			WithArgs("vince", 2, "v", 1, "v").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		doc := &VersionedDocument{ID: "v", Name: "vince"}
+		assert.NoError(t, s.Update(doc, storage.NewUpdateOptions("id = ?", "v").Version("version", 1)))
+		assert.Equal(t, int64(2), doc.Version)
+
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE .versioned_documents.").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+		doc = &VersionedDocument{ID: "v", Name: "vera"}
+		assert.Equal(t, storage.ErrConflict, s.Update(doc, storage.NewUpdateOptions("id = ?", "v").Version("version", 1)))
+		assert.Equal(t, int64(0), doc.Version)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Begin", func(t *testing.T) {
+		s, mock := newMock(t)
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO .documents.").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		tx := s.Begin()
+		assert.NoError(t, tx.Create(&Document{"d", "dave", 40}))
+		assert.NoError(t, tx.Commit())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		s, mock := newMock(t)
+		ctx := context.Background()
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO .documents.").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		assert.NoError(t, s.Transaction(ctx, func(tx storage.Storage) error {
+			return tx.Create(&Document{"d", "dave", 40})
+		}))
+
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO .documents.").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("SAVEPOINT").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO .documents.").WillReturnError(errDB)
+		mock.ExpectExec("ROLLBACK TO SAVEPOINT").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+		assert.Equal(t, errDB, s.Transaction(ctx, func(tx storage.Storage) error {
+			if err := tx.Create(&Document{"e", "eve", 50}); err != nil {
+				return err
+			}
+			return tx.Transaction(ctx, func(tx storage.Storage) error {
+				return tx.Create(&Document{"f", "frank", 60})
+			})
+		}))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -11,11 +11,33 @@ package errors
 type Code int
 
 const (
-	ErrServerUnknownError   Code = 100001
-	ErrUnauthorized         Code = 100002
-	ErrForbidden            Code = 100003
-	ErrBadRequestParameters Code = 100004
+	ErrServerUnknownError    Code = 100001
+	ErrUnauthorized          Code = 100002
+	ErrForbidden             Code = 100003
+	ErrBadRequestParameters  Code = 100004
+	ErrConflict              Code = 100005
+	ErrPreconditionFailed    Code = 100006
+	ErrRequestEntityTooLarge Code = 100007
 
-	ErrUserNotFount        Code = 200001
-	ErrUserCreateVerifying Code = 200002
+	ErrUserNotFount                Code = 200001
+	ErrUserCreateVerifying         Code = 200002
+	ErrUserDeactivated             Code = 200003
+	ErrUserEmergencyAccessNotFound Code = 200004
+
+	ErrOrganizationNotFound                 Code = 300001
+	ErrOrganizationWrongTransactionPassword Code = 300002
+	ErrOrganizationUserNotFound             Code = 300003
+
+	ErrIdentityNotFound         Code = 400001
+	ErrIdentityInvalidSignature Code = 400002
+	ErrIdentityAlreadyExists    Code = 400003
+
+	ErrChannelNotFound           Code = 500001
+	ErrChannelAlreadyExists      Code = 500002
+	ErrChannelUpdateNotFound     Code = 500003
+	ErrChannelUpdateNotSatisfied Code = 500004
+	ErrChannelConfigChanged      Code = 500005
+
+	ErrTransactionNotFound                Code = 600001
+	ErrTransactionInvalidProposalResponse Code = 600002
 )
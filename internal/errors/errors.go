@@ -10,6 +10,7 @@ package errors
 
 import (
 	"fmt"
+	"net/http"
 )
 
 type Error struct {
@@ -37,3 +38,20 @@ func NewErrorf(status int, code Code, format string, a ...interface{}) *Error {
 		Message:    fmt.Sprintf(format, a...),
 	}
 }
+
+// StatusCode 返回 *Error 的 HTTP 状态码，err 不是 *Error 时返回 0
+func StatusCode(err error) int {
+	if e, ok := err.(*Error); ok {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// NewConflictError 以版本作为前置条件更新资源时发生冲突的错误，
+// ifMatch 为 true 时客户端指定了 If-Match，视为前置条件失败，否则为与其他请求同时修改，客户端可以重试
+func NewConflictError(resource string, ifMatch bool) *Error {
+	if ifMatch {
+		return NewErrorf(http.StatusPreconditionFailed, ErrPreconditionFailed, "%s has been modified", resource)
+	}
+	return NewErrorf(http.StatusConflict, ErrConflict, "%s has been modified concurrently, please retry", resource)
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+// Package migrations Alkaid 的表结构迁移，新的迁移追加到 Migrations 末尾，已发布的迁移不能修改版本、名称以及内容。
+// 建表以及添加列均使用 snapshots.go 中每个迁移各自的快照结构，不依赖当前的数据模型。
+package migrations
+
+import (
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/migrate"
+)
+
+var Migrations = []*migrate.Migration{
+	{
+		Version: "20220501000000",
+		Name:    "initial_schema",
+		Content: migrate.Describe(initialModels()...),
+		Up: func(tx storage.Storage) error {
+			return tx.Migrator().AutoMigrate(initialModels()...)
+		},
+		Down: func(tx storage.Storage) error {
+			return tx.Migrator().DropTable(initialModels()...)
+		},
+	},
+	{
+		Version: "20220601000000",
+		Name:    "add_version_columns",
+		Content: migrate.Describe(versionModels()...),
+		Up: func(tx storage.Storage) error {
+			for _, model := range versionModels() {
+				if err := addColumns(tx, model, "Version"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx storage.Storage) error {
+			for _, model := range versionModels() {
+				if err := dropColumns(tx, model, "Version"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: "20220701000000",
+		Name:    "add_organization_crypto_suite",
+		Content: migrate.Describe(new(cryptoSuiteOrganization)),
+		Up: func(tx storage.Storage) error {
+			// 已有的组织均使用 ECDSA P-256，列默认值保持一致
+			return addColumns(tx, new(cryptoSuiteOrganization), "CryptoSuite")
+		},
+		Down: func(tx storage.Storage) error {
+			return dropColumns(tx, new(cryptoSuiteOrganization), "CryptoSuite")
+		},
+	},
+	{
+		Version: "20220801000000",
+		Name:    "add_organization_key_store",
+		Content: migrate.Describe(new(keyStoreOrganization)),
+		Up: func(tx storage.Storage) error {
+			// 已有组织的私钥均保存在数据库中
+			return addColumns(tx, new(keyStoreOrganization), "KeyStore")
+		},
+		Down: func(tx storage.Storage) error {
+			return dropColumns(tx, new(keyStoreOrganization), "KeyStore")
+		},
+	},
+	{
+		Version: "20220901000000",
+		Name:    "add_organization_data_key",
+		Content: migrate.Describe(new(dataKeyOrganization)),
+		Up: func(tx storage.Storage) error {
+			return addColumns(tx, new(dataKeyOrganization), "ProtectedDataKey")
+		},
+		Down: func(tx storage.Storage) error {
+			return dropColumns(tx, new(dataKeyOrganization), "ProtectedDataKey")
+		},
+	},
+	{
+		Version: "20221001000000",
+		Name:    "add_user_recovery",
+		Content: migrate.Describe(new(recoveryUser), new(recoveryEmergencyAccess)),
+		Up: func(tx storage.Storage) error {
+			// 已有用户没有恢复码，需要登录后重新生成
+			if err := addColumns(tx, new(recoveryUser), "ProtectedRecoveryKey"); err != nil {
+				return err
+			}
+			if tx.Migrator().HasTable(new(recoveryEmergencyAccess)) {
+				return nil
+			}
+			return tx.Migrator().AutoMigrate(new(recoveryEmergencyAccess))
+		},
+		Down: func(tx storage.Storage) error {
+			if err := tx.Migrator().DropTable(new(recoveryEmergencyAccess)); err != nil {
+				return err
+			}
+			return dropColumns(tx, new(recoveryUser), "ProtectedRecoveryKey")
+		},
+	},
+	{
+		Version: "20221101000000",
+		Name:    "add_user_kdf",
+		Content: migrate.Describe(new(kdfUser)),
+		Up: func(tx storage.Storage) error {
+			// 已有用户使用列默认值，即 100000 次迭代的 PBKDF2，与之前的 GetMasterKey 一致
+			return addColumns(tx, new(kdfUser), kdfUserColumns...)
+		},
+		Down: func(tx storage.Storage) error {
+			return dropColumns(tx, new(kdfUser), kdfUserColumns...)
+		},
+	},
+	{
+		Version: "20221201000000",
+		Name:    "add_account_version_columns",
+		Content: migrate.Describe(accountVersionModels()...),
+		Up: func(tx storage.Storage) error {
+			// 已有记录的版本为列默认值 1
+			for _, model := range accountVersionModels() {
+				if err := addColumns(tx, model, "Version"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx storage.Storage) error {
+			for _, model := range accountVersionModels() {
+				if err := dropColumns(tx, model, "Version"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+}
+
+// kdfUserColumns add_user_kdf 添加的用户 KDF 参数的列
+var kdfUserColumns = []string{"KDF", "KDFIterations", "KDFMemory", "KDFThreads"}
+
+// initialModels 替换 AutoMigrate 时全部数据模型的快照，已有的数据库执行该迁移时不会丢失数据
+func initialModels() []interface{} {
+	return []interface{}{
+		new(initialSystem),
+		new(initialUser),
+		new(initialUserOrganizations),
+		new(initialOrganization),
+		new(initialIdentity),
+		new(initialChannel),
+		new(initialConfigUpdate),
+		new(initialTransaction),
+	}
+}
+
+// versionModels add_version_columns 的快照结构，使用版本列实现乐观锁的数据模型
+func versionModels() []interface{} {
+	return []interface{}{
+		new(versionIdentity),
+		new(versionChannel),
+		new(versionConfigUpdate),
+		new(versionTransaction),
+	}
+}
+
+// accountVersionModels add_account_version_columns 的快照结构，在 add_version_columns 之后才使用版本列的用户、成员关系以及组织
+func accountVersionModels() []interface{} {
+	return []interface{}{
+		new(accountVersionUser),
+		new(accountVersionUserOrganizations),
+		new(accountVersionOrganization),
+	}
+}
+
+// addColumns 添加快照结构中的列，已经存在的列直接跳过
+func addColumns(tx storage.Storage, model interface{}, fields ...string) error {
+	for _, field := range fields {
+		if tx.Migrator().HasColumn(model, field) {
+			continue
+		}
+		if err := tx.Migrator().AddColumn(model, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropColumns 删除快照结构中的列
+func dropColumns(tx storage.Storage, model interface{}, fields ...string) error {
+	for _, field := range fields {
+		if err := tx.Migrator().DropColumn(model, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// New 使用全部迁移创建迁移工具
+func New(db storage.Storage) (*migrate.Migrator, error) {
+	return migrate.New(db, Migrations...)
+}
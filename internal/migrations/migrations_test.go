@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package migrations
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/storage/sqlite3"
+	"github.com/yakumioto/alkaid/internal/services/channels"
+	"github.com/yakumioto/alkaid/internal/services/identities"
+	"github.com/yakumioto/alkaid/internal/services/organizations"
+	"github.com/yakumioto/alkaid/internal/services/systems"
+	"github.com/yakumioto/alkaid/internal/services/transactions"
+	"github.com/yakumioto/alkaid/internal/services/users"
+	"gorm.io/gorm/schema"
+)
+
+// TestMigrations 执行全部迁移后的表结构需要包含当前数据模型的所有列
+func TestMigrations(t *testing.T) {
+	db, err := sqlite3.NewDB(filepath.Join(t.TempDir(), "alkaid.db"))
+	assert.NoError(t, err)
+	m, err := New(db)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = m.Up(ctx, false)
+	assert.NoError(t, err)
+
+	models := []interface{}{
+		new(systems.System),
+		new(users.User),
+		new(users.UserOrganizations),
+		new(users.EmergencyAccess),
+		new(organizations.Organization),
+		new(identities.Identity),
+		new(channels.Channel),
+		new(channels.ConfigUpdate),
+		new(transactions.Transaction),
+	}
+	for _, model := range models {
+		sch, err := schema.Parse(model, new(sync.Map), schema.NamingStrategy{})
+		assert.NoError(t, err)
+		assert.True(t, db.Migrator().HasTable(model), sch.Table)
+		for _, field := range sch.Fields {
+			if field.DBName != "" {
+				assert.True(t, db.Migrator().HasColumn(model, field.DBName), sch.Table+"."+field.DBName)
+			}
+		}
+	}
+
+	_, err = m.Down(ctx, len(Migrations), false)
+	assert.NoError(t, err)
+	for _, model := range models {
+		assert.False(t, db.Migrator().HasTable(model))
+	}
+}
@@ -0,0 +1,300 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package migrations
+
+// 迁移建表以及添加列时使用的快照结构，只包含迁移时的列以及 gorm 标签，已发布迁移的快照结构不能修改。
+// 数据模型后续新增的列需要通过新的迁移添加
+
+// initial_schema 的快照结构，与替换 AutoMigrate 时的数据模型一致
+
+type initialSystem struct {
+	Key       string `gorm:"primaryKey"`
+	Value     string
+	CreatedAt int64 `gorm:"autoCreateTime"`
+	UpdatedAt int64 `gorm:"autoUpdateTime"`
+}
+
+func (initialSystem) TableName() string {
+	return "systems"
+}
+
+type initialUser struct {
+	ResourceID              string `gorm:"primaryKey"`
+	UserID                  string `gorm:"uniqueIndex"`
+	Name                    string
+	Email                   string `gorm:"uniqueIndex"`
+	Password                string
+	Root                    bool
+	ProtectedSymmetricKey   string
+	ProtectedSignPrivateKey string
+	SignPublicKey           string
+	ProtectedTLSPrivateKey  string
+	TLSPublicKey            string
+	ProtectedRSAPrivateKey  string
+	RSAPublicKey            string
+	Deactivate              bool
+	CreatedAt               int64 `gorm:"autoCreateTime"`
+	UpdatedAt               int64 `gorm:"autoUpdateTime"`
+	DeactivateAt            int64
+}
+
+func (initialUser) TableName() string {
+	return "users"
+}
+
+type initialUserOrganizations struct {
+	ResourceID     string `gorm:"primaryKey"`
+	UserID         string `gorm:"index"`
+	OrganizationID string `gorm:"index"`
+	Role           int
+	Status         string
+	Deactivate     bool
+	CreatedAt      int64 `gorm:"autoCreateTime"`
+	UpdatedAt      int64 `gorm:"autoUpdateTime"`
+	DeactivateAt   int64
+}
+
+func (initialUserOrganizations) TableName() string {
+	return "user_organizations"
+}
+
+type initialOrganization struct {
+	ResourceID                string `gorm:"primaryKey"`
+	OrganizationID            string `gorm:"uniqueIndex"`
+	Name                      string
+	Domain                    string `gorm:"uniqueIndex"`
+	Description               string
+	Country                   string
+	Province                  string
+	Locality                  string
+	OrganizationalUnit        string
+	StreetAddress             string
+	PostalCode                string
+	ProtectedSignCAPrivateKey string
+	ProtectedTLSCAPrivateKey  string
+	SignCACertificate         string
+	TlsCACertificate          string
+	CreatedAt                 int64
+	UpdatedAt                 int64
+}
+
+func (initialOrganization) TableName() string {
+	return "organizations"
+}
+
+type initialIdentity struct {
+	ResourceID              string `gorm:"primaryKey"`
+	IdentityID              string `gorm:"uniqueIndex"`
+	OrganizationID          string `gorm:"index"`
+	UserID                  string `gorm:"index"`
+	Name                    string
+	Use                     string
+	Type                    string
+	Description             string
+	NodeOUs                 bool
+	SANs                    string `gorm:"type:text"`
+	ProtectedSignPrivateKey string
+	ProtectedTLSPrivateKey  string
+	SignCertificate         string
+	TLSCertificate          string
+	CreatedAt               int64 `gorm:"autoCreateTime"`
+	UpdatedAt               int64 `gorm:"autoUpdateTime"`
+}
+
+func (initialIdentity) TableName() string {
+	return "identities"
+}
+
+type initialChannel struct {
+	ResourceID     string `gorm:"primaryKey"`
+	ChannelID      string `gorm:"uniqueIndex:idx_network_channel"`
+	NetworkID      string `gorm:"uniqueIndex:idx_network_channel"`
+	OrganizationID string `gorm:"index"`
+	Description    string
+	GenesisBlock   []byte
+	Config         []byte
+	CreatedAt      int64 `gorm:"autoCreateTime"`
+	UpdatedAt      int64 `gorm:"autoUpdateTime"`
+}
+
+func (initialChannel) TableName() string {
+	return "channels"
+}
+
+type initialConfigUpdate struct {
+	ResourceID           string `gorm:"primaryKey"`
+	ChannelID            string `gorm:"index"`
+	NetworkID            string `gorm:"index"`
+	OrganizationID       string
+	UserID               string
+	Description          string
+	Status               string
+	Sequence             uint64
+	ConfigUpdateEnvelope []byte
+	Config               []byte
+	Envelope             []byte
+	CreatedAt            int64 `gorm:"autoCreateTime"`
+	UpdatedAt            int64 `gorm:"autoUpdateTime"`
+}
+
+func (initialConfigUpdate) TableName() string {
+	return "config_updates"
+}
+
+type initialTransaction struct {
+	ResourceID     string `gorm:"primaryKey"`
+	TxID           string `gorm:"uniqueIndex"`
+	NetworkID      string `gorm:"index"`
+	ChannelID      string `gorm:"index"`
+	ContractID     string
+	OrganizationID string
+	UserID         string `gorm:"index"`
+	IdentityID     string
+	Function       string
+	Status         string
+	Proposal       []byte
+	SignedProposal []byte
+	Envelope       []byte
+	CreatedAt      int64 `gorm:"autoCreateTime"`
+	UpdatedAt      int64 `gorm:"autoUpdateTime"`
+}
+
+func (initialTransaction) TableName() string {
+	return "transactions"
+}
+
+// add_version_columns 的快照结构
+
+type versionIdentity struct {
+	Version int64 `gorm:"default:1"`
+}
+
+func (versionIdentity) TableName() string {
+	return "identities"
+}
+
+type versionChannel struct {
+	Version int64 `gorm:"default:1"`
+}
+
+func (versionChannel) TableName() string {
+	return "channels"
+}
+
+type versionConfigUpdate struct {
+	Version int64 `gorm:"default:1"`
+}
+
+func (versionConfigUpdate) TableName() string {
+	return "config_updates"
+}
+
+type versionTransaction struct {
+	Version int64 `gorm:"default:1"`
+}
+
+func (versionTransaction) TableName() string {
+	return "transactions"
+}
+
+// add_organization_crypto_suite 的快照结构
+
+type cryptoSuiteOrganization struct {
+	CryptoSuite string `gorm:"default:ECDSA_P256"`
+}
+
+func (cryptoSuiteOrganization) TableName() string {
+	return "organizations"
+}
+
+// add_organization_key_store 的快照结构
+
+type keyStoreOrganization struct {
+	KeyStore string `gorm:"default:SW"`
+}
+
+func (keyStoreOrganization) TableName() string {
+	return "organizations"
+}
+
+// add_organization_data_key 的快照结构
+
+type dataKeyOrganization struct {
+	ProtectedDataKey string
+}
+
+func (dataKeyOrganization) TableName() string {
+	return "organizations"
+}
+
+// add_user_recovery 的快照结构
+
+type recoveryUser struct {
+	ProtectedRecoveryKey string
+}
+
+func (recoveryUser) TableName() string {
+	return "users"
+}
+
+type recoveryEmergencyAccess struct {
+	ResourceID            string `gorm:"primaryKey"`
+	UserID                string `gorm:"index"`
+	GranteeID             string `gorm:"index"`
+	WaitTime              int64
+	Status                string
+	ProtectedSymmetricKey string
+	RecoveryInitiatedAt   int64
+	Version               int64 `gorm:"default:1"`
+	CreatedAt             int64 `gorm:"autoCreateTime"`
+	UpdatedAt             int64 `gorm:"autoUpdateTime"`
+}
+
+func (recoveryEmergencyAccess) TableName() string {
+	return "emergency_accesses"
+}
+
+// add_user_kdf 的快照结构
+
+type kdfUser struct {
+	KDF           string `gorm:"default:pbkdf2-sha256"`
+	KDFIterations int    `gorm:"default:100000"`
+	KDFMemory     int
+	KDFThreads    int
+}
+
+func (kdfUser) TableName() string {
+	return "users"
+}
+
+// add_account_version_columns 的快照结构
+
+type accountVersionUser struct {
+	Version int64 `gorm:"default:1"`
+}
+
+func (accountVersionUser) TableName() string {
+	return "users"
+}
+
+type accountVersionUserOrganizations struct {
+	Version int64 `gorm:"default:1"`
+}
+
+func (accountVersionUserOrganizations) TableName() string {
+	return "user_organizations"
+}
+
+type accountVersionOrganization struct {
+	Version int64 `gorm:"default:1"`
+}
+
+func (accountVersionOrganization) TableName() string {
+	return "organizations"
+}
@@ -0,0 +1,592 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/restful"
+	"github.com/yakumioto/alkaid/internal/services/channels"
+	"github.com/yakumioto/alkaid/internal/versions"
+)
+
+type CreateChannel struct {
+}
+
+func (c *CreateChannel) Name() string {
+	return "create_channel"
+}
+
+func (c *CreateChannel) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels"
+}
+
+func (c *CreateChannel) Method() string {
+	return http.MethodPost
+}
+
+func (c *CreateChannel) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(channels.CreateRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		req.OrganizationID = ctx.Param("organizationId")
+		req.NetworkID = ctx.Param("networkId")
+
+		channel, err := channels.Create(req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(channel)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetChannelList struct {
+}
+
+func (c *GetChannelList) Name() string {
+	return "get_channel_list"
+}
+
+func (c *GetChannelList) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels"
+}
+
+func (c *GetChannelList) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetChannelList) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		list, err := channels.GetList(ctx.Param("networkId"))
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(list)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetChannelDetailByID struct {
+}
+
+func (c *GetChannelDetailByID) Name() string {
+	return "get_channel_by_id"
+}
+
+func (c *GetChannelDetailByID) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId"
+}
+
+func (c *GetChannelDetailByID) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetChannelDetailByID) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		channel, err := channels.GetDetailByID(ctx.Param("networkId"), ctx.Param("channelId"))
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, channel.Version)
+		ctx.Render(channel)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetChannelGenesisBlock struct {
+}
+
+func (c *GetChannelGenesisBlock) Name() string {
+	return "get_channel_genesis_block"
+}
+
+func (c *GetChannelGenesisBlock) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId/genesis"
+}
+
+func (c *GetChannelGenesisBlock) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetChannelGenesisBlock) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		channelID := ctx.Param("channelId")
+
+		block, err := channels.GetGenesisBlock(ctx.Param("networkId"), channelID)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		renderProto(ctx, block, channelID+".block")
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetChannelConfig struct {
+}
+
+func (c *GetChannelConfig) Name() string {
+	return "get_channel_config"
+}
+
+func (c *GetChannelConfig) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId/config"
+}
+
+func (c *GetChannelConfig) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetChannelConfig) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		channelID := ctx.Param("channelId")
+
+		config, err := channels.GetConfig(ctx.Param("networkId"), channelID)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		renderProto(ctx, config, channelID+"-config.pb")
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type ProposeChannelUpdate struct {
+}
+
+func (c *ProposeChannelUpdate) Name() string {
+	return "propose_channel_update"
+}
+
+func (c *ProposeChannelUpdate) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId/updates"
+}
+
+func (c *ProposeChannelUpdate) Method() string {
+	return http.MethodPost
+}
+
+func (c *ProposeChannelUpdate) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(channels.ProposeUpdateRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		req.OrganizationID = ctx.Param("organizationId")
+		req.NetworkID = ctx.Param("networkId")
+		req.ChannelID = ctx.Param("channelId")
+		if userCtx := getUserContext(ctx); userCtx != nil {
+			req.UserID = userCtx.ID
+		}
+
+		update, err := channels.ProposeUpdate(req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(update)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetChannelUpdateList struct {
+}
+
+func (c *GetChannelUpdateList) Name() string {
+	return "get_channel_update_list"
+}
+
+func (c *GetChannelUpdateList) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId/updates"
+}
+
+func (c *GetChannelUpdateList) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetChannelUpdateList) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		list, err := channels.GetUpdateList(ctx.Param("networkId"), ctx.Param("channelId"))
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(list)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetChannelUpdateDetailByID struct {
+}
+
+func (c *GetChannelUpdateDetailByID) Name() string {
+	return "get_channel_update_by_id"
+}
+
+func (c *GetChannelUpdateDetailByID) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId/updates/:updateId"
+}
+
+func (c *GetChannelUpdateDetailByID) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetChannelUpdateDetailByID) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		update, err := channels.GetUpdateDetailByID(ctx.Param("networkId"), ctx.Param("channelId"),
+			ctx.Param("updateId"))
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, update.Version)
+		ctx.Render(update)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetChannelUpdateEnvelope struct {
+}
+
+func (c *GetChannelUpdateEnvelope) Name() string {
+	return "get_channel_update_envelope"
+}
+
+func (c *GetChannelUpdateEnvelope) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId/updates/:updateId/config-update"
+}
+
+func (c *GetChannelUpdateEnvelope) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetChannelUpdateEnvelope) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		id := ctx.Param("updateId")
+
+		env, err := channels.GetUpdateEnvelope(ctx.Param("networkId"), ctx.Param("channelId"), id)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		renderProto(ctx, env, id+".pb")
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type SignChannelUpdate struct {
+}
+
+func (c *SignChannelUpdate) Name() string {
+	return "sign_channel_update"
+}
+
+func (c *SignChannelUpdate) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId/updates/:updateId/signatures"
+}
+
+func (c *SignChannelUpdate) Method() string {
+	return http.MethodPost
+}
+
+func (c *SignChannelUpdate) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(channels.SignUpdateRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		update, err := channels.SignUpdate(getUserContext(ctx), ctx.Param("networkId"), ctx.Param("channelId"),
+			ctx.Param("updateId"), req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(update)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetChannelUpdateSigningRequest struct {
+}
+
+func (c *GetChannelUpdateSigningRequest) Name() string {
+	return "get_channel_update_signing_request"
+}
+
+func (c *GetChannelUpdateSigningRequest) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId/updates/:updateId/signing-request"
+}
+
+func (c *GetChannelUpdateSigningRequest) Method() string {
+	return http.MethodPost
+}
+
+func (c *GetChannelUpdateSigningRequest) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(channels.UpdateSigningRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		signingRequest, err := channels.GetUpdateSigningRequest(getUserContext(ctx), ctx.Param("networkId"),
+			ctx.Param("channelId"), ctx.Param("updateId"), req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(signingRequest)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type SubmitChannelUpdate struct {
+}
+
+func (c *SubmitChannelUpdate) Name() string {
+	return "submit_channel_update"
+}
+
+func (c *SubmitChannelUpdate) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId/updates/:updateId/envelope"
+}
+
+func (c *SubmitChannelUpdate) Method() string {
+	return http.MethodPost
+}
+
+func (c *SubmitChannelUpdate) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(channels.SubmitUpdateRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		id := ctx.Param("updateId")
+		tx, err := channels.SubmitUpdate(ctx, getUserContext(ctx), ctx.Param("networkId"), ctx.Param("channelId"), id, req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		renderProto(ctx, tx, id+".tx")
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
@@ -8,12 +8,138 @@
 
 package controllers
 
-import "github.com/yakumioto/alkaid/internal/common/log"
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/yakumioto/alkaid/internal/common/configtx"
+	"github.com/yakumioto/alkaid/internal/common/log"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/restful"
+	"github.com/yakumioto/alkaid/internal/services/users"
+)
 
 var (
 	logger = log.GetPackageLogger("restful.controllers")
 )
 
+// getQueryOptions 解析列表接口的过滤、排序以及翻页参数，
+// unscoped=true 时包含已停用的记录，只有 root 用户可以使用
+func getQueryOptions(ctx *restful.Context, schema *storage.Schema) (*storage.QueryOptions, error) {
+	options, err := storage.NewQueryOptionsWithCtx(ctx.Context, schema)
+	if err != nil {
+		logger.Warnf("parse query options error: %v", err)
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters, err.Error())
+	}
+
+	if value := ctx.Query("unscoped"); value != "" {
+		unscoped, err := strconv.ParseBool(value)
+		if err != nil {
+			logger.Warnf("parse unscoped error: %v", err)
+			return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"unscoped must be a boolean")
+		}
+
+		if unscoped {
+			if userCtx := getUserContext(ctx); userCtx == nil || !userCtx.Root {
+				logger.Warnf("non-root user query deactivated records")
+				return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+					"only root user can query deactivated records")
+			}
+			options.Unscoped()
+		}
+	}
+
+	return options, nil
+}
+
+// getUserContext 获取 Auth 中间件解析出的用户信息，未登录时返回 nil
+func getUserContext(ctx *restful.Context) *users.UserContext {
+	value, ok := ctx.Get("UserContext")
+	if !ok {
+		return nil
+	}
+
+	userCtx, ok := value.(*users.UserContext)
+	if !ok {
+		return nil
+	}
+
+	return userCtx
+}
+
+// setETag 使用资源的版本作为 ETag，客户端修改时通过 If-Match 携带
+func setETag(ctx *restful.Context, version int64) {
+	ctx.Header("ETag", strconv.Quote(strconv.FormatInt(version, 10)))
+}
+
+// getIfMatch 解析 If-Match 中的资源版本，未指定或者为 * 时返回 0。
+// 版本只支持强校验，弱 ETag 以及无法解析的值不会与任何版本匹配
+func getIfMatch(ctx *restful.Context) (int64, error) {
+	value := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+
+	tag, err := strconv.Unquote(value)
+	if err == nil {
+		var version int64
+		if version, err = strconv.ParseInt(tag, 10, 64); err == nil && version > 0 {
+			return version, nil
+		}
+	}
+
+	logger.Warnf("invalid If-Match header: %v", value)
+	return 0, errors.NewError(http.StatusPreconditionFailed, errors.ErrPreconditionFailed,
+		"If-Match does not match the current version")
+}
+
+// renderProto 渲染 proto 消息，encoding=protobuf 时直接下载二进制文件，
+// 否则使用 protolator 展开后按照 Accept 指定的格式返回
+func renderProto(ctx *restful.Context, msg proto.Message, filename string) {
+	if ctx.Query("encoding") == "protobuf" {
+		data, err := proto.Marshal(msg)
+		if err != nil {
+			logger.Errorf("[%v] marshal proto message error: %v", filename, err)
+			ctx.Render(errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to marshal proto message")).Abort()
+			return
+		}
+
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		ctx.Data(http.StatusOK, "application/octet-stream", data)
+		return
+	}
+
+	renderProtoTree(ctx, msg, filename)
+}
+
+// renderProtoTree 使用 protolator 展开 proto 消息后按照 Accept 指定的格式返回
+func renderProtoTree(ctx *restful.Context, msg proto.Message, name string) {
+	data, err := configtx.MarshalJSON(msg)
+	if err != nil {
+		logger.Errorf("[%v] marshal proto message to json error: %v", name, err)
+		ctx.Render(errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to marshal proto message")).Abort()
+		return
+	}
+
+	tree := make(map[string]interface{})
+	if err = json.Unmarshal(data, &tree); err != nil {
+		logger.Errorf("[%v] unmarshal json error: %v", name, err)
+		ctx.Render(errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to marshal proto message")).Abort()
+		return
+	}
+
+	ctx.Render(tree)
+}
+
 // type Controllers struct{}
 //
 // func (c *Controllers) RenderFormat(ctx *gin.Context) string {
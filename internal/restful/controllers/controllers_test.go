@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/crypto/kek"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/memory"
+	"github.com/yakumioto/alkaid/internal/restful"
+	"github.com/yakumioto/alkaid/internal/services/organizations"
+	"github.com/yakumioto/alkaid/internal/services/users"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+
+	km, err := kek.NewLocal(make([]byte, kek.KeySize))
+	if err != nil {
+		panic(err)
+	}
+	kek.Initialize(km)
+	storage.Initialize(memory.NewDB())
+	if err := storage.AutoMigrate(new(users.User), new(users.UserOrganizations), new(users.EmergencyAccess),
+		new(organizations.Organization)); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// newEngine 使用 userCtx 代替 Auth 中间件注册控制器
+func newEngine(userCtx *users.UserContext, controllers ...restful.Controller) *gin.Engine {
+	engine := gin.New()
+	engine.Use(func(ctx *gin.Context) {
+		ctx.Set("UserContext", userCtx)
+	})
+	for _, controller := range controllers {
+		engine.Handle(controller.Method(), controller.Path(), controller.HandlerFuncChain()...)
+	}
+
+	return engine
+}
+
+func TestIfMatch(t *testing.T) {
+	ctx := context.Background()
+	_, err := users.Create(ctx, &users.CreateRequest{ID: "ivy", Name: "ivy", Email: "ivy@alkaid.com", Password: "ivy"})
+	assert.NoError(t, err)
+	_, err = users.Create(ctx, &users.CreateRequest{ID: "jack", Name: "jack", Email: "jack@alkaid.com", Password: "jack", Root: true})
+	assert.NoError(t, err)
+	_, err = organizations.Create(ctx, &organizations.CreateRequest{OrganizationID: "org1", Name: "org1",
+		Domain: "org1.alkaid.com", TransactionPassword: "password", UserID: "ivy"})
+	assert.NoError(t, err)
+
+	ivy := &users.UserContext{ID: "ivy"}
+	access, err := users.GrantEmergencyAccess(ctx, ivy, "ivy", &users.GrantEmergencyAccessRequest{Password: "ivy", GranteeID: "jack"})
+	assert.NoError(t, err)
+
+	engine := newEngine(ivy,
+		new(GetUserDetailByID),
+		new(UpdateUser),
+		new(ChangeUserPassword),
+		new(ChangeUserEmail),
+		new(DeactivateUser),
+		new(RevokeEmergencyAccess),
+		new(GetOrganizationDetailByID),
+		new(UpdateOrganization),
+		new(DeactivateOrganizationUser),
+		new(ReactivateOrganizationUser),
+	)
+
+	// 按顺序执行，后面的用例依赖前面用例的结果
+	tcs := []struct {
+		name    string
+		method  string
+		path    string
+		ifMatch string
+		body    string
+		status  int
+		etag    string
+	}{
+		{"get user", http.MethodGet, "/users/ivy", "", "", http.StatusOK, `"1"`},
+		{"update user stale version", http.MethodPatch, "/users/ivy", `"2"`, `{"name":"Ivy"}`, http.StatusPreconditionFailed, ""},
+		{"update user weak etag", http.MethodPatch, "/users/ivy", `W/"1"`, `{"name":"Ivy"}`, http.StatusPreconditionFailed, ""},
+		{"update user", http.MethodPatch, "/users/ivy", `"1"`, `{"name":"Ivy"}`, http.StatusOK, `"2"`},
+		{"change password stale version", http.MethodPatch, "/users/ivy/password", `"1"`,
+			`{"password":"ivy","newPassword":"ivy-new"}`, http.StatusPreconditionFailed, ""},
+		{"change email stale version", http.MethodPatch, "/users/ivy/email", `"1"`,
+			`{"password":"ivy","email":"ivy-new@alkaid.com"}`, http.StatusPreconditionFailed, ""},
+		{"deactivate user stale version", http.MethodPost, "/users/ivy/deactivate", `"1"`, "", http.StatusPreconditionFailed, ""},
+		{"revoke emergency access stale version", http.MethodDelete, "/users/ivy/emergency-access/" + access.ResourceID, `"2"`, "",
+			http.StatusPreconditionFailed, ""},
+		{"revoke emergency access", http.MethodDelete, "/users/ivy/emergency-access/" + access.ResourceID, `"1"`, "", http.StatusOK, ""},
+		{"get organization", http.MethodGet, "/organizations/org1", "", "", http.StatusOK, `"1"`},
+		{"update organization stale version", http.MethodPatch, "/organizations/org1", `"2"`, `{"description":"org1"}`,
+			http.StatusPreconditionFailed, ""},
+		{"update organization", http.MethodPatch, "/organizations/org1", `"1"`, `{"description":"org1"}`, http.StatusOK, `"2"`},
+		{"deactivate member stale version", http.MethodPost, "/organizations/org1/users/ivy/deactivate", `"2"`, "",
+			http.StatusPreconditionFailed, ""},
+		{"deactivate member", http.MethodPost, "/organizations/org1/users/ivy/deactivate", `"1"`, "", http.StatusOK, `"2"`},
+		{"reactivate member stale version", http.MethodPost, "/organizations/org1/users/ivy/reactivate", `"1"`, "",
+			http.StatusPreconditionFailed, ""},
+		{"reactivate member", http.MethodPost, "/organizations/org1/users/ivy/reactivate", "*", "", http.StatusOK, `"3"`},
+	}
+
+	for _, tc := range tcs {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		if tc.ifMatch != "" {
+			req.Header.Set("If-Match", tc.ifMatch)
+		}
+
+		resp := httptest.NewRecorder()
+		engine.ServeHTTP(resp, req)
+		assert.Equal(t, tc.status, resp.Code, tc.name)
+		assert.Equal(t, tc.etag, resp.Header().Get("ETag"), tc.name)
+	}
+
+	user, err := users.GetDetailByID("ivy")
+	assert.NoError(t, err)
+	assert.Equal(t, "Ivy", user.Name)
+	assert.Equal(t, int64(2), user.Version)
+}
+
+func TestGetQueryOptionsUnscoped(t *testing.T) {
+	tcs := []struct {
+		name    string
+		userCtx *users.UserContext
+		query   string
+		status  int
+	}{
+		{"scoped", &users.UserContext{ID: "ivy"}, "unscoped=false", http.StatusOK},
+		{"non-root", &users.UserContext{ID: "ivy"}, "unscoped=true", http.StatusForbidden},
+		{"invalid", &users.UserContext{ID: "jack", Root: true}, "unscoped=maybe", http.StatusBadRequest},
+		{"root", &users.UserContext{ID: "jack", Root: true}, "unscoped=true", http.StatusOK},
+	}
+
+	for _, tc := range tcs {
+		resp := httptest.NewRecorder()
+		newEngine(tc.userCtx, new(GetUserList)).ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/users?"+tc.query, nil))
+		assert.Equal(t, tc.status, resp.Code, tc.name)
+	}
+}
+
+func TestToolsMaxBodySize(t *testing.T) {
+	engine := newEngine(&users.UserContext{ID: "ivy"},
+		&DecodeProto{MaxBodySize: 64}, &EncodeProto{MaxBodySize: 64})
+
+	tcs := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+	}{
+		{"decode", "/tools/decode?type=common.Envelope", "", http.StatusOK},
+		{"decode too large", "/tools/decode?type=common.Envelope", strings.Repeat("a", 65), http.StatusRequestEntityTooLarge},
+		{"encode", "/tools/encode?type=common.Envelope", "{}", http.StatusOK},
+		{"encode too large", "/tools/encode?type=common.Envelope", `{"payload":"` + strings.Repeat("a", 64) + `"}`,
+			http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range tcs {
+		resp := httptest.NewRecorder()
+		engine.ServeHTTP(resp, httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(tc.body)))
+		assert.Equal(t, tc.status, resp.Code, tc.name)
+	}
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/restful"
+	"github.com/yakumioto/alkaid/internal/services/identities"
+	"github.com/yakumioto/alkaid/internal/versions"
+)
+
+type CreateIdentity struct {
+}
+
+func (c *CreateIdentity) Name() string {
+	return "create_identity"
+}
+
+func (c *CreateIdentity) Path() string {
+	return "/identities"
+}
+
+func (c *CreateIdentity) Method() string {
+	return http.MethodPost
+}
+
+func (c *CreateIdentity) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(identities.CreateRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		identity, err := identities.Create(getUserContext(ctx), req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(identity)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetIdentityList struct {
+}
+
+func (c *GetIdentityList) Name() string {
+	return "get_identity_list"
+}
+
+func (c *GetIdentityList) Path() string {
+	return "/identities"
+}
+
+func (c *GetIdentityList) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetIdentityList) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		list, err := identities.GetList(getUserContext(ctx))
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(list)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetIdentityDetailByID struct {
+}
+
+func (c *GetIdentityDetailByID) Name() string {
+	return "find_identity_by_id"
+}
+
+func (c *GetIdentityDetailByID) Path() string {
+	return "/identities/:identityId"
+}
+
+func (c *GetIdentityDetailByID) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetIdentityDetailByID) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		id := ctx.Param("identityId")
+
+		identity, err := identities.GetDetailByID(getUserContext(ctx), id)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, identity.Version)
+		ctx.Render(identity)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type UpdateIdentity struct {
+}
+
+func (c *UpdateIdentity) Name() string {
+	return "update_identity"
+}
+
+func (c *UpdateIdentity) Path() string {
+	return "/identities/:identityId"
+}
+
+func (c *UpdateIdentity) Method() string {
+	return http.MethodPatch
+}
+
+func (c *UpdateIdentity) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		id := ctx.Param("identityId")
+
+		req := new(identities.UpdateRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		version, err := getIfMatch(ctx)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+		req.Version = version
+
+		identity, err := identities.Update(getUserContext(ctx), id, req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, identity.Version)
+		ctx.Render(identity)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type ExportIdentityMSP struct {
+}
+
+func (c *ExportIdentityMSP) Name() string {
+	return "export_identity_msp"
+}
+
+// Path 导出需要提交密码，为避免密码出现在访问日志中，使用 POST 请求体传递
+func (c *ExportIdentityMSP) Path() string {
+	return "/identities/:identityId/msp.tar.gz"
+}
+
+func (c *ExportIdentityMSP) Method() string {
+	return http.MethodPost
+}
+
+func (c *ExportIdentityMSP) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		id := ctx.Param("identityId")
+
+		req := new(identities.ExportMSPRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		archive, err := identities.ExportMSP(getUserContext(ctx), id, req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+"-msp.tar.gz"))
+		ctx.Data(http.StatusOK, "application/gzip", archive)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
@@ -0,0 +1,422 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/restful"
+	"github.com/yakumioto/alkaid/internal/services/msps"
+	"github.com/yakumioto/alkaid/internal/services/organizations"
+	"github.com/yakumioto/alkaid/internal/services/users"
+	"github.com/yakumioto/alkaid/internal/versions"
+)
+
+type CreateOrganization struct {
+}
+
+func (c *CreateOrganization) Name() string {
+	return "create_organization"
+}
+
+func (c *CreateOrganization) Path() string {
+	return "/organizations"
+}
+
+func (c *CreateOrganization) Method() string {
+	return http.MethodPost
+}
+
+func (c *CreateOrganization) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(organizations.CreateRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		if userCtx := getUserContext(ctx); userCtx != nil {
+			req.UserID = userCtx.ID
+		}
+
+		org, err := organizations.Create(ctx, req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(org)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetOrganizationList struct {
+}
+
+func (c *GetOrganizationList) Name() string {
+	return "get_organization_list"
+}
+
+func (c *GetOrganizationList) Path() string {
+	return "/organizations"
+}
+
+func (c *GetOrganizationList) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetOrganizationList) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		options, err := getQueryOptions(ctx, organizations.QuerySchema)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		page, err := organizations.GetList(options)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(page)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetOrganizationDetailByID struct {
+}
+
+func (c *GetOrganizationDetailByID) Name() string {
+	return "find_organization_by_id"
+}
+
+func (c *GetOrganizationDetailByID) Path() string {
+	return "/organizations/:organizationId"
+}
+
+func (c *GetOrganizationDetailByID) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetOrganizationDetailByID) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		id := ctx.Param("organizationId")
+
+		org, err := organizations.GetDetailByID(id)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, org.Version)
+		ctx.Render(org)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type UpdateOrganization struct {
+}
+
+func (c *UpdateOrganization) Name() string {
+	return "update_organization"
+}
+
+func (c *UpdateOrganization) Path() string {
+	return "/organizations/:organizationId"
+}
+
+func (c *UpdateOrganization) Method() string {
+	return http.MethodPatch
+}
+
+func (c *UpdateOrganization) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(organizations.UpdateRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		version, err := getIfMatch(ctx)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+		req.Version = version
+
+		org, err := organizations.Update(ctx, ctx.Param("organizationId"), req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, org.Version)
+		ctx.Render(org)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetOrganizationUserList struct {
+}
+
+func (c *GetOrganizationUserList) Name() string {
+	return "get_organization_user_list"
+}
+
+func (c *GetOrganizationUserList) Path() string {
+	return "/organizations/:organizationId/users"
+}
+
+func (c *GetOrganizationUserList) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetOrganizationUserList) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		options, err := getQueryOptions(ctx, users.UserOrganizationsQuerySchema)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		page, err := organizations.GetUserList(ctx.Param("organizationId"), options)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(page)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetOrganizationMSPConfig struct {
+}
+
+func (c *GetOrganizationMSPConfig) Name() string {
+	return "get_organization_msp_config"
+}
+
+func (c *GetOrganizationMSPConfig) Path() string {
+	return "/organizations/:organizationId/msp"
+}
+
+func (c *GetOrganizationMSPConfig) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetOrganizationMSPConfig) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		id := ctx.Param("organizationId")
+
+		config, err := msps.GetMSPConfig(id)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		renderProto(ctx, config, id+"-msp.pb")
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type DeactivateOrganizationUser struct {
+}
+
+func (c *DeactivateOrganizationUser) Name() string {
+	return "deactivate_organization_user"
+}
+
+func (c *DeactivateOrganizationUser) Path() string {
+	return "/organizations/:organizationId/users/:userId/deactivate"
+}
+
+func (c *DeactivateOrganizationUser) Method() string {
+	return http.MethodPost
+}
+
+func (c *DeactivateOrganizationUser) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		version, err := getIfMatch(ctx)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		member, err := organizations.DeactivateUser(ctx, ctx.Param("organizationId"), ctx.Param("userId"), version)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, member.Version)
+		ctx.Render(member)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type ReactivateOrganizationUser struct {
+}
+
+func (c *ReactivateOrganizationUser) Name() string {
+	return "reactivate_organization_user"
+}
+
+func (c *ReactivateOrganizationUser) Path() string {
+	return "/organizations/:organizationId/users/:userId/reactivate"
+}
+
+func (c *ReactivateOrganizationUser) Method() string {
+	return http.MethodPost
+}
+
+func (c *ReactivateOrganizationUser) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		version, err := getIfMatch(ctx)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		member, err := organizations.ReactivateUser(ctx, ctx.Param("organizationId"), ctx.Param("userId"), version)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, member.Version)
+		ctx.Render(member)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
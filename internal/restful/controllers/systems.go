@@ -40,7 +40,7 @@ func (i *InitializeSystem) HandlerFuncChain() []gin.HandlerFunc {
 			return
 		}
 
-		sys, err := systems.SystemInit(req)
+		sys, err := systems.SystemInit(ctx, req)
 		if err != nil {
 			ctx.Render(err).Abort()
 			return
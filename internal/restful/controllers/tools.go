@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/restful"
+	"github.com/yakumioto/alkaid/internal/services/tools"
+	"github.com/yakumioto/alkaid/internal/versions"
+)
+
+// DefaultToolsMaxBodySize 编解码接口未配置时请求体的最大字节数
+const DefaultToolsMaxBodySize = 4 << 20
+
+// readBody 读取不超过 limit 字节的请求体，超过时返回 413，limit 不大于 0 时使用 DefaultToolsMaxBodySize
+func readBody(ctx *restful.Context, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		limit = DefaultToolsMaxBodySize
+	}
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, limit)
+	data, err := ctx.GetRawData()
+	if err != nil {
+		// http.MaxBytesReader 超过限制时返回的错误没有导出的类型
+		if strings.Contains(err.Error(), "request body too large") {
+			return nil, errors.NewErrorf(http.StatusRequestEntityTooLarge, errors.ErrRequestEntityTooLarge,
+				"request body exceeds %d bytes", limit)
+		}
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters, "%v", err)
+	}
+
+	return data, nil
+}
+
+// DecodeProto 将 protobuf 编码的请求体解码后按照 Accept 指定的格式返回，类似 configtxlator proto_decode
+type DecodeProto struct {
+	MaxBodySize int64 // 请求体的最大字节数
+}
+
+func (c *DecodeProto) Name() string {
+	return "decode_proto"
+}
+
+func (c *DecodeProto) Path() string {
+	return "/tools/decode"
+}
+
+func (c *DecodeProto) Method() string {
+	return http.MethodPost
+}
+
+func (c *DecodeProto) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		data, err := readBody(ctx, c.MaxBodySize)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		typ := ctx.Query("type")
+		msg, err := tools.Decode(typ, data)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		renderProtoTree(ctx, msg, typ)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+// EncodeProto 将 JSON 或 YAML 格式的请求体编码为 protobuf 后返回，类似 configtxlator proto_encode
+type EncodeProto struct {
+	MaxBodySize int64 // 请求体的最大字节数
+}
+
+func (c *EncodeProto) Name() string {
+	return "encode_proto"
+}
+
+func (c *EncodeProto) Path() string {
+	return "/tools/encode"
+}
+
+func (c *EncodeProto) Method() string {
+	return http.MethodPost
+}
+
+func (c *EncodeProto) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		data, err := readBody(ctx, c.MaxBodySize)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		format := tools.FormatJSON
+		if strings.Contains(ctx.ContentType(), "yaml") {
+			format = tools.FormatYAML
+		}
+
+		typ := ctx.Query("type")
+		data, err = tools.Encode(typ, format, data)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", typ+".pb"))
+		ctx.Data(http.StatusOK, "application/octet-stream", data)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
@@ -0,0 +1,234 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/restful"
+	"github.com/yakumioto/alkaid/internal/services/transactions"
+	"github.com/yakumioto/alkaid/internal/versions"
+)
+
+type CreateTransaction struct {
+}
+
+func (c *CreateTransaction) Name() string {
+	return "create_transaction"
+}
+
+func (c *CreateTransaction) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId/contracts/:contractId/transactions"
+}
+
+func (c *CreateTransaction) Method() string {
+	return http.MethodPost
+}
+
+func (c *CreateTransaction) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(transactions.CreateRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		req.OrganizationID = ctx.Param("organizationId")
+		req.NetworkID = ctx.Param("networkId")
+		req.ChannelID = ctx.Param("channelId")
+		req.ContractID = ctx.Param("contractId")
+
+		transaction, err := transactions.Create(getUserContext(ctx), req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(transaction)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetTransactionDetailByID struct {
+}
+
+func (c *GetTransactionDetailByID) Name() string {
+	return "get_transaction_detail_by_id"
+}
+
+func (c *GetTransactionDetailByID) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId/contracts/:contractId/transactions/:transactionId"
+}
+
+func (c *GetTransactionDetailByID) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetTransactionDetailByID) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		transaction, err := transactions.GetDetailByID(ctx.Param("networkId"), ctx.Param("channelId"),
+			ctx.Param("contractId"), ctx.Param("transactionId"))
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, transaction.Version)
+		ctx.Render(transaction)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type CreateTransactionEnvelope struct {
+}
+
+func (c *CreateTransactionEnvelope) Name() string {
+	return "create_transaction_envelope"
+}
+
+func (c *CreateTransactionEnvelope) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId/contracts/:contractId/transactions/:transactionId/envelope"
+}
+
+func (c *CreateTransactionEnvelope) Method() string {
+	return http.MethodPost
+}
+
+func (c *CreateTransactionEnvelope) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(transactions.CreateEnvelopeRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		id := ctx.Param("transactionId")
+		if req.Offline {
+			transaction, err := transactions.AssembleEnvelope(getUserContext(ctx), ctx.Param("networkId"),
+				ctx.Param("channelId"), ctx.Param("contractId"), id, req)
+			if err != nil {
+				ctx.Render(err).Abort()
+				return
+			}
+
+			ctx.Render(transaction)
+			return
+		}
+
+		env, err := transactions.CreateEnvelope(getUserContext(ctx), ctx.Param("networkId"), ctx.Param("channelId"),
+			ctx.Param("contractId"), id, req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		renderProto(ctx, env, id+".tx")
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type SignTransaction struct {
+}
+
+func (c *SignTransaction) Name() string {
+	return "sign_transaction"
+}
+
+func (c *SignTransaction) Path() string {
+	return "/organizations/:organizationId/networks/:networkId/channels/:channelId/contracts/:contractId/transactions/:transactionId/signature"
+}
+
+func (c *SignTransaction) Method() string {
+	return http.MethodPost
+}
+
+func (c *SignTransaction) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(transactions.SignRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		transaction, err := transactions.Sign(getUserContext(ctx), ctx.Param("networkId"), ctx.Param("channelId"),
+			ctx.Param("contractId"), ctx.Param("transactionId"), req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(transaction)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
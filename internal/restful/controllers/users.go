@@ -78,6 +78,56 @@ func (l *Login) HandlerFuncChain() []gin.HandlerFunc {
 	}
 }
 
+type PreLogin struct {
+}
+
+func (l *PreLogin) Name() string {
+	return "prelogin"
+}
+
+func (l *PreLogin) Path() string {
+	return "/prelogin"
+}
+
+func (l *PreLogin) Method() string {
+	return http.MethodPost
+}
+
+func (l *PreLogin) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(users.PreLoginRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		params, err := users.PreLogin(req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(params)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
 type CreateUser struct {
 }
 
@@ -101,7 +151,7 @@ func (c *CreateUser) HandlerFuncChain() []gin.HandlerFunc {
 			return
 		}
 
-		user, err := users.Create(req)
+		user, err := users.Create(ctx, req)
 		if err != nil {
 			ctx.Render(err).Abort()
 			return
@@ -127,6 +177,55 @@ func (c *CreateUser) HandlerFuncChain() []gin.HandlerFunc {
 	}
 }
 
+type GetUserList struct {
+}
+
+func (c *GetUserList) Name() string {
+	return "get_user_list"
+}
+
+func (c *GetUserList) Path() string {
+	return "/users"
+}
+
+func (c *GetUserList) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetUserList) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		options, err := getQueryOptions(ctx, users.QuerySchema)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		page, err := users.GetList(options)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(page)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
 type GetUserDetailByID struct {
 }
 
@@ -152,6 +251,667 @@ func (c *GetUserDetailByID) HandlerFuncChain() []gin.HandlerFunc {
 			return
 		}
 
+		setETag(ctx, user.Version)
+		ctx.Render(user)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type UpdateUser struct {
+}
+
+func (c *UpdateUser) Name() string {
+	return "update_user"
+}
+
+func (c *UpdateUser) Path() string {
+	return "/users/:id"
+}
+
+func (c *UpdateUser) Method() string {
+	return http.MethodPatch
+}
+
+func (c *UpdateUser) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(users.UpdateRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		version, err := getIfMatch(ctx)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+		req.Version = version
+
+		user, err := users.Update(ctx, getUserContext(ctx), ctx.Param("id"), req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, user.Version)
+		ctx.Render(user)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type DeactivateUser struct {
+}
+
+func (c *DeactivateUser) Name() string {
+	return "deactivate_user"
+}
+
+func (c *DeactivateUser) Path() string {
+	return "/users/:id/deactivate"
+}
+
+func (c *DeactivateUser) Method() string {
+	return http.MethodPost
+}
+
+func (c *DeactivateUser) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		version, err := getIfMatch(ctx)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		user, err := users.Deactivate(ctx, ctx.Param("id"), version)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, user.Version)
+		ctx.Render(user)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type ReactivateUser struct {
+}
+
+func (c *ReactivateUser) Name() string {
+	return "reactivate_user"
+}
+
+func (c *ReactivateUser) Path() string {
+	return "/users/:id/reactivate"
+}
+
+func (c *ReactivateUser) Method() string {
+	return http.MethodPost
+}
+
+func (c *ReactivateUser) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		version, err := getIfMatch(ctx)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		user, err := users.Reactivate(ctx, ctx.Param("id"), version)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, user.Version)
+		ctx.Render(user)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type ChangeUserPassword struct {
+}
+
+func (c *ChangeUserPassword) Name() string {
+	return "change_user_password"
+}
+
+func (c *ChangeUserPassword) Path() string {
+	return "/users/:id/password"
+}
+
+func (c *ChangeUserPassword) Method() string {
+	return http.MethodPatch
+}
+
+func (c *ChangeUserPassword) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(users.ChangePasswordRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		version, err := getIfMatch(ctx)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+		req.Version = version
+
+		user, err := users.ChangePassword(ctx, getUserContext(ctx), ctx.Param("id"), req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, user.Version)
+		ctx.Render(user)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type ChangeUserEmail struct {
+}
+
+func (c *ChangeUserEmail) Name() string {
+	return "change_user_email"
+}
+
+func (c *ChangeUserEmail) Path() string {
+	return "/users/:id/email"
+}
+
+func (c *ChangeUserEmail) Method() string {
+	return http.MethodPatch
+}
+
+func (c *ChangeUserEmail) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(users.ChangeEmailRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		version, err := getIfMatch(ctx)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+		req.Version = version
+
+		user, err := users.ChangeEmail(ctx, getUserContext(ctx), ctx.Param("id"), req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, user.Version)
+		ctx.Render(user)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type RegenerateUserRecoveryCode struct {
+}
+
+func (c *RegenerateUserRecoveryCode) Name() string {
+	return "regenerate_user_recovery_code"
+}
+
+func (c *RegenerateUserRecoveryCode) Path() string {
+	return "/users/:id/recovery-code"
+}
+
+func (c *RegenerateUserRecoveryCode) Method() string {
+	return http.MethodPost
+}
+
+func (c *RegenerateUserRecoveryCode) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(users.RegenerateRecoveryCodeRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		user, err := users.RegenerateRecoveryCode(ctx, getUserContext(ctx), ctx.Param("id"), req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(user)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type RecoverUser struct {
+}
+
+func (c *RecoverUser) Name() string {
+	return "recover_user"
+}
+
+func (c *RecoverUser) Path() string {
+	return "/users/:id/recover"
+}
+
+func (c *RecoverUser) Method() string {
+	return http.MethodPost
+}
+
+func (c *RecoverUser) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(users.RecoverRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		user, err := users.Recover(ctx, ctx.Param("id"), req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(user)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GrantEmergencyAccess struct {
+}
+
+func (c *GrantEmergencyAccess) Name() string {
+	return "grant_emergency_access"
+}
+
+func (c *GrantEmergencyAccess) Path() string {
+	return "/users/:id/emergency-access"
+}
+
+func (c *GrantEmergencyAccess) Method() string {
+	return http.MethodPost
+}
+
+func (c *GrantEmergencyAccess) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(users.GrantEmergencyAccessRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		access, err := users.GrantEmergencyAccess(ctx, getUserContext(ctx), ctx.Param("id"), req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(access)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type GetEmergencyAccessList struct {
+}
+
+func (c *GetEmergencyAccessList) Name() string {
+	return "get_emergency_access_list"
+}
+
+func (c *GetEmergencyAccessList) Path() string {
+	return "/users/:id/emergency-access"
+}
+
+func (c *GetEmergencyAccessList) Method() string {
+	return http.MethodGet
+}
+
+func (c *GetEmergencyAccessList) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		options, err := getQueryOptions(ctx, users.EmergencyAccessQuerySchema)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		page, err := users.GetEmergencyAccessList(getUserContext(ctx), ctx.Param("id"), options)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(page)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type RevokeEmergencyAccess struct {
+}
+
+func (c *RevokeEmergencyAccess) Name() string {
+	return "revoke_emergency_access"
+}
+
+func (c *RevokeEmergencyAccess) Path() string {
+	return "/users/:id/emergency-access/:accessId"
+}
+
+func (c *RevokeEmergencyAccess) Method() string {
+	return http.MethodDelete
+}
+
+func (c *RevokeEmergencyAccess) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		version, err := getIfMatch(ctx)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		access, err := users.RevokeEmergencyAccess(ctx, getUserContext(ctx), ctx.Param("id"), ctx.Param("accessId"), version)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		ctx.Render(access)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type InitiateEmergencyRecovery struct {
+}
+
+func (c *InitiateEmergencyRecovery) Name() string {
+	return "initiate_emergency_recovery"
+}
+
+func (c *InitiateEmergencyRecovery) Path() string {
+	return "/users/:id/emergency-access/:accessId/initiate"
+}
+
+func (c *InitiateEmergencyRecovery) Method() string {
+	return http.MethodPost
+}
+
+func (c *InitiateEmergencyRecovery) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		access, err := users.InitiateEmergencyRecovery(ctx, getUserContext(ctx), ctx.Param("id"), ctx.Param("accessId"))
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, access.Version)
+		ctx.Render(access)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type RejectEmergencyRecovery struct {
+}
+
+func (c *RejectEmergencyRecovery) Name() string {
+	return "reject_emergency_recovery"
+}
+
+func (c *RejectEmergencyRecovery) Path() string {
+	return "/users/:id/emergency-access/:accessId/reject"
+}
+
+func (c *RejectEmergencyRecovery) Method() string {
+	return http.MethodPost
+}
+
+func (c *RejectEmergencyRecovery) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		access, err := users.RejectEmergencyRecovery(ctx, getUserContext(ctx), ctx.Param("id"), ctx.Param("accessId"))
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
+		setETag(ctx, access.Version)
+		ctx.Render(access)
+	}
+
+	return []gin.HandlerFunc{
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			if !ctx.MatchVersion(versions.V1) {
+				return
+			}
+
+			handler(ctx)
+			ctx.Abort()
+		},
+		func(c *gin.Context) {
+			ctx := restful.NewContext(c)
+			handler(ctx)
+		},
+	}
+}
+
+type EmergencyRecoverUser struct {
+}
+
+func (c *EmergencyRecoverUser) Name() string {
+	return "emergency_recover_user"
+}
+
+func (c *EmergencyRecoverUser) Path() string {
+	return "/users/:id/emergency-access/:accessId/recover"
+}
+
+func (c *EmergencyRecoverUser) Method() string {
+	return http.MethodPost
+}
+
+func (c *EmergencyRecoverUser) HandlerFuncChain() []gin.HandlerFunc {
+	handler := func(ctx *restful.Context) {
+		req := new(users.EmergencyRecoverRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.Render(errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"%v", err)).Abort()
+			return
+		}
+
+		user, err := users.EmergencyRecover(ctx, getUserContext(ctx), ctx.Param("id"), ctx.Param("accessId"), req)
+		if err != nil {
+			ctx.Render(err).Abort()
+			return
+		}
+
 		ctx.Render(user)
 	}
 
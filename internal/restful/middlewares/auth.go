@@ -14,6 +14,7 @@ import (
 	"strings"
 
 	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/util"
 	"github.com/gin-gonic/gin"
 	"github.com/yakumioto/alkaid/internal/common/jwt"
 	"github.com/yakumioto/alkaid/internal/common/log"
@@ -35,6 +36,8 @@ func NewAuth(model, policy string) *Auth {
 	if err != nil {
 		logger.Panicf("new enforcer error: %v", err)
 	}
+	// 角色继承定义在 * 域中，对所有用户生效
+	enforcer.AddNamedDomainMatchingFunc("g", "KeyMatch", util.KeyMatch)
 
 	return &Auth{
 		enforcer: enforcer,
@@ -93,7 +96,7 @@ func (a *Auth) HandlerFunc() gin.HandlerFunc {
 		}
 
 		if ok, _ := a.enforcer.Enforce(
-			fmt.Sprintf("%v::role", userCtx.Role(ctx.GetString("organizationId"))),
+			fmt.Sprintf("%v::role", userCtx.Role(ctx.Param("organizationId"))),
 			userCtx.ID, ctx.FullPath(), ctx.Request.Method); !ok {
 			ctx.Render(errors.NewError(http.StatusUnauthorized, errors.ErrUnauthorized,
 				"no access"))
@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/jwt"
+	"github.com/yakumioto/alkaid/internal/services/users"
+)
+
+func TestAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	jwt.Initialize("secret", time.Hour)
+
+	auth := NewAuth("../../../configs/casbin_route/model.conf", "../../../configs/casbin_route/policy.csv")
+	engine := gin.New()
+	engine.Use(auth.HandlerFunc())
+	ok := func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	}
+	engine.GET("/health", ok)
+	engine.PATCH("/organizations/:organizationId", ok)
+	engine.POST("/organizations/:organizationId/networks/:networkId/channels", ok)
+	engine.POST("/organizations/:organizationId/networks/:networkId/channels/:channelId/updates", ok)
+
+	token := func(user *users.User, orgs ...*users.UserOrganizations) string {
+		token, err := jwt.NewTokenWithUserContext(users.NewUserContext(user, orgs), time.Now().Unix())
+		assert.NoError(t, err)
+		return "Bearer " + token
+	}
+	// 组织管理员的角色取决于路径中的 organizationId
+	orgAdmin := token(&users.User{UserID: "alice"},
+		&users.UserOrganizations{OrganizationID: "org1", Role: users.RoleOrganization},
+		&users.UserOrganizations{OrganizationID: "org2", Role: users.RoleUser},
+		&users.UserOrganizations{OrganizationID: "org3", Role: users.RoleNetwork})
+	channels := "/organizations/%s/networks/network1/channels"
+	updates := channels + "/mychannel/updates"
+
+	tcs := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		status        int
+	}{
+		{"public", http.MethodGet, "/health", "", http.StatusOK},
+		{"no authorization", http.MethodPatch, "/organizations/org1", "", http.StatusUnauthorized},
+		{"organization admin", http.MethodPatch, "/organizations/org1", orgAdmin, http.StatusOK},
+		{"organization user", http.MethodPatch, "/organizations/org2", orgAdmin, http.StatusUnauthorized},
+		{"other organization", http.MethodPatch, "/organizations/org3", orgAdmin, http.StatusUnauthorized},
+		// 组织管理员继承网络管理员的权限
+		{"network admin", http.MethodPost, fmt.Sprintf(channels, "org3"), orgAdmin, http.StatusOK},
+		{"inherited network admin", http.MethodPost, fmt.Sprintf(channels, "org1"), orgAdmin, http.StatusOK},
+		{"user create channel", http.MethodPost, fmt.Sprintf(channels, "org2"), orgAdmin, http.StatusUnauthorized},
+		// 通道配置更新需要组织管理员提议，路由不会继承上级路径的权限
+		{"propose update", http.MethodPost, fmt.Sprintf(updates, "org1"), orgAdmin, http.StatusOK},
+		{"network admin propose update", http.MethodPost, fmt.Sprintf(updates, "org3"), orgAdmin, http.StatusUnauthorized},
+		{"root", http.MethodPatch, "/organizations/org3", token(&users.User{UserID: "root", Root: true}), http.StatusOK},
+	}
+
+	for _, tc := range tcs {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+
+		resp := httptest.NewRecorder()
+		engine.ServeHTTP(resp, req)
+		assert.Equal(t, tc.status, resp.Code, tc.name)
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package channels
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/utils"
+)
+
+const ResourceNamespace = "Channel"
+
+// Channel 应用通道，保存通道的创世区块以及当前生效的通道配置，
+// 两者均为 protobuf 编码后的二进制数据。
+type Channel struct {
+	ResourceID     string `json:"resourceId,omitempty" gorm:"primaryKey"`
+	ChannelID      string `json:"channelId,omitempty" gorm:"uniqueIndex:idx_network_channel"`
+	NetworkID      string `json:"networkId,omitempty" gorm:"uniqueIndex:idx_network_channel"`
+	OrganizationID string `json:"organizationId,omitempty" gorm:"index"`
+	Description    string `json:"description,omitempty"`
+	GenesisBlock   []byte `json:"-"`
+	Config         []byte `json:"-"`
+	Version        int64  `json:"version,omitempty" gorm:"default:1"`
+	CreatedAt      int64  `json:"createdAt,omitempty" gorm:"autoCreateTime"`
+	UpdatedAt      int64  `json:"updatedAt,omitempty" gorm:"autoUpdateTime"`
+}
+
+func (c *Channel) Create() error {
+	c.ResourceID = utils.GenResourceID(ResourceNamespace)
+	c.Version = 1
+	return storage.Create(c)
+}
+
+func (c *Channel) Update(ctx context.Context) error {
+	return storage.FromContext(ctx).Update(c,
+		storage.NewUpdateOptions("resource_id = ?", c.ResourceID).Version("version", c.Version))
+}
+
+func (c *Channel) config() (*cb.Config, error) {
+	config := new(cb.Config)
+	return config, proto.Unmarshal(c.Config, config)
+}
+
+func FindChannelByID(networkID, id string) (*Channel, error) {
+	channel := new(Channel)
+	return channel, storage.FindByQuery(channel,
+		storage.NewQueryOptions().
+			Where("network_id = ? AND (channel_id = ? OR resource_id = ?)", networkID, id, id))
+}
+
+func FindChannels(options *storage.QueryOptions) ([]*Channel, error) {
+	channels := make([]*Channel, 0)
+	return channels, storage.FindByQuery(&channels, options)
+}
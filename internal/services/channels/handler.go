@@ -0,0 +1,852 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package channels
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	ab "github.com/hyperledger/fabric-protos-go/orderer"
+	"github.com/hyperledger/fabric-protos-go/orderer/etcdraft"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/yakumioto/alkaid/internal/common/configtx"
+	"github.com/yakumioto/alkaid/internal/common/log"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/services/identities"
+	"github.com/yakumioto/alkaid/internal/services/msps"
+	"github.com/yakumioto/alkaid/internal/services/organizations"
+	"github.com/yakumioto/alkaid/internal/services/users"
+	"github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/protoutil"
+)
+
+var (
+	logger = log.GetPackageLogger("services.channels")
+
+	// channelIDPattern 与 Fabric 对通道名称的限制保持一致
+	channelIDPattern = regexp.MustCompile(`^[a-z][a-z0-9.-]{0,248}$`)
+)
+
+type ConsenterRequest struct {
+	IdentityID string `json:"identityId,omitempty" validate:"required"` // 排序节点身份，使用其 TLS 证书
+	Host       string `json:"host,omitempty" validate:"required"`
+	Port       uint32 `json:"port,omitempty" validate:"required"`
+}
+
+type AnchorPeerRequest struct {
+	Host string `json:"host,omitempty" validate:"required"`
+	Port int32  `json:"port,omitempty" validate:"required"`
+}
+
+type OrganizationRequest struct {
+	OrganizationID string                      `json:"organizationId,omitempty" validate:"required"`
+	Endpoints      []string                    `json:"endpoints,omitempty"`   // 仅排序组织需要
+	AnchorPeers    []*AnchorPeerRequest        `json:"anchorPeers,omitempty"` // 仅应用组织需要
+	Policies       map[string]*configtx.Policy `json:"policies,omitempty"`
+}
+
+type BatchSizeRequest struct {
+	MaxMessageCount   uint32 `json:"maxMessageCount,omitempty"`
+	AbsoluteMaxBytes  uint32 `json:"absoluteMaxBytes,omitempty"`
+	PreferredMaxBytes uint32 `json:"preferredMaxBytes,omitempty"`
+}
+
+type OrdererRequest struct {
+	Organizations []*OrganizationRequest      `json:"organizations,omitempty" validate:"required"`
+	Consenters    []*ConsenterRequest         `json:"consenters,omitempty" validate:"required"`
+	BatchTimeout  string                      `json:"batchTimeout,omitempty"`
+	BatchSize     *BatchSizeRequest           `json:"batchSize,omitempty"`
+	Policies      map[string]*configtx.Policy `json:"policies,omitempty"`
+}
+
+type ApplicationRequest struct {
+	Organizations []*OrganizationRequest      `json:"organizations,omitempty" validate:"required"`
+	Policies      map[string]*configtx.Policy `json:"policies,omitempty"`
+}
+
+type CreateRequest struct {
+	ChannelID      string                      `json:"channelId,omitempty" validate:"required"`
+	Description    string                      `json:"description,omitempty"`
+	Orderer        *OrdererRequest             `json:"orderer,omitempty" validate:"required"`
+	Application    *ApplicationRequest         `json:"application,omitempty" validate:"required"`
+	Policies       map[string]*configtx.Policy `json:"policies,omitempty"`
+	OrganizationID string                      `json:"-"`
+	NetworkID      string                      `json:"-"`
+}
+
+// Create 根据成员组织，排序节点以及策略生成应用通道的创世区块
+func Create(req *CreateRequest) (*Channel, error) {
+	if !channelIDPattern.MatchString(req.ChannelID) {
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"invalid channel id: %v", req.ChannelID)
+	}
+	if req.Orderer == nil || len(req.Orderer.Organizations) == 0 || len(req.Orderer.Consenters) == 0 {
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"orderer organizations and consenters are required")
+	}
+	if req.Application == nil || len(req.Application.Organizations) == 0 {
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"application organizations are required")
+	}
+
+	// 通道的创建者需要是通道的成员
+	if !req.hasMember(req.OrganizationID) {
+		logger.Warnf("[%v] organization [%v] is not a member of channel", req.ChannelID, req.OrganizationID)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"organization is not a member of channel")
+	}
+
+	if _, err := FindChannelByID(req.NetworkID, req.ChannelID); err != storage.ErrNotFound {
+		if err == nil {
+			logger.Warnf("[%v] channel already exists", req.ChannelID)
+			return nil, errors.NewError(http.StatusConflict, errors.ErrChannelAlreadyExists,
+				"channel already exists")
+		}
+		logger.Errorf("[%v] query channel error: %v", req.ChannelID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	channel, err := req.channel()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := configtx.NewGenesisBlock(channel)
+	if err != nil {
+		logger.Warnf("[%v] create genesis block error: %v", req.ChannelID, err)
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"failed to create genesis block: %v", err)
+	}
+
+	config, err := configtx.ConfigFromBlock(block)
+	if err != nil {
+		logger.Errorf("[%v] extract config from genesis block error: %v", req.ChannelID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to create genesis block")
+	}
+
+	ch := &Channel{
+		ChannelID:      req.ChannelID,
+		NetworkID:      req.NetworkID,
+		OrganizationID: req.OrganizationID,
+		Description:    req.Description,
+	}
+	if ch.GenesisBlock, err = proto.Marshal(block); err == nil {
+		ch.Config, err = proto.Marshal(config)
+	}
+	if err != nil {
+		logger.Errorf("[%v] marshal genesis block error: %v", req.ChannelID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to create genesis block")
+	}
+
+	if err = ch.Create(); err != nil {
+		logger.Errorf("[%v] create channel error: %v", req.ChannelID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to create channel")
+	}
+
+	return ch, nil
+}
+
+func (req *CreateRequest) hasMember(orgID string) bool {
+	for _, orgs := range [][]*OrganizationRequest{req.Orderer.Organizations, req.Application.Organizations} {
+		for _, org := range orgs {
+			if org.OrganizationID == orgID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// channel 将请求转换为 configtx 所需的通道配置，组织的 MSP 配置以及排序节点的 TLS 证书均从数据库中获取
+func (req *CreateRequest) channel() (*configtx.Channel, error) {
+	channel := &configtx.Channel{
+		ChannelID: req.ChannelID,
+		Orderer: &configtx.Orderer{
+			BatchTimeout: req.Orderer.BatchTimeout,
+			Policies:     req.Orderer.Policies,
+		},
+		Application: &configtx.Application{
+			Policies: req.Application.Policies,
+		},
+		Policies: req.Policies,
+	}
+
+	if batchSize := req.Orderer.BatchSize; batchSize != nil {
+		channel.Orderer.BatchSize = &ab.BatchSize{
+			MaxMessageCount:   batchSize.MaxMessageCount,
+			AbsoluteMaxBytes:  batchSize.AbsoluteMaxBytes,
+			PreferredMaxBytes: batchSize.PreferredMaxBytes,
+		}
+	}
+
+	ordererOrgs := make(map[string]bool)
+	for _, orgReq := range req.Orderer.Organizations {
+		org, err := newOrganization(orgReq)
+		if err != nil {
+			return nil, err
+		}
+
+		ordererOrgs[org.MSPID] = true
+		channel.Orderer.Organizations = append(channel.Orderer.Organizations, org)
+	}
+
+	for _, orgReq := range req.Application.Organizations {
+		org, err := newOrganization(orgReq)
+		if err != nil {
+			return nil, err
+		}
+
+		channel.Application.Organizations = append(channel.Application.Organizations, org)
+	}
+
+	for _, consenterReq := range req.Orderer.Consenters {
+		consenter, err := newConsenter(consenterReq, ordererOrgs)
+		if err != nil {
+			return nil, err
+		}
+
+		channel.Orderer.Consenters = append(channel.Orderer.Consenters, consenter)
+	}
+
+	return channel, nil
+}
+
+func newOrganization(req *OrganizationRequest) (*configtx.Organization, error) {
+	org, err := organizations.GetDetailByID(req.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	mspConfig, err := msps.BuildMSPConfig(org)
+	if err != nil {
+		logger.Errorf("[%v] build msp config error: %v", req.OrganizationID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to build msp config")
+	}
+
+	anchorPeers := make([]*pb.AnchorPeer, 0, len(req.AnchorPeers))
+	for _, anchorPeer := range req.AnchorPeers {
+		anchorPeers = append(anchorPeers, &pb.AnchorPeer{Host: anchorPeer.Host, Port: anchorPeer.Port})
+	}
+
+	return &configtx.Organization{
+		MSPID:       org.MSPID(),
+		MSP:         mspConfig,
+		Endpoints:   req.Endpoints,
+		AnchorPeers: anchorPeers,
+		Policies:    req.Policies,
+	}, nil
+}
+
+// newConsenter 排序节点需要是排序组织下的排序节点身份
+func newConsenter(req *ConsenterRequest, ordererOrgs map[string]bool) (*etcdraft.Consenter, error) {
+	identity, err := identities.FindIdentityByID(req.IdentityID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Warnf("[%v] consenter identity not found", req.IdentityID)
+			return nil, errors.NewError(http.StatusNotFound, errors.ErrIdentityNotFound,
+				"consenter identity not found")
+		}
+		logger.Errorf("[%v] query identity error: %v", req.IdentityID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	if identity.Use != identities.UseNode || identity.Type != identities.MSPTypeOrderer ||
+		!ordererOrgs[identity.OrganizationID] {
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"identity %v is not an orderer of the orderer organizations", req.IdentityID)
+	}
+
+	return &etcdraft.Consenter{
+		Host:          req.Host,
+		Port:          req.Port,
+		ClientTlsCert: []byte(identity.TLSCertificate),
+		ServerTlsCert: []byte(identity.TLSCertificate),
+	}, nil
+}
+
+func GetList(networkID string) ([]*Channel, error) {
+	channels, err := FindChannels(storage.NewQueryOptions().Where(&Channel{NetworkID: networkID}))
+	if err != nil {
+		logger.Errorf("[%v] query channels error: %v", networkID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	return channels, nil
+}
+
+func GetDetailByID(networkID, id string) (*Channel, error) {
+	channel, err := FindChannelByID(networkID, id)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Warnf("[%v] channel not found", id)
+			return nil, errors.NewError(http.StatusNotFound, errors.ErrChannelNotFound,
+				"channel not found")
+		}
+		logger.Errorf("[%v] query channel error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	return channel, nil
+}
+
+// GetGenesisBlock 获取通道的创世区块
+func GetGenesisBlock(networkID, id string) (*cb.Block, error) {
+	channel, err := GetDetailByID(networkID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	block := new(cb.Block)
+	if err = proto.Unmarshal(channel.GenesisBlock, block); err != nil {
+		logger.Errorf("[%v] unmarshal genesis block error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to unmarshal genesis block")
+	}
+
+	return block, nil
+}
+
+// GetConfig 获取通道当前生效的配置
+func GetConfig(networkID, id string) (*cb.Config, error) {
+	channel, err := GetDetailByID(networkID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := channel.config()
+	if err != nil {
+		logger.Errorf("[%v] unmarshal channel config error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to unmarshal channel config")
+	}
+
+	return config, nil
+}
+
+type ProposeUpdateRequest struct {
+	Description    string          `json:"description,omitempty"`
+	Config         json.RawMessage `json:"config,omitempty" validate:"required"` // protolator 格式的完整通道配置
+	OrganizationID string          `json:"-"`
+	NetworkID      string          `json:"-"`
+	ChannelID      string          `json:"-"`
+	UserID         string          `json:"-"`
+}
+
+// ProposeUpdate 计算新配置与通道当前配置之间的读写集差异，生成待签名的配置更新提案
+func ProposeUpdate(req *ProposeUpdateRequest) (*ConfigUpdate, error) {
+	channel, err := GetDetailByID(req.NetworkID, req.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+
+	original, err := channel.config()
+	if err != nil {
+		logger.Errorf("[%v] unmarshal channel config error: %v", req.ChannelID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to unmarshal channel config")
+	}
+
+	// 只有当前通道配置中的组织才能提议配置更新
+	member, err := configtx.HasMSP(original, req.OrganizationID)
+	if err != nil {
+		logger.Errorf("[%v] parse channel msps error: %v", req.ChannelID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to parse channel config")
+	}
+	if !member {
+		logger.Warnf("[%v] organization [%v] is not a member of channel", req.ChannelID, req.OrganizationID)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"organization is not a member of channel")
+	}
+
+	updated := new(cb.Config)
+	if err = configtx.UnmarshalJSON(req.Config, updated); err != nil {
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"invalid channel config: %v", err)
+	}
+
+	configUpdate, err := configtx.ComputeUpdate(channel.ChannelID, original, updated)
+	if err != nil {
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"failed to compute config update: %v", err)
+	}
+
+	env := new(cb.ConfigUpdateEnvelope)
+	if env.ConfigUpdate, err = proto.Marshal(configUpdate); err != nil {
+		logger.Errorf("[%v] marshal config update error: %v", req.ChannelID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to marshal config update")
+	}
+
+	status, err := configtx.EvaluateUpdate(original, env)
+	if err != nil {
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"invalid config update: %v", err)
+	}
+
+	updated.Sequence = original.Sequence
+	update := &ConfigUpdate{
+		ChannelID:       channel.ChannelID,
+		NetworkID:       channel.NetworkID,
+		OrganizationID:  req.OrganizationID,
+		UserID:          req.UserID,
+		Description:     req.Description,
+		Status:          ConfigUpdateStatusPending,
+		Sequence:        original.Sequence,
+		SignatureStatus: status,
+	}
+	if update.ConfigUpdateEnvelope, err = proto.Marshal(env); err == nil {
+		update.Config, err = proto.Marshal(updated)
+	}
+	if err != nil {
+		logger.Errorf("[%v] marshal config update error: %v", req.ChannelID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to marshal config update")
+	}
+
+	if err = update.Create(); err != nil {
+		logger.Errorf("[%v] create config update error: %v", req.ChannelID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to create config update")
+	}
+
+	return update, nil
+}
+
+func GetUpdateList(networkID, channelID string) ([]*ConfigUpdate, error) {
+	updates, err := FindConfigUpdates(storage.NewQueryOptions().
+		Where(&ConfigUpdate{NetworkID: networkID, ChannelID: channelID}))
+	if err != nil {
+		logger.Errorf("[%v] query config updates error: %v", channelID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	return updates, nil
+}
+
+// GetUpdateDetailByID 获取配置更新提案以及签名收集状态
+func GetUpdateDetailByID(networkID, channelID, id string) (*ConfigUpdate, error) {
+	update, _, _, err := getUpdate(networkID, channelID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return update, nil
+}
+
+// GetUpdateEnvelope 获取配置更新提案的读写集以及已收集的签名
+func GetUpdateEnvelope(networkID, channelID, id string) (*cb.ConfigUpdateEnvelope, error) {
+	update, err := getConfigUpdate(networkID, channelID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := update.configUpdateEnvelope()
+	if err != nil {
+		logger.Errorf("[%v] unmarshal config update envelope error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to unmarshal config update")
+	}
+
+	return env, nil
+}
+
+type SignUpdateRequest struct {
+	IdentityID      string `json:"identityId,omitempty" validate:"required"`
+	SignatureHeader []byte `json:"signatureHeader,omitempty"` // 离线签名模式下使用签名请求中的签名头
+	Signature       []byte `json:"signature,omitempty"`       // 离线签名模式下客户端的签名，无需提供密码
+	identities.Credentials
+}
+
+// SignUpdate 组织管理员对配置更新进行签名。
+// 提供 Signature 时为离线签名模式，签名头以及签名由 GetUpdateSigningRequest 返回的数据在客户端生成
+func SignUpdate(userCtx *users.UserContext, networkID, channelID, id string, req *SignUpdateRequest) (*ConfigUpdate, error) {
+	update, channel, env, err := getUpdate(networkID, channelID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err = update.checkPending(channel); err != nil {
+		return nil, err
+	}
+
+	var signature *cb.ConfigSignature
+	if len(req.Signature) != 0 {
+		signature, err = verifyConfigSignature(userCtx, id, env, req)
+	} else {
+		signature, err = newConfigSignature(userCtx, id, env, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signatureHeader, err := protoutil.UnmarshalSignatureHeader(signature.SignatureHeader)
+	if err != nil {
+		logger.Errorf("[%v] unmarshal signature header error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to unmarshal signature header")
+	}
+
+	signedData, err := protoutil.ConfigUpdateEnvelopeAsSignedData(env)
+	if err != nil {
+		logger.Errorf("[%v] parse config signatures error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to parse config signatures")
+	}
+	for _, sd := range signedData {
+		if bytes.Equal(sd.Identity, signatureHeader.Creator) {
+			return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"identity %v has already signed the config update", req.IdentityID)
+		}
+	}
+	env.Signatures = append(env.Signatures, signature)
+
+	config, err := channel.config()
+	if err != nil {
+		logger.Errorf("[%v] unmarshal channel config error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to unmarshal channel config")
+	}
+	if update.SignatureStatus, err = configtx.EvaluateUpdate(config, env); err != nil {
+		logger.Errorf("[%v] evaluate config update error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to evaluate config update")
+	}
+
+	if update.SignatureStatus.Satisfied {
+		update.Status = ConfigUpdateStatusSatisfied
+	}
+	if update.ConfigUpdateEnvelope, err = proto.Marshal(env); err != nil {
+		logger.Errorf("[%v] marshal config update envelope error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to marshal config update")
+	}
+
+	if err = update.Update(context.Background()); err != nil {
+		if err == storage.ErrConflict {
+			logger.Warnf("[%v] config update has been modified concurrently", id)
+			return nil, errors.NewConflictError("config update", false)
+		}
+		logger.Errorf("[%v] update config update error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to update config update")
+	}
+
+	return update, nil
+}
+
+// checkSigner 仅组织管理员可以代表组织对配置更新进行签名
+func checkSigner(userCtx *users.UserContext, id string, identity *identities.Identity) error {
+	if !userCtx.HasRole(identity.OrganizationID, users.RoleOrganization) {
+		logger.Warnf("[%v] user [%v] is not an administrator of [%v]", id, userCtx.ID, identity.OrganizationID)
+		return errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"only organization administrators can sign config update")
+	}
+
+	return nil
+}
+
+// newConfigSignature 在服务端解密签名私钥后对配置更新进行签名
+func newConfigSignature(userCtx *users.UserContext, id string, env *cb.ConfigUpdateEnvelope,
+	req *SignUpdateRequest) (*cb.ConfigSignature, error) {
+	signer, identity, err := identities.GetSigner(userCtx, req.IdentityID, &req.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSigner(userCtx, id, identity); err != nil {
+		return nil, err
+	}
+
+	signature, err := configtx.NewConfigSignature(signer, env.ConfigUpdate)
+	if err != nil {
+		logger.Errorf("[%v] sign config update error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to sign config update")
+	}
+
+	return signature, nil
+}
+
+// verifyConfigSignature 校验客户端离线生成的配置更新签名，签名头中的创建者必须是该身份
+func verifyConfigSignature(userCtx *users.UserContext, id string, env *cb.ConfigUpdateEnvelope,
+	req *SignUpdateRequest) (*cb.ConfigSignature, error) {
+	verifier, identity, err := identities.GetVerifier(userCtx, req.IdentityID)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSigner(userCtx, id, identity); err != nil {
+		return nil, err
+	}
+
+	creator, err := verifier.Serialize()
+	if err != nil {
+		logger.Errorf("[%v] serialize identity [%v] error: %v", id, req.IdentityID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to serialize identity")
+	}
+
+	signatureHeader, err := protoutil.UnmarshalSignatureHeader(req.SignatureHeader)
+	if err != nil || !bytes.Equal(signatureHeader.Creator, creator) || len(signatureHeader.Nonce) == 0 {
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"invalid signature header")
+	}
+
+	signature, err := verifier.Verify(bytes.Join([][]byte{req.SignatureHeader, env.ConfigUpdate}, nil), req.Signature)
+	if err != nil {
+		logger.Warnf("[%v] verify signature of identity [%v] error: %v", id, req.IdentityID, err)
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrIdentityInvalidSignature,
+			"%v", err)
+	}
+
+	return &cb.ConfigSignature{
+		SignatureHeader: req.SignatureHeader,
+		Signature:       signature,
+	}, nil
+}
+
+type UpdateSigningRequest struct {
+	IdentityID string `json:"identityId,omitempty" validate:"required"`
+}
+
+// GetUpdateSigningRequest 离线签名模式下生成配置更新的签名头以及待签名的数据
+func GetUpdateSigningRequest(userCtx *users.UserContext, networkID, channelID, id string,
+	req *UpdateSigningRequest) (*identities.SigningRequest, error) {
+	update, channel, env, err := getUpdate(networkID, channelID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err = update.checkPending(channel); err != nil {
+		return nil, err
+	}
+
+	verifier, identity, err := identities.GetVerifier(userCtx, req.IdentityID)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSigner(userCtx, id, identity); err != nil {
+		return nil, err
+	}
+
+	signatureHeader, err := protoutil.NewSignatureHeader(verifier)
+	if err != nil {
+		logger.Errorf("[%v] create signature header error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to create signature header")
+	}
+
+	header, err := proto.Marshal(signatureHeader)
+	if err != nil {
+		logger.Errorf("[%v] marshal signature header error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to marshal signature header")
+	}
+
+	signingRequest := identities.NewSigningRequest(bytes.Join([][]byte{header, env.ConfigUpdate}, nil))
+	signingRequest.SignatureHeader = header
+	return signingRequest, nil
+}
+
+type SubmitUpdateRequest struct {
+	IdentityID string `json:"identityId,omitempty" validate:"required"`
+	identities.Credentials
+}
+
+// SubmitUpdate 签名满足全部修改策略后，生成最终的 CONFIG_UPDATE 交易，并将更新后的配置作为通道的当前配置。
+// 提案以及通道在同一个事务中更新，任意一个更新失败时均不生效。已经生成过交易的提案直接返回之前的交易。
+func SubmitUpdate(ctx context.Context, userCtx *users.UserContext, networkID, channelID, id string, req *SubmitUpdateRequest) (*cb.Envelope, error) {
+	update, channel, env, err := getUpdate(networkID, channelID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Status == ConfigUpdateStatusSubmitted {
+		tx := new(cb.Envelope)
+		if err = proto.Unmarshal(update.Envelope, tx); err != nil {
+			logger.Errorf("[%v] unmarshal config update tx error: %v", id, err)
+			return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to unmarshal config update tx")
+		}
+		return tx, nil
+	}
+
+	if err = update.checkPending(channel); err != nil {
+		return nil, err
+	}
+	if !update.SignatureStatus.Satisfied {
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrChannelUpdateNotSatisfied,
+			"config update signatures do not satisfy the policies yet")
+	}
+
+	signer, _, err := identities.GetSigner(userCtx, req.IdentityID, &req.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := configtx.NewConfigUpdateTx(channel.ChannelID, signer, env)
+	if err != nil {
+		logger.Errorf("[%v] create config update tx error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to create config update tx")
+	}
+
+	// 与排序节点一致，将写集应用到通道当前配置，写集中被修改元素的版本号随之更新
+	current, err := channel.config()
+	if err != nil {
+		logger.Errorf("[%v] unmarshal channel config error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to unmarshal channel config")
+	}
+	configUpdate := new(cb.ConfigUpdate)
+	if err = proto.Unmarshal(env.ConfigUpdate, configUpdate); err != nil {
+		logger.Errorf("[%v] unmarshal config update error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to unmarshal config update")
+	}
+	config, err := configtx.ApplyUpdate(current, configUpdate)
+	if err != nil {
+		logger.Errorf("[%v] apply config update error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to apply config update")
+	}
+
+	if channel.Config, err = proto.Marshal(config); err == nil {
+		update.Envelope, err = proto.Marshal(tx)
+	}
+	if err != nil {
+		logger.Errorf("[%v] marshal config update tx error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to marshal config update tx")
+	}
+
+	update.Status = ConfigUpdateStatusSubmitted
+	err = storage.Transaction(ctx, func(tx storage.Storage) error {
+		ctx := storage.NewContext(ctx, tx)
+		if err := update.Update(ctx); err != nil {
+			if err == storage.ErrConflict {
+				logger.Warnf("[%v] config update has been modified concurrently", id)
+				return errors.NewConflictError("config update", false)
+			}
+			logger.Errorf("[%v] update config update error: %v", id, err)
+			return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to update config update")
+		}
+		if err := channel.Update(ctx); err != nil {
+			if err == storage.ErrConflict {
+				logger.Warnf("[%v] channel has been modified concurrently", channel.ChannelID)
+				return errors.NewConflictError("channel", false)
+			}
+			logger.Errorf("[%v] update channel config error: %v", channel.ChannelID, err)
+			return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to update channel config")
+		}
+
+		return nil
+	})
+	if err != nil {
+		if e, ok := err.(*errors.Error); ok {
+			return nil, e
+		}
+		logger.Errorf("[%v] commit config update transaction error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to submit config update")
+	}
+
+	return tx, nil
+}
+
+// checkPending 只有基于通道当前配置且未提交的提案可以继续签名和提交
+func (u *ConfigUpdate) checkPending(channel *Channel) error {
+	if u.Status == ConfigUpdateStatusSubmitted {
+		return errors.NewError(http.StatusConflict, errors.ErrChannelConfigChanged,
+			"config update has already been submitted")
+	}
+
+	config, err := channel.config()
+	if err != nil {
+		logger.Errorf("[%v] unmarshal channel config error: %v", channel.ChannelID, err)
+		return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to unmarshal channel config")
+	}
+	if config.Sequence != u.Sequence {
+		return errors.NewError(http.StatusConflict, errors.ErrChannelConfigChanged,
+			"channel config has changed since the config update was proposed")
+	}
+
+	return nil
+}
+
+func getConfigUpdate(networkID, channelID, id string) (*ConfigUpdate, error) {
+	update, err := FindConfigUpdateByID(networkID, channelID, id)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Warnf("[%v] config update not found", id)
+			return nil, errors.NewError(http.StatusNotFound, errors.ErrChannelUpdateNotFound,
+				"config update not found")
+		}
+		logger.Errorf("[%v] query config update error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	return update, nil
+}
+
+// getUpdate 获取配置更新提案，并根据通道当前配置计算签名收集状态
+func getUpdate(networkID, channelID, id string) (*ConfigUpdate, *Channel, *cb.ConfigUpdateEnvelope, error) {
+	channel, err := GetDetailByID(networkID, channelID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	update, err := getConfigUpdate(networkID, channel.ChannelID, id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	env, err := update.configUpdateEnvelope()
+	if err != nil {
+		logger.Errorf("[%v] unmarshal config update envelope error: %v", id, err)
+		return nil, nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to unmarshal config update")
+	}
+
+	// 已提交或者已过期的提案无法再根据当前配置计算状态
+	if update.Status != ConfigUpdateStatusSubmitted {
+		config, err := channel.config()
+		if err != nil {
+			logger.Errorf("[%v] unmarshal channel config error: %v", channelID, err)
+			return nil, nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to unmarshal channel config")
+		}
+
+		if config.Sequence == update.Sequence {
+			if update.SignatureStatus, err = configtx.EvaluateUpdate(config, env); err != nil {
+				logger.Errorf("[%v] evaluate config update error: %v", id, err)
+				return nil, nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+					"failed to evaluate config update")
+			}
+		}
+	}
+
+	return update, channel, env, nil
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package channels
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/configtx"
+	"github.com/yakumioto/alkaid/internal/common/crypto/kek"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/memory"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/services/identities"
+	"github.com/yakumioto/alkaid/internal/services/organizations"
+	"github.com/yakumioto/alkaid/internal/services/users"
+)
+
+func TestMain(m *testing.M) {
+	km, err := kek.NewLocal(make([]byte, kek.KeySize))
+	if err != nil {
+		panic(err)
+	}
+	kek.Initialize(km)
+	storage.Initialize(memory.NewDB())
+	if err := storage.AutoMigrate(new(organizations.Organization), new(users.UserOrganizations),
+		new(identities.Identity), new(Channel), new(ConfigUpdate)); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// conflictStorage 在事务中更新通道时返回 ErrConflict，模拟提交期间通道被其他请求修改
+type conflictStorage struct {
+	storage.Storage
+}
+
+func (s *conflictStorage) Update(values interface{}, options *storage.UpdateOptions) error {
+	if _, ok := values.(*Channel); ok {
+		return storage.ErrConflict
+	}
+
+	return s.Storage.Update(values, options)
+}
+
+func (s *conflictStorage) Transaction(ctx context.Context, fn func(tx storage.Storage) error) error {
+	return s.Storage.Transaction(ctx, func(tx storage.Storage) error {
+		return fn(&conflictStorage{Storage: tx})
+	})
+}
+
+// newTestChannel 创建排序组织 orderer 以及应用组织 org1、org2，alice 为全部组织的管理员，
+// 每个组织创建一个管理员身份，返回 alice 的 UserContext
+func newTestChannel(t *testing.T) *users.UserContext {
+	ctx := context.Background()
+
+	members := make([]*users.UserOrganizations, 0)
+	for _, id := range []string{"orderer", "org1", "org2"} {
+		_, err := organizations.Create(ctx, &organizations.CreateRequest{OrganizationID: id, Name: id,
+			Domain: id + ".alkaid.com", TransactionPassword: "password", UserID: "alice"})
+		assert.NoError(t, err)
+		members = append(members, users.NewUserOrganizations("alice", id, users.RoleOrganization))
+	}
+	alice := users.NewUserContext(&users.User{UserID: "alice"}, members)
+
+	createIdentity := func(id, orgID, typ string) {
+		_, err := identities.Create(alice, &identities.CreateRequest{IdentityID: id, OrganizationID: orgID, Name: id,
+			Use: identities.UseNode, Type: typ, NodeOUs: true, TransactionPassword: "password"})
+		assert.NoError(t, err, id)
+	}
+	createIdentity("orderer0", "orderer", identities.MSPTypeOrderer)
+	createIdentity("admin-orderer", "orderer", identities.MSPTypeAdmin)
+	createIdentity("admin-org1", "org1", identities.MSPTypeAdmin)
+	createIdentity("admin-org2", "org2", identities.MSPTypeAdmin)
+
+	_, err := Create(&CreateRequest{
+		ChannelID: "mychannel",
+		Orderer: &OrdererRequest{
+			Organizations: []*OrganizationRequest{{OrganizationID: "orderer", Endpoints: []string{"orderer0:7050"}}},
+			Consenters:    []*ConsenterRequest{{IdentityID: "orderer0", Host: "orderer0", Port: 7050}},
+		},
+		Application: &ApplicationRequest{
+			Organizations: []*OrganizationRequest{{OrganizationID: "org1"}, {OrganizationID: "org2"}},
+		},
+		OrganizationID: "org1",
+		NetworkID:      "network1",
+	})
+	assert.NoError(t, err)
+
+	return alice
+}
+
+// proposeACLs 提议修改应用通道的 ACL，需要应用组织多数管理员签名
+func proposeACLs(t *testing.T, policyRef string) *ConfigUpdate {
+	config, err := GetConfig("network1", "mychannel")
+	assert.NoError(t, err)
+
+	acls, err := proto.Marshal(&pb.ACLs{Acls: map[string]*pb.APIResource{
+		"qscc/GetChainInfo": {PolicyRef: policyRef},
+	}})
+	assert.NoError(t, err)
+	config.ChannelGroup.Groups[configtx.ApplicationGroupKey].Values["ACLs"] = &cb.ConfigValue{
+		Value:     acls,
+		ModPolicy: "Admins",
+	}
+
+	data, err := configtx.MarshalJSON(config)
+	assert.NoError(t, err)
+
+	update, err := ProposeUpdate(&ProposeUpdateRequest{Config: data, OrganizationID: "org1",
+		NetworkID: "network1", ChannelID: "mychannel", UserID: "alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, ConfigUpdateStatusPending, update.Status)
+
+	return update
+}
+
+func TestUpdateWorkflow(t *testing.T) {
+	alice := newTestChannel(t)
+	credentials := identities.Credentials{TransactionPassword: "password"}
+	submitReq := &SubmitUpdateRequest{IdentityID: "admin-org1", Credentials: credentials}
+
+	// 不在通道配置中的组织不能提议配置更新
+	config, err := GetConfig("network1", "mychannel")
+	assert.NoError(t, err)
+	data, err := configtx.MarshalJSON(config)
+	assert.NoError(t, err)
+	_, err = ProposeUpdate(&ProposeUpdateRequest{Config: data, OrganizationID: "org3",
+		NetworkID: "network1", ChannelID: "mychannel", UserID: "alice"})
+	assert.Equal(t, http.StatusForbidden, errors.StatusCode(err))
+
+	update := proposeACLs(t, "/Channel/Application/Readers")
+	stale := proposeACLs(t, "/Channel/Application/Writers")
+
+	// 只有 org1 签名时不满足应用组织的多数管理员策略
+	signed, err := SignUpdate(alice, "network1", "mychannel", update.ResourceID,
+		&SignUpdateRequest{IdentityID: "admin-org1", Credentials: credentials})
+	assert.NoError(t, err)
+	assert.Equal(t, ConfigUpdateStatusPending, signed.Status)
+	_, err = SignUpdate(alice, "network1", "mychannel", update.ResourceID,
+		&SignUpdateRequest{IdentityID: "admin-org1", Credentials: credentials})
+	assert.Equal(t, http.StatusBadRequest, errors.StatusCode(err))
+	_, err = SubmitUpdate(context.Background(), alice, "network1", "mychannel", update.ResourceID, submitReq)
+	assert.Equal(t, http.StatusBadRequest, errors.StatusCode(err))
+
+	signed, err = SignUpdate(alice, "network1", "mychannel", update.ResourceID,
+		&SignUpdateRequest{IdentityID: "admin-org2", Credentials: credentials})
+	assert.NoError(t, err)
+	assert.Equal(t, ConfigUpdateStatusSatisfied, signed.Status)
+
+	// 更新通道失败时提案的状态一同回滚，之后可以重新提交
+	conflict := storage.NewContext(context.Background(), &conflictStorage{Storage: storage.FromContext(context.Background())})
+	_, err = SubmitUpdate(conflict, alice, "network1", "mychannel", update.ResourceID, submitReq)
+	assert.Equal(t, http.StatusConflict, errors.StatusCode(err))
+	stored, err := FindConfigUpdateByID("network1", "mychannel", update.ResourceID)
+	assert.NoError(t, err)
+	assert.Equal(t, ConfigUpdateStatusSatisfied, stored.Status)
+	assert.Empty(t, stored.Envelope)
+	config, err = GetConfig("network1", "mychannel")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), config.Sequence)
+
+	tx, err := SubmitUpdate(context.Background(), alice, "network1", "mychannel", update.ResourceID, submitReq)
+	assert.NoError(t, err)
+	config, err = GetConfig("network1", "mychannel")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), config.Sequence)
+	// 新增 ACLs 后应用配置组的版本号与排序节点一致
+	application := config.ChannelGroup.Groups[configtx.ApplicationGroupKey]
+	assert.Equal(t, uint64(1), application.Version)
+	assert.Equal(t, uint64(0), application.Values["ACLs"].Version)
+
+	// 已提交的提案直接返回之前的交易
+	resubmitted, err := SubmitUpdate(context.Background(), alice, "network1", "mychannel", update.ResourceID, submitReq)
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(tx, resubmitted))
+	_, err = SignUpdate(alice, "network1", "mychannel", update.ResourceID,
+		&SignUpdateRequest{IdentityID: "admin-org2", Credentials: credentials})
+	assert.Equal(t, http.StatusConflict, errors.StatusCode(err))
+
+	// 通道配置变化后基于旧配置的提案失效
+	_, err = SignUpdate(alice, "network1", "mychannel", stale.ResourceID,
+		&SignUpdateRequest{IdentityID: "admin-org1", Credentials: credentials})
+	assert.Equal(t, http.StatusConflict, errors.StatusCode(err))
+	_, err = SubmitUpdate(context.Background(), alice, "network1", "mychannel", stale.ResourceID, submitReq)
+	assert.Equal(t, http.StatusConflict, errors.StatusCode(err))
+
+	// 基于提交后的配置可以继续提议、签名以及提交新的配置更新
+	next := proposeACLs(t, "/Channel/Application/Admins")
+	for _, identityID := range []string{"admin-org1", "admin-org2"} {
+		_, err = SignUpdate(alice, "network1", "mychannel", next.ResourceID,
+			&SignUpdateRequest{IdentityID: identityID, Credentials: credentials})
+		assert.NoError(t, err, identityID)
+	}
+	_, err = SubmitUpdate(context.Background(), alice, "network1", "mychannel", next.ResourceID, submitReq)
+	assert.NoError(t, err)
+	config, err = GetConfig("network1", "mychannel")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), config.Sequence)
+	application = config.ChannelGroup.Groups[configtx.ApplicationGroupKey]
+	assert.Equal(t, uint64(1), application.Version)
+	assert.Equal(t, uint64(1), application.Values["ACLs"].Version)
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package channels
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	"github.com/yakumioto/alkaid/internal/common/configtx"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/utils"
+)
+
+const ConfigUpdateResourceNamespace = "ConfigUpdate"
+
+const (
+	ConfigUpdateStatusPending   = "pending"   // 正在收集签名
+	ConfigUpdateStatusSatisfied = "satisfied" // 签名已满足全部修改策略
+	ConfigUpdateStatusSubmitted = "submitted" // 已生成最终的配置更新交易
+)
+
+// ConfigUpdate 通道配置更新提案。
+// ConfigUpdateEnvelope 中保存读写集以及已收集的签名，Config 为更新后的完整通道配置，
+// Sequence 为提案基于的通道配置序号，通道配置变化后提案失效。
+type ConfigUpdate struct {
+	ResourceID           string                 `json:"resourceId,omitempty" gorm:"primaryKey"`
+	ChannelID            string                 `json:"channelId,omitempty" gorm:"index"`
+	NetworkID            string                 `json:"networkId,omitempty" gorm:"index"`
+	OrganizationID       string                 `json:"organizationId,omitempty"`
+	UserID               string                 `json:"userId,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Status               string                 `json:"status,omitempty"`
+	Sequence             uint64                 `json:"sequence"`
+	ConfigUpdateEnvelope []byte                 `json:"-"`
+	Config               []byte                 `json:"-"`
+	Envelope             []byte                 `json:"-"`
+	SignatureStatus      *configtx.UpdateStatus `json:"signatureStatus,omitempty" gorm:"-"`
+	Version              int64                  `json:"version,omitempty" gorm:"default:1"`
+	CreatedAt            int64                  `json:"createdAt,omitempty" gorm:"autoCreateTime"`
+	UpdatedAt            int64                  `json:"updatedAt,omitempty" gorm:"autoUpdateTime"`
+}
+
+func (u *ConfigUpdate) Create() error {
+	u.ResourceID = utils.GenResourceID(ConfigUpdateResourceNamespace)
+	u.Version = 1
+	return storage.Create(u)
+}
+
+func (u *ConfigUpdate) Update(ctx context.Context) error {
+	return storage.FromContext(ctx).Update(u,
+		storage.NewUpdateOptions("resource_id = ?", u.ResourceID).Version("version", u.Version))
+}
+
+func (u *ConfigUpdate) configUpdateEnvelope() (*cb.ConfigUpdateEnvelope, error) {
+	env := new(cb.ConfigUpdateEnvelope)
+	return env, proto.Unmarshal(u.ConfigUpdateEnvelope, env)
+}
+
+func FindConfigUpdateByID(networkID, channelID, id string) (*ConfigUpdate, error) {
+	update := new(ConfigUpdate)
+	return update, storage.FindByQuery(update,
+		storage.NewQueryOptions().
+			Where(&ConfigUpdate{ResourceID: id, NetworkID: networkID, ChannelID: channelID}))
+}
+
+func FindConfigUpdates(options *storage.QueryOptions) ([]*ConfigUpdate, error) {
+	updates := make([]*ConfigUpdate, 0)
+	return updates, storage.FindByQuery(&updates, options)
+}
@@ -0,0 +1,491 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package identities
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/yakumioto/alkaid/internal/common/certificate"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
+	"github.com/yakumioto/alkaid/internal/common/log"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/services/organizations"
+	"github.com/yakumioto/alkaid/internal/services/users"
+)
+
+var (
+	logger = log.GetPackageLogger("services.identities")
+)
+
+type CreateRequest struct {
+	IdentityID          string   `json:"identityId,omitempty" validate:"required"`
+	OrganizationID      string   `json:"organizationId,omitempty" validate:"required"`
+	Name                string   `json:"name,omitempty" validate:"required"`
+	Use                 string   `json:"use,omitempty" validate:"required,oneof=user node"`
+	Type                string   `json:"type,omitempty" validate:"required,oneof=admin client orderer peer"`
+	Description         string   `json:"description,omitempty"`
+	NodeOUs             bool     `json:"nodeOUs,omitempty"`
+	SANs                []string `json:"sans,omitempty"`
+	TransactionPassword string   `json:"transactionPassword" validate:"required"` // 组织的交易密码，用来解密组织 CA 私钥
+	UserID              string   `json:"-"`
+}
+
+func Create(userCtx *users.UserContext, req *CreateRequest) (*Identity, error) {
+	switch req.Use {
+	case UseUser, UseNode:
+	default:
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"unsupported identity use: %v", req.Use)
+	}
+
+	switch req.Type {
+	case MSPTypeAdmin, MSPTypeClient, MSPTypeOrderer, MSPTypePeer:
+	default:
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"unsupported identity type: %v", req.Type)
+	}
+
+	// 节点身份以及管理员、peer、orderer 类型的身份需要组织管理员权限，
+	// 普通成员只能创建 client 类型的用户身份，避免成员签发带有节点 OU 的证书
+	role := users.RoleUser
+	if req.Use == UseNode || req.Type != MSPTypeClient {
+		role = users.RoleOrganization
+	}
+	if !userCtx.HasRole(req.OrganizationID, role) {
+		logger.Warnf("[%v] user [%v] has no permission to create identity", req.IdentityID, userCtx.ID)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"no permission to create identity")
+	}
+
+	// 生成密钥和签发证书之前检查身份是否已存在
+	if _, err := FindIdentityByID(req.IdentityID); err != storage.ErrNotFound {
+		if err == nil {
+			logger.Warnf("[%v] identity already exists", req.IdentityID)
+			return nil, errors.NewError(http.StatusConflict, errors.ErrIdentityAlreadyExists,
+				"identity already exists")
+		}
+		logger.Errorf("[%v] query identity error: %v", req.IdentityID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	org, err := organizations.GetDetailByID(req.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 用户的签名和通讯密钥均为 ECDSA 密钥，SM2 套件的组织只能创建节点身份
+	if req.Use == UseUser && org.CryptoSuite == organizations.CryptoSuiteSM2 {
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"organizations using the SM2 crypto suite only support node identities")
+	}
+
+	req.UserID = userCtx.ID
+	identity := newIdentityByCreateRequest(req)
+
+	keyring, err := organizations.GetKeyring(org, req.TransactionPassword)
+	if err != nil {
+		return nil, err
+	}
+	signCAPrivateKey, err := org.SignCAPrivateKey(keyring)
+	if err != nil {
+		logger.Warnf("[%v] decrypt signature ca key error: %v", req.IdentityID, err)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrOrganizationWrongTransactionPassword,
+			"wrong transaction password")
+	}
+	tlsCAPrivateKey, err := org.TLSCAPrivateKey(keyring)
+	if err != nil {
+		logger.Warnf("[%v] decrypt tls ca key error: %v", req.IdentityID, err)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrOrganizationWrongTransactionPassword,
+			"wrong transaction password")
+	}
+
+	var signPublicKey, tlsPublicKey []byte
+	switch identity.Use {
+	case UseUser:
+		signPublicKey, tlsPublicKey, err = userPublicKeys(userCtx.ID)
+	case UseNode:
+		signPublicKey, tlsPublicKey, err = identity.genNodeKeys(org.KeyStore, org.KeyPolicy(), keyring)
+	}
+	if err != nil {
+		logger.Errorf("[%v] prepare identity keys error: %v", req.IdentityID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to prepare identity keys")
+	}
+
+	commonName := identity.CommonName(org.Domain)
+	pkixName := org.PkixName(commonName)
+
+	signCertificate, err := certificate.SignCertificate(pkixName, commonName, identity.OrganizationalUnit(),
+		nil, signPublicKey, signCAPrivateKey, []byte(org.SignCACertificate))
+	if err != nil {
+		logger.Errorf("[%v] sign signature certificate error: %v", req.IdentityID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to sign signature certificate")
+	}
+	tlsCertificate, err := certificate.SignTLSCertificate(pkixName, commonName, identity.SANs,
+		tlsPublicKey, tlsCAPrivateKey, []byte(org.TlsCACertificate))
+	if err != nil {
+		logger.Errorf("[%v] sign tls certificate error: %v", req.IdentityID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to sign tls certificate")
+	}
+
+	identity.SignCertificate = string(signCertificate)
+	identity.TLSCertificate = string(tlsCertificate)
+
+	if err = identity.Create(); err != nil {
+		logger.Errorf("[%v] create identity error: %v", req.IdentityID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to create identity")
+	}
+
+	return identity, nil
+}
+
+// userPublicKeys 用户身份直接使用用户 pem 格式的签名和通讯公钥
+func userPublicKeys(userID string) ([]byte, []byte, error) {
+	user, err := users.FindUserByID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return []byte(user.SignPublicKey), []byte(user.TLSPublicKey), nil
+}
+
+// genNodeKeys 按照组织的密钥策略为节点身份生成签名和通讯密钥，私钥使用组织的数据密钥加密，返回 pem 格式的公钥，
+// 签名私钥保存在组织的 keyStore 中，Fabric 只能从文件加载通讯私钥，所以通讯私钥始终由软件生成
+func (i *Identity) genNodeKeys(keyStore string, policy crypto.Policy, keyring *organizations.Keyring) ([]byte, []byte, error) {
+	signPublicKey, protectedSignPrivateKey, err := genProtectedPrivateKey(keyStore, policy, keyring,
+		i.associatedData(fieldProtectedSignPrivateKey))
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsPublicKey, protectedTLSPrivateKey, err := genProtectedPrivateKey(factory.ProviderSW, policy, keyring,
+		i.associatedData(fieldProtectedTLSPrivateKey))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	i.ProtectedSignPrivateKey = protectedSignPrivateKey
+	i.ProtectedTLSPrivateKey = protectedTLSPrivateKey
+
+	return signPublicKey, tlsPublicKey, nil
+}
+
+func genProtectedPrivateKey(keyStore string, policy crypto.Policy, keyring *organizations.Keyring, ad []byte) ([]byte, string, error) {
+	privateKey, err := factory.CryptoKeyGenByUsageWithProvider(keyStore, policy, crypto.UsageSign)
+	if err != nil {
+		return nil, "", err
+	}
+	privateKeyPem, err := privateKey.Bytes()
+	if err != nil {
+		return nil, "", err
+	}
+	publicKey, err := privateKey.PublicKey()
+	if err != nil {
+		return nil, "", err
+	}
+	publicKeyPem, err := publicKey.Bytes()
+	if err != nil {
+		return nil, "", err
+	}
+
+	protectedPrivateKey, err := keyring.Encrypt(privateKeyPem, ad)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return publicKeyPem, protectedPrivateKey, nil
+}
+
+func GetList(userCtx *users.UserContext) ([]*Identity, error) {
+	options := storage.NewQueryOptions()
+	if !userCtx.Root {
+		options.Where(&Identity{UserID: userCtx.ID})
+	}
+
+	identities, err := FindIdentities(options)
+	if err != nil {
+		logger.Errorf("[%v] query identities error: %v", userCtx.ID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	return identities, nil
+}
+
+func GetDetailByID(userCtx *users.UserContext, id string) (*Identity, error) {
+	identity, err := FindIdentityByID(id)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Warnf("[%v] identity not found", id)
+			return nil, errors.NewError(http.StatusNotFound, errors.ErrIdentityNotFound,
+				"identity not found")
+		}
+		logger.Errorf("[%v] query identity error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	// 身份仅对所有者以及组织管理员可见
+	if identity.UserID != userCtx.ID && !userCtx.HasRole(identity.OrganizationID, users.RoleOrganization) {
+		logger.Warnf("[%v] user [%v] has no permission to access identity", id, userCtx.ID)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"no permission to access identity")
+	}
+
+	return identity, nil
+}
+
+type UpdateRequest struct {
+	Description string `json:"description,omitempty"`
+	Version     int64  `json:"-"` // If-Match 指定的版本，为 0 时基于读取到的版本更新
+}
+
+func Update(userCtx *users.UserContext, id string, req *UpdateRequest) (*Identity, error) {
+	identity, err := GetDetailByID(userCtx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Version != 0 && req.Version != identity.Version {
+		logger.Warnf("[%v] identity version mismatch: expected %v, current %v", id, req.Version, identity.Version)
+		return nil, errors.NewError(http.StatusPreconditionFailed, errors.ErrPreconditionFailed,
+			"identity has been modified")
+	}
+
+	// 描述允许清空，只更新描述列
+	identity.Description = req.Description
+	if err = identity.Update("description"); err != nil {
+		if err == storage.ErrConflict {
+			logger.Warnf("[%v] identity has been modified concurrently", id)
+			return nil, errors.NewConflictError("identity", req.Version != 0)
+		}
+		logger.Errorf("[%v] update identity error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to update identity")
+	}
+
+	return identity, nil
+}
+
+// FindLegacyEncryptionIdentities 分批查询私钥不是组织数据密钥加密格式的节点身份 ID，
+// 解密节点私钥需要组织的交易密码，只能列出
+func FindLegacyEncryptionIdentities(ctx context.Context, batchSize int) ([]string, error) {
+	pending := make([]string, 0)
+	last := ""
+	for {
+		list := make([]*Identity, 0, batchSize)
+		err := storage.FromContext(ctx).FindByQuery(&list, storage.NewQueryOptions().
+			Where("resource_id > ?", last).Order("resource_id").Limit(batchSize))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, identity := range list {
+			if identity.LegacyEncryption() {
+				pending = append(pending, identity.IdentityID)
+			}
+		}
+		if len(list) < batchSize {
+			return pending, nil
+		}
+		last = list[len(list)-1].ResourceID
+	}
+}
+
+// Credentials 解密身份私钥所需的凭证
+type Credentials struct {
+	Password            string `json:"password,omitempty"`            // 用户身份需要所有者的登陆密码
+	TransactionPassword string `json:"transactionPassword,omitempty"` // 节点身份需要组织的交易密码
+}
+
+type ExportMSPRequest struct {
+	Credentials
+}
+
+// ExportMSP 导出身份的 MSP 目录，私钥仅在此时解密
+func ExportMSP(userCtx *users.UserContext, id string, req *ExportMSPRequest) ([]byte, error) {
+	identity, err := GetDetailByID(userCtx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	org, err := organizations.GetDetailByID(identity.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	material := &MSPMaterial{
+		Domain:     org.Domain,
+		SignCACert: []byte(org.SignCACertificate),
+		TLSCACert:  []byte(org.TlsCACertificate),
+	}
+
+	material.SignPrivateKey, material.TLSPrivateKey, err = identity.privateKeys(userCtx, &req.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	// HSM 中的签名私钥无法导出，需要节点自行配置 PKCS11 BCCSP
+	if factory.IsKeyHandle(material.SignPrivateKey) {
+		material.SignPrivateKey = nil
+	}
+
+	archive, err := identity.MSPArchive(material)
+	if err != nil {
+		logger.Errorf("[%v] archive msp error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to archive msp")
+	}
+
+	return archive, nil
+}
+
+// GetSigner 解密身份的签名私钥，返回可以直接用于 protoutil 签名的 Signer
+func GetSigner(userCtx *users.UserContext, id string, credentials *Credentials) (*crypto.Signer, *Identity, error) {
+	identity, err := GetDetailByID(userCtx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signPrivateKey, _, err := identity.privateKeys(userCtx, credentials)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signer, err := NewSigner(identity.OrganizationID, []byte(identity.SignCertificate), signPrivateKey)
+	if err != nil {
+		logger.Errorf("[%v] create signer error: %v", id, err)
+		return nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to create signer")
+	}
+
+	return signer, identity, nil
+}
+
+// GetVerifier 离线签名模式下使用，返回校验客户端签名的 Verifier，
+// 只有用户身份的私钥由客户端持有，仅所有者可以使用
+func GetVerifier(userCtx *users.UserContext, id string) (*Verifier, *Identity, error) {
+	identity, err := GetDetailByID(userCtx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if identity.Use != UseUser {
+		return nil, nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"only user identities support offline signing")
+	}
+	if identity.UserID != userCtx.ID {
+		logger.Warnf("[%v] user [%v] is not the owner of identity", id, userCtx.ID)
+		return nil, nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"only the owner can use user identity")
+	}
+
+	user, err := users.FindUserByID(identity.UserID)
+	if err != nil {
+		logger.Errorf("[%v] query user [%v] error: %v", id, identity.UserID, err)
+		return nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	verifier, err := NewVerifier(identity.OrganizationID, []byte(identity.SignCertificate), []byte(user.SignPublicKey))
+	if err != nil {
+		logger.Errorf("[%v] create verifier error: %v", id, err)
+		return nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to create verifier")
+	}
+
+	return verifier, identity, nil
+}
+
+// privateKeys 解密身份的签名以及通讯私钥，用户身份仅所有者可以解密
+func (i *Identity) privateKeys(userCtx *users.UserContext, credentials *Credentials) ([]byte, []byte, error) {
+	switch i.Use {
+	case UseUser:
+		if i.UserID != userCtx.ID {
+			logger.Warnf("[%v] user [%v] is not the owner of identity", i.IdentityID, userCtx.ID)
+			return nil, nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+				"only the owner can use user identity")
+		}
+
+		user, err := users.FindUserByID(i.UserID)
+		if err != nil {
+			logger.Errorf("[%v] query user [%v] error: %v", i.IdentityID, i.UserID, err)
+			return nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"server unknown error")
+		}
+		if !user.ValidatePassword(credentials.Password) {
+			logger.Infof("[%v] wrong user password", i.IdentityID)
+			return nil, nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+				"wrong user password")
+		}
+
+		// 用户登录之前受保护字段可能仍是旧格式，使用提供的密码升级之后再解密
+		if user.LegacyEncryption() {
+			if err = users.UpgradeEncryption(context.Background(), user, credentials.Password); err != nil {
+				logger.Errorf("[%v] upgrade user [%v] encryption error: %v", i.IdentityID, i.UserID, err)
+				return nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+					"failed to upgrade encryption")
+			}
+			if user, err = users.FindUserByID(i.UserID); err != nil {
+				logger.Errorf("[%v] query user [%v] error: %v", i.IdentityID, i.UserID, err)
+				return nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+					"server unknown error")
+			}
+		}
+
+		signPrivateKey, err := user.SignPrivateKey(credentials.Password)
+		if err != nil {
+			logger.Errorf("[%v] decrypt user signature key error: %v", i.IdentityID, err)
+			return nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to decrypt signature key")
+		}
+		tlsPrivateKey, err := user.TLSPrivateKey(credentials.Password)
+		if err != nil {
+			logger.Errorf("[%v] decrypt user tls key error: %v", i.IdentityID, err)
+			return nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to decrypt tls key")
+		}
+
+		return signPrivateKey, tlsPrivateKey, nil
+	case UseNode:
+		org, err := organizations.GetDetailByID(i.OrganizationID)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyring, err := organizations.GetKeyring(org, credentials.TransactionPassword)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		signPrivateKey, err := keyring.DecryptPrivateKey(i.ProtectedSignPrivateKey,
+			i.associatedData(fieldProtectedSignPrivateKey))
+		if err != nil {
+			logger.Warnf("[%v] decrypt node signature key error: %v", i.IdentityID, err)
+			return nil, nil, errors.NewError(http.StatusForbidden, errors.ErrOrganizationWrongTransactionPassword,
+				"wrong transaction password")
+		}
+		tlsPrivateKey, err := keyring.DecryptPrivateKey(i.ProtectedTLSPrivateKey,
+			i.associatedData(fieldProtectedTLSPrivateKey))
+		if err != nil {
+			logger.Warnf("[%v] decrypt node tls key error: %v", i.IdentityID, err)
+			return nil, nil, errors.NewError(http.StatusForbidden, errors.ErrOrganizationWrongTransactionPassword,
+				"wrong transaction password")
+		}
+
+		return signPrivateKey, tlsPrivateKey, nil
+	}
+
+	return nil, nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+		"unsupported identity use: %v", i.Use)
+}
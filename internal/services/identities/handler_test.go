@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package identities
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/memory"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/services/users"
+)
+
+func TestMain(m *testing.M) {
+	storage.Initialize(memory.NewDB())
+	if err := storage.AutoMigrate(new(Identity)); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestUpdate(t *testing.T) {
+	identity := &Identity{IdentityID: "user1-org1", OrganizationID: "org1", UserID: "alice", Name: "user1",
+		ProtectedSignPrivateKey: "sign", ProtectedTLSPrivateKey: "tls"}
+	assert.NoError(t, identity.Create())
+	assert.Equal(t, int64(1), identity.Version)
+
+	userCtx := &users.UserContext{ID: "alice"}
+
+	// 按顺序执行，后面的用例依赖前面用例的结果
+	tcs := []struct {
+		name    string
+		id      string
+		version int64
+		status  int
+		current int64
+	}{
+		{"without If-Match", "user1-org1", 0, 0, 2},
+		{"If-Match", "user1-org1", 2, 0, 3},
+		{"stale If-Match", "user1-org1", 2, http.StatusPreconditionFailed, 3},
+		{"not found", "user2-org1", 1, http.StatusNotFound, 3},
+	}
+
+	for _, tc := range tcs {
+		updated, err := Update(userCtx, tc.id, &UpdateRequest{Description: tc.name, Version: tc.version})
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+		if tc.status == 0 {
+			assert.Equal(t, tc.current, updated.Version, tc.name)
+
+			// 加密后的私钥不会返回给客户端
+			data, err := json.Marshal(updated)
+			assert.NoError(t, err, tc.name)
+			assert.NotContains(t, string(data), "protected", tc.name)
+		}
+
+		stored, err := FindIdentityByID("user1-org1")
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.current, stored.Version, tc.name)
+	}
+
+	// 描述可以清空
+	updated, err := Update(userCtx, "user1-org1", &UpdateRequest{})
+	assert.NoError(t, err)
+	stored, err := FindIdentityByID("user1-org1")
+	assert.NoError(t, err)
+	assert.Equal(t, "", stored.Description)
+	assert.Equal(t, updated.Version, stored.Version)
+
+	// 读取之后记录被其他请求修改
+	assert.Equal(t, storage.ErrConflict, identity.Update())
+	assert.Equal(t, int64(1), identity.Version)
+}
+
+func TestCreatePermission(t *testing.T) {
+	member := users.NewUserContext(&users.User{UserID: "bob"},
+		[]*users.UserOrganizations{{OrganizationID: "org1", Role: users.RoleUser}})
+
+	tcs := []struct {
+		name string
+		use  string
+		typ  string
+	}{
+		{"node", UseNode, MSPTypePeer},
+		{"admin", UseUser, MSPTypeAdmin},
+		{"peer", UseUser, MSPTypePeer},
+		{"orderer", UseUser, MSPTypeOrderer},
+	}
+
+	for _, tc := range tcs {
+		_, err := Create(member, &CreateRequest{IdentityID: "bob-org1", OrganizationID: "org1", Name: "bob",
+			Use: tc.use, Type: tc.typ, TransactionPassword: "password"})
+		assert.Equal(t, http.StatusForbidden, errors.StatusCode(err), tc.name)
+	}
+
+	_, err := FindIdentityByID("bob-org1")
+	assert.Equal(t, storage.ErrNotFound, err)
+}
+
+func TestFindLegacyEncryptionIdentities(t *testing.T) {
+	for _, identity := range []*Identity{
+		{IdentityID: "legacy-user", OrganizationID: "org2", UserID: "alice", Use: UseUser},
+		{IdentityID: "legacy-node", OrganizationID: "org2", Use: UseNode,
+			ProtectedSignPrivateKey: "AAAA", ProtectedTLSPrivateKey: "AAAA"},
+	} {
+		assert.NoError(t, identity.Create(), identity.IdentityID)
+	}
+
+	// 用户身份的私钥由用户的对称密钥保护，不在列表中
+	pending, err := FindLegacyEncryptionIdentities(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"legacy-node"}, pending)
+}
+
+func TestCreateDuplicate(t *testing.T) {
+	assert.NoError(t, (&Identity{IdentityID: "peer0-org3", OrganizationID: "org3", Use: UseNode,
+		Type: MSPTypePeer}).Create())
+
+	admin := users.NewUserContext(&users.User{UserID: "carol"},
+		[]*users.UserOrganizations{{OrganizationID: "org3", Role: users.RoleOrganization}})
+
+	// 身份已存在时在读取组织和生成密钥之前返回
+	_, err := Create(admin, &CreateRequest{IdentityID: "peer0-org3", OrganizationID: "org3", Name: "peer0",
+		Use: UseNode, Type: MSPTypePeer, TransactionPassword: "password"})
+	assert.Equal(t, http.StatusConflict, errors.StatusCode(err))
+	assert.Equal(t, errors.ErrIdentityAlreadyExists, err.(*errors.Error).Code)
+}
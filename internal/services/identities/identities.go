@@ -8,9 +8,160 @@
 
 package identities
 
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"github.com/yakumioto/alkaid/internal/common/certificate"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/utils"
+	"github.com/yakumioto/alkaid/internal/services/organizations"
+)
+
+const ResourceNamespace = "Identity"
+
 const (
-	MSPTypeOrderer = "orderer"
-	MSPTypePeer    = "peer"
-	MSPTypeAdmin   = "admin"
-	MSPTypeClient  = "client"
+	MSPTypeOrderer = certificate.MSPTypeOrderer
+	MSPTypePeer    = certificate.MSPTypePeer
+	MSPTypeAdmin   = certificate.MSPTypeAdmin
+	MSPTypeClient  = certificate.MSPTypeClient
 )
+
+const (
+	UseUser = "user"
+	UseNode = "node"
+)
+
+// SANs 证书的备用名称，以 JSON 数组的形式存储
+type SANs []string
+
+func (s SANs) Value() (driver.Value, error) {
+	if s == nil {
+		return "[]", nil
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+func (s *SANs) Scan(value interface{}) error {
+	var data []byte
+
+	switch value := value.(type) {
+	case []byte:
+		data = value
+	case string:
+		data = []byte(value)
+	case nil:
+		*s = nil
+		return nil
+	default:
+		return fmt.Errorf("unsupported sans type: %T", value)
+	}
+
+	return json.Unmarshal(data, s)
+}
+
+// Identity 身份，由组织 CA 签发的 Fabric MSP 身份。
+// 用户身份直接使用用户的签名和通讯公钥签发证书，私钥依然由用户的对称密钥保护；
+// 节点身份会单独生成签名和通讯密钥，并使用组织的数据密钥进行加密。
+type Identity struct {
+	ResourceID              string `json:"resourceId,omitempty" gorm:"primaryKey"`
+	IdentityID              string `json:"identityId,omitempty" gorm:"uniqueIndex"`
+	OrganizationID          string `json:"organizationId,omitempty" gorm:"index"`
+	UserID                  string `json:"userId,omitempty" gorm:"index"`
+	Name                    string `json:"name,omitempty"`
+	Use                     string `json:"use,omitempty"`
+	Type                    string `json:"type,omitempty"`
+	Description             string `json:"description,omitempty"`
+	NodeOUs                 bool   `json:"nodeOUs,omitempty"`
+	SANs                    SANs   `json:"sans,omitempty" gorm:"type:text"`
+	ProtectedSignPrivateKey string `json:"-"`
+	ProtectedTLSPrivateKey  string `json:"-"`
+	SignCertificate         string `json:"signCertificate,omitempty"`
+	TLSCertificate          string `json:"tlsCertificate,omitempty"`
+	Version                 int64  `json:"version,omitempty" gorm:"default:1"`
+	CreatedAt               int64  `json:"createdAt,omitempty" gorm:"autoCreateTime"`
+	UpdatedAt               int64  `json:"updatedAt,omitempty" gorm:"autoUpdateTime"`
+}
+
+func newIdentityByCreateRequest(req *CreateRequest) *Identity {
+	return &Identity{
+		IdentityID:     req.IdentityID,
+		OrganizationID: req.OrganizationID,
+		UserID:         req.UserID,
+		Name:           req.Name,
+		Use:            req.Use,
+		Type:           req.Type,
+		Description:    req.Description,
+		NodeOUs:        req.NodeOUs,
+		SANs:           req.SANs,
+	}
+}
+
+// CommonName 节点身份解析为域名：{{ .Name }}.{{ .Domain }}，
+// 用户身份解析为邮箱：{{ .Name }}@{{ .Domain }}
+func (i *Identity) CommonName(domain string) string {
+	switch i.Type {
+	case MSPTypeOrderer, MSPTypePeer:
+		return fmt.Sprintf("%s.%s", i.Name, domain)
+	}
+
+	return fmt.Sprintf("%s@%s", i.Name, domain)
+}
+
+// OrganizationalUnit 开启 NodeOUs 时在证书中标识身份类型
+func (i *Identity) OrganizationalUnit() string {
+	if !i.NodeOUs {
+		return ""
+	}
+
+	return i.Type
+}
+
+// 受保护字段加密时使用的附加数据字段名，密文与身份 ID 以及字段名绑定
+const (
+	fieldProtectedSignPrivateKey = "protectedSignPrivateKey"
+	fieldProtectedTLSPrivateKey  = "protectedTlsPrivateKey"
+)
+
+func (i *Identity) associatedData(field string) []byte {
+	return []byte(i.IdentityID + "/" + field)
+}
+
+// LegacyEncryption 节点身份的私钥不是使用组织数据密钥加密的 AES-256-GCM 格式时返回 true，
+// 用户身份的私钥由用户的对称密钥保护，见 users.User.LegacyEncryption
+func (i *Identity) LegacyEncryption() bool {
+	return i.Use == UseNode &&
+		(!organizations.IsEnvelope(i.ProtectedSignPrivateKey) || !organizations.IsEnvelope(i.ProtectedTLSPrivateKey))
+}
+
+func (i *Identity) Create() error {
+	i.ResourceID = utils.GenResourceID(ResourceNamespace)
+	i.Version = 1
+	return storage.Create(i)
+}
+
+// Update 更新身份，指定 columns 时只更新这些列，未指定时只更新非零值字段
+func (i *Identity) Update(columns ...string) error {
+	return storage.Update(i, storage.NewUpdateOptions("resource_id = ?", i.ResourceID).
+		Version("version", i.Version).Select(columns...))
+}
+
+func FindIdentityByID(id string) (*Identity, error) {
+	identity := new(Identity)
+	return identity, storage.FindByQuery(identity,
+		storage.NewQueryOptions().
+			Or(&Identity{IdentityID: id}).
+			Or(&Identity{ResourceID: id}))
+}
+
+func FindIdentities(options *storage.QueryOptions) ([]*Identity, error) {
+	identities := make([]*Identity, 0)
+	return identities, storage.FindByQuery(&identities, options)
+}
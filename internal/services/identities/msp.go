@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package identities
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"path"
+	"time"
+)
+
+const nodeOUsConfigTemplate = `NodeOUs:
+  Enable: true
+  ClientOUIdentifier:
+    Certificate: %[1]s
+    OrganizationalUnitIdentifier: client
+  PeerOUIdentifier:
+    Certificate: %[1]s
+    OrganizationalUnitIdentifier: peer
+  AdminOUIdentifier:
+    Certificate: %[1]s
+    OrganizationalUnitIdentifier: admin
+  OrdererOUIdentifier:
+    Certificate: %[1]s
+    OrganizationalUnitIdentifier: orderer
+`
+
+// MSPMaterial 生成 MSP 目录所需的证书和私钥，均为 pem 格式
+type MSPMaterial struct {
+	Domain         string
+	SignCACert     []byte
+	TLSCACert      []byte
+	SignPrivateKey []byte
+	TLSPrivateKey  []byte
+}
+
+type mspFile struct {
+	name string
+	data []byte
+}
+
+// MSPFiles 按照 cryptogen 的目录结构生成 msp 以及 tls 目录，
+// 节点身份的通讯证书命名为 server.crt，用户身份命名为 client.crt。
+func (i *Identity) MSPFiles(material *MSPMaterial) []*mspFile {
+	commonName := i.CommonName(material.Domain)
+	caCertName := fmt.Sprintf("cacerts/ca.%s-cert.pem", material.Domain)
+	signCertName := fmt.Sprintf("%s-cert.pem", commonName)
+
+	files := []*mspFile{
+		{path.Join("msp", caCertName), material.SignCACert},
+		{path.Join("msp/tlscacerts", fmt.Sprintf("tlsca.%s-cert.pem", material.Domain)), material.TLSCACert},
+		{path.Join("msp/signcerts", signCertName), []byte(i.SignCertificate)},
+	}
+	if material.SignPrivateKey != nil {
+		files = append(files, &mspFile{"msp/keystore/priv_sk", material.SignPrivateKey})
+	}
+
+	if i.NodeOUs {
+		files = append(files, &mspFile{"msp/config.yaml", []byte(fmt.Sprintf(nodeOUsConfigTemplate, caCertName))})
+	} else if i.Type == MSPTypeAdmin {
+		// 未开启 NodeOUs 时通过 admincerts 标识管理员
+		files = append(files, &mspFile{path.Join("msp/admincerts", signCertName), []byte(i.SignCertificate)})
+	}
+
+	tlsName := "client"
+	if i.Use == UseNode {
+		tlsName = "server"
+	}
+
+	files = append(files,
+		&mspFile{"tls/ca.crt", material.TLSCACert},
+		&mspFile{path.Join("tls", tlsName+".crt"), []byte(i.TLSCertificate)},
+		&mspFile{path.Join("tls", tlsName+".key"), material.TLSPrivateKey},
+	)
+
+	return files
+}
+
+// MSPArchive 将 MSP 目录打包为 tar.gz，根目录为身份 ID
+func (i *Identity) MSPArchive(material *MSPMaterial) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+
+	now := time.Now()
+	for _, file := range i.MSPFiles(material) {
+		mode := int64(0644)
+		if path.Ext(file.name) == ".key" || path.Base(file.name) == "priv_sk" {
+			mode = 0600
+		}
+
+		if err := tw.WriteHeader(&tar.Header{
+			Name:    path.Join(i.IdentityID, file.name),
+			Mode:    mode,
+			Size:    int64(len(file.data)),
+			ModTime: now,
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package identities
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentity_MSPArchive(t *testing.T) {
+	tcs := []struct {
+		identity *Identity
+		files    []string
+	}{
+		{
+			&Identity{IdentityID: "peer0", Name: "peer0", Use: UseNode, Type: MSPTypePeer, NodeOUs: true},
+			[]string{
+				"peer0/msp/cacerts/ca.org1.example.com-cert.pem",
+				"peer0/msp/tlscacerts/tlsca.org1.example.com-cert.pem",
+				"peer0/msp/signcerts/peer0.org1.example.com-cert.pem",
+				"peer0/msp/keystore/priv_sk",
+				"peer0/msp/config.yaml",
+				"peer0/tls/ca.crt",
+				"peer0/tls/server.crt",
+				"peer0/tls/server.key",
+			},
+		},
+		{
+			&Identity{IdentityID: "admin", Name: "Admin", Use: UseUser, Type: MSPTypeAdmin},
+			[]string{
+				"admin/msp/cacerts/ca.org1.example.com-cert.pem",
+				"admin/msp/tlscacerts/tlsca.org1.example.com-cert.pem",
+				"admin/msp/signcerts/Admin@org1.example.com-cert.pem",
+				"admin/msp/keystore/priv_sk",
+				"admin/msp/admincerts/Admin@org1.example.com-cert.pem",
+				"admin/tls/ca.crt",
+				"admin/tls/client.crt",
+				"admin/tls/client.key",
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		archive, err := tc.identity.MSPArchive(&MSPMaterial{
+			Domain:         "org1.example.com",
+			SignCACert:     []byte("sign ca"),
+			TLSCACert:      []byte("tls ca"),
+			SignPrivateKey: []byte("sign key"),
+			TLSPrivateKey:  []byte("tls key"),
+		})
+		assert.NoError(t, err)
+
+		gr, err := gzip.NewReader(bytes.NewReader(archive))
+		assert.NoError(t, err)
+		tr := tar.NewReader(gr)
+
+		files := make([]string, 0)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			assert.NoError(t, err)
+			files = append(files, header.Name)
+		}
+
+		assert.Equal(t, tc.files, files)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package identities
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"math/big"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/pkg/errors"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/yakumioto/alkaid/internal/common/certificate"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
+	fabricCrypto "github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/common/crypto"
+)
+
+// NewSigner 使用身份 pem 格式的签名私钥以及签名证书创建 Signer，
+// ECDSA 私钥的签名结果为 low-S 格式，SM2 私钥（SM2 套件组织的节点身份）使用 SM3 计算摘要，
+// 私钥为 HSM 中密钥的句柄时在设备中签名
+func NewSigner(mspID string, cert, privateKey []byte) (*crypto.Signer, error) {
+	if factory.IsKeyHandle(privateKey) {
+		key, err := factory.CryptoPrivateKeyImport(privateKey, crypto.EcdsaP256)
+		if err != nil {
+			return nil, err
+		}
+
+		return crypto.NewSigner(mspID, cert, key)
+	}
+
+	parsed, err := certificate.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm := crypto.EcdsaP256
+	if _, ok := parsed.(*sm2.PrivateKey); ok {
+		algorithm = crypto.Sm2
+	}
+
+	key, err := factory.CryptoKeyImport(privateKey, algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.NewSigner(mspID, cert, key)
+}
+
+// SigningRequest 离线签名模式下返回给客户端的待签名数据，
+// 客户端使用自己的签名私钥对 Digest 进行 ECDSA 签名后提交 ASN.1 编码的签名结果
+type SigningRequest struct {
+	SignatureHeader []byte `json:"signatureHeader,omitempty"` // 配置更新签名需要原样提交
+	Payload         []byte `json:"payload,omitempty"`         // 待签名的原始数据
+	Digest          []byte `json:"digest,omitempty"`          // Payload 的 SHA-256 摘要
+}
+
+func NewSigningRequest(payload []byte) *SigningRequest {
+	digest := sha256.Sum256(payload)
+	return &SigningRequest{
+		Payload: payload,
+		Digest:  digest[:],
+	}
+}
+
+// Verifier 离线签名模式下代替 Signer，只持有身份证书以及用户的签名公钥，签名由客户端在本地完成
+type Verifier struct {
+	mspID     string
+	cert      []byte
+	publicKey *ecdsa.PublicKey
+}
+
+// NewVerifier 证书以及公钥均为 pem 格式
+func NewVerifier(mspID string, cert, publicKey []byte) (*Verifier, error) {
+	key, err := certificate.PublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Verifier{
+		mspID:     mspID,
+		cert:      cert,
+		publicKey: key,
+	}, nil
+}
+
+// Verify 校验客户端对 msg 的签名，返回转换为 low-S 格式后的签名
+func (v *Verifier) Verify(msg, signature []byte) ([]byte, error) {
+	sig := new(fabricCrypto.ECDSASignature)
+	rest, err := asn1.Unmarshal(signature, sig)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid signature")
+	}
+	if len(rest) != 0 || sig.R == nil || sig.S == nil || sig.R.Sign() <= 0 || sig.S.Sign() <= 0 {
+		return nil, errors.New("invalid signature")
+	}
+
+	digest := sha256.Sum256(msg)
+	if !ecdsa.Verify(v.publicKey, digest[:], sig.R, sig.S) {
+		return nil, errors.New("signature verification failed")
+	}
+
+	// Fabric 只接受 low-S 格式的签名，(r, N-s) 同样是合法的签名
+	halfOrder := new(big.Int).Rsh(v.publicKey.Params().N, 1)
+	if sig.S.Cmp(halfOrder) == 1 {
+		sig.S.Sub(v.publicKey.Params().N, sig.S)
+	}
+
+	return asn1.Marshal(*sig)
+}
+
+func (v *Verifier) Serialize() ([]byte, error) {
+	return proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   v.mspID,
+		IdBytes: v.cert,
+	})
+}
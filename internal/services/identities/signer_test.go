@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package identities
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
+	fabricCrypto "github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/common/crypto"
+	"github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/protoutil"
+)
+
+func TestNewSigner(t *testing.T) {
+	privateKey, err := factory.CryptoKeyGen(crypto.EcdsaP256)
+	assert.NoError(t, err)
+	privateKeyPem, err := privateKey.Bytes()
+	assert.NoError(t, err)
+	publicKey, err := privateKey.PublicKey()
+	assert.NoError(t, err)
+
+	signer, err := NewSigner("org1", []byte("cert"), privateKeyPem)
+	assert.NoError(t, err)
+
+	// 可以直接用于 protoutil
+	env, err := protoutil.CreateSignedEnvelope(cb.HeaderType_CONFIG_UPDATE, "mychannel", signer, &cb.ConfigUpdateEnvelope{}, 0, 0)
+	assert.NoError(t, err)
+	digest := sha256.Sum256(env.Payload)
+	assert.True(t, publicKey.(crypto.LowSVerifier).VerifyLowS(digest[:], env.Signature))
+
+	payload, err := protoutil.UnmarshalPayload(env.Payload)
+	assert.NoError(t, err)
+	header, err := protoutil.UnmarshalSignatureHeader(payload.Header.SignatureHeader)
+	assert.NoError(t, err)
+	creator := new(msp.SerializedIdentity)
+	assert.NoError(t, proto.Unmarshal(header.Creator, creator))
+	assert.Equal(t, "org1", creator.Mspid)
+	assert.Equal(t, []byte("cert"), creator.IdBytes)
+
+	_, err = NewSigner("org1", []byte("cert"), []byte("invalid"))
+	assert.Error(t, err)
+
+	publicKeyPem, err := publicKey.Bytes()
+	assert.NoError(t, err)
+	_, err = NewSigner("org1", []byte("cert"), publicKeyPem)
+	assert.Error(t, err)
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	key, err := fabricCrypto.GeneratePrivateKey()
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+
+	verifier, err := NewVerifier("org1", []byte("cert"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	assert.NoError(t, err)
+
+	msg := []byte("proposal")
+	request := NewSigningRequest(msg)
+	digest := sha256.Sum256(msg)
+	assert.Equal(t, digest[:], request.Digest)
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, request.Digest)
+	assert.NoError(t, err)
+	halfOrder := new(big.Int).Rsh(key.Params().N, 1)
+	lowS := new(big.Int).Set(s)
+	highS := new(big.Int).Set(s)
+	if s.Cmp(halfOrder) == 1 {
+		lowS.Sub(key.Params().N, s)
+	} else {
+		highS.Sub(key.Params().N, s)
+	}
+
+	expected, err := asn1.Marshal(fabricCrypto.ECDSASignature{R: r, S: lowS})
+	assert.NoError(t, err)
+	highSig, err := asn1.Marshal(fabricCrypto.ECDSASignature{R: r, S: highS})
+	assert.NoError(t, err)
+	other, err := asn1.Marshal(fabricCrypto.ECDSASignature{R: r, S: big.NewInt(1)})
+	assert.NoError(t, err)
+
+	tcs := []struct {
+		msg       []byte
+		signature []byte
+		isErr     bool
+	}{
+		{msg, expected, false},
+		{msg, highSig, false},
+		{[]byte("other"), expected, true},
+		{msg, other, true},
+		{msg, []byte("invalid"), true},
+	}
+
+	for _, tc := range tcs {
+		signature, err := verifier.Verify(tc.msg, tc.signature)
+		if tc.isErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, expected, signature)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package msps
+
+import (
+	"net/http"
+
+	"github.com/hyperledger/fabric-protos-go/msp"
+	"github.com/yakumioto/alkaid/internal/common/configtx"
+	"github.com/yakumioto/alkaid/internal/common/log"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/services/identities"
+	"github.com/yakumioto/alkaid/internal/services/organizations"
+)
+
+var (
+	logger = log.GetPackageLogger("services.msps")
+)
+
+// GetMSPConfig 获取组织的通道 MSP 配置，MSP 配置中仅包含公开的证书信息
+func GetMSPConfig(orgID string) (*msp.MSPConfig, error) {
+	org, err := organizations.GetDetailByID(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := BuildMSPConfig(org)
+	if err != nil {
+		logger.Errorf("[%v] build msp config error: %v", orgID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to build msp config")
+	}
+
+	return config, nil
+}
+
+// BuildMSPConfig 根据组织的根证书以及未开启 NodeOUs 的管理员身份生成 MSP 配置
+func BuildMSPConfig(org *organizations.Organization) (*msp.MSPConfig, error) {
+	admins, err := identities.FindIdentities(storage.NewQueryOptions().
+		Where(&identities.Identity{OrganizationID: org.OrganizationID, Type: identities.MSPTypeAdmin}))
+	if err != nil {
+		return nil, err
+	}
+
+	adminCerts := make([][]byte, 0)
+	for _, admin := range admins {
+		if !admin.NodeOUs {
+			adminCerts = append(adminCerts, []byte(admin.SignCertificate))
+		}
+	}
+
+	return configtx.NewMSPConfig(org.MSPOptions(adminCerts))
+}
@@ -9,13 +9,16 @@
 package organizations
 
 import (
-	"encoding/base64"
+	"context"
 	"net/http"
 
+	"github.com/yakumioto/alkaid/internal/common/certificate"
 	"github.com/yakumioto/alkaid/internal/common/crypto"
 	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
 	"github.com/yakumioto/alkaid/internal/common/log"
+	"github.com/yakumioto/alkaid/internal/common/storage"
 	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/services/users"
 )
 
 var (
@@ -33,13 +36,40 @@ type CreateRequest struct {
 	OrganizationalUnit  string `json:"organizationalUnit,omitempty"`
 	StreetAddress       string `json:"streetAddress,omitempty"`
 	PostalCode          string `json:"postalCode,omitempty"`
+	CryptoSuite         string `json:"cryptoSuite,omitempty" validate:"omitempty,oneof=ECDSA_P256 SM2"`
+	KeyStore            string `json:"keyStore,omitempty" validate:"omitempty,oneof=SW PKCS11"`
 	TransactionPassword string `json:"transactionPassword" validate:"required"` // 交易密码仅用来加解密 PrivateKey
+	UserID              string `json:"-"`
 }
 
-func Create(req *CreateRequest) (*Organization, error) {
+// Create 创建组织，组织及其管理员成员关系在同一个事务中写入
+func Create(ctx context.Context, req *CreateRequest) (*Organization, error) {
+	switch req.CryptoSuite {
+	case "", CryptoSuiteECDSA, CryptoSuiteSM2:
+	default:
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"unsupported crypto suite: %v", req.CryptoSuite)
+	}
+	switch req.KeyStore {
+	case "", KeyStoreSW:
+	case KeyStorePKCS11:
+		if req.CryptoSuite == CryptoSuiteSM2 {
+			return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"the PKCS11 key store does not support the SM2 crypto suite")
+		}
+		if _, err := factory.GetProvider(req.KeyStore); err != nil {
+			logger.Warnf("[%v] key store unavailable: %v", req.OrganizationID, err)
+			return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"the PKCS11 key store is not enabled")
+		}
+	default:
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"unsupported key store: %v", req.KeyStore)
+	}
+
 	org := newOrganizationByCreateRequest(req)
 
-	signCAPrivateKey, err := factory.CryptoKeyGen(crypto.EcdsaP256)
+	signCAPrivateKey, err := factory.CryptoKeyGenByUsageWithProvider(org.KeyStore, org.KeyPolicy(), crypto.UsageSign)
 	if err != nil {
 		logger.Errorf("[%v] generate signature key error: %v", req.OrganizationID, err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
@@ -52,7 +82,7 @@ func Create(req *CreateRequest) (*Organization, error) {
 			"failed to convert the signature key to pem format")
 	}
 
-	tlsCAPrivateKey, err := factory.CryptoKeyGen(crypto.EcdsaP256)
+	tlsCAPrivateKey, err := factory.CryptoKeyGenByUsageWithProvider(org.KeyStore, org.KeyPolicy(), crypto.UsageSign)
 	if err != nil {
 		logger.Errorf("[%v] generate tls key error: %v", req.OrganizationID, err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
@@ -65,29 +95,335 @@ func Create(req *CreateRequest) (*Organization, error) {
 			"failed to convert the tls key to pem format")
 	}
 
-	aesKey, err := factory.CryptoKeyImport([]byte(req.TransactionPassword), crypto.AesCbc256)
+	keyring, err := org.genKeyring(req.TransactionPassword)
 	if err != nil {
-		logger.Errorf("[%v] import transaction password error: %v", req.OrganizationID, err)
+		logger.Errorf("[%v] generate data key error: %v", req.OrganizationID, err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
-			"failed to import transaction password key")
+			"failed to generate data key")
 	}
-	protectedSignPrivateKey, err := aesKey.Encrypt(signCAPrivateKeyPem)
+	org.ProtectedSignCAPrivateKey, err = keyring.Encrypt(signCAPrivateKeyPem,
+		org.AssociatedData(fieldProtectedSignCAPrivateKey))
 	if err != nil {
 		logger.Errorf("[%v] encryption signing key error: %v", req.OrganizationID, err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
 			"encryption signing key failed")
 	}
-	protectedTLSPrivateKey, err := aesKey.Encrypt(tlsCAPrivateKeyPem)
+	org.ProtectedTLSCAPrivateKey, err = keyring.Encrypt(tlsCAPrivateKeyPem,
+		org.AssociatedData(fieldProtectedTLSCAPrivateKey))
 	if err != nil {
 		logger.Errorf("[%v] encryption tls key error: %v", req.OrganizationID, err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
 			"encryption tls key failed")
 	}
 
-	org.ProtectedSignCAPrivateKey = base64.StdEncoding.EncodeToString(protectedSignPrivateKey)
-	org.ProtectedTLSCAPrivateKey = base64.StdEncoding.EncodeToString(protectedTLSPrivateKey)
+	signCACertificate, err := certificate.NewCA(org.PkixName("ca."+org.Domain), caSigner(signCAPrivateKey, signCAPrivateKeyPem))
+	if err != nil {
+		logger.Errorf("[%v] generate signature ca certificate error: %v", req.OrganizationID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to generate signature ca certificate")
+	}
+
+	tlsCACertificate, err := certificate.NewCA(org.PkixName("tlsca."+org.Domain), caSigner(tlsCAPrivateKey, tlsCAPrivateKeyPem))
+	if err != nil {
+		logger.Errorf("[%v] generate tls ca certificate error: %v", req.OrganizationID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to generate tls ca certificate")
+	}
+
+	org.SignCACertificate = string(signCACertificate)
+	org.TlsCACertificate = string(tlsCACertificate)
+
+	err = storage.Transaction(ctx, func(tx storage.Storage) error {
+		ctx := storage.NewContext(ctx, tx)
+		if err := org.Create(ctx); err != nil {
+			logger.Errorf("[%v] create organization error: %v", req.OrganizationID, err)
+			return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to create organization")
+		}
+
+		// 组织创建者默认成为组织管理员
+		if req.UserID != "" {
+			member := users.NewUserOrganizations(req.UserID, org.OrganizationID, users.RoleOrganization)
+			if err := member.Create(ctx); err != nil {
+				logger.Errorf("[%v] add organization administrator [%v] error: %v", req.OrganizationID, req.UserID, err)
+				return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+					"failed to add organization administrator")
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		if e, ok := err.(*errors.Error); ok {
+			return nil, e
+		}
+		logger.Errorf("[%v] commit organization transaction error: %v", req.OrganizationID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to create organization")
+	}
+
+	return org, nil
+}
+
+// GetKeyring 使用交易密码解封组织的数据密钥，组织缺少数据密钥时返回服务端错误
+func GetKeyring(org *Organization, transactionPassword string) (*Keyring, error) {
+	if org.ProtectedDataKey == "" {
+		logger.Errorf("[%v] organization data key not found", org.OrganizationID)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"organization data key not found")
+	}
+
+	keyring, err := org.Keyring(transactionPassword)
+	if err != nil {
+		logger.Warnf("[%v] unwrap data key error: %v", org.OrganizationID, err)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrOrganizationWrongTransactionPassword,
+			"wrong transaction password")
+	}
+
+	return keyring, nil
+}
+
+// ReencryptDataKeys 分批将组织的数据密钥重新封装为当前的 KEK，不需要交易密码，可以重复执行，dryRun 时只统计不更新。
+// 返回重新封装的组织以及无法升级的组织，CA 私钥解密需要交易密码，不是数据密钥加密格式的组织只能列出。
+// 更新时以读取到的版本作为前置条件，数据已被其他请求修改时同样列为无法升级，重新执行即可
+func ReencryptDataKeys(ctx context.Context, batchSize int, dryRun bool) (rewrapped, pending []string, err error) {
+	rewrapped, pending = make([]string, 0), make([]string, 0)
+	last := ""
+	for {
+		list := make([]*Organization, 0, batchSize)
+		err = storage.FromContext(ctx).FindByQuery(&list, storage.NewQueryOptions().
+			Where("resource_id > ?", last).Order("resource_id").Limit(batchSize))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, org := range list {
+			if org.LegacyEncryption() {
+				pending = append(pending, org.OrganizationID)
+				continue
+			}
+
+			values, err := org.rewrapDataKey()
+			if err != nil {
+				logger.Warnf("[%v] rewrap data key error: %v", org.OrganizationID, err)
+				pending = append(pending, org.OrganizationID)
+				continue
+			}
+			if values == nil {
+				continue
+			}
+			if !dryRun {
+				err = storage.FromContext(ctx).Update(values,
+					storage.NewUpdateOptions("resource_id = ?", org.ResourceID).Version("version", org.Version))
+				if err == storage.ErrConflict {
+					pending = append(pending, org.OrganizationID)
+					continue
+				}
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+			rewrapped = append(rewrapped, org.OrganizationID)
+		}
+		if len(list) < batchSize {
+			return rewrapped, pending, nil
+		}
+		last = list[len(list)-1].ResourceID
+	}
+}
+
+// caSigner HSM 中的密钥通过 crypto.Signer 在设备中签发证书，软件密钥直接使用 pem 格式的私钥
+func caSigner(key crypto.Key, privateKeyPem []byte) interface{} {
+	if signer, ok := key.(crypto.StdSigner); ok {
+		return signer.Signer()
+	}
+
+	return privateKeyPem
+}
+
+func GetList(options *storage.QueryOptions) (*storage.Page, error) {
+	list := make([]*Organization, 0)
+	page, err := storage.FindPage(&list, options)
+	if err != nil {
+		logger.Errorf("query organizations error: %v", err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	return page, nil
+}
+
+// GetUserList 查询组织成员
+func GetUserList(id string, options *storage.QueryOptions) (*storage.Page, error) {
+	org, err := GetDetailByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*users.UserOrganizations, 0)
+	page, err := storage.FindPage(&list, options.Where(&users.UserOrganizations{OrganizationID: org.OrganizationID}))
+	if err != nil {
+		logger.Errorf("[%v] query organization users error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	return page, nil
+}
+
+func GetDetailByID(id string) (*Organization, error) {
+	org, err := FindOrganizationByID(id)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Warnf("[%v] organization not found", id)
+			return nil, errors.NewError(http.StatusNotFound, errors.ErrOrganizationNotFound,
+				"organization not found")
+		}
+		logger.Errorf("[%v] query organization error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	return org, nil
+}
+
+type UpdateRequest struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Version     int64  `json:"-"` // If-Match 指定的版本，为 0 时基于读取到的版本更新
+}
+
+// Update 修改组织的名称以及描述，证书中包含的字段在签发之后不能修改
+func Update(ctx context.Context, id string, req *UpdateRequest) (*Organization, error) {
+	org, err := GetDetailByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Version != 0 && req.Version != org.Version {
+		logger.Warnf("[%v] organization version mismatch: expected %v, current %v", id, req.Version, org.Version)
+		return nil, errors.NewError(http.StatusPreconditionFailed, errors.ErrPreconditionFailed,
+			"organization has been modified")
+	}
+
+	// 只更新名称和描述列，名称不能清空，未指定时保持不变，描述允许清空
+	values := &Organization{Name: req.Name, Description: req.Description}
+	if values.Name == "" {
+		values.Name = org.Name
+	}
+	err = storage.FromContext(ctx).Update(values,
+		storage.NewUpdateOptions("resource_id = ?", org.ResourceID).Version("version", org.Version).
+			Select("name", "description"))
+	if err != nil {
+		if err == storage.ErrConflict {
+			logger.Warnf("[%v] organization has been modified concurrently", id)
+			return nil, errors.NewConflictError("organization", req.Version != 0)
+		}
+		logger.Errorf("[%v] update organization error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to update organization")
+	}
+
+	return GetDetailByID(org.OrganizationID)
+}
+
+// DeactivateUser 停用组织成员，停用后登录签发的 JWT 不再包含该组织。
+// version 为 If-Match 指定的成员关系版本，为 0 时基于读取到的版本停用
+func DeactivateUser(ctx context.Context, id, userID string, version int64) (*users.UserOrganizations, error) {
+	member, err := getUser(id, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMemberVersion(member, version); err != nil {
+		return nil, err
+	}
+
+	if err = softDeleteUser(ctx, member, version, storage.Storage.Delete); err != nil {
+		if e, ok := err.(*errors.Error); ok {
+			return nil, e
+		}
+		logger.Errorf("[%v] deactivate organization user [%v] error: %v", id, userID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to deactivate organization user")
+	}
+
+	return getUser(id, userID, true)
+}
+
+// ReactivateUser 恢复已停用的组织成员，成员未停用时直接返回。
+// version 为 If-Match 指定的成员关系版本，为 0 时基于读取到的版本恢复
+func ReactivateUser(ctx context.Context, id, userID string, version int64) (*users.UserOrganizations, error) {
+	member, err := getUser(id, userID, true)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMemberVersion(member, version); err != nil {
+		return nil, err
+	}
+
+	if !member.Deactivate {
+		return member, nil
+	}
+
+	if err = softDeleteUser(ctx, member, version, storage.Storage.Restore); err != nil {
+		if e, ok := err.(*errors.Error); ok {
+			return nil, e
+		}
+		logger.Errorf("[%v] reactivate organization user [%v] error: %v", id, userID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to reactivate organization user")
+	}
+
+	return getUser(id, userID, true)
+}
+
+// checkMemberVersion If-Match 指定了版本且与成员关系当前的版本不一致时返回前置条件失败
+func checkMemberVersion(member *users.UserOrganizations, version int64) error {
+	if version != 0 && version != member.Version {
+		logger.Warnf("[%v] organization user [%v] version mismatch: expected %v, current %v",
+			member.OrganizationID, member.UserID, version, member.Version)
+		return errors.NewError(http.StatusPreconditionFailed, errors.ErrPreconditionFailed,
+			"organization user has been modified")
+	}
+
+	return nil
+}
+
+// softDeleteUser 在同一个事务中以版本作为前置条件更新成员关系后停用或者恢复成员，期间被修改时不会变更成员的状态
+func softDeleteUser(ctx context.Context, member *users.UserOrganizations, version int64,
+	fn func(s storage.Storage, value interface{}, conditions ...interface{}) error) error {
+	return storage.Transaction(ctx, func(tx storage.Storage) error {
+		err := tx.Update(new(users.UserOrganizations),
+			storage.NewUpdateOptions("resource_id = ?", member.ResourceID).Version("version", member.Version))
+		switch {
+		case err == storage.ErrConflict:
+			logger.Warnf("[%v] organization user [%v] has been modified concurrently", member.OrganizationID, member.UserID)
+			return errors.NewConflictError("organization user", version != 0)
+		case err != nil:
+			return err
+		}
+
+		return fn(tx, member)
+	})
+}
 
-	// TODO: 生成证书
+func getUser(id, userID string, unscoped bool) (*users.UserOrganizations, error) {
+	org, err := GetDetailByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := users.FindUserOrganization(org.OrganizationID, userID, unscoped)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Warnf("[%v] organization user [%v] not found", id, userID)
+			return nil, errors.NewError(http.StatusNotFound, errors.ErrOrganizationUserNotFound,
+				"organization user not found")
+		}
+		logger.Errorf("[%v] query organization user [%v] error: %v", id, userID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
 
-	return nil, nil
+	return member, nil
 }
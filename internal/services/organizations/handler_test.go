@@ -0,0 +1,330 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package organizations
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/certificate"
+	"github.com/yakumioto/alkaid/internal/common/configtx"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
+	"github.com/yakumioto/alkaid/internal/common/crypto/kek"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/memory"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/services/users"
+)
+
+func TestMain(m *testing.M) {
+	km, err := kek.NewLocal(make([]byte, kek.KeySize))
+	if err != nil {
+		panic(err)
+	}
+	kek.Initialize(km)
+	storage.Initialize(memory.NewDB())
+	if err := storage.AutoMigrate(new(Organization), new(users.UserOrganizations)); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+func newCreateRequest(id, domain, userID string) *CreateRequest {
+	return &CreateRequest{
+		OrganizationID:      id,
+		Name:                id,
+		Domain:              domain,
+		TransactionPassword: "password",
+		UserID:              userID,
+	}
+}
+
+func TestCreate(t *testing.T) {
+	tcs := []struct {
+		name    string
+		req     *CreateRequest
+		status  int
+		members int
+	}{
+		{"create", newCreateRequest("org1", "org1.alkaid.com", "alice"), 0, 1},
+		{"without administrator", newCreateRequest("org2", "org2.alkaid.com", ""), 0, 0},
+		{"duplicate id", newCreateRequest("org1", "org3.alkaid.com", "bob"), http.StatusInternalServerError, 1},
+		{"duplicate domain", newCreateRequest("org4", "org1.alkaid.com", "bob"), http.StatusInternalServerError, 0},
+	}
+
+	for _, tc := range tcs {
+		org, err := Create(context.Background(), tc.req)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+		if tc.status == 0 {
+			assert.Equal(t, "China", org.Country, tc.name)
+			assert.NotEmpty(t, org.SignCACertificate, tc.name)
+			assert.NotEmpty(t, org.ProtectedSignCAPrivateKey, tc.name)
+
+			// 加密后的 CA 私钥不会返回给客户端
+			data, err := json.Marshal(org)
+			assert.NoError(t, err, tc.name)
+			assert.NotContains(t, string(data), "protected", tc.name)
+		}
+
+		// 创建失败时不会留下组织成员关系
+		count, err := storage.Count(new(users.UserOrganizations), storage.NewQueryOptions().
+			Where(&users.UserOrganizations{OrganizationID: tc.req.OrganizationID}))
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, int64(tc.members), count, tc.name)
+	}
+}
+
+func TestCreateCryptoSuite(t *testing.T) {
+	tcs := []struct {
+		name        string
+		cryptoSuite string
+		expected    string
+		hashFamily  string
+		status      int
+	}{
+		{"default", "", CryptoSuiteECDSA, "", 0},
+		{"sm2", CryptoSuiteSM2, CryptoSuiteSM2, configtx.HashFamilySM3, 0},
+		{"unsupported", "RSA_2048", "", "", http.StatusBadRequest},
+	}
+
+	for i, tc := range tcs {
+		id := fmt.Sprintf("suite%d", i)
+		req := newCreateRequest(id, id+".alkaid.com", "")
+		req.CryptoSuite = tc.cryptoSuite
+
+		org, err := Create(context.Background(), req)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+		if tc.status != 0 {
+			continue
+		}
+
+		assert.Equal(t, tc.expected, org.CryptoSuite, tc.name)
+		assert.Equal(t, tc.hashFamily, org.MSPOptions(nil).SignatureHashFamily, tc.name)
+
+		// CA 私钥与组织的密码套件一致，并且可以用来签发证书
+		keyring, err := org.Keyring("password")
+		assert.NoError(t, err, tc.name)
+		privateKey, err := org.SignCAPrivateKey(keyring)
+		assert.NoError(t, err, tc.name)
+		_, err = factory.CryptoKeyImportByUsage(privateKey, org.KeyPolicy(), crypto.UsageSign)
+		assert.NoError(t, err, tc.name)
+		_, err = certificate.NewCA(org.PkixName("ca."+org.Domain), privateKey)
+		assert.NoError(t, err, tc.name)
+
+		_, err = org.Keyring("wrong password")
+		assert.Error(t, err, tc.name)
+	}
+}
+
+func TestCreateKeyStore(t *testing.T) {
+	tcs := []struct {
+		name        string
+		keyStore    string
+		cryptoSuite string
+		expected    string
+		status      int
+	}{
+		{"default", "", "", KeyStoreSW, 0},
+		{"software", KeyStoreSW, CryptoSuiteSM2, KeyStoreSW, 0},
+		// 默认编译不包含 PKCS11 支持
+		{"pkcs11 not enabled", KeyStorePKCS11, "", "", http.StatusBadRequest},
+		{"pkcs11 with sm2", KeyStorePKCS11, CryptoSuiteSM2, "", http.StatusBadRequest},
+		{"unsupported", "KMS", "", "", http.StatusBadRequest},
+	}
+
+	for i, tc := range tcs {
+		id := fmt.Sprintf("store%d", i)
+		req := newCreateRequest(id, id+".alkaid.com", "")
+		req.KeyStore = tc.keyStore
+		req.CryptoSuite = tc.cryptoSuite
+
+		org, err := Create(context.Background(), req)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+		if tc.status != 0 {
+			continue
+		}
+
+		assert.Equal(t, tc.expected, org.KeyStore, tc.name)
+		keyring, err := org.Keyring("password")
+		assert.NoError(t, err, tc.name)
+		privateKey, err := org.SignCAPrivateKey(keyring)
+		assert.NoError(t, err, tc.name)
+		assert.False(t, factory.IsKeyHandle(privateKey.([]byte)), tc.name)
+	}
+}
+
+func TestGetKeyring(t *testing.T) {
+	org, err := Create(context.Background(), newCreateRequest("keyring", "keyring.alkaid.com", ""))
+	assert.NoError(t, err)
+
+	_, err = GetKeyring(org, "password")
+	assert.NoError(t, err)
+	_, err = GetKeyring(org, "wrong password")
+	assert.Equal(t, http.StatusForbidden, errors.StatusCode(err))
+
+	// 数据密钥与组织绑定，无法被其他组织使用
+	other := &Organization{OrganizationID: "other", ProtectedDataKey: org.ProtectedDataKey}
+	_, err = other.Keyring("password")
+	assert.Error(t, err)
+
+	// 缺少数据密钥的组织无法解封
+	_, err = GetKeyring(&Organization{OrganizationID: "missing"}, "password")
+	assert.Equal(t, http.StatusInternalServerError, errors.StatusCode(err))
+
+	// 非 Envelope 格式的密文不再被解密
+	keyring, err := GetKeyring(org, "password")
+	assert.NoError(t, err)
+	_, err = keyring.Decrypt("AAAA", org.AssociatedData(fieldProtectedSignCAPrivateKey))
+	assert.Error(t, err)
+}
+
+func TestReencryptDataKeys(t *testing.T) {
+	org, err := Create(context.Background(), newCreateRequest("reencrypt", "reencrypt.alkaid.com", ""))
+	assert.NoError(t, err)
+
+	// 数据密钥由其他 KEK 封装或者 CA 私钥不是数据密钥加密的格式时无法升级
+	other, err := kek.NewLocal([]byte(strings.Repeat("o", kek.KeySize)))
+	assert.NoError(t, err)
+	foreign := &Organization{OrganizationID: "reencrypt-foreign", Domain: "reencrypt-foreign.alkaid.com",
+		ProtectedSignCAPrivateKey: org.ProtectedSignCAPrivateKey, ProtectedTLSCAPrivateKey: org.ProtectedTLSCAPrivateKey}
+	foreign.ProtectedDataKey, err = other.WrapKey([]byte("data key"), foreign.AssociatedData(fieldProtectedDataKey))
+	assert.NoError(t, err)
+	assert.NoError(t, foreign.Create(context.Background()))
+	legacy := &Organization{OrganizationID: "reencrypt-legacy", Domain: "reencrypt-legacy.alkaid.com",
+		ProtectedDataKey: org.ProtectedDataKey, ProtectedSignCAPrivateKey: "AAAA", ProtectedTLSCAPrivateKey: "AAAA"}
+	assert.NoError(t, legacy.Create(context.Background()))
+
+	for _, dryRun := range []bool{true, false} {
+		rewrapped, pending, err := ReencryptDataKeys(context.Background(), 1, dryRun)
+		assert.NoError(t, err)
+		assert.Empty(t, rewrapped)
+		assert.ElementsMatch(t, []string{"reencrypt-foreign", "reencrypt-legacy"}, pending)
+	}
+
+	stored, err := FindOrganizationByID("reencrypt")
+	assert.NoError(t, err)
+	assert.Equal(t, org.ProtectedDataKey, stored.ProtectedDataKey)
+}
+
+func TestGetUserList(t *testing.T) {
+	_, err := Create(context.Background(), newCreateRequest("members", "members.alkaid.com", "alice"))
+	assert.NoError(t, err)
+	assert.NoError(t, users.NewUserOrganizations("bob", "members", users.RoleUser).Create(context.Background()))
+
+	tcs := []struct {
+		name     string
+		id       string
+		options  *storage.QueryOptions
+		expected []string
+		status   int
+	}{
+		{"all", "members", storage.NewQueryOptions().Order("user_id"), []string{"alice", "bob"}, 0},
+		{"filter", "members", storage.NewQueryOptions().Where("role = ?", users.RoleUser), []string{"bob"}, 0},
+		{"not found", "nobody", storage.NewQueryOptions(), nil, http.StatusNotFound},
+	}
+
+	for _, tc := range tcs {
+		page, err := GetUserList(tc.id, tc.options)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+		if tc.status != 0 {
+			continue
+		}
+
+		ids := make([]string, 0)
+		for _, member := range *page.Items.(*[]*users.UserOrganizations) {
+			ids = append(ids, member.UserID)
+		}
+		assert.Equal(t, tc.expected, ids, tc.name)
+	}
+}
+
+func TestDeactivateUser(t *testing.T) {
+	_, err := Create(context.Background(), newCreateRequest("org5", "org5.alkaid.com", "alice"))
+	assert.NoError(t, err)
+	assert.NoError(t, users.NewUserOrganizations("bob", "org5", users.RoleUser).Create(context.Background()))
+
+	// 按顺序执行，后面的用例依赖前面用例的结果
+	tcs := []struct {
+		name    string
+		action  func(ctx context.Context, id, userID string, version int64) (*users.UserOrganizations, error)
+		id      string
+		userID  string
+		version int64
+		status  int
+		members []string
+	}{
+		{"version mismatch", DeactivateUser, "org5", "bob", 2, http.StatusPreconditionFailed, []string{"alice", "bob"}},
+		{"deactivate", DeactivateUser, "org5", "bob", 1, 0, []string{"alice"}},
+		{"deactivate again", DeactivateUser, "org5", "bob", 0, http.StatusNotFound, []string{"alice"}},
+		{"reactivate stale version", ReactivateUser, "org5", "bob", 1, http.StatusPreconditionFailed, []string{"alice"}},
+		{"reactivate", ReactivateUser, "org5", "bob", 2, 0, []string{"alice", "bob"}},
+		{"not member", DeactivateUser, "org5", "carol", 0, http.StatusNotFound, []string{"alice", "bob"}},
+		{"organization not found", ReactivateUser, "nobody", "bob", 0, http.StatusNotFound, []string{"alice", "bob"}},
+	}
+
+	for _, tc := range tcs {
+		member, err := tc.action(context.Background(), tc.id, tc.userID, tc.version)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+		if tc.status == 0 {
+			assert.Equal(t, tc.userID, member.UserID, tc.name)
+		}
+
+		page, err := GetUserList("org5", storage.NewQueryOptions().Order("user_id"))
+		assert.NoError(t, err, tc.name)
+		ids := make([]string, 0)
+		for _, member := range *page.Items.(*[]*users.UserOrganizations) {
+			ids = append(ids, member.UserID)
+		}
+		assert.Equal(t, tc.members, ids, tc.name)
+	}
+
+	_, err = DeactivateUser(context.Background(), "org5", "bob", 0)
+	assert.NoError(t, err)
+	orgs, err := users.FindUserOrganizationsByUserID("bob")
+	assert.NoError(t, err)
+	for _, org := range orgs {
+		assert.NotEqual(t, "org5", org.OrganizationID)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	_, err := Create(context.Background(), newCreateRequest("update", "update.alkaid.com", "alice"))
+	assert.NoError(t, err)
+
+	// 按顺序执行，后面的用例依赖前面用例的结果
+	tcs := []struct {
+		name        string
+		req         *UpdateRequest
+		status      int
+		orgName     string
+		description string
+	}{
+		{"update", &UpdateRequest{Name: "Update", Description: "update"}, 0, "Update", "update"},
+		{"clear description", &UpdateRequest{Name: "Update"}, 0, "Update", ""},
+		{"keep name", &UpdateRequest{Description: "update"}, 0, "Update", "update"},
+	}
+
+	for _, tc := range tcs {
+		_, err := Update(context.Background(), "update", tc.req)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+
+		org, err := GetDetailByID("update")
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.orgName, org.Name, tc.name)
+		assert.Equal(t, tc.description, org.Description, tc.name)
+	}
+}
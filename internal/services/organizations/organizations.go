@@ -9,12 +9,32 @@
 package organizations
 
 import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/yakumioto/alkaid/internal/common/certificate"
+	"github.com/yakumioto/alkaid/internal/common/configtx"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
+	"github.com/yakumioto/alkaid/internal/common/crypto/kek"
+	"github.com/yakumioto/alkaid/internal/common/crypto/utils"
 	"github.com/yakumioto/alkaid/internal/common/storage"
-	"github.com/yakumioto/alkaid/internal/common/utils"
 )
 
 const ResourceNamespace = "Organization"
 
+// 组织可选的密码套件，SM2 套件使用 SM2 密钥以及 SM2WithSM3 签名的证书，需要配合国密版本的 Fabric 使用
+const (
+	CryptoSuiteECDSA = "ECDSA_P256"
+	CryptoSuiteSM2   = "SM2"
+)
+
+// 组织 CA 以及节点签名私钥的存储方式，PKCS11 的私钥保存在 HSM 中，数据库只保存密钥句柄，目前只支持 ECDSA 套件
+const (
+	KeyStoreSW     = factory.ProviderSW
+	KeyStorePKCS11 = factory.ProviderPKCS11
+)
+
 // Organization 组织，组织中包含了加密后的 Sign CA，TLS CA 密钥。
 // 所以在创建组织时需要填入一个交易密码，此密码用来加解密上述的两个 CA 密钥。
 type Organization struct {
@@ -29,10 +49,14 @@ type Organization struct {
 	OrganizationalUnit        string `json:"organizationalUnit,omitempty"`
 	StreetAddress             string `json:"streetAddress,omitempty"`
 	PostalCode                string `json:"postalCode,omitempty"`
-	ProtectedSignCAPrivateKey string `json:"protectedSignCAPrivateKey,omitempty"`
-	ProtectedTLSCAPrivateKey  string `json:"protectedTlsCAPrivateKey,omitempty"`
+	CryptoSuite               string `json:"cryptoSuite,omitempty" gorm:"default:ECDSA_P256"`
+	KeyStore                  string `json:"keyStore,omitempty" gorm:"default:SW"`
+	ProtectedDataKey          string `json:"-"`
+	ProtectedSignCAPrivateKey string `json:"-"`
+	ProtectedTLSCAPrivateKey  string `json:"-"`
 	SignCACertificate         string `json:"signCACertificate,omitempty"`
 	TlsCACertificate          string `json:"tlsCACertificate,omitempty"`
+	Version                   int64  `json:"version,omitempty" gorm:"default:1"`
 	CreatedAt                 int64  `json:"createdAt,omitempty"`
 	UpdatedAt                 int64  `json:"updatedAt,omitempty"`
 }
@@ -51,6 +75,8 @@ func newOrganizationByCreateRequest(req *CreateRequest) *Organization {
 		PostalCode:         req.PostalCode,
 	}
 
+	org.SetCryptoSuite(req.CryptoSuite)
+	org.SetKeyStore(req.KeyStore)
 	org.SetCountry(org.Country)
 	org.SetProvince(org.Province)
 	org.SetLocality(org.Locality)
@@ -59,18 +85,49 @@ func newOrganizationByCreateRequest(req *CreateRequest) *Organization {
 	return org
 }
 
-func (o *Organization) Create() error {
+func (o *Organization) Create(ctx context.Context) error {
 	o.ResourceID = utils.GenResourceID(ResourceNamespace)
+	o.Version = 1
 	o.SetCountry(o.Country)
 	o.SetProvince(o.Province)
 	o.SetLocality(o.Locality)
 	o.SetOrganizationalUnit(o.OrganizationalUnit)
-	return storage.Create(o)
+	return storage.FromContext(ctx).Create(o)
+}
+
+// SetCryptoSuite 组织的密码套件，决定 CA 以及节点身份的密钥算法，默认使用 ECDSA P-256
+func (o *Organization) SetCryptoSuite(cryptoSuite string) {
+	if cryptoSuite != "" {
+		o.CryptoSuite = cryptoSuite
+		return
+	}
+
+	o.CryptoSuite = CryptoSuiteECDSA
+}
+
+// SetKeyStore 组织私钥的存储方式，默认保存在数据库中
+func (o *Organization) SetKeyStore(keyStore string) {
+	if keyStore != "" {
+		o.KeyStore = keyStore
+		return
+	}
+
+	o.KeyStore = KeyStoreSW
+}
+
+// KeyPolicy 组织密码套件对应的密钥策略，CA 以及节点身份按照用途申请密钥
+func (o *Organization) KeyPolicy() crypto.Policy {
+	if o.CryptoSuite == CryptoSuiteSM2 {
+		return crypto.SM2Policy
+	}
+
+	return crypto.DefaultPolicy
 }
 
 func (o *Organization) SetCountry(country string) {
 	if country != "" {
 		o.Country = country
+		return
 	}
 
 	o.Country = "China"
@@ -79,6 +136,7 @@ func (o *Organization) SetCountry(country string) {
 func (o *Organization) SetProvince(province string) {
 	if province != "" {
 		o.Province = province
+		return
 	}
 
 	o.Province = "Beijing"
@@ -87,6 +145,7 @@ func (o *Organization) SetProvince(province string) {
 func (o *Organization) SetLocality(locality string) {
 	if locality != "" {
 		o.Locality = locality
+		return
 	}
 
 	o.Locality = "Beijing"
@@ -95,11 +154,236 @@ func (o *Organization) SetLocality(locality string) {
 func (o *Organization) SetOrganizationalUnit(organizationalUnit string) {
 	if organizationalUnit != "" {
 		o.OrganizationalUnit = organizationalUnit
+		return
 	}
 
 	o.OrganizationalUnit = "Alkaid"
 }
 
+// PkixName 根据组织信息生成证书的 Subject，commonName 用于区分 Sign CA 和 TLS CA。
+func (o *Organization) PkixName(commonName string) *certificate.PkixName {
+	return &certificate.PkixName{
+		OrgName:       o.Name,
+		Domain:        o.Domain,
+		CommonName:    commonName,
+		Country:       o.Country,
+		Province:      o.Province,
+		Locality:      o.Locality,
+		OrgUnit:       o.OrganizationalUnit,
+		StreetAddress: o.StreetAddress,
+		PostalCode:    o.PostalCode,
+	}
+}
+
+// SignCAPrivateKey 使用组织的数据密钥解密组织的根签名私钥，返回 pem 格式的私钥，
+// 私钥保存在 HSM 中时返回可以直接用于签发证书的 crypto.Signer，见 certificate.NewCA
+func (o *Organization) SignCAPrivateKey(keyring *Keyring) (interface{}, error) {
+	return o.decryptCAPrivateKey(keyring, o.ProtectedSignCAPrivateKey, fieldProtectedSignCAPrivateKey)
+}
+
+// TLSCAPrivateKey 使用组织的数据密钥解密组织的根通讯私钥，返回值与 SignCAPrivateKey 相同
+func (o *Organization) TLSCAPrivateKey(keyring *Keyring) (interface{}, error) {
+	return o.decryptCAPrivateKey(keyring, o.ProtectedTLSCAPrivateKey, fieldProtectedTLSCAPrivateKey)
+}
+
+func (o *Organization) decryptCAPrivateKey(keyring *Keyring, protectedPrivateKey, field string) (interface{}, error) {
+	privateKey, err := keyring.DecryptPrivateKey(protectedPrivateKey, o.AssociatedData(field))
+	if err != nil {
+		return nil, err
+	}
+
+	return CertificateSigner(privateKey)
+}
+
+// MSPID 组织在 Fabric 网络中的 MSP ID，直接使用组织 ID
+func (o *Organization) MSPID() string {
+	return o.OrganizationID
+}
+
+// MSPOptions 生成组织 MSP 配置的参数，adminCerts 为未开启 NodeOUs 的管理员证书
+// SM2 套件的组织使用 SM3 作为 MSP 的摘要算法
+func (o *Organization) MSPOptions(adminCerts [][]byte) *configtx.MSPOptions {
+	opts := &configtx.MSPOptions{
+		MSPID:      o.MSPID(),
+		RootCerts:  [][]byte{[]byte(o.SignCACertificate)},
+		TLSRoots:   [][]byte{[]byte(o.TlsCACertificate)},
+		AdminCerts: adminCerts,
+		NodeOUs:    true,
+	}
+	if o.CryptoSuite == CryptoSuiteSM2 {
+		opts.SignatureHashFamily = configtx.HashFamilySM3
+		opts.IdentityIdentifierHashFunction = configtx.HashFunctionSM3
+	}
+
+	return opts
+}
+
+// 受保护字段加密时使用的附加数据字段名，密文与组织 ID 以及字段名绑定
+const (
+	fieldProtectedDataKey          = "protectedDataKey"
+	fieldProtectedSignCAPrivateKey = "protectedSignCAPrivateKey"
+	fieldProtectedTLSCAPrivateKey  = "protectedTlsCAPrivateKey"
+)
+
+// AssociatedData 组织受保护字段的附加数据
+func (o *Organization) AssociatedData(field string) []byte {
+	return []byte(o.OrganizationID + "/" + field)
+}
+
+// Keyring 组织的数据密钥，用来加解密组织 CA 私钥以及节点私钥
+type Keyring struct {
+	dataKey *utils.StretchedKey
+}
+
+// transactionPasswordKey 交易密码经过 PBKDF2 以及 HKDF 扩展后的密钥，使用组织 ID 作为盐
+func (o *Organization) transactionPasswordKey(transactionPassword string) (*utils.StretchedKey, error) {
+	return utils.GetStretchedKey(utils.GetMasterKey(transactionPassword, o.OrganizationID))
+}
+
+// genKeyring 生成组织的数据密钥，数据密钥先使用交易密码扩展的密钥加密，再使用服务端 KEK 封装
+func (o *Organization) genKeyring(transactionPassword string) (*Keyring, error) {
+	dataKey, err := utils.GenSymmetricKey()
+	if err != nil {
+		return nil, err
+	}
+	passwordKey, err := o.transactionPasswordKey(transactionPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	ad := o.AssociatedData(fieldProtectedDataKey)
+	protectedDataKey, err := utils.EncryptWithStretchedKey(passwordKey, dataKey.Key(), ad)
+	if err != nil {
+		return nil, err
+	}
+	o.ProtectedDataKey, err = kek.WrapKey([]byte(protectedDataKey), ad)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to wrap data key")
+	}
+
+	return &Keyring{dataKey: dataKey}, nil
+}
+
+// Keyring 使用服务端 KEK 以及交易密码解封组织的数据密钥，交易密码错误时返回错误
+func (o *Organization) Keyring(transactionPassword string) (*Keyring, error) {
+	if o.ProtectedDataKey == "" {
+		return nil, errors.New("organization data key not found")
+	}
+
+	ad := o.AssociatedData(fieldProtectedDataKey)
+	protectedDataKey, err := kek.UnwrapKey(o.ProtectedDataKey, ad)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to unwrap data key")
+	}
+	passwordKey, err := o.transactionPasswordKey(transactionPassword)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := utils.DecryptWithStretchedKey(passwordKey, string(protectedDataKey), ad)
+	if err != nil {
+		return nil, err
+	}
+	if len(dataKey) != 64 {
+		return nil, errors.New("invalid data key length")
+	}
+
+	return &Keyring{dataKey: &utils.StretchedKey{Enc: dataKey[:32], Mac: dataKey[32:]}}, nil
+}
+
+// rewrapDataKey 使用当前的 KEK 重新封装数据密钥，只返回需要更新的字段，已经使用当前 KEK 封装时返回 nil
+func (o *Organization) rewrapDataKey() (*Organization, error) {
+	rewrapped, ok, err := kek.RewrapKey(o.ProtectedDataKey, o.AssociatedData(fieldProtectedDataKey))
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	return &Organization{ProtectedDataKey: rewrapped}, nil
+}
+
+// LegacyEncryption CA 私钥不是使用数据密钥加密的 AES-256-GCM 格式时返回 true
+func (o *Organization) LegacyEncryption() bool {
+	return o.ProtectedDataKey == "" || !IsEnvelope(o.ProtectedSignCAPrivateKey) || !IsEnvelope(o.ProtectedTLSCAPrivateKey)
+}
+
+// IsEnvelope 密文是数据密钥加密的 AES-256-GCM 格式时返回 true
+func IsEnvelope(ciphertext string) bool {
+	envelope, err := utils.ParseEnvelope(ciphertext)
+	return err == nil && envelope.Type == utils.AesGcm256B64
+}
+
+// Encrypt 使用数据密钥加密，ad 为绑定的附加数据
+func (k *Keyring) Encrypt(data, ad []byte) (string, error) {
+	return utils.EncryptWithStretchedKey(k.dataKey, data, ad)
+}
+
+// Decrypt 使用数据密钥解密，ad 必须与加密时绑定的附加数据一致
+func (k *Keyring) Decrypt(ciphertext string, ad []byte) ([]byte, error) {
+	return utils.DecryptWithStretchedKey(k.dataKey, ciphertext, ad)
+}
+
+// DecryptPrivateKey 解密 CA 或者节点私钥，并校验解密结果确实是一个私钥或者 HSM 中密钥的句柄
+func (k *Keyring) DecryptPrivateKey(protectedPrivateKey string, ad []byte) ([]byte, error) {
+	privateKey, err := k.Decrypt(protectedPrivateKey, ad)
+	if err != nil {
+		return nil, err
+	}
+
+	return validatePrivateKey(privateKey)
+}
+
+func validatePrivateKey(privateKey []byte) ([]byte, error) {
+	if factory.IsKeyHandle(privateKey) {
+		return privateKey, nil
+	}
+	if _, err := certificate.ParsePrivateKey(privateKey); err != nil {
+		return nil, err
+	}
+
+	return privateKey, nil
+}
+
+// CertificateSigner 将私钥转换为 certificate 签发证书时使用的私钥，pem 格式的私钥直接返回，
+// HSM 中密钥的句柄返回在设备中签名的 crypto.Signer
+func CertificateSigner(privateKey []byte) (interface{}, error) {
+	if !factory.IsKeyHandle(privateKey) {
+		return privateKey, nil
+	}
+
+	key, err := factory.CryptoPrivateKeyImport(privateKey, crypto.EcdsaP256)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.StdSigner)
+	if !ok {
+		return nil, errors.New("the key cannot be used to sign certificates")
+	}
+
+	return signer.Signer(), nil
+}
+
+// QuerySchema 组织列表允许过滤以及排序的字段
+var QuerySchema = &storage.Schema{
+	Fields: map[string]storage.Field{
+		"organizationId": {Column: "organization_id"},
+		"name":           {Column: "name"},
+		"domain":         {Column: "domain"},
+		"description":    {Column: "description"},
+		"cryptoSuite":    {Column: "crypto_suite"},
+		"keyStore":       {Column: "key_store"},
+		"country":        {Column: "country"},
+		"province":       {Column: "province"},
+		"locality":       {Column: "locality"},
+		"createdAt":      {Column: "created_at", Type: storage.FieldInt},
+		"updatedAt":      {Column: "updated_at", Type: storage.FieldInt},
+	},
+	Key:         "resource_id",
+	DefaultSort: "createdAt",
+}
+
 func FindOrganizationByID(id string) (*Organization, error) {
-	return nil, nil
+	org := new(Organization)
+	return org, storage.FindByQuery(org,
+		storage.NewQueryOptions().
+			Or(&Organization{OrganizationID: id}).
+			Or(&Organization{ResourceID: id}))
 }
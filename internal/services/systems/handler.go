@@ -9,6 +9,7 @@
 package systems
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/yakumioto/alkaid/internal/common/log"
@@ -28,41 +29,57 @@ type InitRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
-func SystemInit(req *InitRequest) (*System, error) {
-	initialized := newSystemByID(KSystemInitialized)
-	if err := initialized.findByID(); err != nil {
-		if err != storage.ErrNotFound {
-			logger.Errorf("query system [%v] error: %v", KSystemInitialized, err)
-			return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
-				"server unknown error")
+// SystemInit 初始化系统，root 用户与初始化标记在同一个事务中写入，任意一步失败都不会留下半初始化的状态
+func SystemInit(ctx context.Context, req *InitRequest) (*System, error) {
+	sys := newSystem(KSystemInitialized, VSystemInitialized)
+
+	err := storage.Transaction(ctx, func(tx storage.Storage) error {
+		ctx := storage.NewContext(ctx, tx)
+
+		initialized := newSystemByID(KSystemInitialized)
+		if err := initialized.findByID(ctx); err != nil {
+			if err != storage.ErrNotFound {
+				logger.Errorf("query system [%v] error: %v", KSystemInitialized, err)
+				return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+					"server unknown error")
+			}
 		}
-	}
 
-	if initialized.Value == VSystemInitialized {
-		logger.Warnln("system is initialized")
-		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
-			"system is initialized")
-	}
+		if initialized.Value == VSystemInitialized {
+			logger.Warnln("system is initialized")
+			return errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+				"system is initialized")
+		}
+
+		// 处理未初始化情况
+		if _, err := users.Create(ctx, &users.CreateRequest{
+			ID:       req.ID,
+			Name:     req.Name,
+			Email:    req.Email,
+			Root:     true,
+			Password: req.Password,
+		}); err != nil {
+			logger.Errorf("[%v] initialize root user error: %v", req.ID, err)
+			return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to initialize root user")
+		}
+
+		if err := sys.create(ctx); err != nil {
+			logger.Errorf("[%v] create system error: %v", sys.Key, err)
+			return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to initialize system")
+		}
 
-	// 处理未初始化情况
-	user, err := users.Create(&users.CreateRequest{
-		ID:       req.ID,
-		Name:     req.Name,
-		Email:    req.Email,
-		Root:     true,
-		Password: req.Password,
+		return nil
 	})
 	if err != nil {
-		logger.Errorf("[%v] initialize root user error: %v", user.UserID, err)
+		if e, ok := err.(*errors.Error); ok {
+			return nil, e
+		}
+		logger.Errorf("commit system initialization transaction error: %v", err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
-			"failed to initialize root user")
+			"failed to initialize system")
 	}
 
-	sys := newSystem(KSystemInitialized, VSystemInitialized)
-	if err := sys.create(); err != nil {
-		logger.Errorf("[%v] create system error: %v", sys.Key, err)
-		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
-			"failed to create user")
-	}
 	return sys, nil
 }
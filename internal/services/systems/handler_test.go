@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package systems
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/memory"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/services/users"
+)
+
+func TestMain(m *testing.M) {
+	storage.Initialize(memory.NewDB())
+	if err := storage.AutoMigrate(new(System), new(users.User)); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestSystemInit(t *testing.T) {
+	_, err := users.Create(context.Background(), &users.CreateRequest{
+		ID: "alice", Name: "Alice", Email: "alice@alkaid.com", Password: "alice",
+	})
+	assert.NoError(t, err)
+
+	// 按顺序执行，后面的用例依赖前面用例的结果
+	tcs := []struct {
+		name        string
+		req         *InitRequest
+		status      int
+		initialized bool
+		rootCreated bool
+	}{
+		{"conflicting root user", &InitRequest{ID: "root", Name: "root", Email: "alice@alkaid.com", Password: "root"}, http.StatusInternalServerError, false, false},
+		{"initialize", &InitRequest{ID: "root", Name: "root", Email: "root@alkaid.com", Password: "root"}, 0, true, true},
+		{"already initialized", &InitRequest{ID: "root2", Name: "root", Email: "root2@alkaid.com", Password: "root"}, http.StatusForbidden, true, false},
+	}
+
+	for _, tc := range tcs {
+		sys, err := SystemInit(context.Background(), tc.req)
+		if tc.status == 0 {
+			assert.NoError(t, err, tc.name)
+			assert.Equal(t, VSystemInitialized, sys.Value, tc.name)
+		} else {
+			e, ok := err.(*errors.Error)
+			assert.True(t, ok, tc.name)
+			assert.Equal(t, tc.status, e.StatusCode, tc.name)
+		}
+
+		initialized := newSystemByID(KSystemInitialized)
+		_ = initialized.findByID(context.Background())
+		assert.Equal(t, tc.initialized, initialized.Value == VSystemInitialized, tc.name)
+
+		user, err := users.FindUserByID(tc.req.ID)
+		if !tc.rootCreated {
+			assert.Equal(t, storage.ErrNotFound, err, tc.name)
+			continue
+		}
+		assert.NoError(t, err, tc.name)
+		assert.True(t, user.Root, tc.name)
+	}
+}
@@ -8,7 +8,11 @@
 
 package systems
 
-import "github.com/yakumioto/alkaid/internal/common/storage"
+import (
+	"context"
+
+	"github.com/yakumioto/alkaid/internal/common/storage"
+)
 
 const (
 	KSystemInitialized = "system_initialized"
@@ -38,10 +42,10 @@ func newSystemByID(id string) *System {
 	}
 }
 
-func (s *System) create() error {
-	return storage.Create(s)
+func (s *System) create(ctx context.Context) error {
+	return storage.FromContext(ctx).Create(s)
 }
 
-func (s *System) findByID() error {
-	return storage.FindByID(s, s.Key)
+func (s *System) findByID(ctx context.Context) error {
+	return storage.FromContext(ctx).FindByID(s, s.Key)
 }
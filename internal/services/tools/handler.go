@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package tools
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/yakumioto/alkaid/internal/common/configtx"
+	"github.com/yakumioto/alkaid/internal/common/log"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"gopkg.in/yaml.v3"
+
+	// 注册 Fabric 的 proto 消息类型，与 configtxlator 支持的类型保持一致
+	_ "github.com/hyperledger/fabric-protos-go/common"
+	_ "github.com/hyperledger/fabric-protos-go/ledger/rwset"
+	_ "github.com/hyperledger/fabric-protos-go/ledger/rwset/kvrwset"
+	_ "github.com/hyperledger/fabric-protos-go/msp"
+	_ "github.com/hyperledger/fabric-protos-go/orderer"
+	_ "github.com/hyperledger/fabric-protos-go/orderer/etcdraft"
+	_ "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+var (
+	logger = log.GetPackageLogger("services.tools")
+)
+
+// Decode 将 protobuf 编码的数据解码为 typ 指定的消息，typ 为完整的消息名称，例如 common.Block
+func Decode(typ string, data []byte) (proto.Message, error) {
+	msg, err := newMessage(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = proto.Unmarshal(data, msg); err != nil {
+		logger.Warnf("[%v] unmarshal proto message error: %v", typ, err)
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"failed to decode %s", typ)
+	}
+
+	return msg, nil
+}
+
+// Encode 将 protolator 格式的 JSON 或 YAML 数据编码为 typ 指定的 protobuf 消息
+func Encode(typ, format string, data []byte) ([]byte, error) {
+	msg, err := newMessage(typ)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == FormatYAML {
+		tree := make(map[string]interface{})
+		if err = yaml.Unmarshal(data, &tree); err != nil {
+			logger.Warnf("[%v] unmarshal yaml error: %v", typ, err)
+			return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"invalid yaml: %v", err)
+		}
+
+		data, err = json.Marshal(tree)
+		if err != nil {
+			logger.Warnf("[%v] convert yaml to json error: %v", typ, err)
+			return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"invalid yaml: %v", err)
+		}
+	}
+
+	if err = configtx.UnmarshalJSON(data, msg); err != nil {
+		logger.Warnf("[%v] unmarshal json to proto message error: %v", typ, err)
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"failed to encode %s: %v", typ, err)
+	}
+
+	data, err = proto.Marshal(msg)
+	if err != nil {
+		logger.Errorf("[%v] marshal proto message error: %v", typ, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to marshal proto message")
+	}
+
+	return data, nil
+}
+
+func newMessage(typ string) (proto.Message, error) {
+	msgType := proto.MessageType(typ)
+	if msgType == nil {
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"unknown message type: %s", typ)
+	}
+
+	msg, ok := reflect.New(msgType.Elem()).Interface().(proto.Message)
+	if !ok {
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"unknown message type: %s", typ)
+	}
+
+	return msg, nil
+}
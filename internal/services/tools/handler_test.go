@@ -0,0 +1,47 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package tools
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tcs := []struct {
+		typ    string
+		format string
+		data   string
+		isErr  bool
+	}{
+		{"common.BlockHeader", FormatJSON, `{"number":"3","previous_hash":"AQI=","data_hash":"AwQ="}`, false},
+		{"common.BlockHeader", FormatYAML, "number: \"3\"\nprevious_hash: AQI=\ndata_hash: AwQ=\n", false},
+		{"common.BlockHeader", FormatJSON, `{"number":`, true},
+		{"common.Unknown", FormatJSON, `{}`, true},
+	}
+
+	for _, tc := range tcs {
+		data, err := Encode(tc.typ, tc.format, []byte(tc.data))
+		if tc.isErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+
+		msg, err := Decode(tc.typ, data)
+		assert.NoError(t, err)
+		assert.True(t, proto.Equal(&cb.BlockHeader{Number: 3, PreviousHash: []byte{1, 2}, DataHash: []byte{3, 4}}, msg))
+	}
+
+	_, err := Decode("common.Block", []byte{0xff})
+	assert.Error(t, err)
+}
@@ -0,0 +1,383 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package transactions
+
+import (
+	"net/http"
+
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"github.com/yakumioto/alkaid/internal/common/log"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/errors"
+	"github.com/yakumioto/alkaid/internal/services/channels"
+	"github.com/yakumioto/alkaid/internal/services/identities"
+	"github.com/yakumioto/alkaid/internal/services/users"
+)
+
+var (
+	logger = log.GetPackageLogger("services.transactions")
+)
+
+type CreateRequest struct {
+	IdentityID     string            `json:"identityId,omitempty" validate:"required"`
+	Function       string            `json:"function,omitempty" validate:"required"`
+	Args           []string          `json:"args,omitempty"`
+	Transient      map[string]string `json:"transient,omitempty"`
+	Offline        bool              `json:"offline,omitempty"` // 离线签名模式，无需提供密码
+	OrganizationID string            `json:"-"`
+	NetworkID      string            `json:"-"`
+	ChannelID      string            `json:"-"`
+	ContractID     string            `json:"-"`
+	identities.Credentials
+}
+
+// Create 使用调用者的用户身份创建链码调用提案。
+// 默认在服务端解密签名私钥并签名，离线签名模式下返回待签名的提案，由客户端签名后提交
+func Create(userCtx *users.UserContext, req *CreateRequest) (*Transaction, error) {
+	if req.Function == "" {
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"function is required")
+	}
+
+	channel, err := channels.GetDetailByID(req.NetworkID, req.ChannelID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		creator  Serializer
+		signer   *crypto.Signer
+		identity *identities.Identity
+	)
+	if req.Offline {
+		creator, identity, err = identities.GetVerifier(userCtx, req.IdentityID)
+	} else {
+		signer, identity, err = identities.GetSigner(userCtx, req.IdentityID, &req.Credentials)
+		creator = signer
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if identity.Use != identities.UseUser {
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"only user identities can invoke contracts")
+	}
+	if identity.OrganizationID != req.OrganizationID {
+		logger.Warnf("[%v] identity [%v] does not belong to organization [%v]",
+			req.ContractID, req.IdentityID, req.OrganizationID)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"identity does not belong to the organization")
+	}
+
+	transient := make(map[string][]byte, len(req.Transient))
+	for k, v := range req.Transient {
+		transient[k] = []byte(v)
+	}
+
+	cis := NewChaincodeInvocationSpec(req.ContractID, req.Function, req.Args)
+	proposal, txID, err := NewProposal(creator, channel.ChannelID, cis, transient)
+	if err != nil {
+		logger.Errorf("[%v] create proposal error: %v", req.ContractID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to create proposal")
+	}
+
+	transaction := &Transaction{
+		TxID:           txID,
+		NetworkID:      req.NetworkID,
+		ChannelID:      channel.ChannelID,
+		ContractID:     req.ContractID,
+		OrganizationID: req.OrganizationID,
+		UserID:         userCtx.ID,
+		IdentityID:     identity.IdentityID,
+		Function:       req.Function,
+		Status:         StatusUnsigned,
+	}
+
+	if transaction.Proposal, err = proto.Marshal(proposal); err != nil {
+		logger.Errorf("[%v] marshal proposal error: %v", txID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to marshal proposal")
+	}
+
+	if !req.Offline {
+		signature, err := signer.Sign(transaction.Proposal)
+		if err != nil {
+			logger.Errorf("[%v] sign proposal error: %v", txID, err)
+			return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to sign proposal")
+		}
+		if err = transaction.signProposal(signature); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = transaction.Create(); err != nil {
+		logger.Errorf("[%v] create transaction error: %v", txID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to create transaction")
+	}
+
+	return transaction, transaction.setSigningRequest()
+}
+
+func GetDetailByID(networkID, channelID, contractID, id string) (*Transaction, error) {
+	channel, err := channels.GetDetailByID(networkID, channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	transaction, err := FindTransactionByID(networkID, channel.ChannelID, contractID, id)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Warnf("[%v] transaction not found", id)
+			return nil, errors.NewError(http.StatusNotFound, errors.ErrTransactionNotFound,
+				"transaction not found")
+		}
+		logger.Errorf("[%v] query transaction error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	return transaction, transaction.setSigningRequest()
+}
+
+type CreateEnvelopeRequest struct {
+	ProposalResponses [][]byte `json:"proposalResponses,omitempty" validate:"required"` // protobuf 编码的背书结果
+	Offline           bool     `json:"offline,omitempty"`                               // 离线签名模式，无需提供密码
+	identities.Credentials
+}
+
+// CreateEnvelope 根据背书结果组装交易信封，并使用创建提案的身份签名
+func CreateEnvelope(userCtx *users.UserContext, networkID, channelID, contractID, id string,
+	req *CreateEnvelopeRequest) (*cb.Envelope, error) {
+	transaction, err := GetDetailByID(networkID, channelID, contractID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if transaction.Status == StatusEndorsed {
+		env, err := transaction.envelope()
+		if err != nil {
+			logger.Errorf("[%v] unmarshal transaction envelope error: %v", id, err)
+			return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to unmarshal transaction envelope")
+		}
+		return env, nil
+	}
+
+	proposal, responses, err := transaction.endorsement(req)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, _, err := identities.GetSigner(userCtx, transaction.IdentityID, &req.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := NewSignedTx(proposal, signer, responses...)
+	if err != nil {
+		logger.Warnf("[%v] create signed transaction error: %v", id, err)
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrTransactionInvalidProposalResponse,
+			"%v", err)
+	}
+
+	if err = transaction.saveEnvelope(env, StatusEndorsed); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// AssembleEnvelope 离线签名模式下根据背书结果组装未签名的交易信封，由客户端签名后提交
+func AssembleEnvelope(userCtx *users.UserContext, networkID, channelID, contractID, id string,
+	req *CreateEnvelopeRequest) (*Transaction, error) {
+	transaction, err := GetDetailByID(networkID, channelID, contractID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	proposal, responses, err := transaction.endorsement(req)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, _, err := identities.GetVerifier(userCtx, transaction.IdentityID)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := NewUnsignedTx(proposal, verifier, responses...)
+	if err != nil {
+		logger.Warnf("[%v] create unsigned transaction error: %v", id, err)
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrTransactionInvalidProposalResponse,
+			"%v", err)
+	}
+
+	if err = transaction.saveEnvelope(env, StatusAssembled); err != nil {
+		return nil, err
+	}
+
+	return transaction, transaction.setSigningRequest()
+}
+
+type SignRequest struct {
+	Signature []byte `json:"signature,omitempty" validate:"required"` // ASN.1 编码的 ECDSA 签名
+}
+
+// Sign 离线签名模式下提交客户端对提案或者交易信封的签名，签名使用用户的签名公钥校验
+func Sign(userCtx *users.UserContext, networkID, channelID, contractID, id string,
+	req *SignRequest) (*Transaction, error) {
+	transaction, err := GetDetailByID(networkID, channelID, contractID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if transaction.SigningRequest == nil {
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"transaction is %v and does not need a signature", transaction.Status)
+	}
+
+	verifier, _, err := identities.GetVerifier(userCtx, transaction.IdentityID)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := verifier.Verify(transaction.SigningRequest.Payload, req.Signature)
+	if err != nil {
+		logger.Warnf("[%v] verify signature error: %v", id, err)
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrIdentityInvalidSignature,
+			"%v", err)
+	}
+
+	switch transaction.Status {
+	case StatusUnsigned:
+		if err = transaction.signProposal(signature); err != nil {
+			return nil, err
+		}
+		if err = transaction.Update(); err != nil {
+			if err == storage.ErrConflict {
+				logger.Warnf("[%v] transaction has been modified concurrently", id)
+				return nil, errors.NewConflictError("transaction", false)
+			}
+			logger.Errorf("[%v] update transaction error: %v", id, err)
+			return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to update transaction")
+		}
+	case StatusAssembled:
+		env, err := transaction.envelope()
+		if err != nil {
+			logger.Errorf("[%v] unmarshal transaction envelope error: %v", id, err)
+			return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to unmarshal transaction envelope")
+		}
+
+		env.Signature = signature
+		if err = transaction.saveEnvelope(env, StatusEndorsed); err != nil {
+			return nil, err
+		}
+	}
+
+	return transaction, transaction.setSigningRequest()
+}
+
+// signProposal 使用提案签名生成 SignedProposal
+func (t *Transaction) signProposal(signature []byte) error {
+	data, err := proto.Marshal(&pb.SignedProposal{ProposalBytes: t.Proposal, Signature: signature})
+	if err != nil {
+		logger.Errorf("[%v] marshal signed proposal error: %v", t.TxID, err)
+		return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to marshal signed proposal")
+	}
+
+	t.SignedProposal = data
+	t.Status = StatusProposed
+	return nil
+}
+
+// endorsement 解析背书结果，只有已签名且未生成交易信封的提案可以组装交易
+func (t *Transaction) endorsement(req *CreateEnvelopeRequest) (*pb.Proposal, []*pb.ProposalResponse, error) {
+	if t.Status != StatusProposed {
+		return nil, nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"transaction is %v, only proposed transactions can be assembled", t.Status)
+	}
+
+	if len(req.ProposalResponses) == 0 {
+		return nil, nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"proposal responses are required")
+	}
+
+	responses := make([]*pb.ProposalResponse, 0, len(req.ProposalResponses))
+	for _, data := range req.ProposalResponses {
+		response := new(pb.ProposalResponse)
+		if err := proto.Unmarshal(data, response); err != nil {
+			logger.Warnf("[%v] unmarshal proposal response error: %v", t.TxID, err)
+			return nil, nil, errors.NewError(http.StatusBadRequest, errors.ErrTransactionInvalidProposalResponse,
+				"invalid proposal response")
+		}
+		responses = append(responses, response)
+	}
+
+	proposal, err := t.proposal()
+	if err != nil {
+		logger.Errorf("[%v] unmarshal proposal error: %v", t.TxID, err)
+		return nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to unmarshal proposal")
+	}
+
+	return proposal, responses, nil
+}
+
+func (t *Transaction) saveEnvelope(env *cb.Envelope, status string) error {
+	data, err := proto.Marshal(env)
+	if err != nil {
+		logger.Errorf("[%v] marshal transaction envelope error: %v", t.TxID, err)
+		return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to marshal transaction envelope")
+	}
+
+	t.Envelope = data
+	t.Status = status
+	if err = t.Update(); err != nil {
+		if err == storage.ErrConflict {
+			logger.Warnf("[%v] transaction has been modified concurrently", t.TxID)
+			return errors.NewConflictError("transaction", false)
+		}
+		logger.Errorf("[%v] update transaction error: %v", t.TxID, err)
+		return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to update transaction")
+	}
+
+	return nil
+}
+
+// setSigningRequest 离线签名模式下设置客户端需要签名的数据
+func (t *Transaction) setSigningRequest() error {
+	t.SigningRequest = nil
+
+	switch t.Status {
+	case StatusUnsigned:
+		t.SigningRequest = identities.NewSigningRequest(t.Proposal)
+	case StatusAssembled:
+		env, err := t.envelope()
+		if err != nil {
+			logger.Errorf("[%v] unmarshal transaction envelope error: %v", t.TxID, err)
+			return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"failed to unmarshal transaction envelope")
+		}
+		t.SigningRequest = identities.NewSigningRequest(env.Payload)
+	}
+
+	return nil
+}
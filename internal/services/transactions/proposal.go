@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package transactions
+
+import (
+	"bytes"
+
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/pkg/errors"
+	"github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/protoutil"
+)
+
+// NewChaincodeInvocationSpec 构造链码调用规范，方法名作为链码的第一个参数
+func NewChaincodeInvocationSpec(contractID, function string, args []string) *pb.ChaincodeInvocationSpec {
+	input := make([][]byte, 0, len(args)+1)
+	input = append(input, []byte(function))
+	for _, arg := range args {
+		input = append(input, []byte(arg))
+	}
+
+	return &pb.ChaincodeInvocationSpec{
+		ChaincodeSpec: &pb.ChaincodeSpec{
+			Type:        pb.ChaincodeSpec_GOLANG,
+			ChaincodeId: &pb.ChaincodeID{Name: contractID},
+			Input:       &pb.ChaincodeInput{Args: input},
+		},
+	}
+}
+
+// Serializer 提供提案创建者的身份，离线签名模式下只需要身份而不需要私钥
+type Serializer interface {
+	Serialize() ([]byte, error)
+}
+
+// NewProposal 创建未签名的链码调用提案，返回提案以及交易 ID
+func NewProposal(creator Serializer, channelID string, cis *pb.ChaincodeInvocationSpec,
+	transient map[string][]byte) (*pb.Proposal, string, error) {
+	identity, err := creator.Serialize()
+	if err != nil {
+		return nil, "", errors.WithMessage(err, "failed to serialize creator")
+	}
+
+	proposal, txID, err := protoutil.CreateChaincodeProposalWithTransient(cb.HeaderType_ENDORSER_TRANSACTION,
+		channelID, cis, identity, transient)
+	if err != nil {
+		return nil, "", errors.WithMessage(err, "failed to create chaincode proposal")
+	}
+
+	return proposal, txID, nil
+}
+
+// NewSignedProposal 创建链码调用提案并使用 signer 签名，返回提案、已签名提案以及交易 ID
+func NewSignedProposal(signer protoutil.Signer, channelID string, cis *pb.ChaincodeInvocationSpec,
+	transient map[string][]byte) (*pb.Proposal, *pb.SignedProposal, string, error) {
+	proposal, txID, err := NewProposal(signer, channelID, cis, transient)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	signedProposal, err := protoutil.GetSignedProposal(proposal, signer)
+	if err != nil {
+		return nil, nil, "", errors.WithMessage(err, "failed to sign chaincode proposal")
+	}
+
+	return proposal, signedProposal, txID, nil
+}
+
+// NewSignedTx 校验背书结果确实是针对该提案的，然后组装并签名交易信封
+func NewSignedTx(proposal *pb.Proposal, signer protoutil.Signer, responses ...*pb.ProposalResponse) (*cb.Envelope, error) {
+	if len(responses) == 0 {
+		return nil, errors.New("at least one proposal response is required")
+	}
+
+	header, err := protoutil.UnmarshalHeader(proposal.Header)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid proposal header")
+	}
+
+	proposalHash, err := protoutil.GetProposalHash1(header, proposal.Payload)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to compute proposal hash")
+	}
+
+	for i, response := range responses {
+		if response.Response == nil || response.Endorsement == nil {
+			return nil, errors.Errorf("proposal response %d is not endorsed", i)
+		}
+
+		payload, err := protoutil.UnmarshalProposalResponsePayload(response.Payload)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid proposal response %d", i)
+		}
+
+		if !bytes.Equal(payload.ProposalHash, proposalHash) {
+			return nil, errors.Errorf("proposal response %d does not match the proposal", i)
+		}
+	}
+
+	return protoutil.CreateSignedTx(proposal, signer, responses...)
+}
+
+// NewUnsignedTx 与 NewSignedTx 相同，但不对交易签名，离线签名模式下由客户端对 Payload 签名后填入 Signature
+func NewUnsignedTx(proposal *pb.Proposal, creator Serializer, responses ...*pb.ProposalResponse) (*cb.Envelope, error) {
+	return NewSignedTx(proposal, &unsignedSigner{creator}, responses...)
+}
+
+// unsignedSigner 只提供身份，签名结果为空
+type unsignedSigner struct {
+	Serializer
+}
+
+func (s *unsignedSigner) Sign(_ []byte) ([]byte, error) {
+	return nil, nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package transactions
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+	fabricCrypto "github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/common/crypto"
+	"github.com/yakumioto/alkaid/third_party/github.com/hyperledger/fabric/protoutil"
+)
+
+type testSigner struct {
+	mspID  string
+	name   string
+	signer *fabricCrypto.ECDSASigner
+}
+
+func newTestSigner(t *testing.T, mspID, name string) *testSigner {
+	key, err := fabricCrypto.GeneratePrivateKey()
+	assert.NoError(t, err)
+	return &testSigner{mspID: mspID, name: name, signer: &fabricCrypto.ECDSASigner{PrivateKey: key}}
+}
+
+func (s *testSigner) Sign(msg []byte) ([]byte, error) {
+	digest := sha256.Sum256(msg)
+	return s.signer.Sign(rand.Reader, digest[:], nil)
+}
+
+func (s *testSigner) Serialize() ([]byte, error) {
+	return proto.Marshal(&msp.SerializedIdentity{Mspid: s.mspID, IdBytes: []byte(s.name)})
+}
+
+// endorse 模拟背书节点对提案进行背书
+func endorse(t *testing.T, endorser protoutil.Signer, proposal *pb.Proposal, result string) *pb.ProposalResponse {
+	response, err := protoutil.CreateProposalResponse(proposal.Header, proposal.Payload,
+		&pb.Response{Status: 200}, []byte(result), nil, &pb.ChaincodeID{Name: "basic"}, endorser)
+	assert.NoError(t, err)
+	return response
+}
+
+func TestNewSignedTx(t *testing.T) {
+	client := newTestSigner(t, "org1", "user1")
+	peer1 := newTestSigner(t, "org1", "peer0")
+	peer2 := newTestSigner(t, "org2", "peer0")
+
+	cis := NewChaincodeInvocationSpec("basic", "transfer", []string{"a", "b", "10"})
+	proposal, signedProposal, txID, err := NewSignedProposal(client, "mychannel", cis, map[string][]byte{"k": []byte("v")})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, txID)
+	assert.Equal(t, proposal.Payload, mustUnmarshalProposal(t, signedProposal.ProposalBytes).Payload)
+
+	other, _, _, err := NewSignedProposal(client, "mychannel", cis, nil)
+	assert.NoError(t, err)
+
+	tcs := []struct {
+		signer    protoutil.Signer
+		responses []*pb.ProposalResponse
+		isErr     bool
+	}{
+		{client, []*pb.ProposalResponse{endorse(t, peer1, proposal, "ok"), endorse(t, peer2, proposal, "ok")}, false},
+		{client, nil, true},
+		{client, []*pb.ProposalResponse{endorse(t, peer1, proposal, "ok"), endorse(t, peer2, proposal, "diff")}, true},
+		{client, []*pb.ProposalResponse{endorse(t, peer1, other, "ok")}, true},
+		{peer1, []*pb.ProposalResponse{endorse(t, peer1, proposal, "ok")}, true},
+	}
+
+	for _, tc := range tcs {
+		env, err := NewSignedTx(proposal, tc.signer, tc.responses...)
+		if tc.isErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+
+		id, err := protoutil.GetOrComputeTxIDFromEnvelope(protoutil.MarshalOrPanic(env))
+		assert.NoError(t, err)
+		assert.Equal(t, txID, id)
+
+		action, err := protoutil.GetActionFromEnvelopeMsg(env)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("ok"), action.Results)
+
+		// 离线签名模式下组装的交易信封与服务端签名的交易只有签名不同
+		unsigned, err := NewUnsignedTx(proposal, tc.signer, tc.responses...)
+		assert.NoError(t, err)
+		assert.Nil(t, unsigned.Signature)
+		assert.Equal(t, env.Payload, unsigned.Payload)
+	}
+}
+
+func mustUnmarshalProposal(t *testing.T, data []byte) *pb.Proposal {
+	proposal, err := protoutil.UnmarshalProposal(data)
+	assert.NoError(t, err)
+	return proposal
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package transactions
+
+import (
+	"github.com/golang/protobuf/proto"
+	cb "github.com/hyperledger/fabric-protos-go/common"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/utils"
+	"github.com/yakumioto/alkaid/internal/services/identities"
+)
+
+const ResourceNamespace = "Transaction"
+
+const (
+	StatusUnsigned  = "unsigned"  // 离线签名模式下提案等待客户端签名
+	StatusProposed  = "proposed"  // 提案已签名，等待背书
+	StatusAssembled = "assembled" // 离线签名模式下交易信封已组装，等待客户端签名
+	StatusEndorsed  = "endorsed"  // 已根据背书结果生成交易信封
+)
+
+// Transaction 链码交易。
+// SignedProposal 为发送给背书节点的已签名提案，Envelope 为根据背书结果组装的交易信封，
+// 两者均为 protobuf 编码后的二进制数据。
+// 离线签名模式下，SigningRequest 为客户端需要签名的提案或者交易信封。
+type Transaction struct {
+	ResourceID     string `json:"resourceId,omitempty" gorm:"primaryKey"`
+	TxID           string `json:"txId,omitempty" gorm:"uniqueIndex"`
+	NetworkID      string `json:"networkId,omitempty" gorm:"index"`
+	ChannelID      string `json:"channelId,omitempty" gorm:"index"`
+	ContractID     string `json:"contractId,omitempty"`
+	OrganizationID string `json:"organizationId,omitempty"`
+	UserID         string `json:"userId,omitempty" gorm:"index"`
+	IdentityID     string `json:"identityId,omitempty"`
+	Function       string `json:"function,omitempty"`
+	Status         string `json:"status,omitempty"`
+	Proposal       []byte `json:"-"`
+	SignedProposal []byte `json:"signedProposal,omitempty"`
+	Envelope       []byte `json:"-"`
+	Version        int64  `json:"version,omitempty" gorm:"default:1"`
+	CreatedAt      int64  `json:"createdAt,omitempty" gorm:"autoCreateTime"`
+	UpdatedAt      int64  `json:"updatedAt,omitempty" gorm:"autoUpdateTime"`
+
+	SigningRequest *identities.SigningRequest `json:"signingRequest,omitempty" gorm:"-"`
+}
+
+func (t *Transaction) Create() error {
+	t.ResourceID = utils.GenResourceID(ResourceNamespace)
+	t.Version = 1
+	return storage.Create(t)
+}
+
+func (t *Transaction) Update() error {
+	return storage.Update(t, storage.NewUpdateOptions("resource_id = ?", t.ResourceID).Version("version", t.Version))
+}
+
+func (t *Transaction) proposal() (*pb.Proposal, error) {
+	proposal := new(pb.Proposal)
+	return proposal, proto.Unmarshal(t.Proposal, proposal)
+}
+
+func (t *Transaction) envelope() (*cb.Envelope, error) {
+	env := new(cb.Envelope)
+	return env, proto.Unmarshal(t.Envelope, env)
+}
+
+// FindTransactionByID id 可以是资源 ID 或者交易 ID
+func FindTransactionByID(networkID, channelID, contractID, id string) (*Transaction, error) {
+	transaction := new(Transaction)
+	return transaction, storage.FindByQuery(transaction,
+		storage.NewQueryOptions().
+			Where("network_id = ? AND channel_id = ? AND contract_id = ? AND (resource_id = ? OR tx_id = ?)",
+				networkID, channelID, contractID, id, id))
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package users
+
+import (
+	"context"
+
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
+	"github.com/yakumioto/alkaid/internal/common/crypto/utils"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+)
+
+const EmergencyAccessResourceNamespace = "EmergencyAccess"
+
+// 紧急访问的状态
+const (
+	EmergencyAccessStatusGranted           = "granted"
+	EmergencyAccessStatusRecoveryInitiated = "recovery_initiated"
+)
+
+// EmergencyAccess 紧急联系人，用户的对称密钥使用联系人的 RSA 公钥加密保存，
+// 联系人发起恢复并等待 WaitTime 秒后，用户未拒绝时联系人可以为用户设置新密码
+type EmergencyAccess struct {
+	ResourceID            string `json:"resourceId,omitempty" gorm:"primaryKey"`
+	UserID                string `json:"userId,omitempty" gorm:"index"`
+	GranteeID             string `json:"granteeId,omitempty" gorm:"index"`
+	WaitTime              int64  `json:"waitTime,omitempty"`
+	Status                string `json:"status,omitempty"`
+	ProtectedSymmetricKey string `json:"-"`
+	RecoveryInitiatedAt   int64  `json:"recoveryInitiatedAt,omitempty"` // 最近一次发起恢复的时间
+	Version               int64  `json:"version,omitempty" gorm:"default:1"`
+	CreatedAt             int64  `json:"createdAt,omitempty" gorm:"autoCreateTime"`
+	UpdatedAt             int64  `json:"updatedAt,omitempty" gorm:"autoUpdateTime"`
+}
+
+func newEmergencyAccess(userID, granteeID string, waitTime int64) *EmergencyAccess {
+	return &EmergencyAccess{
+		UserID:    userID,
+		GranteeID: granteeID,
+		WaitTime:  waitTime,
+		Status:    EmergencyAccessStatusGranted,
+	}
+}
+
+func (ea *EmergencyAccess) Create(ctx context.Context) error {
+	ea.ResourceID = utils.GenResourceID(EmergencyAccessResourceNamespace)
+	return storage.FromContext(ctx).Create(ea)
+}
+
+func (ea *EmergencyAccess) associatedData() []byte {
+	return []byte(ea.UserID + "/" + ea.GranteeID + "/" + fieldProtectedSymmetricKey)
+}
+
+// wrapSymmetricKey 使用联系人的 RSA 公钥加密用户的对称密钥
+func (ea *EmergencyAccess) wrapSymmetricKey(symmetricKey *utils.StretchedKey, rsaPublicKey string) error {
+	publicKey, err := factory.CryptoKeyImport([]byte(rsaPublicKey), crypto.Rsa2048)
+	if err != nil {
+		return err
+	}
+
+	ea.ProtectedSymmetricKey, err = utils.EncryptWithAssociatedData(utils.Rsa2048OaepSha256B64,
+		symmetricKey.Key(), ea.associatedData(), publicKey)
+	return err
+}
+
+// unwrapSymmetricKey 使用联系人的 RSA 私钥解密用户的对称密钥
+func (ea *EmergencyAccess) unwrapSymmetricKey(rsaPrivateKey []byte) (*utils.StretchedKey, error) {
+	privateKey, err := factory.CryptoKeyImport(rsaPrivateKey, crypto.Rsa2048)
+	if err != nil {
+		return nil, err
+	}
+
+	symmetricKey, err := utils.DecryptWithAssociatedData(ea.ProtectedSymmetricKey, ea.associatedData(), privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSymmetricKey(symmetricKey)
+}
+
+// RecoveryAvailableAt 联系人可以恢复账户的时间，未发起恢复时返回 0
+func (ea *EmergencyAccess) RecoveryAvailableAt() int64 {
+	if ea.Status != EmergencyAccessStatusRecoveryInitiated {
+		return 0
+	}
+
+	return ea.RecoveryInitiatedAt + ea.WaitTime
+}
+
+// EmergencyAccessQuerySchema 紧急访问列表允许过滤以及排序的字段
+var EmergencyAccessQuerySchema = &storage.Schema{
+	Fields: map[string]storage.Field{
+		"userId":    {Column: "user_id"},
+		"granteeId": {Column: "grantee_id"},
+		"status":    {Column: "status"},
+		"createdAt": {Column: "created_at", Type: storage.FieldInt},
+		"updatedAt": {Column: "updated_at", Type: storage.FieldInt},
+	},
+	Key:         "resource_id",
+	DefaultSort: "createdAt",
+}
+
+// FindEmergencyAccessByID 通过资源 ID 查询用户的紧急访问
+func FindEmergencyAccessByID(userID, id string) (*EmergencyAccess, error) {
+	access := new(EmergencyAccess)
+	return access, storage.FindByQuery(access, storage.NewQueryOptions().
+		Where(&EmergencyAccess{UserID: userID, ResourceID: id}))
+}
+
+// FindEmergencyAccess 查询用户授予联系人的紧急访问
+func FindEmergencyAccess(userID, granteeID string) (*EmergencyAccess, error) {
+	access := new(EmergencyAccess)
+	return access, storage.FindByQuery(access, storage.NewQueryOptions().
+		Where(&EmergencyAccess{UserID: userID, GranteeID: granteeID}))
+}
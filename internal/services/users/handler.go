@@ -9,7 +9,9 @@
 package users
 
 import (
+	"context"
 	"net/http"
+	"net/mail"
 
 	"github.com/yakumioto/alkaid/internal/common/crypto"
 	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
@@ -31,7 +33,8 @@ type CreateRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
-func Create(req *CreateRequest) (*User, error) {
+// Create 创建用户，ctx 携带事务时在该事务中创建
+func Create(ctx context.Context, req *CreateRequest) (*User, error) {
 	u := newUserByCreateRequest(req)
 
 	// 生成扩展密钥
@@ -50,7 +53,7 @@ func Create(req *CreateRequest) (*User, error) {
 			"failed to generate symmetric key")
 	}
 
-	signPrivateKey, err := factory.CryptoKeyGen(crypto.EcdsaP256)
+	signPrivateKey, err := factory.CryptoKeyGenByUsage(crypto.DefaultPolicy, crypto.UsageSign)
 	if err != nil {
 		logger.Errorf("[%v] generate signature key error: %v", u.UserID, err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
@@ -63,7 +66,7 @@ func Create(req *CreateRequest) (*User, error) {
 			"failed to convert the signature key to pem format")
 	}
 
-	tlsPrivateKey, err := factory.CryptoKeyGen(crypto.EcdsaP256)
+	tlsPrivateKey, err := factory.CryptoKeyGenByUsage(crypto.DefaultPolicy, crypto.UsageSign)
 	if err != nil {
 		logger.Errorf("[%v] generate tls key error: %v", u.UserID, err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
@@ -76,7 +79,7 @@ func Create(req *CreateRequest) (*User, error) {
 			"failed to convert the tls key to pem format")
 	}
 
-	rsaPrivateKey, err := factory.CryptoKeyGen(crypto.Rsa2048)
+	rsaPrivateKey, err := factory.CryptoKeyGenByUsage(crypto.DefaultPolicy, crypto.UsageWrap)
 	if err != nil {
 		logger.Errorf("[%v] generate rsa key error: %v", u.UserID, err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
@@ -89,32 +92,22 @@ func Create(req *CreateRequest) (*User, error) {
 			"failed to convert the rsa key to pem format")
 	}
 
-	symmetricKeyAesKey, err := factory.CryptoKeyImport(symmetricKey.Enc, crypto.AesCbc256)
-	if err != nil {
-		logger.Errorf("[%v] import aes password error: %v", u.UserID, err)
-		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
-			"failed to import aes password key")
-	}
-	symmetricKeyHashKey, err := factory.CryptoKeyImport(symmetricKey.Mac, crypto.HmacSha256)
-	if err != nil {
-		logger.Errorf("[%v] import hash password error: %v", u.UserID, err)
-		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
-			"failed to import hash password key")
-	}
-
-	protectedSignPrivateKey, err := utils.Encrypt(utils.AesCbc256HmacSha256B64, signPrivateKeyPem, symmetricKeyAesKey, symmetricKeyHashKey)
+	protectedSignPrivateKey, err := utils.EncryptWithStretchedKey(symmetricKey, signPrivateKeyPem,
+		u.associatedData(fieldProtectedSignPrivateKey))
 	if err != nil {
 		logger.Errorf("[%v] encryption signing private key error: %v", u.UserID, err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
 			"encryption signing private key failed")
 	}
-	protectedTLSPrivateKey, err := utils.Encrypt(utils.AesCbc256HmacSha256B64, tlsPrivateKeyPem, symmetricKeyAesKey, symmetricKeyHashKey)
+	protectedTLSPrivateKey, err := utils.EncryptWithStretchedKey(symmetricKey, tlsPrivateKeyPem,
+		u.associatedData(fieldProtectedTLSPrivateKey))
 	if err != nil {
 		logger.Errorf("[%v] encryption tls private key error: %v", u.UserID, err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
 			"encryption tls private key failed")
 	}
-	protectedRSAPrivateKey, err := utils.Encrypt(utils.AesCbc256HmacSha256B64, rsaPrivateKeyPem, symmetricKeyAesKey, symmetricKeyHashKey)
+	protectedRSAPrivateKey, err := utils.EncryptWithStretchedKey(symmetricKey, rsaPrivateKeyPem,
+		u.associatedData(fieldProtectedRSAPrivateKey))
 	if err != nil {
 		logger.Errorf("[%v] encryption rsa private key error: %v", u.UserID, err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
@@ -151,19 +144,8 @@ func Create(req *CreateRequest) (*User, error) {
 	}
 	u.RSAPublicKey = string(pubKeyPem)
 
-	stretchedKeyAesKey, err := factory.CryptoKeyImport(stretchedKey.Enc, crypto.AesCbc256)
-	if err != nil {
-		logger.Errorf("[%v] import aes password error: %v", u.UserID, err)
-		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
-			"failed to import aes password key")
-	}
-	stretchedKeyHashKey, err := factory.CryptoKeyImport(stretchedKey.Mac, crypto.HmacSha256)
-	if err != nil {
-		logger.Errorf("[%v] import hash password error: %v", u.UserID, err)
-		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
-			"failed to import hash password key")
-	}
-	protectedSymmetricKey, err := utils.Encrypt(utils.AesCbc256HmacSha256B64, symmetricKey.Key(), stretchedKeyAesKey, stretchedKeyHashKey)
+	protectedSymmetricKey, err := utils.EncryptWithStretchedKey(stretchedKey, symmetricKey.Key(),
+		u.associatedData(fieldProtectedSymmetricKey))
 	if err != nil {
 		logger.Errorf("[%v] encryption symmetric key error: %v", u.UserID, err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
@@ -171,7 +153,14 @@ func Create(req *CreateRequest) (*User, error) {
 	}
 	u.ProtectedSymmetricKey = protectedSymmetricKey
 
-	if err = u.Create(); err != nil {
+	// 生成一次性的恢复码，只在创建时返回
+	if err = u.setRecoveryCode(symmetricKey); err != nil {
+		logger.Errorf("[%v] generate recovery code error: %v", u.UserID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to generate recovery code")
+	}
+
+	if err = u.Create(ctx); err != nil {
 		logger.Errorf("[%v] create user error: %v", u.UserID, err)
 		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
 			"failed to create user")
@@ -180,8 +169,16 @@ func Create(req *CreateRequest) (*User, error) {
 	return u, nil
 }
 
-func GetList() ([]*User, error) {
-	return nil, nil
+func GetList(options *storage.QueryOptions) (*storage.Page, error) {
+	list := make([]*User, 0)
+	page, err := storage.FindPage(&list, options)
+	if err != nil {
+		logger.Errorf("query users error: %v", err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	return page, nil
 }
 
 func GetDetailByID(id string) (*User, error) {
@@ -200,13 +197,64 @@ func GetDetailByID(id string) (*User, error) {
 	return user, nil
 }
 
+type UpdateRequest struct {
+	Name    string `json:"name,omitempty"`
+	Version int64  `json:"-"` // If-Match 指定的版本，为 0 时基于读取到的版本更新
+}
+
+// Update 修改用户的基本信息，只有用户本人以及 root 用户可以修改
+func Update(ctx context.Context, userCtx *UserContext, id string, req *UpdateRequest) (*User, error) {
+	user, err := GetDetailByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !userCtx.Root && userCtx.ID != user.UserID {
+		logger.Warnf("[%v] user [%v] has no permission to update user", id, userCtx.ID)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"only the user can update user")
+	}
+	if err = checkVersion(user, req.Version); err != nil {
+		return nil, err
+	}
+
+	if err = updateUser(ctx, user, &User{Name: req.Name}, req.Version); err != nil {
+		return nil, err
+	}
+
+	return GetDetailByID(user.ResourceID)
+}
+
+type PreLoginRequest struct {
+	ID string `json:"id,omitempty"`
+}
+
+// PreLogin 返回用户的 KDF 参数，客户端可以据此自行生成主密钥。
+// 用户不存在时返回当前的 KDF 策略，避免通过该接口判断用户是否存在
+func PreLogin(req *PreLoginRequest) (*utils.KDFParams, error) {
+	user, err := FindUnscopedUserByID(req.ID)
+	switch err {
+	case nil:
+		params := user.KDFParams()
+		return &params, nil
+	case storage.ErrNotFound:
+		params := KDFPolicy()
+		return &params, nil
+	default:
+		logger.Errorf("[%v] query user error: %v", req.ID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+}
+
 type LoginRequest struct {
 	ID       string `json:"id,omitempty"`
 	Password string `json:"password,omitempty"`
 }
 
+// Login 校验用户密码，已停用的用户无法登录，返回的成员关系不包含已停用的成员关系
 func Login(req *LoginRequest) (*User, []*UserOrganizations, error) {
-	user, err := FindUserByID(req.ID)
+	user, err := FindUnscopedUserByID(req.ID)
 	if err != nil {
 		if err == storage.ErrNotFound {
 			logger.Infof("[%v] user not found", req.ID)
@@ -219,18 +267,757 @@ func Login(req *LoginRequest) (*User, []*UserOrganizations, error) {
 			"server unknown error")
 	}
 
-	if !utils.ValidatePassword(req.Password, user.Email, user.Password) {
+	if !user.ValidatePassword(req.Password) {
 		logger.Infof("[%v] wrong user password", req.ID)
 		return nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
 			"wrong user password")
 	}
 
+	if user.Deactivate {
+		logger.Infof("[%v] user is deactivated", req.ID)
+		return nil, nil, errors.NewError(http.StatusForbidden, errors.ErrUserDeactivated,
+			"user is deactivated")
+	}
+
 	organizations, err := FindUserOrganizationsByUserID(user.UserID)
-	if err != nil && err != storage.ErrNotFound {
+	if err != nil {
 		logger.Errorf("[%v] query user organizations error: %v", req.ID, err)
 		return nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
 			"server unknown error")
 	}
 
+	// 只有登录时才能拿到用户密码，将旧格式的受保护字段升级为 AEAD 格式。
+	// 旧格式只能在升级时解密，因此在返回之前同步升级，后续请求读取到的都是新格式
+	if err = UpgradeEncryption(context.Background(), user, req.Password); err != nil {
+		logger.Warnf("[%v] upgrade encryption error: %v", user.UserID, err)
+	}
+
+	// 将不满足 KDF 策略的密码哈希以及对称密钥密文升级为当前策略的参数，
+	// 与 UpgradeEncryption 一样在返回之前同步升级，并以升级时读取到的版本作为前置条件
+	if err = UpgradeKDF(context.Background(), user.ResourceID, req.Password); err != nil {
+		logger.Warnf("[%v] upgrade kdf error: %v", user.UserID, err)
+	}
+
 	return user, organizations, nil
 }
+
+// UpgradeEncryption 将用户旧格式的受保护字段重新加密为 AES-256-GCM 格式，并与用户 ID 以及字段名绑定。
+// 受保护字段只能使用用户的密码解密，因此在登录以及使用用户身份私钥时升级，
+// alkaid reencrypt 通过 FindLegacyEncryptionUsers 列出仍在等待升级的用户。
+// 更新时以读取到的版本作为前置条件，数据已被其他请求修改时视为已经升级
+func UpgradeEncryption(ctx context.Context, user *User, password string) error {
+	values, err := user.upgradeEncryption(password)
+	if err != nil {
+		return err
+	}
+	if values == nil {
+		return nil
+	}
+
+	err = storage.FromContext(ctx).Update(values,
+		storage.NewUpdateOptions("resource_id = ?", user.ResourceID).Version("version", user.Version))
+	if err != nil && err != storage.ErrConflict {
+		return err
+	}
+
+	return nil
+}
+
+// FindLegacyEncryptionUsers 分批查询受保护字段仍是旧格式的用户 ID，包含已停用的用户。
+// 这些用户的受保护字段没有密码无法解密，需要等待用户登录后升级
+func FindLegacyEncryptionUsers(ctx context.Context, batchSize int) ([]string, error) {
+	pending := make([]string, 0)
+	last := ""
+	for {
+		list := make([]*User, 0, batchSize)
+		err := storage.FromContext(ctx).FindByQuery(&list, storage.NewQueryOptions().
+			Where("resource_id > ?", last).Order("resource_id").Limit(batchSize).Unscoped())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, user := range list {
+			if user.LegacyEncryption() {
+				pending = append(pending, user.UserID)
+			}
+		}
+		if len(list) < batchSize {
+			return pending, nil
+		}
+		last = list[len(list)-1].ResourceID
+	}
+}
+
+// UpgradeKDF 用户的 KDF 参数不满足当前策略时，使用策略的参数重新生成密码哈希并重新加密对称密钥。
+// 与 UpgradeEncryption 相同，更新时以读取到的版本作为前置条件，数据已被其他请求修改时视为已经升级
+func UpgradeKDF(ctx context.Context, id, password string) error {
+	user, err := FindUnscopedUserByID(id)
+	if err != nil {
+		return err
+	}
+	if user.KDFParams().Satisfies(KDFPolicy()) {
+		return nil
+	}
+
+	values, err := user.rewrapSymmetricKey(password, password, user.Email)
+	if err != nil {
+		return err
+	}
+
+	err = storage.FromContext(ctx).Update(values, storage.NewUpdateOptions("resource_id = ?", user.ResourceID).
+		Version("version", user.Version).Select(symmetricKeyColumns...))
+	switch err {
+	case nil:
+		logger.Infof("[%v] upgrade kdf from %v to %v", user.UserID, user.KDFParams(), values.KDFParams())
+		return nil
+	case storage.ErrConflict:
+		return nil
+	default:
+		return err
+	}
+}
+
+type ChangePasswordRequest struct {
+	Password    string `json:"password" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required"`
+	Version     int64  `json:"-"` // If-Match 指定的版本，为 0 时基于读取到的版本更新
+}
+
+// ChangePassword 修改用户密码，只有用户本人可以修改，对称密钥使用新密码重新加密，私钥密文保持不变
+func ChangePassword(ctx context.Context, userCtx *UserContext, id string, req *ChangePasswordRequest) (*User, error) {
+	if req.NewPassword == "" {
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"new password is required")
+	}
+
+	return changeCredentials(ctx, userCtx, id, req.Password, req.Version, func(user *User) (string, string, error) {
+		return req.NewPassword, user.Email, nil
+	})
+}
+
+type ChangeEmailRequest struct {
+	Password string `json:"password" validate:"required"`
+	Email    string `json:"email" validate:"required,email"`
+	Version  int64  `json:"-"` // If-Match 指定的版本，为 0 时基于读取到的版本更新
+}
+
+// ChangeEmail 修改用户邮箱，邮箱是扩展密钥以及密码哈希的盐，需要提交密码重新加密对称密钥
+func ChangeEmail(ctx context.Context, userCtx *UserContext, id string, req *ChangeEmailRequest) (*User, error) {
+	if address, err := mail.ParseAddress(req.Email); err != nil || address.Address != req.Email {
+		return nil, errors.NewErrorf(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"invalid email: %v", req.Email)
+	}
+
+	return changeCredentials(ctx, userCtx, id, req.Password, req.Version, func(user *User) (string, string, error) {
+		if req.Email == user.Email {
+			return "", "", errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+				"the new email is the same as the current email")
+		}
+
+		_, err := FindUnscopedUserByID(req.Email)
+		switch err {
+		case nil:
+			logger.Warnf("[%v] email [%v] already exists", user.UserID, req.Email)
+			return "", "", errors.NewError(http.StatusConflict, errors.ErrConflict,
+				"email already exists")
+		case storage.ErrNotFound:
+			return req.Password, req.Email, nil
+		default:
+			logger.Errorf("[%v] query user [%v] error: %v", user.UserID, req.Email, err)
+			return "", "", errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+				"server unknown error")
+		}
+	})
+}
+
+// changeCredentials 校验原密码后使用新的密码以及邮箱重新加密对称密钥，并在同一次更新中修改密码哈希。
+// 更新时以读取到的版本作为前置条件，期间被其他请求修改时返回冲突
+func changeCredentials(ctx context.Context, userCtx *UserContext, id, password string, version int64,
+	credentials func(user *User) (string, string, error)) (*User, error) {
+	user, err := GetDetailByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkVersion(user, version); err != nil {
+		return nil, err
+	}
+
+	if userCtx.ID != user.UserID {
+		logger.Warnf("[%v] user [%v] has no permission to change credentials", id, userCtx.ID)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"only the user can change credentials")
+	}
+	if !user.ValidatePassword(password) {
+		logger.Infof("[%v] wrong user password", id)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"wrong user password")
+	}
+
+	newPassword, newEmail, err := credentials(user)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := user.rewrapSymmetricKey(password, newPassword, newEmail)
+	if err != nil {
+		logger.Errorf("[%v] rewrap symmetric key error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to rewrap symmetric key")
+	}
+
+	if err = updateUser(ctx, user, values, version, symmetricKeyColumns...); err != nil {
+		return nil, err
+	}
+
+	return GetDetailByID(user.ResourceID)
+}
+
+// checkVersion If-Match 指定了版本且与用户当前的版本不一致时返回前置条件失败
+func checkVersion(user *User, version int64) error {
+	if version != 0 && version != user.Version {
+		logger.Warnf("[%v] user version mismatch: expected %v, current %v", user.UserID, version, user.Version)
+		return errors.NewError(http.StatusPreconditionFailed, errors.ErrPreconditionFailed,
+			"user has been modified")
+	}
+
+	return nil
+}
+
+// updateUser 以读取到的用户版本作为前置条件更新用户，更新成功后 values 中的版本为新的版本。
+// version 为 If-Match 指定的版本，指定时冲突视为前置条件失败，否则为与其他请求同时修改。
+// 指定 columns 时只更新指定的列，零值同样会被更新
+func updateUser(ctx context.Context, user, values *User, version int64, columns ...string) error {
+	err := storage.FromContext(ctx).Update(values, storage.NewUpdateOptions("resource_id = ?", user.ResourceID).
+		Version("version", user.Version).Select(columns...))
+	switch {
+	case err == nil:
+		return nil
+	case err == storage.ErrConflict:
+		logger.Warnf("[%v] user has been modified concurrently", user.UserID)
+		return errors.NewConflictError("user", version != 0)
+	default:
+		logger.Errorf("[%v] update user error: %v", user.UserID, err)
+		return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to update user")
+	}
+}
+
+type RegenerateRecoveryCodeRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+// RegenerateRecoveryCode 重新生成恢复码，只有用户本人可以生成，旧的恢复码随之失效
+func RegenerateRecoveryCode(ctx context.Context, userCtx *UserContext, id string, req *RegenerateRecoveryCodeRequest) (*User, error) {
+	user, err := GetDetailByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if userCtx.ID != user.UserID {
+		logger.Warnf("[%v] user [%v] has no permission to regenerate recovery code", id, userCtx.ID)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"only the user can regenerate recovery code")
+	}
+	symmetricKey, err := user.SymmetricKey(req.Password)
+	if err != nil || !user.ValidatePassword(req.Password) {
+		logger.Infof("[%v] wrong user password", id)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"wrong user password")
+	}
+
+	values := &User{UserID: user.UserID}
+	if err = values.setRecoveryCode(symmetricKey); err != nil {
+		logger.Errorf("[%v] generate recovery code error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to generate recovery code")
+	}
+	if err = updateUser(ctx, user, values, 0); err != nil {
+		return nil, err
+	}
+
+	return withRecoveryCode(user.ResourceID, values.RecoveryCode)
+}
+
+type RecoverRequest struct {
+	RecoveryCode string `json:"recoveryCode" validate:"required"`
+	NewPassword  string `json:"newPassword" validate:"required"`
+}
+
+// Recover 使用恢复码为忘记密码的用户设置新密码。恢复码只能使用一次，恢复后返回新的恢复码
+func Recover(ctx context.Context, id string, req *RecoverRequest) (*User, error) {
+	if req.NewPassword == "" {
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"new password is required")
+	}
+
+	user, err := GetDetailByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	symmetricKey, err := user.RecoverSymmetricKey(req.RecoveryCode)
+	if err != nil {
+		logger.Infof("[%v] invalid recovery code: %v", id, err)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"invalid recovery code")
+	}
+
+	values, err := user.wrapSymmetricKey(symmetricKey, req.NewPassword, user.Email)
+	if err == nil {
+		err = values.setRecoveryCode(symmetricKey)
+	}
+	if err != nil {
+		logger.Errorf("[%v] rewrap symmetric key error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to rewrap symmetric key")
+	}
+
+	// 以读取到的版本作为前置条件，同一个恢复码不能被使用两次
+	if err = updateUser(ctx, user, values, 0, append(symmetricKeyColumns, "protected_recovery_key")...); err != nil {
+		return nil, err
+	}
+
+	return withRecoveryCode(user.ResourceID, values.RecoveryCode)
+}
+
+func withRecoveryCode(id, recoveryCode string) (*User, error) {
+	user, err := GetDetailByID(id)
+	if err != nil {
+		return nil, err
+	}
+	user.RecoveryCode = recoveryCode
+
+	return user, nil
+}
+
+// DefaultEmergencyWaitTime 未指定时紧急联系人发起恢复后需要等待的时间，单位为秒
+const DefaultEmergencyWaitTime int64 = 7 * 24 * 60 * 60
+
+type GrantEmergencyAccessRequest struct {
+	Password  string `json:"password" validate:"required"`
+	GranteeID string `json:"granteeId" validate:"required"`
+	WaitTime  int64  `json:"waitTime,omitempty"`
+}
+
+// GrantEmergencyAccess 将 root 用户或者所在组织的管理员设置为紧急联系人，对称密钥使用联系人的 RSA 公钥加密保存
+func GrantEmergencyAccess(ctx context.Context, userCtx *UserContext, id string, req *GrantEmergencyAccessRequest) (*EmergencyAccess, error) {
+	if req.WaitTime < 0 {
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"wait time cannot be negative")
+	}
+	if req.WaitTime == 0 {
+		req.WaitTime = DefaultEmergencyWaitTime
+	}
+
+	user, err := GetDetailByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if userCtx.ID != user.UserID {
+		logger.Warnf("[%v] user [%v] has no permission to grant emergency access", id, userCtx.ID)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"only the user can grant emergency access")
+	}
+	symmetricKey, err := user.SymmetricKey(req.Password)
+	if err != nil || !user.ValidatePassword(req.Password) {
+		logger.Infof("[%v] wrong user password", id)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"wrong user password")
+	}
+
+	grantee, err := GetDetailByID(req.GranteeID)
+	if err != nil {
+		return nil, err
+	}
+	if grantee.UserID == user.UserID {
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"cannot grant emergency access to yourself")
+	}
+	if err = checkEmergencyContact(user, grantee); err != nil {
+		return nil, err
+	}
+
+	_, err = FindEmergencyAccess(user.UserID, grantee.UserID)
+	switch err {
+	case nil:
+		logger.Warnf("[%v] emergency access for [%v] already exists", id, grantee.UserID)
+		return nil, errors.NewError(http.StatusConflict, errors.ErrConflict,
+			"emergency access already exists")
+	case storage.ErrNotFound:
+	default:
+		logger.Errorf("[%v] query emergency access error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	access := newEmergencyAccess(user.UserID, grantee.UserID, req.WaitTime)
+	if err = access.wrapSymmetricKey(symmetricKey, grantee.RSAPublicKey); err != nil {
+		logger.Errorf("[%v] wrap symmetric key for [%v] error: %v", id, grantee.UserID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to wrap symmetric key")
+	}
+	if err = access.Create(ctx); err != nil {
+		logger.Errorf("[%v] create emergency access error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to create emergency access")
+	}
+
+	return access, nil
+}
+
+// checkEmergencyContact 紧急联系人必须是 root 用户，或者是用户所在组织的管理员
+func checkEmergencyContact(user, grantee *User) error {
+	if grantee.Root {
+		return nil
+	}
+
+	organizations, err := FindUserOrganizationsByUserID(user.UserID)
+	if err != nil {
+		logger.Errorf("[%v] query user organizations error: %v", user.UserID, err)
+		return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+	for _, org := range organizations {
+		member, err := FindUserOrganization(org.OrganizationID, grantee.UserID, false)
+		if err == nil && member.Role == RoleOrganization {
+			return nil
+		}
+	}
+
+	logger.Warnf("[%v] user [%v] cannot be an emergency contact", user.UserID, grantee.UserID)
+	return errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+		"emergency contact must be root or an organization administrator of the user")
+}
+
+// checkEmergencyGrantee 紧急联系人在授予之后可能已经被移出用户所在的组织或者不再是管理员，
+// 发起恢复以及恢复之前重新检查联系人的资格
+func checkEmergencyGrantee(user *User, access *EmergencyAccess) (*User, error) {
+	grantee, err := GetDetailByID(access.GranteeID)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkEmergencyContact(user, grantee); err != nil {
+		return nil, err
+	}
+
+	return grantee, nil
+}
+
+// GetEmergencyAccessList 查询用户授予的以及被授予的紧急访问，只有用户本人可以查询
+func GetEmergencyAccessList(userCtx *UserContext, id string, options *storage.QueryOptions) (*storage.Page, error) {
+	user, err := GetDetailByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if userCtx.ID != user.UserID {
+		logger.Warnf("[%v] user [%v] has no permission to query emergency access", id, userCtx.ID)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"only the user can query emergency access")
+	}
+
+	list := make([]*EmergencyAccess, 0)
+	page, err := storage.FindPage(&list,
+		options.Where("(user_id = ? OR grantee_id = ?)", user.UserID, user.UserID))
+	if err != nil {
+		logger.Errorf("[%v] query emergency access error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	return page, nil
+}
+
+// getEmergencyAccess 查询用户授予的紧急访问，只有授予者以及联系人可以访问
+func getEmergencyAccess(userCtx *UserContext, id, accessID string) (*User, *EmergencyAccess, error) {
+	user, err := GetDetailByID(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, err := FindEmergencyAccessByID(user.UserID, accessID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Warnf("[%v] emergency access [%v] not found", id, accessID)
+			return nil, nil, errors.NewError(http.StatusNotFound, errors.ErrUserEmergencyAccessNotFound,
+				"emergency access not found")
+		}
+		logger.Errorf("[%v] query emergency access [%v] error: %v", id, accessID, err)
+		return nil, nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	if userCtx.ID != access.UserID && userCtx.ID != access.GranteeID {
+		logger.Warnf("[%v] user [%v] has no permission to access emergency access [%v]", id, userCtx.ID, accessID)
+		return nil, nil, errors.NewError(http.StatusNotFound, errors.ErrUserEmergencyAccessNotFound,
+			"emergency access not found")
+	}
+
+	return user, access, nil
+}
+
+// updateEmergencyAccess 使用版本号更新紧急访问，更新成功后 access 中的版本为新的版本。
+// version 为 If-Match 指定的版本，指定时冲突视为前置条件失败
+func updateEmergencyAccess(ctx context.Context, access *EmergencyAccess, version int64) error {
+	err := storage.FromContext(ctx).Update(access,
+		storage.NewUpdateOptions("resource_id = ?", access.ResourceID).Version("version", access.Version))
+	switch {
+	case err == nil:
+		return nil
+	case err == storage.ErrConflict:
+		logger.Warnf("[%v] emergency access [%v] has been modified concurrently", access.UserID, access.ResourceID)
+		return errors.NewConflictError("emergency access", version != 0)
+	default:
+		logger.Errorf("[%v] update emergency access [%v] error: %v", access.UserID, access.ResourceID, err)
+		return errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to update emergency access")
+	}
+}
+
+// InitiateEmergencyRecovery 紧急联系人发起恢复，等待时间结束之前用户可以拒绝
+func InitiateEmergencyRecovery(ctx context.Context, userCtx *UserContext, id, accessID string) (*EmergencyAccess, error) {
+	user, access, err := getEmergencyAccess(userCtx, id, accessID)
+	if err != nil {
+		return nil, err
+	}
+	if userCtx.ID != access.GranteeID {
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"only the emergency contact can initiate recovery")
+	}
+	if _, err = checkEmergencyGrantee(user, access); err != nil {
+		return nil, err
+	}
+	if access.Status != EmergencyAccessStatusGranted {
+		return nil, errors.NewError(http.StatusConflict, errors.ErrConflict,
+			"recovery has already been initiated")
+	}
+
+	access.Status = EmergencyAccessStatusRecoveryInitiated
+	access.RecoveryInitiatedAt = TimeNowFunc()
+	if err = updateEmergencyAccess(ctx, access, 0); err != nil {
+		return nil, err
+	}
+	logger.Infof("[%v] emergency contact [%v] initiated recovery", access.UserID, access.GranteeID)
+
+	return access, nil
+}
+
+// RejectEmergencyRecovery 用户拒绝紧急联系人发起的恢复，紧急访问回到已授予状态
+func RejectEmergencyRecovery(ctx context.Context, userCtx *UserContext, id, accessID string) (*EmergencyAccess, error) {
+	_, access, err := getEmergencyAccess(userCtx, id, accessID)
+	if err != nil {
+		return nil, err
+	}
+	if userCtx.ID != access.UserID {
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"only the user can reject recovery")
+	}
+	if access.Status != EmergencyAccessStatusRecoveryInitiated {
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"recovery has not been initiated")
+	}
+
+	access.Status = EmergencyAccessStatusGranted
+	if err = updateEmergencyAccess(ctx, access, 0); err != nil {
+		return nil, err
+	}
+
+	return access, nil
+}
+
+// RevokeEmergencyAccess 用户撤销紧急访问，同时删除为联系人加密的对称密钥。
+// version 为 If-Match 指定的版本，删除之前先以版本作为前置条件更新，期间被修改时不会删除
+func RevokeEmergencyAccess(ctx context.Context, userCtx *UserContext, id, accessID string, version int64) (*EmergencyAccess, error) {
+	_, access, err := getEmergencyAccess(userCtx, id, accessID)
+	if err != nil {
+		return nil, err
+	}
+	if userCtx.ID != access.UserID {
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"only the user can revoke emergency access")
+	}
+	if version != 0 && version != access.Version {
+		logger.Warnf("[%v] emergency access [%v] version mismatch: expected %v, current %v",
+			id, accessID, version, access.Version)
+		return nil, errors.NewError(http.StatusPreconditionFailed, errors.ErrPreconditionFailed,
+			"emergency access has been modified")
+	}
+
+	err = storage.Transaction(ctx, func(tx storage.Storage) error {
+		ctx := storage.NewContext(ctx, tx)
+		if err := updateEmergencyAccess(ctx, access, version); err != nil {
+			return err
+		}
+
+		return tx.Delete(access)
+	})
+	if err != nil {
+		if e, ok := err.(*errors.Error); ok {
+			return nil, e
+		}
+		logger.Errorf("[%v] delete emergency access [%v] error: %v", id, accessID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to revoke emergency access")
+	}
+
+	return access, nil
+}
+
+type EmergencyRecoverRequest struct {
+	Password    string `json:"password" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required"`
+}
+
+// EmergencyRecover 等待时间结束后，紧急联系人使用自己的 RSA 私钥解密用户的对称密钥，并为用户设置新密码
+func EmergencyRecover(ctx context.Context, userCtx *UserContext, id, accessID string, req *EmergencyRecoverRequest) (*User, error) {
+	if req.NewPassword == "" {
+		return nil, errors.NewError(http.StatusBadRequest, errors.ErrBadRequestParameters,
+			"new password is required")
+	}
+
+	user, access, err := getEmergencyAccess(userCtx, id, accessID)
+	if err != nil {
+		return nil, err
+	}
+	if userCtx.ID != access.GranteeID {
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"only the emergency contact can recover the user")
+	}
+	if access.Status != EmergencyAccessStatusRecoveryInitiated {
+		return nil, errors.NewError(http.StatusPreconditionFailed, errors.ErrPreconditionFailed,
+			"recovery has not been initiated")
+	}
+	if TimeNowFunc() < access.RecoveryAvailableAt() {
+		return nil, errors.NewErrorf(http.StatusPreconditionFailed, errors.ErrPreconditionFailed,
+			"recovery is available after %v", access.RecoveryAvailableAt())
+	}
+
+	grantee, err := checkEmergencyGrantee(user, access)
+	if err != nil {
+		return nil, err
+	}
+	rsaPrivateKey, err := grantee.RSAPrivateKey(req.Password)
+	if err != nil || !grantee.ValidatePassword(req.Password) {
+		logger.Infof("[%v] wrong user password", grantee.UserID)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"wrong user password")
+	}
+
+	symmetricKey, err := access.unwrapSymmetricKey(rsaPrivateKey)
+	if err != nil {
+		logger.Errorf("[%v] unwrap symmetric key by [%v] error: %v", id, grantee.UserID, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to unwrap symmetric key")
+	}
+	values, err := user.wrapSymmetricKey(symmetricKey, req.NewPassword, user.Email)
+	if err != nil {
+		logger.Errorf("[%v] rewrap symmetric key error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to rewrap symmetric key")
+	}
+
+	// 设置新密码后紧急访问回到已授予状态，对称密钥没有变化，为联系人加密的密文依然有效
+	err = storage.Transaction(ctx, func(tx storage.Storage) error {
+		ctx := storage.NewContext(ctx, tx)
+		if err := updateUser(ctx, user, values, 0, symmetricKeyColumns...); err != nil {
+			return err
+		}
+
+		access.Status = EmergencyAccessStatusGranted
+		return updateEmergencyAccess(ctx, access, 0)
+	})
+	if err != nil {
+		if e, ok := err.(*errors.Error); ok {
+			return nil, e
+		}
+		logger.Errorf("[%v] commit emergency recovery transaction error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to recover the user")
+	}
+	logger.Infof("[%v] recovered by emergency contact [%v]", id, grantee.UserID)
+
+	return GetDetailByID(user.ResourceID)
+}
+
+// Deactivate 停用用户，停用后用户无法登录，默认的查询也不再包含该用户，root 用户不能被停用。
+// version 为 If-Match 指定的版本，为 0 时基于读取到的版本停用
+func Deactivate(ctx context.Context, id string, version int64) (*User, error) {
+	user, err := GetDetailByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkVersion(user, version); err != nil {
+		return nil, err
+	}
+
+	if user.Root {
+		logger.Warnf("[%v] root user cannot be deactivated", id)
+		return nil, errors.NewError(http.StatusForbidden, errors.ErrForbidden,
+			"root user cannot be deactivated")
+	}
+
+	if err = softDelete(ctx, user, version, storage.Storage.Delete); err != nil {
+		if e, ok := err.(*errors.Error); ok {
+			return nil, e
+		}
+		logger.Errorf("[%v] deactivate user error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to deactivate user")
+	}
+
+	return getUnscopedDetailByID(user.ResourceID)
+}
+
+// Reactivate 恢复已停用的用户，用户未停用时直接返回。
+// version 为 If-Match 指定的版本，为 0 时基于读取到的版本恢复
+func Reactivate(ctx context.Context, id string, version int64) (*User, error) {
+	user, err := getUnscopedDetailByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkVersion(user, version); err != nil {
+		return nil, err
+	}
+
+	if !user.Deactivate {
+		return user, nil
+	}
+
+	if err = softDelete(ctx, user, version, storage.Storage.Restore); err != nil {
+		if e, ok := err.(*errors.Error); ok {
+			return nil, e
+		}
+		logger.Errorf("[%v] reactivate user error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"failed to reactivate user")
+	}
+
+	return getUnscopedDetailByID(user.ResourceID)
+}
+
+// softDelete 在同一个事务中以版本作为前置条件更新用户后停用或者恢复用户，期间被修改时不会变更用户的状态
+func softDelete(ctx context.Context, user *User, version int64,
+	fn func(s storage.Storage, value interface{}, conditions ...interface{}) error) error {
+	return storage.Transaction(ctx, func(tx storage.Storage) error {
+		if err := updateUser(storage.NewContext(ctx, tx), user, new(User), version); err != nil {
+			return err
+		}
+
+		return fn(tx, user)
+	})
+}
+
+func getUnscopedDetailByID(id string) (*User, error) {
+	user, err := FindUnscopedUserByID(id)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			logger.Warnf("[%v] user not found", id)
+			return nil, errors.NewError(http.StatusNotFound, errors.ErrUserNotFount,
+				"user not found")
+		}
+		logger.Errorf("[%v] query user error: %v", id, err)
+		return nil, errors.NewError(http.StatusInternalServerError, errors.ErrServerUnknownError,
+			"server unknown error")
+	}
+
+	return user, nil
+}
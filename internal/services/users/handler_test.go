@@ -0,0 +1,725 @@
+/*
+ * Copyright (c) 2022. The Alkaid Authors. All rights reserved.
+ * Use of this source code is governed by a MIT-style
+ * license that can be found in the LICENSE file.
+ *
+ * Alkaid is a BaaS service based on Hyperledger Fabric.
+ */
+
+package users
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/yakumioto/alkaid/internal/common/crypto"
+	"github.com/yakumioto/alkaid/internal/common/crypto/factory"
+	"github.com/yakumioto/alkaid/internal/common/crypto/utils"
+	"github.com/yakumioto/alkaid/internal/common/storage"
+	"github.com/yakumioto/alkaid/internal/common/storage/memory"
+	"github.com/yakumioto/alkaid/internal/errors"
+)
+
+func TestMain(m *testing.M) {
+	storage.Initialize(memory.NewDB())
+	if err := storage.AutoMigrate(new(User), new(UserOrganizations), new(EmergencyAccess)); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+func TestCreate(t *testing.T) {
+	tcs := []struct {
+		name   string
+		req    *CreateRequest
+		status int
+	}{
+		{"create", &CreateRequest{ID: "alice", Name: "Alice", Email: "alice@alkaid.com", Password: "alice"}, 0},
+		{"duplicate id", &CreateRequest{ID: "alice", Name: "Alice", Email: "alice2@alkaid.com", Password: "alice"}, http.StatusInternalServerError},
+		{"duplicate email", &CreateRequest{ID: "alice2", Name: "Alice", Email: "alice@alkaid.com", Password: "alice"}, http.StatusInternalServerError},
+	}
+
+	for _, tc := range tcs {
+		user, err := Create(context.Background(), tc.req)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+		if tc.status != 0 {
+			continue
+		}
+
+		assert.NotEmpty(t, user.ResourceID, tc.name)
+		assert.NotEmpty(t, user.ProtectedSymmetricKey, tc.name)
+		assert.NotEmpty(t, user.RecoveryCode, tc.name)
+		assert.NotZero(t, user.CreatedAt, tc.name)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	_, err := Create(context.Background(), &CreateRequest{ID: "bob", Name: "Bob", Email: "bob@alkaid.com", Password: "bob"})
+	assert.NoError(t, err)
+	assert.NoError(t, NewUserOrganizations("bob", "org1", RoleOrganization).Create(context.Background()))
+
+	tcs := []struct {
+		name   string
+		req    *LoginRequest
+		orgs   int
+		status int
+	}{
+		{"user id", &LoginRequest{ID: "bob", Password: "bob"}, 1, 0},
+		{"email", &LoginRequest{ID: "bob@alkaid.com", Password: "bob"}, 1, 0},
+		{"wrong password", &LoginRequest{ID: "bob", Password: "alice"}, 0, http.StatusInternalServerError},
+		{"not found", &LoginRequest{ID: "nobody", Password: "bob"}, 0, http.StatusNotFound},
+	}
+
+	for _, tc := range tcs {
+		user, orgs, err := Login(tc.req)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+		if tc.status != 0 {
+			continue
+		}
+
+		assert.Equal(t, "bob", user.UserID, tc.name)
+		assert.Len(t, orgs, tc.orgs, tc.name)
+	}
+}
+
+func TestGetDetailByID(t *testing.T) {
+	user, err := Create(context.Background(), &CreateRequest{ID: "carol", Name: "Carol", Email: "carol@alkaid.com", Password: "carol"})
+	assert.NoError(t, err)
+
+	tcs := []struct {
+		name   string
+		id     string
+		status int
+	}{
+		{"user id", "carol", 0},
+		{"resource id", user.ResourceID, 0},
+		{"not found", "nobody", http.StatusNotFound},
+	}
+
+	for _, tc := range tcs {
+		found, err := GetDetailByID(tc.id)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+		if tc.status == 0 {
+			assert.Equal(t, user.ResourceID, found.ResourceID, tc.name)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	_, err := Create(context.Background(), &CreateRequest{ID: "peggy", Name: "peggy", Email: "peggy@alkaid.com", Password: "peggy"})
+	assert.NoError(t, err)
+
+	// 按顺序执行，后面的用例依赖前面用例的结果
+	tcs := []struct {
+		name    string
+		userCtx *UserContext
+		req     *UpdateRequest
+		status  int
+		version int64
+	}{
+		{"not owner", &UserContext{ID: "alice"}, &UpdateRequest{Name: "Peggy"}, http.StatusForbidden, 1},
+		{"version mismatch", &UserContext{ID: "peggy"}, &UpdateRequest{Name: "Peggy", Version: 2}, http.StatusPreconditionFailed, 1},
+		{"update", &UserContext{ID: "peggy"}, &UpdateRequest{Name: "Peggy", Version: 1}, 0, 2},
+		{"root", &UserContext{ID: "alice", Root: true}, &UpdateRequest{Name: "Peggy Root"}, 0, 3},
+	}
+
+	for _, tc := range tcs {
+		_, err := Update(context.Background(), tc.userCtx, "peggy", tc.req)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+
+		user, err := GetDetailByID("peggy")
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.version, user.Version, tc.name)
+	}
+}
+
+func TestGetList(t *testing.T) {
+	for _, id := range []string{"list1", "list2", "list3"} {
+		_, err := Create(context.Background(), &CreateRequest{ID: id, Name: id, Email: id + "@example.com", Password: id})
+		assert.NoError(t, err)
+	}
+
+	// 按顺序执行，cursor 为上一个用例返回的 NextCursor
+	tcs := []struct {
+		name     string
+		query    string
+		expected []string
+		next     bool
+	}{
+		{"filter", "filter=email~@example.com&sort=-userId", []string{"list3", "list2", "list1"}, false},
+		{"limit", "filter=email~@example.com&sort=userId&limit=2", []string{"list1", "list2"}, true},
+		{"cursor", "filter=email~@example.com&sort=userId&limit=2&cursor=", []string{"list3"}, false},
+		{"empty", "userId=nobody", []string{}, false},
+	}
+
+	cursor := ""
+	for _, tc := range tcs {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/users?"+tc.query+cursor, nil)
+		options, err := storage.NewQueryOptionsWithCtx(ctx, QuerySchema)
+		assert.NoError(t, err, tc.name)
+
+		page, err := GetList(options)
+		assert.NoError(t, err, tc.name)
+
+		ids := make([]string, 0)
+		for _, user := range *page.Items.(*[]*User) {
+			ids = append(ids, user.UserID)
+		}
+		assert.Equal(t, tc.expected, ids, tc.name)
+		assert.Equal(t, tc.next, page.NextCursor != "", tc.name)
+		cursor = page.NextCursor
+	}
+}
+
+func TestDeactivate(t *testing.T) {
+	_, err := Create(context.Background(), &CreateRequest{ID: "dave", Name: "Dave", Email: "dave@alkaid.com", Password: "dave"})
+	assert.NoError(t, err)
+	_, err = Create(context.Background(), &CreateRequest{ID: "admin", Name: "Admin", Email: "admin@alkaid.com", Password: "admin", Root: true})
+	assert.NoError(t, err)
+
+	// 按顺序执行，后面的用例依赖前面用例的结果
+	tcs := []struct {
+		name    string
+		action  func(ctx context.Context, id string, version int64) (*User, error)
+		id      string
+		version int64
+		status  int
+		login   int
+	}{
+		{"version mismatch", Deactivate, "dave", 2, http.StatusPreconditionFailed, 0},
+		{"deactivate", Deactivate, "dave", 1, 0, http.StatusForbidden},
+		{"deactivate again", Deactivate, "dave", 0, http.StatusNotFound, http.StatusForbidden},
+		{"reactivate stale version", Reactivate, "dave", 1, http.StatusPreconditionFailed, http.StatusForbidden},
+		{"reactivate", Reactivate, "dave", 2, 0, 0},
+		{"reactivate active user", Reactivate, "dave", 0, 0, 0},
+		{"deactivate root", Deactivate, "admin", 0, http.StatusForbidden, 0},
+		{"reactivate not found", Reactivate, "nobody", 0, http.StatusNotFound, http.StatusNotFound},
+	}
+
+	for _, tc := range tcs {
+		user, err := tc.action(context.Background(), tc.id, tc.version)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+		if tc.status == 0 {
+			_, err = GetDetailByID(tc.id)
+			assert.Equal(t, user.Deactivate, errors.StatusCode(err) == http.StatusNotFound, tc.name)
+			assert.Equal(t, user.Deactivate, user.DeactivateAt != 0, tc.name)
+		}
+
+		_, _, err = Login(&LoginRequest{ID: tc.id, Password: tc.id})
+		assert.Equal(t, tc.login, errors.StatusCode(err), tc.name)
+	}
+
+	// 默认不包含已停用的用户
+	_, err = Deactivate(context.Background(), "dave", 0)
+	assert.NoError(t, err)
+	for _, options := range []*storage.QueryOptions{
+		storage.NewQueryOptions().Where("user_id = ?", "dave"),
+		storage.NewQueryOptions().Where("user_id = ?", "dave").Unscoped(),
+	} {
+		page, err := GetList(options)
+		assert.NoError(t, err)
+		assert.Equal(t, options.IsUnscoped(), page.Total == 1)
+	}
+}
+
+func TestNewUserContext(t *testing.T) {
+	orgs := []*UserOrganizations{
+		{OrganizationID: "org1", Role: RoleOrganization},
+		{OrganizationID: "org2", Role: RoleUser, Deactivate: true},
+	}
+
+	userCtx := NewUserContext(&User{UserID: "erin"}, orgs)
+	assert.Equal(t, RoleOrganization.String(), userCtx.Role("org1"))
+	assert.Equal(t, RoleNone.String(), userCtx.Role("org2"))
+}
+
+func TestUpgradeEncryption(t *testing.T) {
+	user, err := Create(context.Background(), &CreateRequest{ID: "frank", Name: "Frank", Email: "frank@alkaid.com", Password: "frank"})
+	assert.NoError(t, err)
+	signPrivateKey, err := user.SignPrivateKey("frank")
+	assert.NoError(t, err)
+
+	// 新用户直接使用 AES-256-GCM 格式，不需要升级
+	values, err := user.upgradeEncryption("frank")
+	assert.NoError(t, err)
+	assert.Nil(t, values)
+
+	// 将受保护字段转换为旧的 AES-256-CBC + HMAC-SHA256 格式
+	legacyEncrypt := func(key *utils.StretchedKey, ciphertext, field string) string {
+		text, err := utils.DecryptWithStretchedKey(key, ciphertext, user.associatedData(field))
+		assert.NoError(t, err)
+		aesKey, _ := factory.CryptoKeyImport(key.Enc, crypto.AesCbc256)
+		hmacKey, _ := factory.CryptoKeyImport(key.Mac, crypto.HmacSha256)
+		legacy, err := utils.Encrypt(utils.AesCbc256HmacSha256B64, text, aesKey, hmacKey)
+		assert.NoError(t, err)
+		return legacy
+	}
+	stretchedKey, _ := user.StretchedKey("frank")
+	symmetricKey, _ := user.SymmetricKey("frank")
+	legacy := &User{
+		ProtectedSymmetricKey:   legacyEncrypt(stretchedKey, user.ProtectedSymmetricKey, fieldProtectedSymmetricKey),
+		ProtectedSignPrivateKey: legacyEncrypt(symmetricKey, user.ProtectedSignPrivateKey, fieldProtectedSignPrivateKey),
+		ProtectedTLSPrivateKey:  legacyEncrypt(symmetricKey, user.ProtectedTLSPrivateKey, fieldProtectedTLSPrivateKey),
+		ProtectedRSAPrivateKey:  legacyEncrypt(symmetricKey, user.ProtectedRSAPrivateKey, fieldProtectedRSAPrivateKey),
+	}
+	assert.NoError(t, storage.Update(legacy, storage.NewUpdateOptions("resource_id = ?", user.ResourceID)))
+
+	user, err = GetDetailByID("frank")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(user.ProtectedSignPrivateKey, "1."))
+
+	// 旧格式只能在升级时解密，正常读取时被拒绝
+	_, err = user.SignPrivateKey("frank")
+	assert.Error(t, err)
+
+	// 未登录的用户由 alkaid reencrypt 列出
+	assert.True(t, user.LegacyEncryption())
+	pending, err := FindLegacyEncryptionUsers(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"frank"}, pending)
+
+	tcs := []struct {
+		name     string
+		password string
+		prefix   string
+		success  bool
+	}{
+		{"wrong password", "alice", "1.", false},
+		{"upgrade", "frank", "5.", true},
+		{"already upgraded", "frank", "5.", true},
+	}
+
+	for _, tc := range tcs {
+		found, err := GetDetailByID("frank")
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.success, UpgradeEncryption(context.Background(), found, tc.password) == nil, tc.name)
+
+		found, err = GetDetailByID("frank")
+		assert.NoError(t, err, tc.name)
+		for _, ciphertext := range []string{found.ProtectedSymmetricKey, found.ProtectedSignPrivateKey,
+			found.ProtectedTLSPrivateKey, found.ProtectedRSAPrivateKey} {
+			assert.True(t, strings.HasPrefix(ciphertext, tc.prefix), tc.name)
+		}
+
+		key, err := found.SignPrivateKey("frank")
+		assert.Equal(t, tc.success, err == nil, tc.name)
+		if tc.success {
+			assert.Equal(t, signPrivateKey, key, tc.name)
+		}
+	}
+
+	pending, err = FindLegacyEncryptionUsers(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// 已升级的记录被替换为旧格式的密文后，正常读取以及升级都会拒绝
+	upgraded, err := GetDetailByID("frank")
+	assert.NoError(t, err)
+	for name, downgraded := range map[string]string{
+		"downgraded": legacy.ProtectedSignPrivateKey,
+		"swapped":    legacy.ProtectedTLSPrivateKey,
+	} {
+		user := *upgraded
+		user.ProtectedSignPrivateKey = downgraded
+		_, err = user.SignPrivateKey("frank")
+		assert.Error(t, err, name)
+		_, err = user.upgradeEncryption("frank")
+		assert.Error(t, err, name)
+	}
+
+	// 没有 MAC 的 AES-256-CBC 格式始终被拒绝
+	aesKey, _ := factory.CryptoKeyImport(symmetricKey.Enc, crypto.AesCbc256)
+	unauthenticated, err := utils.Encrypt(utils.AesCbc256B64, signPrivateKey, aesKey)
+	assert.NoError(t, err)
+	user = &User{UserID: upgraded.UserID, Email: upgraded.Email, ProtectedSymmetricKey: upgraded.ProtectedSymmetricKey,
+		ProtectedSignPrivateKey: unauthenticated}
+	_, err = user.SignPrivateKey("frank")
+	assert.Error(t, err)
+
+	// 密文与字段绑定，交换字段后无法解密
+	user, err = GetDetailByID("frank")
+	assert.NoError(t, err)
+	user.ProtectedSignPrivateKey = user.ProtectedTLSPrivateKey
+	_, err = user.SignPrivateKey("frank")
+	assert.Error(t, err)
+
+	// 密文与用户绑定，复制到其他用户的记录后无法解密
+	user, err = GetDetailByID("frank")
+	assert.NoError(t, err)
+	user.UserID = "mallory"
+	_, err = user.SignPrivateKey("frank")
+	assert.Error(t, err)
+}
+
+func TestChangePassword(t *testing.T) {
+	user, err := Create(context.Background(), &CreateRequest{ID: "ivan", Name: "Ivan", Email: "ivan@alkaid.com", Password: "ivan"})
+	assert.NoError(t, err)
+	signPrivateKey, err := user.SignPrivateKey("ivan")
+	assert.NoError(t, err)
+
+	tcs := []struct {
+		name    string
+		userCtx *UserContext
+		req     *ChangePasswordRequest
+		status  int
+	}{
+		{"not owner", &UserContext{ID: "alice", Root: true}, &ChangePasswordRequest{Password: "ivan", NewPassword: "new"}, http.StatusForbidden},
+		{"wrong password", &UserContext{ID: "ivan"}, &ChangePasswordRequest{Password: "wrong", NewPassword: "new"}, http.StatusForbidden},
+		{"empty password", &UserContext{ID: "ivan"}, &ChangePasswordRequest{Password: "ivan"}, http.StatusBadRequest},
+		{"version mismatch", &UserContext{ID: "ivan"}, &ChangePasswordRequest{Password: "ivan", NewPassword: "new", Version: 2}, http.StatusPreconditionFailed},
+		{"change", &UserContext{ID: "ivan"}, &ChangePasswordRequest{Password: "ivan", NewPassword: "new", Version: 1}, 0},
+	}
+
+	for _, tc := range tcs {
+		_, err := ChangePassword(context.Background(), tc.userCtx, "ivan", tc.req)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+	}
+
+	updated, err := FindUserByID("ivan")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), updated.Version)
+	assert.False(t, updated.ValidatePassword("ivan"))
+	assert.True(t, updated.ValidatePassword("new"))
+
+	// 私钥密文保持不变，使用新密码可以解密
+	assert.NotEqual(t, user.ProtectedSymmetricKey, updated.ProtectedSymmetricKey)
+	assert.Equal(t, user.ProtectedSignPrivateKey, updated.ProtectedSignPrivateKey)
+	assert.Equal(t, user.ProtectedTLSPrivateKey, updated.ProtectedTLSPrivateKey)
+	assert.Equal(t, user.ProtectedRSAPrivateKey, updated.ProtectedRSAPrivateKey)
+	privateKey, err := updated.SignPrivateKey("new")
+	assert.NoError(t, err)
+	assert.Equal(t, signPrivateKey, privateKey)
+	_, err = updated.SignPrivateKey("ivan")
+	assert.Error(t, err)
+
+	_, _, err = Login(&LoginRequest{ID: "ivan", Password: "new"})
+	assert.NoError(t, err)
+}
+
+func TestChangeEmail(t *testing.T) {
+	user, err := Create(context.Background(), &CreateRequest{ID: "grace", Name: "Grace", Email: "grace@alkaid.com", Password: "grace"})
+	assert.NoError(t, err)
+	_, err = Create(context.Background(), &CreateRequest{ID: "heidi", Name: "Heidi", Email: "heidi@alkaid.com", Password: "heidi"})
+	assert.NoError(t, err)
+	tlsPrivateKey, err := user.TLSPrivateKey("grace")
+	assert.NoError(t, err)
+
+	tcs := []struct {
+		name   string
+		req    *ChangeEmailRequest
+		status int
+	}{
+		{"invalid email", &ChangeEmailRequest{Password: "grace", Email: "grace"}, http.StatusBadRequest},
+		{"same email", &ChangeEmailRequest{Password: "grace", Email: "grace@alkaid.com"}, http.StatusBadRequest},
+		{"duplicate email", &ChangeEmailRequest{Password: "grace", Email: "heidi@alkaid.com"}, http.StatusConflict},
+		{"wrong password", &ChangeEmailRequest{Password: "wrong", Email: "grace2@alkaid.com"}, http.StatusForbidden},
+		{"change", &ChangeEmailRequest{Password: "grace", Email: "grace2@alkaid.com"}, 0},
+	}
+
+	for _, tc := range tcs {
+		_, err := ChangeEmail(context.Background(), &UserContext{ID: "grace"}, "grace", tc.req)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+	}
+
+	_, err = FindUserByID("grace@alkaid.com")
+	assert.Equal(t, storage.ErrNotFound, err)
+	updated, err := FindUserByID("grace2@alkaid.com")
+	assert.NoError(t, err)
+	assert.True(t, updated.ValidatePassword("grace"))
+
+	// 邮箱是扩展密钥的盐，修改后对称密钥重新加密，私钥密文保持不变
+	assert.NotEqual(t, user.ProtectedSymmetricKey, updated.ProtectedSymmetricKey)
+	assert.Equal(t, user.ProtectedTLSPrivateKey, updated.ProtectedTLSPrivateKey)
+	privateKey, err := updated.TLSPrivateKey("grace")
+	assert.NoError(t, err)
+	assert.Equal(t, tlsPrivateKey, privateKey)
+}
+
+func TestRecover(t *testing.T) {
+	user, err := Create(context.Background(), &CreateRequest{ID: "judy", Name: "Judy", Email: "judy@alkaid.com", Password: "judy"})
+	assert.NoError(t, err)
+	signPrivateKey, err := user.SignPrivateKey("judy")
+	assert.NoError(t, err)
+
+	// 恢复码只在创建时返回，不会被保存
+	stored, err := GetDetailByID("judy")
+	assert.NoError(t, err)
+	assert.Empty(t, stored.RecoveryCode)
+
+	tcs := []struct {
+		name   string
+		req    *RecoverRequest
+		status int
+	}{
+		{"wrong recovery code", &RecoverRequest{RecoveryCode: "AAAA-BBBB", NewPassword: "new"}, http.StatusForbidden},
+		{"empty password", &RecoverRequest{RecoveryCode: user.RecoveryCode}, http.StatusBadRequest},
+		{"recover", &RecoverRequest{RecoveryCode: strings.ToLower(user.RecoveryCode), NewPassword: "new"}, 0},
+		{"used recovery code", &RecoverRequest{RecoveryCode: user.RecoveryCode, NewPassword: "newer"}, http.StatusForbidden},
+	}
+
+	var recovered *User
+	for _, tc := range tcs {
+		result, err := Recover(context.Background(), "judy", tc.req)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+		if tc.status == 0 {
+			recovered = result
+		}
+	}
+
+	assert.NotEmpty(t, recovered.RecoveryCode)
+	assert.NotEqual(t, user.RecoveryCode, recovered.RecoveryCode)
+	assert.True(t, recovered.ValidatePassword("new"))
+	privateKey, err := recovered.SignPrivateKey("new")
+	assert.NoError(t, err)
+	assert.Equal(t, signPrivateKey, privateKey)
+
+	// 重新生成恢复码后旧的恢复码失效
+	_, err = RegenerateRecoveryCode(context.Background(), &UserContext{ID: "alice"}, "judy", &RegenerateRecoveryCodeRequest{Password: "new"})
+	assert.Equal(t, http.StatusForbidden, errors.StatusCode(err))
+	_, err = RegenerateRecoveryCode(context.Background(), &UserContext{ID: "judy"}, "judy", &RegenerateRecoveryCodeRequest{Password: "judy"})
+	assert.Equal(t, http.StatusForbidden, errors.StatusCode(err))
+	regenerated, err := RegenerateRecoveryCode(context.Background(), &UserContext{ID: "judy"}, "judy", &RegenerateRecoveryCodeRequest{Password: "new"})
+	assert.NoError(t, err)
+	_, err = Recover(context.Background(), "judy", &RecoverRequest{RecoveryCode: recovered.RecoveryCode, NewPassword: "newer"})
+	assert.Equal(t, http.StatusForbidden, errors.StatusCode(err))
+	_, err = Recover(context.Background(), "judy", &RecoverRequest{RecoveryCode: regenerated.RecoveryCode, NewPassword: "newer"})
+	assert.NoError(t, err)
+}
+
+func TestEmergencyAccess(t *testing.T) {
+	ctx := context.Background()
+	for _, id := range []string{"kevin", "laura", "mike"} {
+		_, err := Create(ctx, &CreateRequest{ID: id, Name: id, Email: id + "@alkaid.com", Password: id})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, NewUserOrganizations("kevin", "org-emergency", RoleUser).Create(ctx))
+	assert.NoError(t, NewUserOrganizations("laura", "org-emergency", RoleOrganization).Create(ctx))
+	assert.NoError(t, NewUserOrganizations("mike", "org-emergency", RoleUser).Create(ctx))
+
+	kevin := &UserContext{ID: "kevin"}
+	laura := &UserContext{ID: "laura"}
+
+	grants := []struct {
+		name    string
+		userCtx *UserContext
+		req     *GrantEmergencyAccessRequest
+		status  int
+	}{
+		{"not owner", laura, &GrantEmergencyAccessRequest{Password: "kevin", GranteeID: "laura"}, http.StatusForbidden},
+		{"wrong password", kevin, &GrantEmergencyAccessRequest{Password: "wrong", GranteeID: "laura"}, http.StatusForbidden},
+		{"negative wait time", kevin, &GrantEmergencyAccessRequest{Password: "kevin", GranteeID: "laura", WaitTime: -1}, http.StatusBadRequest},
+		{"self", kevin, &GrantEmergencyAccessRequest{Password: "kevin", GranteeID: "kevin"}, http.StatusBadRequest},
+		{"not administrator", kevin, &GrantEmergencyAccessRequest{Password: "kevin", GranteeID: "mike"}, http.StatusForbidden},
+		{"grantee not found", kevin, &GrantEmergencyAccessRequest{Password: "kevin", GranteeID: "nobody"}, http.StatusNotFound},
+		{"grant", kevin, &GrantEmergencyAccessRequest{Password: "kevin", GranteeID: "laura", WaitTime: 3600}, 0},
+		{"duplicate", kevin, &GrantEmergencyAccessRequest{Password: "kevin", GranteeID: "laura"}, http.StatusConflict},
+	}
+	for _, tc := range grants {
+		_, err := GrantEmergencyAccess(ctx, tc.userCtx, "kevin", tc.req)
+		assert.Equal(t, tc.status, errors.StatusCode(err), tc.name)
+	}
+
+	access, err := FindEmergencyAccess("kevin", "laura")
+	assert.NoError(t, err)
+	assert.Equal(t, EmergencyAccessStatusGranted, access.Status)
+
+	// 加密的对称密钥与紧急访问绑定，移动到其他用户的紧急访问后无法解密
+	grantee, err := FindUserByID("laura")
+	assert.NoError(t, err)
+	rsaPrivateKey, err := grantee.RSAPrivateKey("laura")
+	assert.NoError(t, err)
+	_, err = access.unwrapSymmetricKey(rsaPrivateKey)
+	assert.NoError(t, err)
+	moved := &EmergencyAccess{UserID: "mike", GranteeID: "laura", ProtectedSymmetricKey: access.ProtectedSymmetricKey}
+	_, err = moved.unwrapSymmetricKey(rsaPrivateKey)
+	assert.Error(t, err)
+
+	page, err := GetEmergencyAccessList(laura, "laura", storage.NewQueryOptions())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), page.Total)
+	_, err = GetEmergencyAccessList(laura, "kevin", storage.NewQueryOptions())
+	assert.Equal(t, http.StatusForbidden, errors.StatusCode(err))
+
+	now := TimeNowFunc()
+	defer func(f func() int64) { TimeNowFunc = f }(TimeNowFunc)
+	TimeNowFunc = func() int64 { return now }
+
+	recoverReq := &EmergencyRecoverRequest{Password: "laura", NewPassword: "new"}
+
+	// 未发起恢复时不能恢复，只有联系人可以发起恢复，用户可以拒绝
+	_, err = EmergencyRecover(ctx, laura, "kevin", access.ResourceID, recoverReq)
+	assert.Equal(t, http.StatusPreconditionFailed, errors.StatusCode(err))
+	_, err = InitiateEmergencyRecovery(ctx, kevin, "kevin", access.ResourceID)
+	assert.Equal(t, http.StatusForbidden, errors.StatusCode(err))
+	_, err = InitiateEmergencyRecovery(ctx, &UserContext{ID: "mike"}, "kevin", access.ResourceID)
+	assert.Equal(t, http.StatusNotFound, errors.StatusCode(err))
+	access, err = InitiateEmergencyRecovery(ctx, laura, "kevin", access.ResourceID)
+	assert.NoError(t, err)
+	assert.Equal(t, now+3600, access.RecoveryAvailableAt())
+	_, err = InitiateEmergencyRecovery(ctx, laura, "kevin", access.ResourceID)
+	assert.Equal(t, http.StatusConflict, errors.StatusCode(err))
+	access, err = RejectEmergencyRecovery(ctx, kevin, "kevin", access.ResourceID)
+	assert.NoError(t, err)
+	assert.Equal(t, EmergencyAccessStatusGranted, access.Status)
+
+	// 等待时间结束之前不能恢复
+	_, err = InitiateEmergencyRecovery(ctx, laura, "kevin", access.ResourceID)
+	assert.NoError(t, err)
+	_, err = EmergencyRecover(ctx, laura, "kevin", access.ResourceID, recoverReq)
+	assert.Equal(t, http.StatusPreconditionFailed, errors.StatusCode(err))
+
+	TimeNowFunc = func() int64 { return now + 3600 }
+	_, err = EmergencyRecover(ctx, laura, "kevin", access.ResourceID, &EmergencyRecoverRequest{Password: "wrong", NewPassword: "new"})
+	assert.Equal(t, http.StatusForbidden, errors.StatusCode(err))
+	user, err := EmergencyRecover(ctx, laura, "kevin", access.ResourceID, recoverReq)
+	assert.NoError(t, err)
+	assert.True(t, user.ValidatePassword("new"))
+	_, err = user.SignPrivateKey("new")
+	assert.NoError(t, err)
+
+	// 恢复后紧急访问回到已授予状态，用户可以撤销
+	access, err = FindEmergencyAccess("kevin", "laura")
+	assert.NoError(t, err)
+	assert.Equal(t, EmergencyAccessStatusGranted, access.Status)
+	_, err = RevokeEmergencyAccess(ctx, laura, "kevin", access.ResourceID, 0)
+	assert.Equal(t, http.StatusForbidden, errors.StatusCode(err))
+	_, err = RevokeEmergencyAccess(ctx, kevin, "kevin", access.ResourceID, 0)
+	assert.NoError(t, err)
+	_, err = FindEmergencyAccess("kevin", "laura")
+	assert.Equal(t, storage.ErrNotFound, err)
+}
+
+// 紧急联系人不再是用户所在组织的管理员后不能发起恢复，也不能完成已经发起的恢复
+func TestEmergencyAccessRevokedContact(t *testing.T) {
+	ctx := context.Background()
+	for _, id := range []string{"paul", "quinn"} {
+		_, err := Create(ctx, &CreateRequest{ID: id, Name: id, Email: id + "@alkaid.com", Password: id})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, NewUserOrganizations("paul", "org-revoked", RoleUser).Create(ctx))
+	member := NewUserOrganizations("quinn", "org-revoked", RoleOrganization)
+	assert.NoError(t, member.Create(ctx))
+
+	paul := &UserContext{ID: "paul"}
+	quinn := &UserContext{ID: "quinn"}
+	access, err := GrantEmergencyAccess(ctx, paul, "paul", &GrantEmergencyAccessRequest{Password: "paul", GranteeID: "quinn"})
+	assert.NoError(t, err)
+
+	setRole := func(role Role) {
+		assert.NoError(t, storage.Update(&UserOrganizations{Role: role},
+			storage.NewUpdateOptions("resource_id = ?", member.ResourceID)))
+	}
+
+	setRole(RoleUser)
+	_, err = InitiateEmergencyRecovery(ctx, quinn, "paul", access.ResourceID)
+	assert.Equal(t, http.StatusForbidden, errors.StatusCode(err))
+
+	setRole(RoleOrganization)
+	_, err = InitiateEmergencyRecovery(ctx, quinn, "paul", access.ResourceID)
+	assert.NoError(t, err)
+
+	// 等待时间结束之后才检查到联系人已经不是管理员
+	now := TimeNowFunc()
+	defer func(f func() int64) { TimeNowFunc = f }(TimeNowFunc)
+	TimeNowFunc = func() int64 { return now + access.WaitTime }
+
+	setRole(RoleUser)
+	_, err = EmergencyRecover(ctx, quinn, "paul", access.ResourceID, &EmergencyRecoverRequest{Password: "quinn", NewPassword: "new"})
+	assert.Equal(t, http.StatusForbidden, errors.StatusCode(err))
+	user, err := FindUserByID("paul")
+	assert.NoError(t, err)
+	assert.True(t, user.ValidatePassword("paul"))
+}
+
+func TestPreLogin(t *testing.T) {
+	_, err := Create(context.Background(), &CreateRequest{ID: "nina", Name: "Nina", Email: "nina@alkaid.com", Password: "nina"})
+	assert.NoError(t, err)
+
+	params, err := PreLogin(&PreLoginRequest{ID: "nina@alkaid.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, utils.DefaultKDFParams, *params)
+
+	// 用户不存在时返回当前的 KDF 策略
+	defer func(policy utils.KDFParams) { assert.NoError(t, SetKDFPolicy(policy)) }(KDFPolicy())
+	argon2id := utils.KDFParams{Type: utils.KDFArgon2id, Iterations: 2, Memory: 16, Threads: 1}
+	assert.NoError(t, SetKDFPolicy(argon2id))
+	params, err = PreLogin(&PreLoginRequest{ID: "nobody"})
+	assert.NoError(t, err)
+	assert.Equal(t, argon2id, *params)
+
+	assert.Error(t, SetKDFPolicy(utils.KDFParams{Type: utils.KDFPBKDF2Sha256, Iterations: 1}))
+	assert.Equal(t, argon2id, KDFPolicy())
+}
+
+func TestUpgradeKDF(t *testing.T) {
+	user, err := Create(context.Background(), &CreateRequest{ID: "oscar", Name: "Oscar", Email: "oscar@alkaid.com", Password: "oscar"})
+	assert.NoError(t, err)
+	rsaPrivateKey, err := user.RSAPrivateKey("oscar")
+	assert.NoError(t, err)
+	assert.Equal(t, utils.KDFPBKDF2Sha256, user.KDF)
+
+	// 参数满足策略时不需要升级
+	assert.NoError(t, UpgradeKDF(context.Background(), "oscar", "oscar"))
+	unchanged, err := FindUserByID("oscar")
+	assert.NoError(t, err)
+	assert.Equal(t, user.ProtectedSymmetricKey, unchanged.ProtectedSymmetricKey)
+
+	defer func(policy utils.KDFParams) { assert.NoError(t, SetKDFPolicy(policy)) }(KDFPolicy())
+	argon2id := utils.KDFParams{Type: utils.KDFArgon2id, Iterations: 2, Memory: 16, Threads: 1}
+	assert.NoError(t, SetKDFPolicy(argon2id))
+	assert.Error(t, UpgradeKDF(context.Background(), "oscar", "wrong"))
+	assert.NoError(t, UpgradeKDF(context.Background(), "oscar", "oscar"))
+
+	upgraded, err := FindUserByID("oscar")
+	assert.NoError(t, err)
+	assert.Equal(t, argon2id, upgraded.KDFParams())
+	assert.NotEqual(t, user.Password, upgraded.Password)
+	assert.NotEqual(t, user.ProtectedSymmetricKey, upgraded.ProtectedSymmetricKey)
+	assert.Equal(t, user.ProtectedRSAPrivateKey, upgraded.ProtectedRSAPrivateKey)
+	assert.True(t, upgraded.ValidatePassword("oscar"))
+	privateKey, err := upgraded.RSAPrivateKey("oscar")
+	assert.NoError(t, err)
+	assert.Equal(t, rsaPrivateKey, privateKey)
+
+	params, err := PreLogin(&PreLoginRequest{ID: "oscar"})
+	assert.NoError(t, err)
+	assert.Equal(t, argon2id, *params)
+
+	// 提高 Argon2id 的内存要求后再次升级
+	stronger := utils.KDFParams{Type: utils.KDFArgon2id, Iterations: 2, Memory: 32, Threads: 1}
+	assert.NoError(t, SetKDFPolicy(stronger))
+	assert.NoError(t, UpgradeKDF(context.Background(), "oscar", "oscar"))
+	upgraded, err = FindUserByID("oscar")
+	assert.NoError(t, err)
+	assert.Equal(t, stronger, upgraded.KDFParams())
+	assert.True(t, upgraded.ValidatePassword("oscar"))
+
+	// 从 Argon2id 切换为 PBKDF2 时内存以及并行度更新为零值
+	pbkdf2 := utils.KDFParams{Type: utils.KDFPBKDF2Sha256, Iterations: 200000}
+	assert.NoError(t, SetKDFPolicy(pbkdf2))
+	assert.NoError(t, UpgradeKDF(context.Background(), "oscar", "oscar"))
+	upgraded, err = FindUserByID("oscar")
+	assert.NoError(t, err)
+	assert.Equal(t, pbkdf2, upgraded.KDFParams())
+	assert.True(t, upgraded.ValidatePassword("oscar"))
+	params, err = PreLogin(&PreLoginRequest{ID: "oscar"})
+	assert.NoError(t, err)
+	assert.Equal(t, pbkdf2, *params)
+
+	// 登录时在返回之前完成升级
+	assert.NoError(t, SetKDFPolicy(argon2id))
+	_, _, err = Login(&LoginRequest{ID: "oscar", Password: "oscar"})
+	assert.NoError(t, err)
+	upgraded, err = FindUserByID("oscar")
+	assert.NoError(t, err)
+	assert.Equal(t, argon2id, upgraded.KDFParams())
+	assert.True(t, upgraded.ValidatePassword("oscar"))
+}
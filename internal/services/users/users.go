@@ -9,15 +9,24 @@
 package users
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
 	"errors"
+	"fmt"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/yakumioto/alkaid/internal/common/crypto/utils"
 	"github.com/yakumioto/alkaid/internal/common/storage"
 )
 
-const ResourceNamespace = "User"
+const (
+	ResourceNamespace                  = "User"
+	UserOrganizationsResourceNamespace = "UserOrganizations"
+)
 
 const (
 	RoleRoot Role = iota
@@ -81,6 +90,10 @@ type User struct {
 	Name                    string `json:"name,omitempty"`
 	Email                   string `json:"email,omitempty" gorm:"uniqueIndex"`
 	Password                string `json:"-"`
+	KDF                     string `json:"kdf,omitempty" gorm:"default:pbkdf2-sha256"`
+	KDFIterations           int    `json:"kdfIterations,omitempty" gorm:"default:100000"`
+	KDFMemory               int    `json:"kdfMemory,omitempty"`
+	KDFThreads              int    `json:"kdfThreads,omitempty"`
 	Root                    bool   `json:"root,omitempty"`
 	ProtectedSymmetricKey   string `json:"protectedSymmetricKey,omitempty"`
 	ProtectedSignPrivateKey string `json:"protectedSignPrivateKey,omitempty"`
@@ -89,40 +102,414 @@ type User struct {
 	TLSPublicKey            string `json:"tlsPublicKey,omitempty"`
 	ProtectedRSAPrivateKey  string `json:"protectedRSAPrivateKey,omitempty"`
 	RSAPublicKey            string `json:"rsaPublicKey,omitempty"`
+	ProtectedRecoveryKey    string `json:"-"`
+	RecoveryCode            string `json:"recoveryCode,omitempty" gorm:"-"` // 仅在生成时返回一次
 	Deactivate              bool   `json:"deactivate,omitempty"`
+	Version                 int64  `json:"version,omitempty" gorm:"default:1"`
 	CreatedAt               int64  `json:"createdAt,omitempty" gorm:"autoCreateTime"`
 	UpdatedAt               int64  `json:"updatedAt,omitempty" gorm:"autoUpdateTime"`
 	DeactivateAt            int64  `json:"deactivateAt,omitempty"`
 }
 
+// kdfPolicy 新用户使用的 KDF 参数，已有用户的参数不满足时在下次登录时升级
+var (
+	kdfPolicyLock sync.RWMutex
+	kdfPolicy     = utils.DefaultKDFParams
+)
+
+// SetKDFPolicy 设置用户密码的 KDF 策略，PBKDF2 忽略 Argon2id 的内存以及并行度
+func SetKDFPolicy(params utils.KDFParams) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	if params.Type == utils.KDFPBKDF2Sha256 {
+		params.Memory, params.Threads = 0, 0
+	}
+	kdfPolicyLock.Lock()
+	kdfPolicy = params
+	kdfPolicyLock.Unlock()
+
+	return nil
+}
+
+// KDFPolicy 当前的 KDF 策略
+func KDFPolicy() utils.KDFParams {
+	kdfPolicyLock.RLock()
+	defer kdfPolicyLock.RUnlock()
+
+	return kdfPolicy
+}
+
 func newUserByCreateRequest(req *CreateRequest) *User {
-	return &User{
+	u := &User{
 		UserID:   req.ID,
 		Email:    req.Email,
 		Name:     req.Name,
 		Root:     req.Root,
 		Password: req.Password,
 	}
+	u.setKDFParams(KDFPolicy())
+
+	return u
+}
+
+// KDFParams 用户密码的 KDF 参数，没有保存参数的用户使用默认参数
+func (u *User) KDFParams() utils.KDFParams {
+	if u.KDF == "" {
+		return utils.DefaultKDFParams
+	}
+
+	return utils.KDFParams{
+		Type:       u.KDF,
+		Iterations: u.KDFIterations,
+		Memory:     u.KDFMemory,
+		Threads:    u.KDFThreads,
+	}
+}
+
+func (u *User) setKDFParams(params utils.KDFParams) {
+	u.KDF = params.Type
+	u.KDFIterations = params.Iterations
+	u.KDFMemory = params.Memory
+	u.KDFThreads = params.Threads
+}
+
+// masterKey 使用用户的 KDF 参数从密码生成主密钥，邮箱作为盐
+func (u *User) masterKey(password string) ([]byte, error) {
+	return u.KDFParams().MasterKey(password, u.Email)
 }
 
 func (u *User) StretchedKey(password string) (*utils.StretchedKey, error) {
-	return utils.GetStretchedKey(utils.GetMasterKey(password, u.Email))
+	masterKey, err := u.masterKey(password)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.GetStretchedKey(masterKey)
 }
 
-func (u *User) Create() error {
+func (u *User) Create(ctx context.Context) error {
+	masterKey, err := u.masterKey(u.Password)
+	if err != nil {
+		return err
+	}
 	u.ResourceID = utils.GenResourceID(ResourceNamespace)
-	u.Password = utils.HashPassword(
-		string(utils.GetMasterKey(u.Password, u.Email)), u.Password, 1)
-	return storage.Create(u)
+	u.Password = hashPassword(masterKey, u.Password)
+	u.Version = 1
+	return storage.FromContext(ctx).Create(u)
 }
 
+// hashPassword 对主密钥再进行一次哈希，主密钥由 KDF 生成，修改密码、邮箱或者 KDF 参数时需要同时更新
+func hashPassword(masterKey []byte, password string) string {
+	return utils.HashPassword(string(masterKey), password, 1)
+}
+
+// 受保护字段加密时使用的附加数据字段名，密文与用户 ID 以及字段名绑定，无法在记录或字段之间互换
+const (
+	fieldProtectedSymmetricKey   = "protectedSymmetricKey"
+	fieldProtectedSignPrivateKey = "protectedSignPrivateKey"
+	fieldProtectedTLSPrivateKey  = "protectedTlsPrivateKey"
+	fieldProtectedRSAPrivateKey  = "protectedRSAPrivateKey"
+	fieldProtectedRecoveryKey    = "protectedRecoveryKey"
+)
+
+func (u *User) associatedData(field string) []byte {
+	return []byte(u.UserID + "/" + field)
+}
+
+// SymmetricKey 使用密码生成的扩展密钥解密用户的对称密钥
+func (u *User) SymmetricKey(password string) (*utils.StretchedKey, error) {
+	stretchedKey, err := u.StretchedKey(password)
+	if err != nil {
+		return nil, err
+	}
+
+	symmetricKey, err := utils.DecryptWithStretchedKey(stretchedKey, u.ProtectedSymmetricKey,
+		u.associatedData(fieldProtectedSymmetricKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return newSymmetricKey(symmetricKey)
+}
+
+// SignPrivateKey 解密用户的签名私钥，返回 pem 格式
+func (u *User) SignPrivateKey(password string) ([]byte, error) {
+	return u.decryptPrivateKey(password, u.ProtectedSignPrivateKey, fieldProtectedSignPrivateKey)
+}
+
+// TLSPrivateKey 解密用户的通讯私钥，返回 pem 格式
+func (u *User) TLSPrivateKey(password string) ([]byte, error) {
+	return u.decryptPrivateKey(password, u.ProtectedTLSPrivateKey, fieldProtectedTLSPrivateKey)
+}
+
+func (u *User) decryptPrivateKey(password, protectedPrivateKey, field string) ([]byte, error) {
+	symmetricKey, err := u.SymmetricKey(password)
+	if err != nil {
+		return nil, err
+	}
+
+	return utils.DecryptWithStretchedKey(symmetricKey, protectedPrivateKey, u.associatedData(field))
+}
+
+// rewrapSymmetricKey 使用原密码解密对称密钥，并使用新密码以及新邮箱生成的扩展密钥重新加密，
+// 返回只包含需要更新字段的 User。私钥由对称密钥加密，附加数据只与用户 ID 绑定，因此私钥密文不需要修改
+func (u *User) rewrapSymmetricKey(password, newPassword, newEmail string) (*User, error) {
+	symmetricKey, err := u.SymmetricKey(password)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.wrapSymmetricKey(symmetricKey, newPassword, newEmail)
+}
+
+// symmetricKeyColumns wrapSymmetricKey 返回的 User 中需要更新的列。
+// 例如 KDF 从 Argon2id 切换为 PBKDF2 后内存以及并行度为零值，需要通过 Select 更新
+var symmetricKeyColumns = []string{"email", "password", "kdf", "kdf_iterations", "kdf_memory", "kdf_threads",
+	"protected_symmetric_key"}
+
+// wrapSymmetricKey 使用新密码以及新邮箱生成的扩展密钥加密对称密钥，同时生成新的密码哈希，
+// 扩展密钥使用当前 KDF 策略的参数生成，更新时使用 symmetricKeyColumns 指定更新的列
+func (u *User) wrapSymmetricKey(symmetricKey *utils.StretchedKey, newPassword, newEmail string) (*User, error) {
+	updated := &User{UserID: u.UserID, Email: newEmail}
+	updated.setKDFParams(KDFPolicy())
+	masterKey, err := updated.masterKey(newPassword)
+	if err != nil {
+		return nil, err
+	}
+	stretchedKey, err := utils.GetStretchedKey(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	updated.ProtectedSymmetricKey, err = utils.EncryptWithStretchedKey(stretchedKey, symmetricKey.Key(),
+		updated.associatedData(fieldProtectedSymmetricKey))
+	if err != nil {
+		return nil, err
+	}
+	updated.Password = hashPassword(masterKey, newPassword)
+
+	return updated, nil
+}
+
+// recoveryCodeEncoding 恢复码使用不含填充的 base32 编码，每 4 个字符使用 - 分隔
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// genRecoveryCode 生成 160 bit 的随机恢复码
+func genRecoveryCode() (string, error) {
+	data := make([]byte, 20)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+
+	code := recoveryCodeEncoding.EncodeToString(data)
+	groups := make([]string, 0, len(code)/4)
+	for i := 0; i < len(code); i += 4 {
+		groups = append(groups, code[i:i+4])
+	}
+
+	return strings.Join(groups, "-"), nil
+}
+
+// recoveryKey 恢复码生成的扩展密钥，忽略大小写以及分隔符，使用用户 ID 作为盐，修改邮箱后依然有效
+func (u *User) recoveryKey(recoveryCode string) (*utils.StretchedKey, error) {
+	code := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(recoveryCode))
+	return utils.GetStretchedKey(utils.GetMasterKey(code, u.UserID))
+}
+
+// setRecoveryCode 生成新的恢复码并单独加密对称密钥，旧的恢复码随之失效
+func (u *User) setRecoveryCode(symmetricKey *utils.StretchedKey) error {
+	recoveryCode, err := genRecoveryCode()
+	if err != nil {
+		return err
+	}
+	recoveryKey, err := u.recoveryKey(recoveryCode)
+	if err != nil {
+		return err
+	}
+
+	u.ProtectedRecoveryKey, err = utils.EncryptWithStretchedKey(recoveryKey, symmetricKey.Key(),
+		u.associatedData(fieldProtectedRecoveryKey))
+	if err != nil {
+		return err
+	}
+	u.RecoveryCode = recoveryCode
+
+	return nil
+}
+
+// RecoverSymmetricKey 使用恢复码解密对称密钥
+func (u *User) RecoverSymmetricKey(recoveryCode string) (*utils.StretchedKey, error) {
+	if u.ProtectedRecoveryKey == "" {
+		return nil, errors.New("recovery code is not enabled")
+	}
+
+	recoveryKey, err := u.recoveryKey(recoveryCode)
+	if err != nil {
+		return nil, err
+	}
+	symmetricKey, err := utils.DecryptWithStretchedKey(recoveryKey, u.ProtectedRecoveryKey,
+		u.associatedData(fieldProtectedRecoveryKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return newSymmetricKey(symmetricKey)
+}
+
+// RSAPrivateKey 解密用户的 RSA 私钥，返回 pem 格式
+func (u *User) RSAPrivateKey(password string) ([]byte, error) {
+	return u.decryptPrivateKey(password, u.ProtectedRSAPrivateKey, fieldProtectedRSAPrivateKey)
+}
+
+func newSymmetricKey(symmetricKey []byte) (*utils.StretchedKey, error) {
+	if len(symmetricKey) != 64 {
+		return nil, errors.New("invalid symmetric key length")
+	}
+
+	return &utils.StretchedKey{
+		Enc: symmetricKey[:32],
+		Mac: symmetricKey[32:],
+	}, nil
+}
+
+// upgradeEncryption 使用密码解密所有受保护字段，并以 AES-256-GCM 加附加数据的格式重新加密，
+// 所有字段已经是新格式时返回 nil。旧格式不校验附加数据，只有全部字段都是旧格式时才升级，
+// 新旧格式混合的记录视为被篡改，避免已升级的记录被替换为其他记录或字段的旧格式密文
+func (u *User) upgradeEncryption(password string) (*User, error) {
+	fields := []struct {
+		name       string
+		ciphertext string
+	}{
+		{fieldProtectedSymmetricKey, u.ProtectedSymmetricKey},
+		{fieldProtectedSignPrivateKey, u.ProtectedSignPrivateKey},
+		{fieldProtectedTLSPrivateKey, u.ProtectedTLSPrivateKey},
+		{fieldProtectedRSAPrivateKey, u.ProtectedRSAPrivateKey},
+	}
+
+	legacy := 0
+	for _, field := range fields {
+		envelope, err := utils.ParseEnvelope(field.ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", field.name, err)
+		}
+		switch envelope.Type {
+		case utils.AesGcm256B64:
+		case utils.AesCbc256HmacSha256B64:
+			legacy++
+		default:
+			return nil, fmt.Errorf("%s: unexpected encryption type: %v", field.name, envelope.Type)
+		}
+	}
+	if legacy == 0 {
+		return nil, nil
+	}
+	if legacy != len(fields) {
+		return nil, errors.New("protected fields have mixed encryption formats")
+	}
+
+	stretchedKey, err := u.StretchedKey(password)
+	if err != nil {
+		return nil, err
+	}
+	key, err := utils.DecryptLegacyWithStretchedKey(stretchedKey, u.ProtectedSymmetricKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fieldProtectedSymmetricKey, err)
+	}
+	symmetricKey, err := newSymmetricKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertexts := make([]string, len(fields))
+	for i, field := range fields {
+		key := symmetricKey
+		if field.name == fieldProtectedSymmetricKey {
+			key = stretchedKey
+		}
+
+		text, err := utils.DecryptLegacyWithStretchedKey(key, field.ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", field.name, err)
+		}
+		if ciphertexts[i], err = utils.EncryptWithStretchedKey(key, text, u.associatedData(field.name)); err != nil {
+			return nil, fmt.Errorf("%s: %v", field.name, err)
+		}
+	}
+
+	return &User{
+		ProtectedSymmetricKey:   ciphertexts[0],
+		ProtectedSignPrivateKey: ciphertexts[1],
+		ProtectedTLSPrivateKey:  ciphertexts[2],
+		ProtectedRSAPrivateKey:  ciphertexts[3],
+	}, nil
+}
+
+// LegacyEncryption 受保护字段中存在 AES-256-CBC + HMAC-SHA256 格式的旧密文时返回 true，
+// 旧密文只能使用用户的密码解密，在登录或者使用用户身份私钥时通过 UpgradeEncryption 升级
+func (u *User) LegacyEncryption() bool {
+	for _, ciphertext := range []string{u.ProtectedSymmetricKey, u.ProtectedSignPrivateKey,
+		u.ProtectedTLSPrivateKey, u.ProtectedRSAPrivateKey} {
+		envelope, err := utils.ParseEnvelope(ciphertext)
+		if err == nil && envelope.Type == utils.AesCbc256HmacSha256B64 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SoftDeleteColumns 停用的用户视为软删除，默认不会被查询到
+func (User) SoftDeleteColumns() (string, string) {
+	return "deactivate", "deactivate_at"
+}
+
+func (u *User) ValidatePassword(password string) bool {
+	masterKey, err := u.masterKey(password)
+	if err != nil {
+		return false
+	}
+
+	return utils.ValidatePassword(string(masterKey), password, u.Password)
+}
+
+// QuerySchema 用户列表允许过滤以及排序的字段
+var QuerySchema = &storage.Schema{
+	Fields: map[string]storage.Field{
+		"userId":       {Column: "user_id"},
+		"name":         {Column: "name"},
+		"email":        {Column: "email"},
+		"kdf":          {Column: "kdf"},
+		"root":         {Column: "root", Type: storage.FieldBool},
+		"deactivate":   {Column: "deactivate", Type: storage.FieldBool},
+		"deactivateAt": {Column: "deactivate_at", Type: storage.FieldInt},
+		"createdAt":    {Column: "created_at", Type: storage.FieldInt},
+		"updatedAt":    {Column: "updated_at", Type: storage.FieldInt},
+	},
+	Key:         "resource_id",
+	DefaultSort: "createdAt",
+}
+
+// FindUserByID 通过用户 ID、邮箱或者资源 ID 查询用户，不包含已停用的用户
 func FindUserByID(id string) (*User, error) {
+	return findUserByID(id, false)
+}
+
+// FindUnscopedUserByID 与 FindUserByID 相同，但是包含已停用的用户
+func FindUnscopedUserByID(id string) (*User, error) {
+	return findUserByID(id, true)
+}
+
+func findUserByID(id string, unscoped bool) (*User, error) {
+	options := storage.NewQueryOptions().
+		Or(&User{UserID: id}).
+		Or(&User{Email: id}).
+		Or(&User{ResourceID: id})
+	if unscoped {
+		options.Unscoped()
+	}
+
 	user := new(User)
-	return user, storage.FindByQuery(user,
-		storage.NewQueryOptions().
-			Or(&User{UserID: id}).
-			Or(&User{Email: id}).
-			Or(&User{ResourceID: id}))
+	return user, storage.FindByQuery(user, options)
 }
 
 type UserOrganizations struct {
@@ -132,11 +519,59 @@ type UserOrganizations struct {
 	Role           Role   `json:"role,omitempty"`
 	Status         string `json:"status,omitempty"`
 	Deactivate     bool   `json:"deactivate,omitempty"`
+	Version        int64  `json:"version,omitempty" gorm:"default:1"`
 	CreatedAt      int64  `json:"createdAt,omitempty" gorm:"autoCreateTime"`
 	UpdatedAt      int64  `json:"updatedAt,omitempty" gorm:"autoUpdateTime"`
 	DeactivateAt   int64  `json:"deactivateAt,omitempty"`
 }
 
+func NewUserOrganizations(userID, organizationID string, role Role) *UserOrganizations {
+	return &UserOrganizations{
+		UserID:         userID,
+		OrganizationID: organizationID,
+		Role:           role,
+	}
+}
+
+func (uo *UserOrganizations) Create(ctx context.Context) error {
+	uo.ResourceID = utils.GenResourceID(UserOrganizationsResourceNamespace)
+	uo.Version = 1
+	return storage.FromContext(ctx).Create(uo)
+}
+
+// SoftDeleteColumns 停用的成员关系视为软删除，默认不会被查询到
+func (UserOrganizations) SoftDeleteColumns() (string, string) {
+	return "deactivate", "deactivate_at"
+}
+
+// UserOrganizationsQuerySchema 组织成员列表允许过滤以及排序的字段
+var UserOrganizationsQuerySchema = &storage.Schema{
+	Fields: map[string]storage.Field{
+		"userId":       {Column: "user_id"},
+		"role":         {Column: "role", Type: storage.FieldInt},
+		"status":       {Column: "status"},
+		"deactivate":   {Column: "deactivate", Type: storage.FieldBool},
+		"deactivateAt": {Column: "deactivate_at", Type: storage.FieldInt},
+		"createdAt":    {Column: "created_at", Type: storage.FieldInt},
+		"updatedAt":    {Column: "updated_at", Type: storage.FieldInt},
+	},
+	Key:         "resource_id",
+	DefaultSort: "createdAt",
+}
+
+// FindUserOrganization 查询用户在组织中的成员关系，unscoped 为 true 时包含已停用的成员关系
+func FindUserOrganization(organizationID, userID string, unscoped bool) (*UserOrganizations, error) {
+	options := storage.NewQueryOptions().
+		Where(&UserOrganizations{OrganizationID: organizationID, UserID: userID})
+	if unscoped {
+		options.Unscoped()
+	}
+
+	member := new(UserOrganizations)
+	return member, storage.FindByQuery(member, options)
+}
+
+// FindUserOrganizationsByUserID 查询用户的成员关系，不包含已停用的成员关系
 func FindUserOrganizationsByUserID(id string) ([]*UserOrganizations, error) {
 	organizations := make([]*UserOrganizations, 0)
 	return organizations, storage.FindByQuery(&organizations,
@@ -159,6 +594,9 @@ type organization struct {
 func NewUserContext(user *User, orgs []*UserOrganizations) *UserContext {
 	organizations := make([]*organization, 0)
 	for _, org := range orgs {
+		if org.Deactivate {
+			continue
+		}
 		organizations = append(organizations, &organization{
 			OrganizationID: org.OrganizationID,
 			Role:           org.Role,
@@ -186,6 +624,21 @@ func (u *UserContext) Role(orgID string) string {
 	return RoleNone.String()
 }
 
+// HasRole 判断用户在组织中是否拥有指定或更高的权限
+func (u *UserContext) HasRole(orgID string, role Role) bool {
+	if u.Root {
+		return true
+	}
+
+	for _, org := range u.Organizations {
+		if org.OrganizationID == orgID {
+			return org.Role.LE(role)
+		}
+	}
+
+	return false
+}
+
 func (u *UserContext) Valid() error {
 	if !u.verifyExpiresAt() {
 		return errors.New("token is expired")